@@ -0,0 +1,99 @@
+// Command gatewayctl runs admin operations against a running gateway.
+//
+//	gatewayctl replay [flags] <request_id>
+//
+// replay re-runs a captured request through a pool, provider or credential
+// and prints the comparison with the original response.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+func main() {
+	log.SetFlags(0)
+	if len(os.Args) < 2 {
+		usage()
+	}
+	switch os.Args[1] {
+	case "replay":
+		replay(os.Args[2:])
+	default:
+		usage()
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: gatewayctl replay [flags] <request_id>")
+	os.Exit(2)
+}
+
+func replay(args []string) {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	var (
+		adminURL   = fs.String("admin", envDefault("GATEWAY_ADMIN_URL", "http://127.0.0.1:8080/admin"), "admin base URL (default GATEWAY_ADMIN_URL)")
+		token      = fs.String("token", strings.TrimSpace(os.Getenv("ADMIN_TOKEN")), "admin token (default ADMIN_TOKEN)")
+		poolID     = fs.Uint64("pool", 0, "pool to replay through (default: the original pool)")
+		providerID = fs.Uint64("provider", 0, "pin the replay to a provider")
+		credID     = fs.Uint64("credential", 0, "pin the replay to a credential")
+		model      = fs.String("model", "", "override the requested model")
+		timeout    = fs.Duration("timeout", 5*time.Minute, "replay timeout")
+	)
+	_ = fs.Parse(args)
+	if fs.NArg() != 1 {
+		usage()
+	}
+	if *token == "" {
+		log.Fatalf("admin token is required (ADMIN_TOKEN)")
+	}
+
+	body, _ := json.Marshal(map[string]any{
+		"pool_id":       *poolID,
+		"provider_id":   *providerID,
+		"credential_id": *credID,
+		"model":         *model,
+		"timeout_ms":    timeout.Milliseconds(),
+	})
+	endpoint := strings.TrimRight(*adminURL, "/") + "/api/captures/" + url.PathEscape(fs.Arg(0)) + "/replay"
+	req, err := http.NewRequest(http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		log.Fatalf("replay: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+*token)
+
+	client := &http.Client{Timeout: *timeout + 30*time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		log.Fatalf("replay: %v", err)
+	}
+	defer resp.Body.Close()
+	raw, _ := io.ReadAll(resp.Body)
+
+	var out bytes.Buffer
+	if json.Indent(&out, raw, "", "  ") != nil {
+		out.Reset()
+		out.Write(raw)
+	}
+	fmt.Println(strings.TrimSpace(out.String()))
+	if resp.StatusCode != http.StatusOK {
+		os.Exit(1)
+	}
+}
+
+func envDefault(key, def string) string {
+	if v := strings.TrimSpace(os.Getenv(key)); v != "" {
+		return v
+	}
+	return def
+}
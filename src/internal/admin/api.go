@@ -3,12 +3,16 @@ package admin
 import (
 	"database/sql"
 	"encoding/json"
+	"errors"
+	"io"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
 
 	"github.com/go-chi/chi/v5"
+
+	"claude-gateway/src/internal/router"
 )
 
 func (h *Handler) logsStream(w http.ResponseWriter, r *http.Request) {
@@ -38,7 +42,7 @@ func (h *Handler) listLogs(w http.ResponseWriter, r *http.Request) {
 	_ = h.db.QueryRowContext(r.Context(), "SELECT COUNT(*) FROM request_logs").Scan(&total)
 
 	rows, err := h.db.QueryContext(r.Context(),
-		`SELECT id, pool_id, provider_id, credential_id, client_key, src_ip, user_agent, is_test, stream, request_bytes, response_bytes, input_tokens, output_tokens, facade, req_model, upstream_model, status, latency_ms, ttft_ms, tps, error_msg, ts 
+		`SELECT id, pool_id, provider_id, credential_id, client_key, src_ip, user_agent, is_test, stream, request_bytes, response_bytes, input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, cache_hit, facade, req_model, upstream_model, status, latency_ms, ttft_ms, tps, error_msg, ts 
 		 FROM request_logs ORDER BY id DESC LIMIT ? OFFSET ?`, limit, offset)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
@@ -60,6 +64,9 @@ func (h *Handler) listLogs(w http.ResponseWriter, r *http.Request) {
 		ResponseBytes int     `json:"response_bytes,omitempty"`
 		InputTokens   int64   `json:"input_tokens,omitempty"`
 		OutputTokens  int64   `json:"output_tokens,omitempty"`
+		CacheCreation int64   `json:"cache_creation_tokens,omitempty"`
+		CacheRead     int64   `json:"cache_read_tokens,omitempty"`
+		CacheHit      bool    `json:"cache_hit,omitempty"`
 		Facade        string  `json:"facade"`
 		RequestModel  string  `json:"request_model"`
 		UpstreamModel string  `json:"upstream_model"`
@@ -80,12 +87,13 @@ func (h *Handler) listLogs(w http.ResponseWriter, r *http.Request) {
 			isTest, stream             bool
 			reqBytes, respBytes        sql.NullInt64
 			inTok, outTok              sql.NullInt64
+			cacheWrite, cacheRead      sql.NullInt64
 			status, latency            sql.NullInt64
 			ttft                       sql.NullInt64
 			tps                        sql.NullFloat64
 			ts                         time.Time
 		)
-		if err := rows.Scan(&l.ID, &poolID, &provID, &credID, &l.ClientKey, &srcIP, &ua, &isTest, &stream, &reqBytes, &respBytes, &inTok, &outTok, &l.Facade, &l.RequestModel, &upModel, &status, &latency, &ttft, &tps, &errMsg, &ts); err != nil {
+		if err := rows.Scan(&l.ID, &poolID, &provID, &credID, &l.ClientKey, &srcIP, &ua, &isTest, &stream, &reqBytes, &respBytes, &inTok, &outTok, &cacheWrite, &cacheRead, &l.CacheHit, &l.Facade, &l.RequestModel, &upModel, &status, &latency, &ttft, &tps, &errMsg, &ts); err != nil {
 			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
 			return
 		}
@@ -100,6 +108,8 @@ func (h *Handler) listLogs(w http.ResponseWriter, r *http.Request) {
 		l.ResponseBytes = int(respBytes.Int64)
 		l.InputTokens = inTok.Int64
 		l.OutputTokens = outTok.Int64
+		l.CacheCreation = cacheWrite.Int64
+		l.CacheRead = cacheRead.Int64
 		l.UpstreamModel = upModel.String
 		l.Status = int(status.Int64)
 		l.LatencyMs = latency.Int64
@@ -126,6 +136,8 @@ func (h *Handler) getStats(w http.ResponseWriter, r *http.Request) {
 			SUM(CASE WHEN status >= 200 AND status < 400 THEN 1 ELSE 0 END) as success,
 			IFNULL(SUM(input_tokens), 0) as input_tokens,
 			IFNULL(SUM(output_tokens), 0) as output_tokens,
+			IFNULL(SUM(cache_creation_tokens), 0) as cache_creation_tokens,
+			IFNULL(SUM(cache_read_tokens), 0) as cache_read_tokens,
 			IFNULL(AVG(latency_ms), 0) as avg_latency,
 			IFNULL(AVG(ttft_ms), 0) as avg_ttft,
 			IFNULL(AVG(tps), 0) as avg_tps
@@ -140,19 +152,21 @@ func (h *Handler) getStats(w http.ResponseWriter, r *http.Request) {
 	defer dailyRows.Close()
 
 	type dayStat struct {
-		Day          string  `json:"day"`
-		Total        int64   `json:"total"`
-		Success      int64   `json:"success"`
-		InputTokens  int64   `json:"input_tokens"`
-		OutputTokens int64   `json:"output_tokens"`
-		AvgLatencyMs float64 `json:"avg_latency"`
-		AvgTTFTMs    float64 `json:"avg_ttft"`
-		AvgTPS       float64 `json:"avg_tps"`
+		Day                 string  `json:"day"`
+		Total               int64   `json:"total"`
+		Success             int64   `json:"success"`
+		InputTokens         int64   `json:"input_tokens"`
+		OutputTokens        int64   `json:"output_tokens"`
+		CacheCreationTokens int64   `json:"cache_creation_tokens"`
+		CacheReadTokens     int64   `json:"cache_read_tokens"`
+		AvgLatencyMs        float64 `json:"avg_latency"`
+		AvgTTFTMs           float64 `json:"avg_ttft"`
+		AvgTPS              float64 `json:"avg_tps"`
 	}
 	days := []dayStat{}
 	for dailyRows.Next() {
 		var s dayStat
-		if err := dailyRows.Scan(&s.Day, &s.Total, &s.Success, &s.InputTokens, &s.OutputTokens, &s.AvgLatencyMs, &s.AvgTTFTMs, &s.AvgTPS); err != nil {
+		if err := dailyRows.Scan(&s.Day, &s.Total, &s.Success, &s.InputTokens, &s.OutputTokens, &s.CacheCreationTokens, &s.CacheReadTokens, &s.AvgLatencyMs, &s.AvgTTFTMs, &s.AvgTPS); err != nil {
 			continue
 		}
 		days = append(days, s)
@@ -164,7 +178,8 @@ func (h *Handler) getStats(w http.ResponseWriter, r *http.Request) {
 			DATE_FORMAT(ts, '%Y-%m-%d %H:00:00') as hour, 
 			COUNT(*) as total,
 			IFNULL(SUM(input_tokens), 0) as input_tokens,
-			IFNULL(SUM(output_tokens), 0) as output_tokens
+			IFNULL(SUM(output_tokens), 0) as output_tokens,
+			IFNULL(SUM(cache_read_tokens), 0) as cache_read_tokens
 		 FROM request_logs 
 		 WHERE ts > DATE_SUB(NOW(), INTERVAL 24 HOUR)
 		 GROUP BY hour 
@@ -176,15 +191,16 @@ func (h *Handler) getStats(w http.ResponseWriter, r *http.Request) {
 	defer hourlyRows.Close()
 
 	type hourStat struct {
-		Hour         string `json:"hour"`
-		Total        int64  `json:"total"`
-		InputTokens  int64  `json:"input_tokens"`
-		OutputTokens int64  `json:"output_tokens"`
+		Hour            string `json:"hour"`
+		Total           int64  `json:"total"`
+		InputTokens     int64  `json:"input_tokens"`
+		OutputTokens    int64  `json:"output_tokens"`
+		CacheReadTokens int64  `json:"cache_read_tokens"`
 	}
 	hours := []hourStat{}
 	for hourlyRows.Next() {
 		var s hourStat
-		if err := hourlyRows.Scan(&s.Hour, &s.Total, &s.InputTokens, &s.OutputTokens); err != nil {
+		if err := hourlyRows.Scan(&s.Hour, &s.Total, &s.InputTokens, &s.OutputTokens, &s.CacheReadTokens); err != nil {
 			continue
 		}
 		hours = append(hours, s)
@@ -198,11 +214,13 @@ func (h *Handler) getStats(w http.ResponseWriter, r *http.Request) {
 			SUM(CASE WHEN status >= 200 AND status < 400 THEN 1 ELSE 0 END),
 			IFNULL(SUM(input_tokens), 0),
 			IFNULL(SUM(output_tokens), 0),
+			IFNULL(SUM(cache_creation_tokens), 0),
+			IFNULL(SUM(cache_read_tokens), 0),
 			IFNULL(AVG(latency_ms), 0),
 			IFNULL(AVG(ttft_ms), 0),
 			IFNULL(AVG(tps), 0)
 		 FROM request_logs 
-		 WHERE DATE(ts) = DATE(NOW())`).Scan(&today.Total, &today.Success, &today.InputTokens, &today.OutputTokens, &today.AvgLatencyMs, &today.AvgTTFTMs, &today.AvgTPS)
+		 WHERE DATE(ts) = DATE(NOW())`).Scan(&today.Total, &today.Success, &today.InputTokens, &today.OutputTokens, &today.CacheCreationTokens, &today.CacheReadTokens, &today.AvgLatencyMs, &today.AvgTTFTMs, &today.AvgTPS)
 	if err != nil {
 		// ignore
 	}
@@ -300,11 +318,14 @@ type providerDTO struct {
 	Notes             string          `json:"notes,omitempty"`
 	ModelsJSON        json.RawMessage `json:"models_json,omitempty"`
 	ModelsRefreshedAt string          `json:"models_refreshed_at,omitempty"`
+	RefreshEverySec   *int            `json:"models_refresh_interval_sec,omitempty"`
+	ProbeIntervalSec  *int            `json:"probe_interval_sec,omitempty"`
+	ProbeModel        string          `json:"probe_model,omitempty"`
 	Enabled           bool            `json:"enabled"`
 }
 
 func (h *Handler) listProviders(w http.ResponseWriter, r *http.Request) {
-	rows, err := h.db.QueryContext(r.Context(), `SELECT id, type, display_name, group_name, base_url, default_headers_json, model_map_json, notes, models_json, models_refreshed_at, enabled FROM providers ORDER BY id DESC`)
+	rows, err := h.db.QueryContext(r.Context(), `SELECT id, type, display_name, group_name, base_url, default_headers_json, model_map_json, notes, models_json, models_refreshed_at, models_refresh_interval_sec, probe_interval_sec, probe_model, enabled FROM providers ORDER BY id DESC`)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
 		return
@@ -321,8 +342,11 @@ func (h *Handler) listProviders(w http.ResponseWriter, r *http.Request) {
 			notes       sql.NullString
 			modelsJSON  []byte
 			refreshedAt sql.NullTime
+			refreshIvl  sql.NullInt64
+			probeEvery  sql.NullInt64
+			probeModel  sql.NullString
 		)
-		if err := rows.Scan(&p.ID, &p.Type, &displayName, &groupName, &p.BaseURL, &hdrs, &mm, &notes, &modelsJSON, &refreshedAt, &p.Enabled); err != nil {
+		if err := rows.Scan(&p.ID, &p.Type, &displayName, &groupName, &p.BaseURL, &hdrs, &mm, &notes, &modelsJSON, &refreshedAt, &refreshIvl, &probeEvery, &probeModel, &p.Enabled); err != nil {
 			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
 			return
 		}
@@ -341,6 +365,15 @@ func (h *Handler) listProviders(w http.ResponseWriter, r *http.Request) {
 		if refreshedAt.Valid {
 			p.ModelsRefreshedAt = refreshedAt.Time.UTC().Format(time.RFC3339Nano)
 		}
+		if refreshIvl.Valid {
+			v := int(refreshIvl.Int64)
+			p.RefreshEverySec = &v
+		}
+		if probeEvery.Valid {
+			v := int(probeEvery.Int64)
+			p.ProbeIntervalSec = &v
+		}
+		p.ProbeModel = probeModel.String
 		out = append(out, p)
 	}
 	writeJSON(w, http.StatusOK, out)
@@ -367,6 +400,8 @@ func (h *Handler) createProvider(w http.ResponseWriter, r *http.Request) {
 		groupName   any = nil
 		notes       any = nil
 		modelsJSON  any = nil
+		refreshIvl  any = nil
+		probeEvery  any = nil
 	)
 	if strings.TrimSpace(in.DisplayName) != "" {
 		displayName = strings.TrimSpace(in.DisplayName)
@@ -380,9 +415,15 @@ func (h *Handler) createProvider(w http.ResponseWriter, r *http.Request) {
 	if len(in.ModelsJSON) > 0 && string(in.ModelsJSON) != "null" {
 		modelsJSON = in.ModelsJSON
 	}
+	if in.RefreshEverySec != nil && *in.RefreshEverySec > 0 {
+		refreshIvl = *in.RefreshEverySec
+	}
+	if in.ProbeIntervalSec != nil && *in.ProbeIntervalSec > 0 {
+		probeEvery = *in.ProbeIntervalSec
+	}
 
-	res, err := h.db.ExecContext(r.Context(), `INSERT INTO providers(type, display_name, group_name, base_url, default_headers_json, model_map_json, notes, models_json, enabled) VALUES (?,?,?,?,?,?,?,?,?)`,
-		in.Type, displayName, groupName, in.BaseURL, in.DefaultHeadersRaw, in.ModelMapRaw, notes, modelsJSON, in.Enabled)
+	res, err := h.db.ExecContext(r.Context(), `INSERT INTO providers(type, display_name, group_name, base_url, default_headers_json, model_map_json, notes, models_json, models_refresh_interval_sec, probe_interval_sec, probe_model, enabled) VALUES (?,?,?,?,?,?,?,?,?,?,?,?)`,
+		in.Type, displayName, groupName, in.BaseURL, in.DefaultHeadersRaw, in.ModelMapRaw, notes, modelsJSON, refreshIvl, probeEvery, nullStr(strings.TrimSpace(in.ProbeModel)), in.Enabled)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
@@ -414,6 +455,8 @@ func (h *Handler) updateProvider(w http.ResponseWriter, r *http.Request) {
 		groupName   any = nil
 		notes       any = nil
 		modelsJSON  any = nil
+		refreshIvl  any = nil
+		probeEvery  any = nil
 	)
 	if strings.TrimSpace(in.DisplayName) != "" {
 		displayName = strings.TrimSpace(in.DisplayName)
@@ -427,9 +470,15 @@ func (h *Handler) updateProvider(w http.ResponseWriter, r *http.Request) {
 	if len(in.ModelsJSON) > 0 && string(in.ModelsJSON) != "null" {
 		modelsJSON = in.ModelsJSON
 	}
+	if in.RefreshEverySec != nil && *in.RefreshEverySec > 0 {
+		refreshIvl = *in.RefreshEverySec
+	}
+	if in.ProbeIntervalSec != nil && *in.ProbeIntervalSec > 0 {
+		probeEvery = *in.ProbeIntervalSec
+	}
 
-	_, err = h.db.ExecContext(r.Context(), `UPDATE providers SET type=?, display_name=?, group_name=?, base_url=?, default_headers_json=?, model_map_json=?, notes=?, models_json=?, enabled=? WHERE id=?`,
-		in.Type, displayName, groupName, in.BaseURL, in.DefaultHeadersRaw, in.ModelMapRaw, notes, modelsJSON, in.Enabled, id)
+	_, err = h.db.ExecContext(r.Context(), `UPDATE providers SET type=?, display_name=?, group_name=?, base_url=?, default_headers_json=?, model_map_json=?, notes=?, models_json=?, models_refresh_interval_sec=?, probe_interval_sec=?, probe_model=?, enabled=? WHERE id=?`,
+		in.Type, displayName, groupName, in.BaseURL, in.DefaultHeadersRaw, in.ModelMapRaw, notes, modelsJSON, refreshIvl, probeEvery, nullStr(strings.TrimSpace(in.ProbeModel)), in.Enabled, id)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
@@ -460,6 +509,7 @@ type credentialDTO struct {
 	KeyLast4         string `json:"key_last4,omitempty"`
 	Weight           int    `json:"weight"`
 	ConcurrencyLimit *int   `json:"concurrency_limit,omitempty"`
+	AdaptiveConc     bool   `json:"adaptive_concurrency,omitempty"`
 	LastTestAt       string `json:"last_test_at,omitempty"`
 	LastTestOK       *bool  `json:"last_test_ok,omitempty"`
 	LastTestStatus   *int   `json:"last_test_status,omitempty"`
@@ -467,10 +517,12 @@ type credentialDTO struct {
 	LastTestError    string `json:"last_test_error,omitempty"`
 	LastTestModel    string `json:"last_test_model,omitempty"`
 	Enabled          bool   `json:"enabled"`
+	DisabledReason   string `json:"disabled_reason,omitempty"`
+	DisabledAt       string `json:"disabled_at,omitempty"`
 }
 
 func (h *Handler) listCredentials(w http.ResponseWriter, r *http.Request) {
-	q := `SELECT id, provider_id, name, key_last4, weight, concurrency_limit, last_test_at, last_test_ok, last_test_status, last_test_latency_ms, last_test_error, last_test_model, enabled FROM credentials`
+	q := `SELECT id, provider_id, name, key_last4, weight, concurrency_limit, adaptive_concurrency, last_test_at, last_test_ok, last_test_status, last_test_latency_ms, last_test_error, last_test_model, enabled, disabled_reason, disabled_at FROM credentials`
 	var args []any
 	if v := strings.TrimSpace(r.URL.Query().Get("provider_id")); v != "" {
 		pid, err := strconv.ParseUint(v, 10, 64)
@@ -499,8 +551,10 @@ func (h *Handler) listCredentials(w http.ResponseWriter, r *http.Request) {
 			testLat   sql.NullInt64
 			testErr   sql.NullString
 			testModel sql.NullString
+			disReason sql.NullString
+			disAt     sql.NullTime
 		)
-		if err := rows.Scan(&c.ID, &c.ProviderID, &c.Name, &c.KeyLast4, &c.Weight, &conc, &testAt, &testOK, &testSt, &testLat, &testErr, &testModel, &c.Enabled); err != nil {
+		if err := rows.Scan(&c.ID, &c.ProviderID, &c.Name, &c.KeyLast4, &c.Weight, &conc, &c.AdaptiveConc, &testAt, &testOK, &testSt, &testLat, &testErr, &testModel, &c.Enabled, &disReason, &disAt); err != nil {
 			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
 			return
 		}
@@ -529,6 +583,10 @@ func (h *Handler) listCredentials(w http.ResponseWriter, r *http.Request) {
 		if testModel.Valid {
 			c.LastTestModel = testModel.String
 		}
+		c.DisabledReason = disReason.String
+		if disAt.Valid {
+			c.DisabledAt = disAt.Time.UTC().Format(time.RFC3339Nano)
+		}
 		out = append(out, c)
 	}
 	writeJSON(w, http.StatusOK, out)
@@ -559,8 +617,8 @@ func (h *Handler) createCredential(w http.ResponseWriter, r *http.Request) {
 		conc = *in.ConcurrencyLimit
 	}
 	res, err := h.db.ExecContext(r.Context(),
-		`INSERT INTO credentials(provider_id, name, api_key_ciphertext, key_last4, weight, concurrency_limit, enabled) VALUES (?,?,?,?,?,?,?)`,
-		in.ProviderID, in.Name, blob, last4, weight, conc, in.Enabled)
+		`INSERT INTO credentials(provider_id, name, api_key_ciphertext, key_last4, weight, concurrency_limit, adaptive_concurrency, enabled) VALUES (?,?,?,?,?,?,?,?)`,
+		in.ProviderID, in.Name, blob, last4, weight, conc, in.AdaptiveConc, in.Enabled)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
@@ -578,6 +636,7 @@ func (h *Handler) createCredentialsBulk(w http.ResponseWriter, r *http.Request)
 		APIKey           string `json:"api_key,omitempty"`
 		Weight           int    `json:"weight"`
 		ConcurrencyLimit *int   `json:"concurrency_limit,omitempty"`
+		AdaptiveConc     bool   `json:"adaptive_concurrency,omitempty"`
 		Enabled          bool   `json:"enabled"`
 	}
 	var in struct {
@@ -601,7 +660,7 @@ func (h *Handler) createCredentialsBulk(w http.ResponseWriter, r *http.Request)
 	defer tx.Rollback()
 
 	stmt, err := tx.PrepareContext(r.Context(),
-		`INSERT INTO credentials(provider_id, name, api_key_ciphertext, key_last4, weight, concurrency_limit, enabled) VALUES (?,?,?,?,?,?,?)`)
+		`INSERT INTO credentials(provider_id, name, api_key_ciphertext, key_last4, weight, concurrency_limit, adaptive_concurrency, enabled) VALUES (?,?,?,?,?,?,?,?)`)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
 		return
@@ -622,7 +681,7 @@ func (h *Handler) createCredentialsBulk(w http.ResponseWriter, r *http.Request)
 		if it.ConcurrencyLimit != nil {
 			conc = *it.ConcurrencyLimit
 		}
-		_, _ = stmt.ExecContext(r.Context(), in.ProviderID, it.Name, blob, last4v, weight, conc, it.Enabled)
+		_, _ = stmt.ExecContext(r.Context(), in.ProviderID, it.Name, blob, last4v, weight, conc, it.AdaptiveConc, it.Enabled)
 	}
 	_ = tx.Commit()
 	writeJSON(w, http.StatusCreated, map[string]any{"ok": true})
@@ -658,9 +717,11 @@ func (h *Handler) updateCredential(w http.ResponseWriter, r *http.Request) {
 		conc = *in.ConcurrencyLimit
 	}
 
+	// Re-enabling a credential clears any reason it was auto-disabled for.
 	_, err = h.db.ExecContext(r.Context(),
-		`UPDATE credentials SET provider_id=?, name=?, api_key_ciphertext=?, key_last4=?, weight=?, concurrency_limit=?, enabled=? WHERE id=?`,
-		in.ProviderID, in.Name, blob, last4v, weight, conc, in.Enabled, id)
+		`UPDATE credentials SET provider_id=?, name=?, api_key_ciphertext=?, key_last4=?, weight=?, concurrency_limit=?, adaptive_concurrency=?, enabled=?,
+		 disabled_reason=IF(?, NULL, disabled_reason), disabled_at=IF(?, NULL, disabled_at) WHERE id=?`,
+		in.ProviderID, in.Name, blob, last4v, weight, conc, in.AdaptiveConc, in.Enabled, in.Enabled, in.Enabled, id)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
@@ -668,6 +729,10 @@ func (h *Handler) updateCredential(w http.ResponseWriter, r *http.Request) {
 	in.ID = id
 	in.APIKey = ""
 	in.KeyLast4 = last4v
+	if in.Enabled {
+		in.DisabledReason = ""
+		in.DisabledAt = ""
+	}
 	writeJSON(w, http.StatusOK, in)
 }
 
@@ -678,18 +743,64 @@ func (h *Handler) deleteCredential(w http.ResponseWriter, r *http.Request) {
 }
 
 type poolDTO struct {
-	ID            uint64          `json:"id"`
-	Name          string          `json:"name"`
-	ClientKey     string          `json:"client_key"`
-	Strategy      string          `json:"strategy"`
-	TiersJSON     json.RawMessage `json:"tiers_json,omitempty"`
-	CredentialIDs []uint64        `json:"credential_ids"`
-	ModelMapJSON  json.RawMessage `json:"model_map_json,omitempty"`
-	Enabled       bool            `json:"enabled"`
+	ID                  uint64          `json:"id"`
+	Name                string          `json:"name"`
+	ClientKey           string          `json:"client_key"`
+	Strategy            string          `json:"strategy"`
+	TiersJSON           json.RawMessage `json:"tiers_json,omitempty"`
+	TrafficSplitsJSON   json.RawMessage `json:"traffic_splits_json,omitempty"`
+	CredentialIDs       []uint64        `json:"credential_ids"`
+	ModelMapJSON        json.RawMessage `json:"model_map_json,omitempty"`
+	ParamPolicy         string          `json:"param_policy"`
+	ResponseCacheTTLSec int             `json:"response_cache_ttl_sec"`
+	CaptureRate         float64         `json:"capture_rate"`
+	ShadowProviderID    uint64          `json:"shadow_provider_id"`
+	ShadowModel         string          `json:"shadow_model"`
+	ShadowRate          float64         `json:"shadow_rate"`
+	OverflowStrategy    string          `json:"overflow_strategy"`
+	OverflowModel       string          `json:"overflow_model"`
+	Enabled             bool            `json:"enabled"`
+}
+
+// normalizeOverflowStrategy defaults an empty strategy to reject and
+// rejects unknown values.
+func normalizeOverflowStrategy(s string) (string, bool) {
+	switch strings.TrimSpace(s) {
+	case "", router.OverflowReject:
+		return router.OverflowReject, true
+	case router.OverflowRoute, router.OverflowTruncate:
+		return strings.TrimSpace(s), true
+	}
+	return "", false
+}
+
+// validateTrafficSplits checks a pool's traffic_splits_json, which may be
+// absent or null.
+func validateTrafficSplits(raw json.RawMessage) error {
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil
+	}
+	var rules []router.SplitRule
+	if err := json.Unmarshal(raw, &rules); err != nil {
+		return errors.New("traffic_splits_json must be a list of split rules")
+	}
+	return router.ValidateSplitRules(rules)
+}
+
+// normalizeParamPolicy defaults an empty policy to drop and rejects unknown
+// values.
+func normalizeParamPolicy(p string) (string, bool) {
+	switch strings.TrimSpace(p) {
+	case "", router.ParamPolicyDrop:
+		return router.ParamPolicyDrop, true
+	case router.ParamPolicyReject:
+		return router.ParamPolicyReject, true
+	}
+	return "", false
 }
 
 func (h *Handler) listPools(w http.ResponseWriter, r *http.Request) {
-	rows, err := h.db.QueryContext(r.Context(), `SELECT id, name, client_key, strategy, tiers_json, credential_ids_json, model_map_json, enabled FROM pools ORDER BY id DESC`)
+	rows, err := h.db.QueryContext(r.Context(), `SELECT id, name, client_key, strategy, tiers_json, traffic_splits_json, credential_ids_json, model_map_json, param_policy, response_cache_ttl_sec, capture_rate, shadow_provider_id, shadow_model, shadow_rate, overflow_strategy, overflow_model, enabled FROM pools ORDER BY id DESC`)
 	if err != nil {
 		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
 		return
@@ -698,12 +809,13 @@ func (h *Handler) listPools(w http.ResponseWriter, r *http.Request) {
 	out := []poolDTO{}
 	for rows.Next() {
 		var p poolDTO
-		var tiersJSON, idsJSON, mmJSON []byte
-		if err := rows.Scan(&p.ID, &p.Name, &p.ClientKey, &p.Strategy, &tiersJSON, &idsJSON, &mmJSON, &p.Enabled); err != nil {
+		var tiersJSON, splitsJSON, idsJSON, mmJSON []byte
+		if err := rows.Scan(&p.ID, &p.Name, &p.ClientKey, &p.Strategy, &tiersJSON, &splitsJSON, &idsJSON, &mmJSON, &p.ParamPolicy, &p.ResponseCacheTTLSec, &p.CaptureRate, &p.ShadowProviderID, &p.ShadowModel, &p.ShadowRate, &p.OverflowStrategy, &p.OverflowModel, &p.Enabled); err != nil {
 			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
 			return
 		}
 		p.TiersJSON = tiersJSON
+		p.TrafficSplitsJSON = splitsJSON
 		_ = json.Unmarshal(idsJSON, &p.CredentialIDs)
 		if p.CredentialIDs == nil {
 			p.CredentialIDs = []uint64{}
@@ -724,6 +836,42 @@ func (h *Handler) createPool(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "name and client_key are required"})
 		return
 	}
+	policy, ok := normalizeParamPolicy(in.ParamPolicy)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "param_policy must be drop or reject"})
+		return
+	}
+	in.ParamPolicy = policy
+	if in.ResponseCacheTTLSec < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "response_cache_ttl_sec must not be negative"})
+		return
+	}
+	if in.CaptureRate < 0 || in.CaptureRate > 1 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "capture_rate must be between 0 and 1"})
+		return
+	}
+	if in.ShadowRate < 0 || in.ShadowRate > 1 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "shadow_rate must be between 0 and 1"})
+		return
+	}
+	if in.ShadowRate > 0 && in.ShadowProviderID == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "shadow_rate requires shadow_provider_id"})
+		return
+	}
+	if err := validateTrafficSplits(in.TrafficSplitsJSON); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	overflow, ok := normalizeOverflowStrategy(in.OverflowStrategy)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "overflow_strategy must be reject, route or truncate"})
+		return
+	}
+	in.OverflowStrategy, in.OverflowModel = overflow, strings.TrimSpace(in.OverflowModel)
+	if overflow == router.OverflowRoute && in.OverflowModel == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "overflow_strategy route requires overflow_model"})
+		return
+	}
 	if in.CredentialIDs == nil {
 		in.CredentialIDs = []uint64{}
 	}
@@ -734,9 +882,12 @@ func (h *Handler) createPool(w http.ResponseWriter, r *http.Request) {
 	if in.TiersJSON == nil {
 		in.TiersJSON = []byte("null")
 	}
+	if in.TrafficSplitsJSON == nil {
+		in.TrafficSplitsJSON = []byte("null")
+	}
 	res, err := h.db.ExecContext(r.Context(),
-		`INSERT INTO pools(name, client_key, strategy, tiers_json, credential_ids_json, model_map_json, enabled) VALUES (?,?,?,?,?,?,?)`,
-		in.Name, in.ClientKey, in.Strategy, in.TiersJSON, idsJSON, in.ModelMapJSON, in.Enabled)
+		`INSERT INTO pools(name, client_key, strategy, tiers_json, traffic_splits_json, credential_ids_json, model_map_json, param_policy, response_cache_ttl_sec, capture_rate, shadow_provider_id, shadow_model, shadow_rate, overflow_strategy, overflow_model, enabled) VALUES (?,?,?,?,?,?,?,?,?,?,?,?,?,?,?,?)`,
+		in.Name, in.ClientKey, in.Strategy, in.TiersJSON, in.TrafficSplitsJSON, idsJSON, in.ModelMapJSON, in.ParamPolicy, in.ResponseCacheTTLSec, in.CaptureRate, in.ShadowProviderID, strings.TrimSpace(in.ShadowModel), in.ShadowRate, in.OverflowStrategy, in.OverflowModel, in.Enabled)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
@@ -757,6 +908,42 @@ func (h *Handler) updatePool(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
 	}
+	policy, ok := normalizeParamPolicy(in.ParamPolicy)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "param_policy must be drop or reject"})
+		return
+	}
+	in.ParamPolicy = policy
+	if in.ResponseCacheTTLSec < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "response_cache_ttl_sec must not be negative"})
+		return
+	}
+	if in.CaptureRate < 0 || in.CaptureRate > 1 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "capture_rate must be between 0 and 1"})
+		return
+	}
+	if in.ShadowRate < 0 || in.ShadowRate > 1 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "shadow_rate must be between 0 and 1"})
+		return
+	}
+	if in.ShadowRate > 0 && in.ShadowProviderID == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "shadow_rate requires shadow_provider_id"})
+		return
+	}
+	if err := validateTrafficSplits(in.TrafficSplitsJSON); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	overflow, ok := normalizeOverflowStrategy(in.OverflowStrategy)
+	if !ok {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "overflow_strategy must be reject, route or truncate"})
+		return
+	}
+	in.OverflowStrategy, in.OverflowModel = overflow, strings.TrimSpace(in.OverflowModel)
+	if overflow == router.OverflowRoute && in.OverflowModel == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "overflow_strategy route requires overflow_model"})
+		return
+	}
 	if in.CredentialIDs == nil {
 		in.CredentialIDs = []uint64{}
 	}
@@ -767,9 +954,12 @@ func (h *Handler) updatePool(w http.ResponseWriter, r *http.Request) {
 	if in.TiersJSON == nil {
 		in.TiersJSON = []byte("null")
 	}
+	if in.TrafficSplitsJSON == nil {
+		in.TrafficSplitsJSON = []byte("null")
+	}
 	_, err = h.db.ExecContext(r.Context(),
-		`UPDATE pools SET name=?, client_key=?, strategy=?, tiers_json=?, credential_ids_json=?, model_map_json=?, enabled=? WHERE id=?`,
-		in.Name, in.ClientKey, in.Strategy, in.TiersJSON, idsJSON, in.ModelMapJSON, in.Enabled, id)
+		`UPDATE pools SET name=?, client_key=?, strategy=?, tiers_json=?, traffic_splits_json=?, credential_ids_json=?, model_map_json=?, param_policy=?, response_cache_ttl_sec=?, capture_rate=?, shadow_provider_id=?, shadow_model=?, shadow_rate=?, overflow_strategy=?, overflow_model=?, enabled=? WHERE id=?`,
+		in.Name, in.ClientKey, in.Strategy, in.TiersJSON, in.TrafficSplitsJSON, idsJSON, in.ModelMapJSON, in.ParamPolicy, in.ResponseCacheTTLSec, in.CaptureRate, in.ShadowProviderID, strings.TrimSpace(in.ShadowModel), in.ShadowRate, in.OverflowStrategy, in.OverflowModel, in.Enabled, id)
 	if err != nil {
 		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
 		return
@@ -788,6 +978,14 @@ func readJSON(r *http.Request, out any) error {
 	return json.NewDecoder(r.Body).Decode(out)
 }
 
+// readOptionalJSON is readJSON for endpoints whose body may be left out.
+func readOptionalJSON(r *http.Request, out any) error {
+	if err := readJSON(r, out); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
+}
+
 func writeJSON(w http.ResponseWriter, status int, val any) {
 	w.Header().Set("Content-Type", "application/json; charset=utf-8")
 	w.WriteHeader(status)
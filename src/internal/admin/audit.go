@@ -0,0 +1,97 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func (h *Handler) audit(ctx context.Context, r *http.Request, action, targetType string, targetID uint64, detail any) {
+	var detailJSON any = nil
+	if detail != nil {
+		if b, err := json.Marshal(detail); err == nil {
+			detailJSON = b
+		}
+	}
+	var tid any = nil
+	if targetID != 0 {
+		tid = targetID
+	}
+	_, err := h.db.ExecContext(ctx,
+		`INSERT INTO admin_audit_log(action, target_type, target_id, src_ip, user_agent, detail_json) VALUES (?,?,?,?,?,?)`,
+		action, targetType, tid, nullStr(strings.TrimSpace(r.RemoteAddr)), nullStr(strings.TrimSpace(r.UserAgent())), detailJSON)
+	if err != nil {
+		log.Printf("failed to write audit log (%s %s/%d): %v", action, targetType, targetID, err)
+	}
+}
+
+func (h *Handler) listAudit(w http.ResponseWriter, r *http.Request) {
+	limit := 100
+	page := 1
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if l, err := strconv.Atoi(v); err == nil && l > 0 {
+			limit = l
+		}
+	}
+	if v := r.URL.Query().Get("page"); v != "" {
+		if p, err := strconv.Atoi(v); err == nil && p > 0 {
+			page = p
+		}
+	}
+	offset := (page - 1) * limit
+
+	q := `SELECT id, ts, action, target_type, target_id, src_ip, user_agent, detail_json FROM admin_audit_log`
+	var args []any
+	if v := strings.TrimSpace(r.URL.Query().Get("target_type")); v != "" {
+		q += ` WHERE target_type = ?`
+		args = append(args, v)
+	}
+	q += ` ORDER BY id DESC LIMIT ? OFFSET ?`
+	args = append(args, limit, offset)
+
+	rows, err := h.db.QueryContext(r.Context(), q, args...)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type auditEntry struct {
+		ID         uint64          `json:"id"`
+		TS         string          `json:"ts"`
+		Action     string          `json:"action"`
+		TargetType string          `json:"target_type"`
+		TargetID   uint64          `json:"target_id,omitempty"`
+		SrcIP      string          `json:"src_ip,omitempty"`
+		UserAgent  string          `json:"user_agent,omitempty"`
+		Detail     json.RawMessage `json:"detail,omitempty"`
+	}
+	out := []auditEntry{}
+	for rows.Next() {
+		var (
+			e         auditEntry
+			ts        time.Time
+			targetID  sql.NullInt64
+			srcIP, ua sql.NullString
+			detail    []byte
+		)
+		if err := rows.Scan(&e.ID, &ts, &e.Action, &e.TargetType, &targetID, &srcIP, &ua, &detail); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		e.TS = ts.UTC().Format(time.RFC3339)
+		e.TargetID = uint64(targetID.Int64)
+		e.SrcIP = srcIP.String
+		e.UserAgent = ua.String
+		if len(detail) > 0 {
+			e.Detail = detail
+		}
+		out = append(out, e)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"page": page, "limit": limit, "items": out})
+}
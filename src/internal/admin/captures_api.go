@@ -0,0 +1,30 @@
+package admin
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+
+	"claude-gateway/src/internal/capture"
+)
+
+// getCaptures returns the captured bodies of a request, one record per
+// upstream attempt.
+func (h *Handler) getCaptures(w http.ResponseWriter, r *http.Request) {
+	requestID := strings.TrimSpace(chi.URLParam(r, "request_id"))
+	if requestID == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "request_id is required"})
+		return
+	}
+	recs, err := capture.Load(r.Context(), h.db, requestID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	if len(recs) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "no captures for request"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"request_id": requestID, "items": recs})
+}
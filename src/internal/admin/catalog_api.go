@@ -0,0 +1,115 @@
+package admin
+
+import (
+	"database/sql"
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/chi/v5"
+)
+
+type modelCatalogDTO struct {
+	ID               uint64 `json:"id,omitempty"`
+	Model            string `json:"model"`
+	ContextWindow    *int   `json:"context_window,omitempty"`
+	MaxOutputTokens  *int   `json:"max_output_tokens,omitempty"`
+	SupportsTools    *bool  `json:"supports_tools,omitempty"`
+	SupportsVision   *bool  `json:"supports_vision,omitempty"`
+	SupportsThinking *bool  `json:"supports_thinking,omitempty"`
+	SupportsJSONMode *bool  `json:"supports_json_mode,omitempty"`
+	Notes            string `json:"notes,omitempty"`
+}
+
+func (h *Handler) listModelCatalog(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.QueryContext(r.Context(),
+		`SELECT id, model, context_window, max_output_tokens, supports_tools, supports_vision, supports_thinking, supports_json_mode, notes FROM model_catalog ORDER BY model`)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []modelCatalogDTO{}
+	for rows.Next() {
+		var (
+			m                               modelCatalogDTO
+			ctxWin, maxOut                  sql.NullInt64
+			tools, vision, thinking, jsonMd sql.NullBool
+			notes                           sql.NullString
+		)
+		if err := rows.Scan(&m.ID, &m.Model, &ctxWin, &maxOut, &tools, &vision, &thinking, &jsonMd, &notes); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		m.ContextWindow = intPtr(ctxWin)
+		m.MaxOutputTokens = intPtr(maxOut)
+		m.SupportsTools = boolPtr(tools)
+		m.SupportsVision = boolPtr(vision)
+		m.SupportsThinking = boolPtr(thinking)
+		m.SupportsJSONMode = boolPtr(jsonMd)
+		m.Notes = notes.String
+		out = append(out, m)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+// upsertModelCatalog creates or replaces the entry for in.Model. Omitted
+// capabilities are stored as unknown, which routing treats as supported.
+func (h *Handler) upsertModelCatalog(w http.ResponseWriter, r *http.Request) {
+	var in modelCatalogDTO
+	if err := readJSON(r, &in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	in.Model = strings.TrimSpace(in.Model)
+	if in.Model == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "model is required"})
+		return
+	}
+	_, err := h.db.ExecContext(r.Context(),
+		`INSERT INTO model_catalog(model, context_window, max_output_tokens, supports_tools, supports_vision, supports_thinking, supports_json_mode, notes)
+		 VALUES (?,?,?,?,?,?,?,?)
+		 ON DUPLICATE KEY UPDATE context_window=VALUES(context_window), max_output_tokens=VALUES(max_output_tokens),
+		   supports_tools=VALUES(supports_tools), supports_vision=VALUES(supports_vision), supports_thinking=VALUES(supports_thinking),
+		   supports_json_mode=VALUES(supports_json_mode), notes=VALUES(notes)`,
+		in.Model, in.ContextWindow, in.MaxOutputTokens, in.SupportsTools, in.SupportsVision, in.SupportsThinking, in.SupportsJSONMode, nullStr(strings.TrimSpace(in.Notes)))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	_ = h.db.QueryRowContext(r.Context(), `SELECT id FROM model_catalog WHERE model=?`, in.Model).Scan(&in.ID)
+	h.rtr.InvalidateConfig()
+	h.audit(r.Context(), r, "model_catalog.upsert", "model_catalog", in.ID, in)
+	writeJSON(w, http.StatusOK, in)
+}
+
+func (h *Handler) deleteModelCatalog(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid id"})
+		return
+	}
+	if _, err := h.db.ExecContext(r.Context(), `DELETE FROM model_catalog WHERE id=?`, id); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	h.rtr.InvalidateConfig()
+	h.audit(r.Context(), r, "model_catalog.delete", "model_catalog", id, nil)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func intPtr(v sql.NullInt64) *int {
+	if !v.Valid {
+		return nil
+	}
+	n := int(v.Int64)
+	return &n
+}
+
+func boolPtr(v sql.NullBool) *bool {
+	if !v.Valid {
+		return nil
+	}
+	b := v.Bool
+	return &b
+}
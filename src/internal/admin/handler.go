@@ -24,6 +24,9 @@ type Handler struct {
 	cipher     *crypto.AESGCM
 	bus        *logbus.Bus
 	adminToken string
+
+	modelWebhook string
+	replay       http.Handler
 }
 
 func NewHandler(db *sql.DB, rtr *router.Router, m *metrics.Metrics, cipher *crypto.AESGCM, bus *logbus.Bus, adminToken string) *Handler {
@@ -48,21 +51,39 @@ func (h *Handler) Routes() chi.Router {
 			r.Put("/credentials/{id}", h.updateCredential)
 			r.Delete("/credentials/{id}", h.deleteCredential)
 			r.Post("/credentials/{id}/test", h.testCredential)
+			r.Get("/credentials/state", h.listCredentialStates)
+			r.Get("/credentials/disabled", h.listDisabledCredentials)
+			r.Post("/credentials/{id}/circuit/open", h.openCredentialCircuit)
+			r.Post("/credentials/{id}/circuit/close", h.closeCredentialCircuit)
+			r.Post("/credentials/{id}/stats/reset", h.resetCredentialStats)
+			r.Get("/credentials/{id}/probes", h.listCredentialProbes)
 
 			r.Get("/pools", h.listPools)
 			r.Post("/pools", h.createPool)
 			r.Put("/pools/{id}", h.updatePool)
 			r.Delete("/pools/{id}", h.deletePool)
 			r.Post("/pools/{id}/test", h.testPool)
+			r.Get("/pools/{id}/shadow", h.getPoolShadow)
+			r.Get("/pools/{id}/splits", h.getPoolSplits)
+			r.Post("/pools/{id}/splits/reset", h.resetPoolSplits)
 
 			r.Get("/providers/{id}/models", h.getProviderModels)
 			r.Post("/providers/{id}/models/refresh", h.refreshProviderModels)
+			r.Get("/providers/{id}/models/snapshots", h.listModelSnapshots)
+			r.Get("/models/stale", h.listStaleModelMappings)
+			r.Get("/models/catalog", h.listModelCatalog)
+			r.Post("/models/catalog", h.upsertModelCatalog)
+			r.Delete("/models/catalog/{id}", h.deleteModelCatalog)
 			r.Post("/providers/{id}/credentials/test", h.testProviderCredentials)
 
 			r.Get("/logs", h.listLogs)
 			r.Get("/logs/stream", h.logsStream)
+			r.Get("/captures/{request_id}", h.getCaptures)
+			r.Post("/captures/{request_id}/replay", h.replayCapture)
 			r.Get("/stats", h.getStats)
 			r.Get("/stats/detailed", h.getDetailedStats)
+
+			r.Get("/audit", h.listAudit)
 		})
 	})
 	r.Get("/healthz", func(w http.ResponseWriter, _ *http.Request) {
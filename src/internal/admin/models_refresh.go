@@ -0,0 +1,305 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"claude-gateway/src/internal/logbus"
+	"claude-gateway/src/internal/router"
+)
+
+const modelRefresherTick = time.Minute
+
+type modelDiff struct {
+	ProviderID   uint64            `json:"provider_id"`
+	ProviderType string            `json:"provider_type"`
+	Added        []string          `json:"added"`
+	Removed      []string          `json:"removed"`
+	Count        int               `json:"count"`
+	Stale        []staleMappingDTO `json:"stale_mappings,omitempty"`
+	TS           time.Time         `json:"ts"`
+}
+
+type staleMappingDTO struct {
+	Scope   string `json:"scope"`
+	OwnerID uint64 `json:"owner_id"`
+	Alias   string `json:"alias"`
+	Target  string `json:"target"`
+}
+
+func (d modelDiff) changed() bool {
+	return len(d.Added) > 0 || len(d.Removed) > 0
+}
+
+// SetModelDiffWebhook configures a URL that receives a JSON POST whenever a
+// provider's model list changes. It must be called before serving.
+func (h *Handler) SetModelDiffWebhook(url string) {
+	h.modelWebhook = strings.TrimSpace(url)
+}
+
+// RunModelRefresher refreshes the model list of every provider that has
+// models_refresh_interval_sec set. It blocks until ctx is cancelled.
+func (h *Handler) RunModelRefresher(ctx context.Context) {
+	t := time.NewTicker(modelRefresherTick)
+	defer t.Stop()
+	for {
+		h.refreshDueProviders(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (h *Handler) refreshDueProviders(ctx context.Context) {
+	rows, err := h.db.QueryContext(ctx,
+		`SELECT id FROM providers
+		 WHERE enabled = 1 AND models_refresh_interval_sec > 0
+		   AND (models_refreshed_at IS NULL OR TIMESTAMPDIFF(SECOND, models_refreshed_at, NOW()) >= models_refresh_interval_sec)`)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("model refresher: failed to load providers: %v", err)
+		}
+		return
+	}
+	var ids []uint64
+	for rows.Next() {
+		var id uint64
+		if err := rows.Scan(&id); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	rows.Close()
+
+	for _, id := range ids {
+		if ctx.Err() != nil {
+			return
+		}
+		if err := h.refreshProviderModelsOnce(ctx, id); err != nil {
+			log.Printf("model refresher: provider %d: %v", id, err)
+		}
+	}
+}
+
+func (h *Handler) refreshProviderModelsOnce(ctx context.Context, providerID uint64) error {
+	prov, err := h.loadProvider(ctx, providerID)
+	if err != nil {
+		return err
+	}
+	var credID uint64
+	if err := h.db.QueryRowContext(ctx, `SELECT id FROM credentials WHERE provider_id=? AND enabled=1 ORDER BY id DESC LIMIT 1`, providerID).Scan(&credID); err != nil {
+		return fmt.Errorf("no enabled credential: %w", err)
+	}
+	key, err := h.decryptCredentialKey(ctx, credID)
+	if err != nil {
+		return err
+	}
+	fctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	modelIDs, raw, status, err := h.fetchProviderModels(fctx, prov, key)
+	if err != nil {
+		// Record the attempt so a broken provider is retried on its interval
+		// rather than every tick.
+		_, _ = h.db.ExecContext(ctx, `UPDATE providers SET models_refreshed_at=NOW() WHERE id=?`, providerID)
+		return fmt.Errorf("fetch models (status %d): %w", status, err)
+	}
+	_, err = h.storeModelRefresh(ctx, prov, modelIDs, raw)
+	return err
+}
+
+// storeModelRefresh saves a freshly fetched model list, records a snapshot
+// when it differs from the previous one and announces the diff.
+func (h *Handler) storeModelRefresh(ctx context.Context, prov loadedProvider, modelIDs []string, raw []byte) (modelDiff, error) {
+	var prevJSON []byte
+	_ = h.db.QueryRowContext(ctx, `SELECT models_json FROM providers WHERE id=?`, prov.ID).Scan(&prevJSON)
+	prev := parseModelIDs(prevJSON)
+
+	modelsJSON := raw
+	if modelsJSON == nil {
+		modelsJSON, _ = json.Marshal(modelIDs)
+	}
+	if _, err := h.db.ExecContext(ctx, `UPDATE providers SET models_json=?, models_refreshed_at=NOW() WHERE id=?`, modelsJSON, prov.ID); err != nil {
+		return modelDiff{}, err
+	}
+
+	added, removed := diffModelIDs(prev, modelIDs)
+	diff := modelDiff{
+		ProviderID:   prov.ID,
+		ProviderType: prov.Type,
+		Added:        added,
+		Removed:      removed,
+		Count:        len(modelIDs),
+		TS:           time.Now().UTC(),
+	}
+	if len(prevJSON) > 0 && !diff.changed() {
+		return diff, nil
+	}
+
+	idsJSON, _ := json.Marshal(modelIDs)
+	addedJSON, _ := json.Marshal(added)
+	removedJSON, _ := json.Marshal(removed)
+	if _, err := h.db.ExecContext(ctx,
+		`INSERT INTO provider_model_snapshots(provider_id, models_json, added_json, removed_json) VALUES (?,?,?,?)`,
+		prov.ID, idsJSON, addedJSON, removedJSON); err != nil {
+		log.Printf("failed to store model snapshot for provider %d: %v", prov.ID, err)
+	}
+
+	h.rtr.InvalidateConfig()
+	if stale, err := h.rtr.StaleModelMappings(ctx); err == nil {
+		diff.Stale = toStaleMappingDTOs(stale)
+	}
+	if diff.changed() {
+		h.announceModelDiff(diff)
+	}
+	return diff, nil
+}
+
+func (h *Handler) announceModelDiff(diff modelDiff) {
+	detail, _ := json.Marshal(diff)
+	if h.bus != nil {
+		h.bus.Publish(logbus.Event{
+			TS:           diff.TS,
+			Kind:         "model_diff",
+			ProviderID:   diff.ProviderID,
+			ProviderType: diff.ProviderType,
+			Detail:       detail,
+		})
+	}
+	if h.modelWebhook == "" {
+		return
+	}
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.modelWebhook, bytes.NewReader(detail))
+		if err != nil {
+			log.Printf("model diff webhook: %v", err)
+			return
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			log.Printf("model diff webhook: %v", err)
+			return
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode >= 300 {
+			log.Printf("model diff webhook: status %d", resp.StatusCode)
+		}
+	}()
+}
+
+func (h *Handler) listModelSnapshots(w http.ResponseWriter, r *http.Request) {
+	providerID, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid id"})
+		return
+	}
+	limit := 50
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = n
+		}
+	}
+	rows, err := h.db.QueryContext(r.Context(),
+		`SELECT id, ts, models_json, added_json, removed_json FROM provider_model_snapshots WHERE provider_id=? ORDER BY id DESC LIMIT ?`,
+		providerID, limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type snapshot struct {
+		ID      uint64          `json:"id"`
+		TS      string          `json:"ts"`
+		Models  json.RawMessage `json:"models"`
+		Added   json.RawMessage `json:"added,omitempty"`
+		Removed json.RawMessage `json:"removed,omitempty"`
+	}
+	out := []snapshot{}
+	for rows.Next() {
+		var (
+			s       snapshot
+			ts      time.Time
+			added   []byte
+			removed []byte
+		)
+		if err := rows.Scan(&s.ID, &ts, &s.Models, &added, &removed); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		s.TS = ts.UTC().Format(time.RFC3339)
+		if len(added) > 0 {
+			s.Added = added
+		}
+		if len(removed) > 0 {
+			s.Removed = removed
+		}
+		out = append(out, s)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (h *Handler) listStaleModelMappings(w http.ResponseWriter, r *http.Request) {
+	stale, err := h.rtr.StaleModelMappings(r.Context())
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, toStaleMappingDTOs(stale))
+}
+
+func toStaleMappingDTOs(in []router.StaleMapping) []staleMappingDTO {
+	out := make([]staleMappingDTO, 0, len(in))
+	for _, s := range in {
+		out = append(out, staleMappingDTO{Scope: s.Scope, OwnerID: s.OwnerID, Alias: s.Alias, Target: s.Target})
+	}
+	return out
+}
+
+// parseModelIDs accepts both a plain JSON array of ids and an upstream
+// {"data":[{"id":...}]} listing, the two shapes stored in models_json.
+func parseModelIDs(raw []byte) []string {
+	if len(raw) == 0 {
+		return nil
+	}
+	var ids []string
+	if err := json.Unmarshal(raw, &ids); err == nil {
+		return ids
+	}
+	return parseModelIDsFromDataList(raw)
+}
+
+func diffModelIDs(prev, next []string) (added, removed []string) {
+	prevSet := make(map[string]bool, len(prev))
+	for _, id := range prev {
+		prevSet[id] = true
+	}
+	nextSet := make(map[string]bool, len(next))
+	for _, id := range next {
+		nextSet[id] = true
+		if !prevSet[id] {
+			added = append(added, id)
+		}
+	}
+	for _, id := range prev {
+		if !nextSet[id] {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(added)
+	sort.Strings(removed)
+	return added, removed
+}
@@ -0,0 +1,15 @@
+package admin
+
+import "testing"
+
+func TestDiffModelIDs(t *testing.T) {
+	prev := parseModelIDs([]byte(`{"data":[{"id":"claude-3-opus"},{"id":"claude-3-5-sonnet"}]}`))
+	next := parseModelIDs([]byte(`["claude-3-5-sonnet","claude-sonnet-4"]`))
+	added, removed := diffModelIDs(prev, next)
+	if len(added) != 1 || added[0] != "claude-sonnet-4" {
+		t.Fatalf("unexpected added: %#v", added)
+	}
+	if len(removed) != 1 || removed[0] != "claude-3-opus" {
+		t.Fatalf("unexpected removed: %#v", removed)
+	}
+}
@@ -0,0 +1,222 @@
+package admin
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+)
+
+const (
+	proberTick        = 15 * time.Second
+	proberConcurrency = 4
+	proberTimeout     = 20 * time.Second
+	// Credentials in an automatic cooldown are probed more often than their
+	// provider's interval so they can rejoin rotation as soon as they recover.
+	cooldownProbeEvery = 30 * time.Second
+	probeRetention     = 30 * 24 * time.Hour
+)
+
+type probeTarget struct {
+	CredentialID uint64
+	ProviderID   uint64
+	Model        string
+}
+
+// RunProber periodically probes the credentials of every provider that has a
+// probe interval configured. It blocks until ctx is cancelled.
+func (h *Handler) RunProber(ctx context.Context) {
+	t := time.NewTicker(proberTick)
+	defer t.Stop()
+	var lastPrune time.Time
+	for {
+		h.probeDueCredentials(ctx)
+		if time.Since(lastPrune) > time.Hour {
+			if _, err := h.db.ExecContext(ctx, `DELETE FROM credential_probe_history WHERE ts < ?`, time.Now().Add(-probeRetention)); err != nil {
+				log.Printf("failed to prune probe history: %v", err)
+			}
+			lastPrune = time.Now()
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+func (h *Handler) probeDueCredentials(ctx context.Context) {
+	targets, err := h.dueProbeTargets(ctx, time.Now())
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Printf("prober: failed to load credentials: %v", err)
+		}
+		return
+	}
+
+	sem := make(chan struct{}, proberConcurrency)
+	var wg sync.WaitGroup
+	for _, t := range targets {
+		select {
+		case <-ctx.Done():
+			wg.Wait()
+			return
+		case sem <- struct{}{}:
+		}
+		wg.Add(1)
+		go func(t probeTarget) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			h.runProbe(ctx, t)
+		}(t)
+	}
+	wg.Wait()
+}
+
+func (h *Handler) dueProbeTargets(ctx context.Context, now time.Time) ([]probeTarget, error) {
+	rows, err := h.db.QueryContext(ctx,
+		`SELECT c.id, c.provider_id, p.probe_interval_sec, p.probe_model, c.last_test_at
+		 FROM credentials c JOIN providers p ON p.id = c.provider_id
+		 WHERE c.enabled = 1 AND p.enabled = 1 AND p.probe_interval_sec > 0`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var out []probeTarget
+	for rows.Next() {
+		var (
+			t        probeTarget
+			every    int64
+			model    sql.NullString
+			lastTest sql.NullTime
+		)
+		if err := rows.Scan(&t.CredentialID, &t.ProviderID, &every, &model, &lastTest); err != nil {
+			return nil, err
+		}
+		st := h.rtr.CredentialStateOf(t.CredentialID)
+		cooling := st.Open && !st.ManualOpen
+		if !probeDue(now, lastTest.Time, time.Duration(every)*time.Second, cooling) {
+			continue
+		}
+		t.Model = strings.TrimSpace(model.String)
+		out = append(out, t)
+	}
+	return out, rows.Err()
+}
+
+func probeDue(now, lastTest time.Time, every time.Duration, cooling bool) bool {
+	if lastTest.IsZero() {
+		return true
+	}
+	since := now.Sub(lastTest)
+	if cooling && since >= cooldownProbeEvery {
+		return true
+	}
+	return since >= every
+}
+
+func (h *Handler) runProbe(ctx context.Context, t probeTarget) {
+	provID, prov, key, err := h.loadCredentialAndProvider(ctx, t.CredentialID)
+	if err != nil {
+		log.Printf("prober: credential %d: %v", t.CredentialID, err)
+		return
+	}
+	model := t.Model
+	if model == "" {
+		model = strings.TrimSpace(h.pickProviderTestModel(ctx, provID))
+	}
+
+	start := time.Now()
+	pctx, cancel := context.WithTimeout(ctx, proberTimeout)
+	ok, status, errMsg, ttft, tps := h.probeUpstream(pctx, prov, key, model)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	lat := time.Since(start).Milliseconds()
+
+	h.rtr.ObserveProbe(t.CredentialID, ok && status != 0, status)
+	if err := h.persistCredentialTestResult(ctx, t.CredentialID, ok, status, lat, ttft, tps, errMsg, model); err != nil {
+		log.Printf("prober: failed to store result for credential %d: %v", t.CredentialID, err)
+	}
+	if len(errMsg) > 1000 {
+		errMsg = errMsg[:1000]
+	}
+	_, err = h.db.ExecContext(ctx,
+		`INSERT INTO credential_probe_history(credential_id, provider_id, ok, status, latency_ms, ttft_ms, tps, model, error) VALUES (?,?,?,?,?,?,?,?,?)`,
+		t.CredentialID, provID, ok && status != 0, nullInt(status), nullInt64(lat), nullInt64(ttft), tps, nullStr(model), nullStr(errMsg))
+	if err != nil {
+		log.Printf("prober: failed to store history for credential %d: %v", t.CredentialID, err)
+	}
+}
+
+func (h *Handler) listCredentialProbes(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid id"})
+		return
+	}
+	hours := 24
+	if v := r.URL.Query().Get("hours"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			hours = n
+		}
+	}
+	limit := 500
+	if v := r.URL.Query().Get("limit"); v != "" {
+		if n, err := strconv.Atoi(v); err == nil && n > 0 {
+			limit = n
+		}
+	}
+
+	rows, err := h.db.QueryContext(r.Context(),
+		`SELECT ts, ok, status, latency_ms, ttft_ms, tps, model, error FROM credential_probe_history
+		 WHERE credential_id = ? AND ts > ? ORDER BY ts DESC LIMIT ?`,
+		id, time.Now().Add(-time.Duration(hours)*time.Hour), limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	type probeEntry struct {
+		TS        string  `json:"ts"`
+		OK        bool    `json:"ok"`
+		Status    int     `json:"status,omitempty"`
+		LatencyMs int64   `json:"latency_ms,omitempty"`
+		TTFTMs    int64   `json:"ttft_ms,omitempty"`
+		TPS       float64 `json:"tps,omitempty"`
+		Model     string  `json:"model,omitempty"`
+		Error     string  `json:"error,omitempty"`
+	}
+	out := []probeEntry{}
+	for rows.Next() {
+		var (
+			e                probeEntry
+			ts               time.Time
+			status, lat, ttf sql.NullInt64
+			tps              sql.NullFloat64
+			model, errMsg    sql.NullString
+		)
+		if err := rows.Scan(&ts, &e.OK, &status, &lat, &ttf, &tps, &model, &errMsg); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		e.TS = ts.UTC().Format(time.RFC3339)
+		e.Status = int(status.Int64)
+		e.LatencyMs = lat.Int64
+		e.TTFTMs = ttf.Int64
+		e.TPS = tps.Float64
+		e.Model = model.String
+		e.Error = errMsg.String
+		out = append(out, e)
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"credential_id": id, "hours": hours, "items": out})
+}
@@ -0,0 +1,24 @@
+package admin
+
+import (
+	"testing"
+	"time"
+)
+
+func TestProbeDue(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	every := 10 * time.Minute
+
+	if !probeDue(now, time.Time{}, every, false) {
+		t.Fatalf("expected never-tested credential to be due")
+	}
+	if probeDue(now, now.Add(-time.Minute), every, false) {
+		t.Fatalf("expected healthy credential to wait for its interval")
+	}
+	if !probeDue(now, now.Add(-time.Minute), every, true) {
+		t.Fatalf("expected cooling credential to be probed early")
+	}
+	if probeDue(now, now.Add(-10*time.Second), every, true) {
+		t.Fatalf("expected cooling credential probes to be spaced out")
+	}
+}
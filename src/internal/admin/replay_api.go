@@ -0,0 +1,330 @@
+package admin
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/google/uuid"
+
+	"claude-gateway/src/internal/canonical"
+	"claude-gateway/src/internal/capture"
+	"claude-gateway/src/internal/convert"
+	"claude-gateway/src/internal/rawjson"
+	"claude-gateway/src/internal/router"
+	"claude-gateway/src/internal/streamconv"
+)
+
+// SetReplayHandler sets the handler of the client API (/v1/...), through
+// which captured requests are replayed. It must be called before serving.
+func (h *Handler) SetReplayHandler(api http.Handler) {
+	h.replay = api
+}
+
+type replayRequest struct {
+	// PoolID defaults to the pool of the original request. ProviderID and
+	// CredentialID pin the replay to an upstream outside the pool's choice.
+	PoolID       uint64 `json:"pool_id,omitempty"`
+	ProviderID   uint64 `json:"provider_id,omitempty"`
+	CredentialID uint64 `json:"credential_id,omitempty"`
+	Model        string `json:"model,omitempty"`
+	TimeoutMs    int    `json:"timeout_ms,omitempty"`
+}
+
+// replayResult is what a response amounts to for comparison.
+type replayResult struct {
+	RequestID    string          `json:"request_id"`
+	Status       int             `json:"status"`
+	StopReason   string          `json:"stop_reason"`
+	ToolCalls    []replayToolUse `json:"tool_calls"`
+	InputTokens  int             `json:"input_tokens"`
+	OutputTokens int             `json:"output_tokens"`
+	LatencyMs    int64           `json:"latency_ms"`
+	Error        string          `json:"error,omitempty"`
+}
+
+type replayToolUse struct {
+	Name  string          `json:"name"`
+	Input json.RawMessage `json:"input"`
+}
+
+type replayFieldDiff struct {
+	Field    string `json:"field"`
+	Original any    `json:"original"`
+	Replay   any    `json:"replay"`
+}
+
+// replayCapture re-runs a captured request, as a test request that skips
+// the response cache, and compares its response with the original's.
+// Requests are only retained on pools with a capture rate, and are not
+// replayed once redaction has changed them.
+func (h *Handler) replayCapture(w http.ResponseWriter, r *http.Request) {
+	if h.replay == nil {
+		writeJSON(w, http.StatusNotImplemented, map[string]any{"error": "replay is not configured"})
+		return
+	}
+	requestID := strings.TrimSpace(chi.URLParam(r, "request_id"))
+	var in replayRequest
+	if err := readOptionalJSON(r, &in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	timeout := 5 * time.Minute
+	if in.TimeoutMs > 0 {
+		timeout = time.Duration(in.TimeoutMs) * time.Millisecond
+	}
+
+	recs, err := capture.Load(r.Context(), h.db, requestID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	if len(recs) == 0 {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "request was not captured; set a capture rate on its pool"})
+		return
+	}
+	first, last := recs[0], recs[len(recs)-1]
+	if first.Endpoint == "" || first.ClientRequest == "" {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": "capture has no client request to replay"})
+		return
+	}
+	if first.ClientRequestRedacted {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": "captured client request was altered by redaction"})
+		return
+	}
+	if capture.Truncated(first.ClientRequest) {
+		writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": "captured client request was truncated"})
+		return
+	}
+
+	poolID := in.PoolID
+	if poolID == 0 {
+		poolID = first.PoolID
+	}
+	var clientKey string
+	if err := h.db.QueryRowContext(r.Context(), `SELECT client_key FROM pools WHERE id=?`, poolID).Scan(&clientKey); err != nil {
+		writeJSON(w, http.StatusNotFound, map[string]any{"error": "pool not found"})
+		return
+	}
+
+	body := []byte(first.ClientRequest)
+	if m := strings.TrimSpace(in.Model); m != "" {
+		if body, err = rawjson.Set(body, "model", m); err != nil {
+			writeJSON(w, http.StatusUnprocessableEntity, map[string]any{"error": "captured client request is not a JSON object"})
+			return
+		}
+	}
+
+	orig := summarizeResponse(first.Endpoint, []byte(last.ClientResponse))
+	orig.RequestID = requestID
+	h.loadOriginalOutcome(r.Context(), requestID, &orig)
+
+	ctx, cancel := context.WithTimeout(r.Context(), timeout)
+	defer cancel()
+	ctx = context.WithValue(ctx, canonical.ContextKeyClientKey, strings.TrimSpace(clientKey))
+	ctx = router.WithPin(ctx, router.Pin{ProviderID: in.ProviderID, CredentialID: in.CredentialID})
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, first.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	replayID := uuid.NewString()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(clientKey))
+	req.Header.Set("X-Gateway-Test", "1")
+	req.Header.Set("Cache-Control", "no-cache")
+	req.Header.Set("x-request-id", replayID)
+
+	rec := &replayRecorder{header: http.Header{}}
+	start := time.Now()
+	h.replay.ServeHTTP(rec, req)
+
+	got := summarizeResponse(first.Endpoint, rec.body.Bytes())
+	got.RequestID = replayID
+	got.Status = rec.statusCode()
+	got.LatencyMs = time.Since(start).Milliseconds()
+
+	writeJSON(w, http.StatusOK, map[string]any{
+		"endpoint": first.Endpoint,
+		"target": map[string]any{
+			"pool_id":       poolID,
+			"provider_id":   in.ProviderID,
+			"credential_id": in.CredentialID,
+			"model":         in.Model,
+		},
+		"original": orig,
+		"replay":   got,
+		"diff":     diffReplay(orig, got),
+	})
+}
+
+// loadOriginalOutcome fills in what the request log knows about the
+// original request's final attempt.
+func (h *Handler) loadOriginalOutcome(ctx context.Context, requestID string, res *replayResult) {
+	var status, latency, in, out sql.NullInt64
+	err := h.db.QueryRowContext(ctx,
+		`SELECT status, latency_ms, input_tokens, output_tokens FROM request_logs WHERE request_id=? ORDER BY id DESC LIMIT 1`,
+		requestID).Scan(&status, &latency, &in, &out)
+	if err != nil {
+		return
+	}
+	res.Status = int(status.Int64)
+	res.LatencyMs = latency.Int64
+	if res.InputTokens == 0 {
+		res.InputTokens = int(in.Int64)
+	}
+	if res.OutputTokens == 0 {
+		res.OutputTokens = int(out.Int64)
+	}
+}
+
+// summarizeResponse reads a client response of endpoint, streamed or not.
+func summarizeResponse(endpoint string, body []byte) replayResult {
+	res := replayResult{ToolCalls: []replayToolUse{}}
+	resp, err := parseClientResponse(endpoint, body)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+	res.StopReason = resp.StopReason
+	if u := resp.Usage; u != nil {
+		res.InputTokens, res.OutputTokens = u.InputTokens, u.OutputTokens
+	}
+	for _, blk := range resp.Content {
+		if blk.Type == canonical.BlockToolUse {
+			res.ToolCalls = append(res.ToolCalls, replayToolUse{Name: blk.Name, Input: blk.Input})
+		}
+	}
+	return res
+}
+
+func parseClientResponse(endpoint string, body []byte) (canonical.Response, error) {
+	trimmed := bytes.TrimSpace(body)
+	if len(trimmed) == 0 {
+		return canonical.Response{}, errors.New("empty response")
+	}
+	if trimmed[0] != '{' {
+		if endpoint == "/v1/messages" {
+			return streamconv.Collect(bytes.NewReader(body), streamconv.DecodeAnthropic)
+		}
+		if endpoint == "/v1/chat/completions" {
+			return streamconv.Collect(bytes.NewReader(body), streamconv.DecodeOpenAI)
+		}
+		return canonical.Response{}, errors.New("cannot read a streamed response of " + endpoint)
+	}
+
+	var e struct {
+		Error *struct {
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if json.Unmarshal(body, &e) == nil && e.Error != nil {
+		return canonical.Response{}, errors.New(e.Error.Message)
+	}
+	switch endpoint {
+	case "/v1/messages":
+		var ar convert.AnthropicMessageResponse
+		if err := json.Unmarshal(body, &ar); err != nil {
+			return canonical.Response{}, err
+		}
+		return convert.AnthropicResponseToCanonical(ar), nil
+	case "/v1/chat/completions":
+		var or convert.OpenAIChatCompletionResponse
+		if err := json.Unmarshal(body, &or); err != nil {
+			return canonical.Response{}, err
+		}
+		return convert.OpenAIResponseToCanonical(or), nil
+	case "/v1/responses":
+		var rr convert.OpenAIResponsesResponse
+		if err := json.Unmarshal(body, &rr); err != nil {
+			return canonical.Response{}, err
+		}
+		return responsesToCanonical(rr), nil
+	}
+	return canonical.Response{}, errors.New("unknown endpoint " + endpoint)
+}
+
+// responsesToCanonical keeps what a replay compares of a Responses API
+// response, which carries no stop reason of its own.
+func responsesToCanonical(rr convert.OpenAIResponsesResponse) canonical.Response {
+	resp := canonical.Response{StopReason: "end_turn"}
+	for _, item := range rr.Output {
+		if item.Type == "function_call" {
+			resp.Content = append(resp.Content, canonical.ContentBlock{Type: canonical.BlockToolUse, Name: item.Name, Input: json.RawMessage(item.Arguments)})
+			resp.StopReason = "tool_use"
+		}
+	}
+	if u := rr.Usage; u != nil {
+		resp.Usage = &canonical.Usage{InputTokens: u.InputTokens, OutputTokens: u.OutputTokens}
+	}
+	return resp
+}
+
+// diffReplay lists the compared fields whose values differ.
+func diffReplay(orig, got replayResult) []replayFieldDiff {
+	out := []replayFieldDiff{}
+	add := func(field string, a, b any) {
+		if !reflect.DeepEqual(a, b) {
+			out = append(out, replayFieldDiff{Field: field, Original: a, Replay: b})
+		}
+	}
+	add("status", orig.Status, got.Status)
+	add("stop_reason", orig.StopReason, got.StopReason)
+	add("tool_calls", normalizeToolCalls(orig.ToolCalls), normalizeToolCalls(got.ToolCalls))
+	add("input_tokens", orig.InputTokens, got.InputTokens)
+	add("output_tokens", orig.OutputTokens, got.OutputTokens)
+	add("latency_ms", orig.LatencyMs, got.LatencyMs)
+	return out
+}
+
+// normalizeToolCalls decodes tool inputs so that calls differing only in
+// JSON formatting compare equal.
+func normalizeToolCalls(calls []replayToolUse) []map[string]any {
+	out := make([]map[string]any, 0, len(calls))
+	for _, c := range calls {
+		var input any
+		if json.Unmarshal(c.Input, &input) != nil {
+			input = string(c.Input)
+		}
+		out = append(out, map[string]any{"name": c.Name, "input": input})
+	}
+	return out
+}
+
+// replayRecorder keeps the replayed response.
+type replayRecorder struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (r *replayRecorder) Header() http.Header { return r.header }
+
+func (r *replayRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *replayRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.body.Write(b)
+}
+
+func (r *replayRecorder) Flush() {}
+
+func (r *replayRecorder) statusCode() int {
+	if r.status == 0 {
+		return http.StatusOK
+	}
+	return r.status
+}
@@ -0,0 +1,48 @@
+package admin
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSummarizeAndDiffReplay(t *testing.T) {
+	orig := summarizeResponse("/v1/messages", []byte(`{"id":"m1","type":"message","role":"assistant","model":"x","content":[{"type":"tool_use","id":"t1","name":"get_weather","input":{"city":"Paris","unit":"c"}}],"stop_reason":"tool_use","usage":{"input_tokens":12,"output_tokens":7}}`))
+	if orig.StopReason != "tool_use" || len(orig.ToolCalls) != 1 || orig.InputTokens != 12 || orig.OutputTokens != 7 {
+		t.Fatalf("summary = %+v", orig)
+	}
+
+	stream := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"id\":\"m2\",\"usage\":{\"input_tokens\":12,\"output_tokens\":0}}}\n\n" +
+		"event: content_block_start\ndata: {\"type\":\"content_block_start\",\"index\":0,\"content_block\":{\"type\":\"tool_use\",\"id\":\"t2\",\"name\":\"get_weather\",\"input\":{}}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"input_json_delta\",\"partial_json\":\"{\\\"unit\\\": \\\"c\\\", \\\"city\\\": \\\"Paris\\\"}\"}}\n\n" +
+		"event: content_block_stop\ndata: {\"type\":\"content_block_stop\",\"index\":0}\n\n" +
+		"event: message_delta\ndata: {\"type\":\"message_delta\",\"delta\":{\"stop_reason\":\"tool_use\"},\"usage\":{\"output_tokens\":9}}\n\n" +
+		"event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+	got := summarizeResponse("/v1/messages", []byte(stream))
+	if got.Error != "" {
+		t.Fatalf("stream summary error: %s", got.Error)
+	}
+
+	diff := diffReplay(orig, got)
+	if len(diff) != 1 || diff[0].Field != "output_tokens" {
+		t.Fatalf("diff = %+v", diff)
+	}
+
+	failed := summarizeResponse("/v1/chat/completions", []byte(`{"error":{"message":"upstream error: overloaded","type":"server_error"}}`))
+	if failed.Error != "upstream error: overloaded" {
+		t.Fatalf("error summary = %+v", failed)
+	}
+}
+
+func TestReadOptionalJSON(t *testing.T) {
+	var in replayRequest
+	if err := readOptionalJSON(httptest.NewRequest("POST", "/", strings.NewReader("")), &in); err != nil {
+		t.Fatalf("empty body rejected: %v", err)
+	}
+	if err := readOptionalJSON(httptest.NewRequest("POST", "/", strings.NewReader(`{"pool_id":`)), &in); err == nil {
+		t.Fatal("malformed body accepted")
+	}
+	if err := readOptionalJSON(httptest.NewRequest("POST", "/", strings.NewReader(`{"pool_id":3}`)), &in); err != nil || in.PoolID != 3 {
+		t.Fatalf("pool_id = %d, %v", in.PoolID, err)
+	}
+}
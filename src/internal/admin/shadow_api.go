@@ -0,0 +1,46 @@
+package admin
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"claude-gateway/src/internal/shadow"
+)
+
+// getPoolShadow compares a pool's primary traffic with its shadow over the
+// last hours (default 24) and lists the latest shadow results.
+func (h *Handler) getPoolShadow(w http.ResponseWriter, r *http.Request) {
+	poolID, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid id"})
+		return
+	}
+	hours := 24
+	if v, err := strconv.Atoi(r.URL.Query().Get("hours")); err == nil && v > 0 {
+		hours = v
+	}
+	limit := 20
+	if v, err := strconv.Atoi(r.URL.Query().Get("limit")); err == nil && v >= 0 && v <= 200 {
+		limit = v
+	}
+
+	rep, err := shadow.LoadReport(r.Context(), h.db, poolID, time.Now().Add(-time.Duration(hours)*time.Hour))
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	recent, err := shadow.LoadRecent(r.Context(), h.db, poolID, limit)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{
+		"pool_id": poolID,
+		"hours":   hours,
+		"summary": rep,
+		"recent":  recent,
+	})
+}
@@ -0,0 +1,38 @@
+package admin
+
+import (
+	"net/http"
+
+	"github.com/go-chi/chi/v5"
+)
+
+// getPoolSplits reports how a pool's traffic splits are running: each
+// arm's recent results and whether the canary was rolled back.
+func (h *Handler) getPoolSplits(w http.ResponseWriter, r *http.Request) {
+	poolID, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid id"})
+		return
+	}
+	splits, err := h.rtr.SplitStatusOf(r.Context(), poolID)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	writeJSON(w, http.StatusOK, splits)
+}
+
+// resetPoolSplits undoes rollbacks of a pool's canaries and starts their
+// comparison with control over.
+func (h *Handler) resetPoolSplits(w http.ResponseWriter, r *http.Request) {
+	poolID, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid id"})
+		return
+	}
+	if err := h.rtr.ResetSplits(r.Context(), poolID); err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	h.getPoolSplits(w, r)
+}
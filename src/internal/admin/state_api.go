@@ -0,0 +1,201 @@
+package admin
+
+import (
+	"database/sql"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+
+	"claude-gateway/src/internal/router"
+)
+
+type credentialStateDTO struct {
+	CredentialID  uint64  `json:"credential_id"`
+	ProviderID    uint64  `json:"provider_id,omitempty"`
+	Name          string  `json:"name,omitempty"`
+	Enabled       bool    `json:"enabled"`
+	Inflight      int64   `json:"inflight"`
+	Successes     int64   `json:"successes"`
+	Failures      int     `json:"failures"`
+	Total         int64   `json:"total"`
+	EWMALatencyMs float64 `json:"ewma_latency_ms"`
+	LastLatencyMs int64   `json:"last_latency_ms,omitempty"`
+	LastStatus    int     `json:"last_status,omitempty"`
+	LastSeen      string  `json:"last_seen,omitempty"`
+	LastErrorAt   string  `json:"last_error_at,omitempty"`
+	Open          bool    `json:"open"`
+	OpenUntil     string  `json:"open_until,omitempty"`
+	ManualOpen    bool    `json:"manual_open,omitempty"`
+	ManualUntil   string  `json:"manual_until,omitempty"`
+	AdaptiveLimit float64 `json:"adaptive_limit,omitempty"`
+}
+
+func toCredentialStateDTO(st router.CredentialState) credentialStateDTO {
+	return credentialStateDTO{
+		CredentialID:  st.CredentialID,
+		Inflight:      st.Inflight,
+		Successes:     st.Successes,
+		Failures:      st.Failures,
+		Total:         st.Total,
+		EWMALatencyMs: st.EWMALatencyMs,
+		LastLatencyMs: st.LastLatencyMs,
+		LastStatus:    st.LastStatus,
+		LastSeen:      formatTime(st.LastSeen),
+		LastErrorAt:   formatTime(st.LastErrorAt),
+		Open:          st.Open,
+		OpenUntil:     formatTime(st.OpenUntil),
+		ManualOpen:    st.ManualOpen,
+		ManualUntil:   formatTime(st.ManualUntil),
+		AdaptiveLimit: st.AdaptiveLimit,
+	}
+}
+
+func (h *Handler) listCredentialStates(w http.ResponseWriter, r *http.Request) {
+	q := `SELECT id, provider_id, name, enabled FROM credentials`
+	var args []any
+	if v := strings.TrimSpace(r.URL.Query().Get("provider_id")); v != "" {
+		pid, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid provider_id"})
+			return
+		}
+		q += ` WHERE provider_id = ?`
+		args = append(args, pid)
+	}
+	q += ` ORDER BY id DESC`
+	rows, err := h.db.QueryContext(r.Context(), q, args...)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []credentialStateDTO{}
+	for rows.Next() {
+		var (
+			id, provID uint64
+			name       string
+			enabled    bool
+		)
+		if err := rows.Scan(&id, &provID, &name, &enabled); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		dto := toCredentialStateDTO(h.rtr.CredentialStateOf(id))
+		dto.ProviderID = provID
+		dto.Name = name
+		dto.Enabled = enabled
+		out = append(out, dto)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+type circuitOpenRequest struct {
+	DurationMs int64  `json:"duration_ms,omitempty"`
+	Reason     string `json:"reason,omitempty"`
+}
+
+func (h *Handler) openCredentialCircuit(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid id"})
+		return
+	}
+	var in circuitOpenRequest
+	if err := readOptionalJSON(r, &in); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": err.Error()})
+		return
+	}
+	if in.DurationMs < 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "duration_ms must not be negative"})
+		return
+	}
+	st := h.rtr.OpenCircuit(id, time.Duration(in.DurationMs)*time.Millisecond)
+	h.audit(r.Context(), r, "credential.circuit_open", "credential", id, in)
+	writeJSON(w, http.StatusOK, toCredentialStateDTO(st))
+}
+
+func (h *Handler) closeCredentialCircuit(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid id"})
+		return
+	}
+	before := h.rtr.CredentialStateOf(id)
+	st := h.rtr.CloseCircuit(id)
+	h.audit(r.Context(), r, "credential.circuit_close", "credential", id, map[string]any{
+		"was_open":        before.Open,
+		"was_manual_open": before.ManualOpen,
+	})
+	writeJSON(w, http.StatusOK, toCredentialStateDTO(st))
+}
+
+func (h *Handler) resetCredentialStats(w http.ResponseWriter, r *http.Request) {
+	id, err := parseID(chi.URLParam(r, "id"))
+	if err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]any{"error": "invalid id"})
+		return
+	}
+	before := h.rtr.CredentialStateOf(id)
+	st := h.rtr.ResetCredentialStats(id)
+	h.audit(r.Context(), r, "credential.stats_reset", "credential", id, map[string]any{
+		"successes": before.Successes,
+		"failures":  before.Failures,
+		"total":     before.Total,
+	})
+	writeJSON(w, http.StatusOK, toCredentialStateDTO(st))
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339Nano)
+}
+
+type disabledCredentialDTO struct {
+	ID             uint64 `json:"id"`
+	ProviderID     uint64 `json:"provider_id"`
+	ProviderName   string `json:"provider_name,omitempty"`
+	Name           string `json:"name"`
+	KeyLast4       string `json:"key_last4,omitempty"`
+	DisabledReason string `json:"disabled_reason"`
+	DisabledAt     string `json:"disabled_at,omitempty"`
+}
+
+// listDisabledCredentials returns credentials the router took out of service
+// after a permanent upstream failure (exhausted quota, revoked key).
+func (h *Handler) listDisabledCredentials(w http.ResponseWriter, r *http.Request) {
+	rows, err := h.db.QueryContext(r.Context(),
+		`SELECT c.id, c.provider_id, COALESCE(p.display_name, p.type), c.name, c.key_last4, c.disabled_reason, c.disabled_at
+		 FROM credentials c LEFT JOIN providers p ON p.id = c.provider_id
+		 WHERE c.enabled = 0 AND c.disabled_reason IS NOT NULL
+		 ORDER BY c.disabled_at DESC, c.id DESC`)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
+	}
+	defer rows.Close()
+
+	out := []disabledCredentialDTO{}
+	for rows.Next() {
+		var (
+			c        disabledCredentialDTO
+			provName sql.NullString
+			at       sql.NullTime
+		)
+		if err := rows.Scan(&c.ID, &c.ProviderID, &provName, &c.Name, &c.KeyLast4, &c.DisabledReason, &at); err != nil {
+			writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+			return
+		}
+		c.ProviderName = provName.String
+		if at.Valid {
+			c.DisabledAt = formatTime(at.Time)
+		}
+		out = append(out, c)
+	}
+	writeJSON(w, http.StatusOK, out)
+}
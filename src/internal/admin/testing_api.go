@@ -102,15 +102,16 @@ func (h *Handler) refreshProviderModels(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	modelsJSON := raw
-	if modelsJSON == nil {
-		b, _ := json.Marshal(modelIDs)
-		modelsJSON = b
+	diff, err := h.storeModelRefresh(r.Context(), prov, modelIDs, raw)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]any{"error": err.Error()})
+		return
 	}
 
-	_, _ = h.db.ExecContext(r.Context(), `UPDATE providers SET models_json=?, models_refreshed_at=NOW() WHERE id=?`, modelsJSON, providerID)
-
-	var refreshedAt sql.NullTime
+	var (
+		modelsJSON  []byte
+		refreshedAt sql.NullTime
+	)
 	_ = h.db.QueryRowContext(r.Context(), `SELECT models_json, models_refreshed_at FROM providers WHERE id=?`, providerID).Scan(&modelsJSON, &refreshedAt)
 
 	var refStr string
@@ -124,6 +125,9 @@ func (h *Handler) refreshProviderModels(w http.ResponseWriter, r *http.Request)
 		"models":              modelIDs,
 		"models_json":         json.RawMessage(modelsJSON),
 		"models_refreshed_at": refStr,
+		"added":               diff.Added,
+		"removed":             diff.Removed,
+		"stale_mappings":      diff.Stale,
 	})
 }
 
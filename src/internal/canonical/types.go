@@ -13,41 +13,86 @@ type ContextKey string
 
 const ContextKeyClientKey ContextKey = "client_key"
 
+// Request is the provider-neutral form of a generation request. Facades
+// decode client requests into it and provider encoders build upstream
+// requests from it, so a new dialect needs one decoder and one encoder.
 type Request struct {
 	Facade Facade
 	Model  string
 	Stream bool
 
-	System   string
+	// System holds text blocks; CacheControl marks cache breakpoints.
+	System   []ContentBlock
 	Messages []Message
 
+	// MaxTokens is 0 when the client did not set it.
 	MaxTokens int
 
-	Temperature *float64
-	TopP        *float64
+	Temperature      *float64
+	TopP             *float64
+	TopK             *int
+	Stop             []string
+	Seed             *int64
+	PresencePenalty  *float64
+	FrequencyPenalty *float64
+	N                *int
+	Logprobs         bool
+	TopLogprobs      *int
+	// User is the end-user id (OpenAI user, Anthropic metadata.user_id).
+	User string
 
 	Tools      []Tool
-	ToolChoice json.RawMessage
+	ToolChoice *ToolChoice
+
+	// Reasoning is nil when extended thinking is off.
+	Reasoning *Reasoning
+	// ResponseFormat is a Chat Completions response_format object.
+	ResponseFormat json.RawMessage
 
 	Raw json.RawMessage
 }
 
+// Message roles are "user" and "assistant". Tool results travel as
+// tool_result blocks in user messages, as in Anthropic's API.
 type Message struct {
 	Role    string
 	Content []ContentBlock
 }
 
+// Content block types.
+const (
+	BlockText             = "text"
+	BlockImage            = "image"
+	BlockToolUse          = "tool_use"
+	BlockToolResult       = "tool_result"
+	BlockThinking         = "thinking"
+	BlockRedactedThinking = "redacted_thinking"
+)
+
 type ContentBlock struct {
 	Type string `json:"type"`
 
 	Text string `json:"text,omitempty"`
 
+	// Images carry either MediaType and base64 Data, or a URL.
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	URL       string `json:"url,omitempty"`
+
+	ID    string          `json:"id,omitempty"`
 	Name  string          `json:"name,omitempty"`
 	Input json.RawMessage `json:"input,omitempty"`
 
-	ToolUseID string          `json:"tool_use_id,omitempty"`
-	Content   json.RawMessage `json:"content,omitempty"`
-	IsError   *bool           `json:"is_error,omitempty"`
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	// Content is the tool result as Anthropic shapes it: a string or a
+	// list of blocks.
+	Content json.RawMessage `json:"content,omitempty"`
+	IsError *bool           `json:"is_error,omitempty"`
+
+	Thinking  string `json:"thinking,omitempty"`
+	Signature string `json:"signature,omitempty"`
+
+	CacheControl json.RawMessage `json:"cache_control,omitempty"`
 }
 
 type Tool struct {
@@ -56,3 +101,89 @@ type Tool struct {
 	InputSchema json.RawMessage `json:"input_schema,omitempty"`
 }
 
+// ToolChoice Type is "auto", "none", "any" or "tool"; Name is set for "tool".
+type ToolChoice struct {
+	Type string
+	Name string
+}
+
+// Reasoning asks for extended thinking with either an effort level or an
+// explicit token budget; encoders derive whichever their API takes.
+type Reasoning struct {
+	Effort       string
+	BudgetTokens int
+}
+
+// Response is the provider-neutral form of a non-streaming completion.
+type Response struct {
+	ID      string
+	Model   string
+	Content []ContentBlock
+	// StopReason uses Anthropic's vocabulary: end_turn, max_tokens,
+	// stop_sequence, tool_use or refusal.
+	StopReason string
+	// Usage is nil when the upstream did not report it.
+	Usage *Usage
+}
+
+// Usage counts tokens. InputTokens is the whole prompt; the cache counters
+// are the parts of it written to or read from the prompt cache.
+type Usage struct {
+	InputTokens         int
+	OutputTokens        int
+	CacheCreationTokens int
+	CacheReadTokens     int
+}
+
+// EventType enumerates the canonical stream events. They follow Anthropic's
+// stream shape: blocks are opened, receive deltas and are closed in order.
+type EventType string
+
+const (
+	EventMessageStart EventType = "message_start"
+	EventBlockStart   EventType = "block_start"
+	EventBlockDelta   EventType = "block_delta"
+	EventBlockStop    EventType = "block_stop"
+	EventMessageDelta EventType = "message_delta"
+	EventMessageStop  EventType = "message_stop"
+	// EventError ends a stream that failed, in place of EventMessageStop.
+	EventError EventType = "error"
+)
+
+// Delta types of EventBlockDelta.
+const (
+	DeltaText      = "text"
+	DeltaThinking  = "thinking"
+	DeltaInputJSON = "input_json"
+	DeltaSignature = "signature"
+)
+
+// Event is one step of a streamed response.
+type Event struct {
+	Type EventType
+
+	// Index identifies the block of block events.
+	Index int
+	// Block is the opened block on EventBlockStart.
+	Block *ContentBlock
+
+	DeltaType string
+	Delta     string
+
+	// StopReason is set on EventMessageDelta.
+	StopReason string
+	// Usage is the running total so far, on EventMessageStart and
+	// EventMessageDelta, or nil.
+	Usage *Usage
+	// Err is set on EventError.
+	Err *StreamError
+}
+
+// StreamError is a failure that ended a stream. Type is one of Anthropic's
+// error types, such as api_error or overloaded_error.
+type StreamError struct {
+	Type    string
+	Message string
+}
+
+func (e *StreamError) Error() string { return e.Type + ": " + e.Message }
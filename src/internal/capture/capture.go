@@ -0,0 +1,97 @@
+// Package capture keeps the full bodies of sampled requests for debugging
+// conversions: what the client sent, what was sent upstream, what came
+// back and what the client got. Bodies are redacted before they are
+// stored, and stored compressed for a retention period.
+package capture
+
+import (
+	"bytes"
+	"math/rand"
+	"strings"
+)
+
+// DefaultMaxPartBytes bounds each captured body.
+const DefaultMaxPartBytes = 1 << 20
+
+// Capture is one upstream attempt of a request.
+type Capture struct {
+	RequestID string
+	// Endpoint is the client's request path, such as /v1/messages.
+	Endpoint     string
+	Attempt      int
+	PoolID       uint64
+	CredentialID uint64
+	// UpstreamStatus is 0 when the upstream could not be reached.
+	UpstreamStatus int
+
+	ClientRequest    []byte
+	UpstreamRequest  []byte
+	UpstreamResponse []byte
+	ClientResponse   []byte
+}
+
+// Store saves captures. Saving is best-effort and must not block the
+// request.
+type Store interface {
+	Save(c Capture)
+}
+
+// Sample reports whether a request of a pool capturing rate of its
+// requests is captured.
+func Sample(rate float64) bool {
+	switch {
+	case rate <= 0:
+		return false
+	case rate >= 1:
+		return true
+	}
+	return rand.Float64() < rate
+}
+
+// truncatedMarker ends a body cut at the size limit.
+const truncatedMarker = "\n[truncated]"
+
+// Buffer keeps the first max bytes written to it. Writes never fail, so it
+// can sit in a tee without affecting the request.
+type Buffer struct {
+	max       int
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func NewBuffer(max int) *Buffer {
+	if max <= 0 {
+		max = DefaultMaxPartBytes
+	}
+	return &Buffer{max: max}
+}
+
+func (b *Buffer) Write(p []byte) (int, error) {
+	if room := b.max - b.buf.Len(); len(p) > room {
+		b.buf.Write(p[:max(room, 0)])
+		b.truncated = true
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// Bytes returns what was kept, marked when it was cut short.
+func (b *Buffer) Bytes() []byte {
+	if b.truncated {
+		return append(bytes.Clone(b.buf.Bytes()), truncatedMarker...)
+	}
+	return b.buf.Bytes()
+}
+
+// Truncated reports whether a stored body was cut at the size limit.
+func Truncated(body string) bool {
+	return strings.HasSuffix(body, truncatedMarker)
+}
+
+// Clip returns body cut to max bytes like a Buffer would.
+func Clip(body []byte, max int) []byte {
+	b := NewBuffer(max)
+	_, _ = b.Write(body)
+	return b.Bytes()
+}
@@ -0,0 +1,62 @@
+package capture
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactor(t *testing.T) {
+	r, err := NewRedactor([]string{RedactAPIKeys, RedactEmails}, []string{`order-\d+`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := `{"api_key":"abc123","messages":[{"content":"mail bob@example.com with key sk-ant-api03-abcdefghijk about order-42"}],"auth":"Bearer xyz.789"}`
+	got := string(r.Redact([]byte(in)))
+	for _, leak := range []string{"abc123", "bob@example.com", "sk-ant-api03", "order-42", "xyz.789"} {
+		if strings.Contains(got, leak) {
+			t.Fatalf("%q leaked in %s", leak, got)
+		}
+	}
+	if !strings.Contains(got, `"api_key":"[redacted]"`) || !strings.Contains(got, "[redacted-email]") {
+		t.Fatalf("Redact = %s", got)
+	}
+
+	if _, err := NewRedactor([]string{"phones"}, nil); err == nil {
+		t.Fatal("unknown rule set accepted")
+	}
+	if _, err := NewRedactor(nil, []string{"("}); err == nil {
+		t.Fatal("invalid pattern accepted")
+	}
+}
+
+func TestBufferTruncates(t *testing.T) {
+	b := NewBuffer(8)
+	_, _ = b.Write([]byte("hello "))
+	if n, err := b.Write([]byte("world")); n != 5 || err != nil {
+		t.Fatalf("Write = %d, %v", n, err)
+	}
+	if got := string(b.Bytes()); got != "hello wo"+truncatedMarker {
+		t.Fatalf("Bytes = %q", got)
+	}
+	if got := string(Clip([]byte("short"), 8)); got != "short" {
+		t.Fatalf("Clip = %q", got)
+	}
+}
+
+func TestPackRoundTrip(t *testing.T) {
+	r, _ := NewRedactor([]string{RedactEmails}, nil)
+	m := NewMySQL(nil, r, 0)
+	packed := m.pack([]byte(`{"to":"a@b.io","text":"hi"}`))
+	if got := Decompress(packed); got != `{"to":"[redacted-email]","text":"hi"}` {
+		t.Fatalf("unpack = %q", got)
+	}
+	if m.pack(nil) != nil || Decompress(nil) != "" {
+		t.Fatal("empty body not stored as NULL")
+	}
+}
+
+func TestSample(t *testing.T) {
+	if Sample(0) || !Sample(1) {
+		t.Fatal("Sample ignores the bounds")
+	}
+}
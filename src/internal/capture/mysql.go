@@ -0,0 +1,135 @@
+package capture
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"database/sql"
+	"io"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRetention is how long captures are kept when no retention is set.
+const DefaultRetention = 72 * time.Hour
+
+// purgeEvery is how many saves pass between deletions of old captures.
+const purgeEvery = 100
+
+// MySQL stores captures in the request_captures table, each body redacted
+// and gzip-compressed.
+type MySQL struct {
+	db        *sql.DB
+	redact    *Redactor
+	retention time.Duration
+	saves     atomic.Int64
+}
+
+func NewMySQL(db *sql.DB, redact *Redactor, retention time.Duration) *MySQL {
+	if retention <= 0 {
+		retention = DefaultRetention
+	}
+	return &MySQL{db: db, redact: redact, retention: retention}
+}
+
+func (m *MySQL) Save(c Capture) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		now := time.Now().UTC()
+		clientRequest := m.redact.Redact(c.ClientRequest)
+		_, err := m.db.ExecContext(ctx,
+			`INSERT INTO request_captures (request_id, endpoint, attempt, pool_id, credential_id, upstream_status, client_request, client_request_redacted, upstream_request, upstream_response, client_response, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			c.RequestID, c.Endpoint, c.Attempt, c.PoolID, c.CredentialID, c.UpstreamStatus,
+			Compress(clientRequest), !bytes.Equal(clientRequest, c.ClientRequest),
+			m.pack(c.UpstreamRequest), m.pack(c.UpstreamResponse), m.pack(c.ClientResponse), now)
+		if err != nil {
+			log.Printf("request capture save: %v", err)
+			return
+		}
+		if m.saves.Add(1)%purgeEvery == 0 {
+			if _, err := m.db.ExecContext(ctx, `DELETE FROM request_captures WHERE created_at < ? LIMIT 1000`, now.Add(-m.retention)); err != nil {
+				log.Printf("request capture purge: %v", err)
+			}
+		}
+	}()
+}
+
+// pack redacts and compresses a body; empty bodies are stored as NULL.
+func (m *MySQL) pack(body []byte) []byte {
+	return Compress(m.redact.Redact(body))
+}
+
+// Compress gzips a body for storage, returning nil for an empty one.
+func Compress(body []byte) []byte {
+	if len(body) == 0 {
+		return nil
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	_, _ = zw.Write(body)
+	_ = zw.Close()
+	return buf.Bytes()
+}
+
+// Record is a stored capture as the admin API shows it.
+type Record struct {
+	RequestID        string    `json:"request_id"`
+	Endpoint         string    `json:"endpoint"`
+	Attempt          int       `json:"attempt"`
+	PoolID           uint64    `json:"pool_id"`
+	CredentialID     uint64    `json:"credential_id"`
+	UpstreamStatus   int       `json:"upstream_status"`
+	ClientRequest    string    `json:"client_request"`
+	UpstreamRequest  string    `json:"upstream_request"`
+	UpstreamResponse string    `json:"upstream_response"`
+	ClientResponse   string    `json:"client_response"`
+	CreatedAt        time.Time `json:"created_at"`
+
+	// ClientRequestRedacted is set when redaction changed the client
+	// request, which then cannot be replayed as sent.
+	ClientRequestRedacted bool `json:"client_request_redacted"`
+}
+
+// Load returns the captures of a request in attempt order.
+func Load(ctx context.Context, db *sql.DB, requestID string) ([]Record, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT request_id, endpoint, attempt, pool_id, credential_id, upstream_status, client_request, client_request_redacted, upstream_request, upstream_response, client_response, created_at
+		 FROM request_captures WHERE request_id = ? ORDER BY attempt, id`, requestID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Record{}
+	for rows.Next() {
+		var (
+			rec   Record
+			parts [4][]byte
+		)
+		if err := rows.Scan(&rec.RequestID, &rec.Endpoint, &rec.Attempt, &rec.PoolID, &rec.CredentialID, &rec.UpstreamStatus,
+			&parts[0], &rec.ClientRequestRedacted, &parts[1], &parts[2], &parts[3], &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		rec.ClientRequest = Decompress(parts[0])
+		rec.UpstreamRequest = Decompress(parts[1])
+		rec.UpstreamResponse = Decompress(parts[2])
+		rec.ClientResponse = Decompress(parts[3])
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
+
+// Decompress reverses Compress; unreadable data yields "".
+func Decompress(b []byte) string {
+	if len(b) == 0 {
+		return ""
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return ""
+	}
+	out, _ := io.ReadAll(zr)
+	return string(out)
+}
@@ -0,0 +1,65 @@
+package capture
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// Redaction rule sets that can be switched on by name.
+const (
+	RedactAPIKeys = "api_keys"
+	RedactEmails  = "emails"
+)
+
+var ruleSets = map[string][]rule{
+	RedactAPIKeys: {
+		{regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=\-]+`), "Bearer [redacted]"},
+		{regexp.MustCompile(`\b(?:sk|rk|pk)-[A-Za-z0-9_\-]{8,}`), "[redacted-key]"},
+		{regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{20,}`), "[redacted-key]"},
+		{regexp.MustCompile(`(?i)("(?:api[_-]?key|x-api-key|access[_-]?token|secret|password)"\s*:\s*")[^"]*`), "${1}[redacted]"},
+	},
+	RedactEmails: {
+		{regexp.MustCompile(`[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`), "[redacted-email]"},
+	},
+}
+
+type rule struct {
+	re   *regexp.Regexp
+	repl string
+}
+
+// Redactor rewrites captured bodies before they are stored.
+type Redactor struct {
+	rules []rule
+}
+
+// NewRedactor applies the named rule sets and then the custom regular
+// expressions, whose matches are replaced with [redacted].
+func NewRedactor(sets []string, custom []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, name := range sets {
+		rs, ok := ruleSets[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown redaction rule set %q", name)
+		}
+		r.rules = append(r.rules, rs...)
+	}
+	for _, expr := range custom {
+		re, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("redaction pattern %q: %w", expr, err)
+		}
+		r.rules = append(r.rules, rule{re, "[redacted]"})
+	}
+	return r, nil
+}
+
+func (r *Redactor) Redact(body []byte) []byte {
+	if r == nil {
+		return body
+	}
+	for _, rl := range r.rules {
+		body = rl.re.ReplaceAll(body, []byte(rl.repl))
+	}
+	return body
+}
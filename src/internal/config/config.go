@@ -3,7 +3,10 @@ package config
 import (
 	"fmt"
 	"os"
+	"regexp"
+	"strconv"
 	"strings"
+	"time"
 )
 
 type Config struct {
@@ -13,6 +16,24 @@ type Config struct {
 	KeyEncMasterB64    string
 	ClientToken        string
 	CORSAllowedOrigins []string
+	ModelDiffWebhook   string
+	// StreamKeepalive is the upstream silence after which streaming
+	// clients get a keepalive frame; zero disables keepalives.
+	StreamKeepalive time.Duration
+	// ResponseCacheBackend is "memory" or "mysql". The size limits bound
+	// the memory backend as a whole and every entry in either backend.
+	ResponseCacheBackend       string
+	ResponseCacheMaxBytes      int
+	ResponseCacheMaxEntryBytes int
+	// IdempotencyWindow is how long responses to requests sent with an
+	// Idempotency-Key are kept; zero disables the header.
+	IdempotencyWindow time.Duration
+	// Body capture, which pools enable with a capture rate, redacts the
+	// CaptureRedact rule sets and CaptureRedactRegex matches, and keeps
+	// captures for CaptureRetention.
+	CaptureRedact      []string
+	CaptureRedactRegex string
+	CaptureRetention   time.Duration
 }
 
 func FromEnv() (Config, error) {
@@ -37,6 +58,43 @@ func FromEnv() (Config, error) {
 	}
 
 	clientToken := strings.TrimSpace(os.Getenv("CLIENT_TOKEN"))
+	modelDiffWebhook := strings.TrimSpace(os.Getenv("MODEL_DIFF_WEBHOOK_URL"))
+	keepalive, err := time.ParseDuration(getenvDefault("STREAM_KEEPALIVE", "15s"))
+	if err != nil || keepalive < 0 {
+		return Config{}, fmt.Errorf("STREAM_KEEPALIVE must be a non-negative duration such as 15s")
+	}
+	cacheBackend := strings.ToLower(getenvDefault("RESPONSE_CACHE_BACKEND", "memory"))
+	if cacheBackend != "memory" && cacheBackend != "mysql" {
+		return Config{}, fmt.Errorf("RESPONSE_CACHE_BACKEND must be memory or mysql")
+	}
+	cacheMaxBytes, err := getenvInt("RESPONSE_CACHE_MAX_BYTES", 64<<20)
+	if err != nil {
+		return Config{}, err
+	}
+	cacheMaxEntryBytes, err := getenvInt("RESPONSE_CACHE_MAX_ENTRY_BYTES", 1<<20)
+	if err != nil {
+		return Config{}, err
+	}
+	idemWindow, err := time.ParseDuration(getenvDefault("IDEMPOTENCY_WINDOW", "24h"))
+	if err != nil || idemWindow < 0 {
+		return Config{}, fmt.Errorf("IDEMPOTENCY_WINDOW must be a non-negative duration such as 24h")
+	}
+	captureRedact := splitList(getenvDefault("CAPTURE_REDACT", "api_keys,emails"))
+	for _, set := range captureRedact {
+		if set != "api_keys" && set != "emails" {
+			return Config{}, fmt.Errorf("CAPTURE_REDACT must list api_keys and/or emails, or be none")
+		}
+	}
+	captureRegex := strings.TrimSpace(os.Getenv("CAPTURE_REDACT_REGEX"))
+	if captureRegex != "" {
+		if _, err := regexp.Compile(captureRegex); err != nil {
+			return Config{}, fmt.Errorf("CAPTURE_REDACT_REGEX: %w", err)
+		}
+	}
+	captureRetention, err := time.ParseDuration(getenvDefault("CAPTURE_RETENTION", "72h"))
+	if err != nil || captureRetention <= 0 {
+		return Config{}, fmt.Errorf("CAPTURE_RETENTION must be a positive duration such as 72h")
+	}
 
 	return Config{
 		HTTPAddr:           httpAddr,
@@ -45,6 +103,16 @@ func FromEnv() (Config, error) {
 		KeyEncMasterB64:    keyEnc,
 		ClientToken:        clientToken,
 		CORSAllowedOrigins: allowed,
+		ModelDiffWebhook:   modelDiffWebhook,
+		StreamKeepalive:    keepalive,
+
+		ResponseCacheBackend:       cacheBackend,
+		ResponseCacheMaxBytes:      cacheMaxBytes,
+		ResponseCacheMaxEntryBytes: cacheMaxEntryBytes,
+		IdempotencyWindow:          idemWindow,
+		CaptureRedact:              captureRedact,
+		CaptureRedactRegex:         captureRegex,
+		CaptureRetention:           captureRetention,
 	}, nil
 }
 
@@ -56,6 +124,30 @@ func getenvDefault(key, def string) string {
 	return v
 }
 
+func getenvInt(key string, def int) (int, error) {
+	v := strings.TrimSpace(os.Getenv(key))
+	if v == "" {
+		return def, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer", key)
+	}
+	return n, nil
+}
+
+// splitList splits a comma-separated list, where "none" means empty.
+func splitList(v string) []string {
+	var out []string
+	for _, p := range strings.Split(v, ",") {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p != "" && p != "none" {
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
 func splitCSV(v string) []string {
 	parts := strings.Split(v, ",")
 	out := make([]string, 0, len(parts))
@@ -0,0 +1,417 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"claude-gateway/src/internal/canonical"
+	anthropicproto "claude-gateway/src/internal/proto/anthropic"
+)
+
+// AnthropicRequestToCanonical decodes a Messages API request.
+func AnthropicRequestToCanonical(ar anthropicproto.MessageCreateRequest) (canonical.Request, error) {
+	cr := canonical.Request{
+		Facade:      canonical.FacadeAnthropic,
+		Model:       ar.Model,
+		Stream:      ar.Stream,
+		System:      anthropicSystemToCanonical(ar.System),
+		MaxTokens:   ar.MaxTokens,
+		Temperature: ar.Temperature,
+		TopP:        ar.TopP,
+		TopK:        ar.TopK,
+		Stop:        ar.StopSeqs,
+		User:        anthropicMetadataUserID(ar.Metadata),
+	}
+	for _, m := range ar.Messages {
+		role := strings.TrimSpace(m.Role)
+		if role == "" {
+			return canonical.Request{}, fmt.Errorf("%w: anthropic message missing role", ErrUnsupportedMessageShape)
+		}
+		if role != "user" && role != "assistant" {
+			return canonical.Request{}, fmt.Errorf("%w: unsupported anthropic message role %q", ErrUnsupportedMessageShape, role)
+		}
+		raw, err := anthropicContentToBlocks(m.Content)
+		if err != nil {
+			return canonical.Request{}, err
+		}
+		msg := canonical.Message{Role: role}
+		for _, blk := range raw {
+			b, ok, err := anthropicBlockToCanonical(blk)
+			if err != nil {
+				return canonical.Request{}, err
+			}
+			if ok {
+				msg.Content = append(msg.Content, b)
+			}
+		}
+		cr.Messages = append(cr.Messages, msg)
+	}
+	for _, t := range ar.Tools {
+		if strings.TrimSpace(t.Name) == "" {
+			return canonical.Request{}, fmt.Errorf("%w: anthropic tool missing name", ErrUnsupportedMessageShape)
+		}
+		if len(t.InputSchema) > 0 && !json.Valid(t.InputSchema) {
+			return canonical.Request{}, fmt.Errorf("invalid anthropic tool input_schema for %q", t.Name)
+		}
+		cr.Tools = append(cr.Tools, canonical.Tool{Name: t.Name, Description: t.Description, InputSchema: t.InputSchema})
+	}
+	tc, err := anthropicToolChoiceToCanonical(ar.ToolChoice)
+	if err != nil {
+		return canonical.Request{}, err
+	}
+	cr.ToolChoice = tc
+	if th := ar.Thinking; th != nil && th.Type == "enabled" {
+		cr.Reasoning = &canonical.Reasoning{BudgetTokens: th.BudgetTokens}
+	}
+	return cr, nil
+}
+
+// CanonicalToAnthropicRequest encodes a Messages API request.
+func CanonicalToAnthropicRequest(cr canonical.Request) (anthropicproto.MessageCreateRequest, error) {
+	maxTokens := cr.MaxTokens
+	if maxTokens <= 0 {
+		maxTokens = 1024
+	}
+	req := anthropicproto.MessageCreateRequest{
+		Model:       cr.Model,
+		MaxTokens:   maxTokens,
+		Messages:    make([]anthropicproto.Message, 0, len(cr.Messages)),
+		Temperature: cr.Temperature,
+		TopP:        cr.TopP,
+		TopK:        cr.TopK,
+		StopSeqs:    cr.Stop,
+		Stream:      cr.Stream,
+		Thinking:    reasoningToAnthropic(cr.Reasoning, maxTokens),
+	}
+	if req.Thinking != nil {
+		// Extended thinking does not allow sampling overrides.
+		req.Temperature = nil
+		req.TopP = nil
+		req.TopK = nil
+	}
+	if cr.User != "" {
+		req.Metadata, _ = json.Marshal(map[string]string{"user_id": cr.User})
+	}
+	req.System = canonicalSystemToAnthropic(cr.System)
+	for _, m := range cr.Messages {
+		var blocks []map[string]any
+		for _, b := range m.Content {
+			if blk, ok := canonicalBlockToAnthropic(b); ok {
+				blocks = append(blocks, blk)
+			}
+		}
+		req.Messages = append(req.Messages, anthropicproto.Message{Role: m.Role, Content: blocks})
+	}
+	for _, t := range cr.Tools {
+		req.Tools = append(req.Tools, anthropicproto.ToolDefinition{
+			Name:        t.Name,
+			Description: t.Description,
+			InputSchema: t.InputSchema,
+		})
+	}
+	req.ToolChoice = canonicalToolChoiceToAnthropic(cr.ToolChoice)
+	if err := applyResponseFormatToAnthropic(&req, cr.ResponseFormat); err != nil {
+		return anthropicproto.MessageCreateRequest{}, err
+	}
+	return req, nil
+}
+
+// AnthropicResponseToCanonical decodes a Messages API response.
+func AnthropicResponseToCanonical(ar AnthropicMessageResponse) canonical.Response {
+	out := canonical.Response{
+		ID:         ar.ID,
+		Model:      ar.Model,
+		StopReason: ar.StopReason,
+		Usage: &canonical.Usage{
+			InputTokens:         ar.Usage.PromptTokens(),
+			OutputTokens:        ar.Usage.OutputTokens,
+			CacheCreationTokens: ar.Usage.CacheCreationInputTokens,
+			CacheReadTokens:     ar.Usage.CacheReadInputTokens,
+		},
+	}
+	for _, blk := range ar.Content {
+		switch blk["type"] {
+		case "text":
+			t, _ := blk["text"].(string)
+			out.Content = append(out.Content, canonical.ContentBlock{Type: canonical.BlockText, Text: t})
+		case "thinking":
+			t, _ := blk["thinking"].(string)
+			sig, _ := blk["signature"].(string)
+			out.Content = append(out.Content, canonical.ContentBlock{Type: canonical.BlockThinking, Thinking: t, Signature: sig})
+		case "redacted_thinking":
+			d, _ := blk["data"].(string)
+			out.Content = append(out.Content, canonical.ContentBlock{Type: canonical.BlockRedactedThinking, Data: d})
+		case "tool_use":
+			id, _ := blk["id"].(string)
+			name, _ := blk["name"].(string)
+			input, _ := json.Marshal(blk["input"])
+			out.Content = append(out.Content, canonical.ContentBlock{Type: canonical.BlockToolUse, ID: id, Name: name, Input: input})
+		}
+	}
+	return out
+}
+
+// CanonicalToAnthropicResponse encodes a Messages API response reporting
+// model. Thinking from other providers gets a synthetic signature.
+func CanonicalToAnthropicResponse(resp canonical.Response, model string) AnthropicMessageResponse {
+	content := make([]map[string]any, 0, len(resp.Content))
+	for _, b := range resp.Content {
+		switch b.Type {
+		case canonical.BlockText:
+			if strings.TrimSpace(b.Text) != "" {
+				content = append(content, map[string]any{"type": "text", "text": b.Text})
+			}
+		case canonical.BlockThinking:
+			if strings.TrimSpace(b.Thinking) == "" {
+				continue
+			}
+			sig := b.Signature
+			if sig == "" {
+				sig = SyntheticThinkingSignature(b.Thinking)
+			}
+			content = append(content, map[string]any{"type": "thinking", "thinking": b.Thinking, "signature": sig})
+		case canonical.BlockRedactedThinking:
+			content = append(content, map[string]any{"type": "redacted_thinking", "data": b.Data})
+		case canonical.BlockToolUse:
+			content = append(content, map[string]any{"type": "tool_use", "id": b.ID, "name": b.Name, "input": rawToAny(b.Input)})
+		}
+	}
+	stop := resp.StopReason
+	if stop == "" {
+		stop = "end_turn"
+	}
+	return AnthropicMessageResponse{
+		ID:         "msg_" + uuid.NewString(),
+		Type:       "message",
+		Role:       "assistant",
+		Model:      model,
+		Content:    content,
+		StopReason: stop,
+		Usage:      canonicalUsageToAnthropic(resp.Usage),
+	}
+}
+
+func canonicalUsageToAnthropic(u *canonical.Usage) AnthropicUsage {
+	if u == nil {
+		return AnthropicUsage{}
+	}
+	return AnthropicUsage{
+		InputTokens:              u.InputTokens - u.CacheCreationTokens - u.CacheReadTokens,
+		OutputTokens:             u.OutputTokens,
+		CacheCreationInputTokens: u.CacheCreationTokens,
+		CacheReadInputTokens:     u.CacheReadTokens,
+	}
+}
+
+// anthropicSystemToCanonical accepts a string or a list of text blocks.
+func anthropicSystemToCanonical(v any) []canonical.ContentBlock {
+	switch s := v.(type) {
+	case nil:
+		return nil
+	case string:
+		if strings.TrimSpace(s) == "" {
+			return nil
+		}
+		return []canonical.ContentBlock{{Type: canonical.BlockText, Text: s}}
+	case []any:
+		var out []canonical.ContentBlock
+		for _, bi := range s {
+			blk, ok := bi.(map[string]any)
+			if !ok {
+				continue
+			}
+			t, _ := blk["text"].(string)
+			if t == "" {
+				continue
+			}
+			out = append(out, canonical.ContentBlock{Type: canonical.BlockText, Text: t, CacheControl: rawField(blk, "cache_control")})
+		}
+		return out
+	default:
+		b, _ := json.Marshal(v)
+		return []canonical.ContentBlock{{Type: canonical.BlockText, Text: string(b)}}
+	}
+}
+
+// canonicalSystemToAnthropic returns a string unless some block carries
+// cache_control, which only the block form can express.
+func canonicalSystemToAnthropic(system []canonical.ContentBlock) any {
+	if hasCacheControl(system) {
+		blocks := make([]map[string]any, 0, len(system))
+		for _, b := range system {
+			blocks = append(blocks, textBlockWithCache(b))
+		}
+		return blocks
+	}
+	if sys := strings.TrimSpace(joinText(system, "\n")); sys != "" {
+		return sys
+	}
+	return nil
+}
+
+func anthropicBlockToCanonical(blk map[string]any) (canonical.ContentBlock, bool, error) {
+	typ, _ := blk["type"].(string)
+	switch typ {
+	case "text":
+		t, _ := blk["text"].(string)
+		if t == "" {
+			return canonical.ContentBlock{}, false, nil
+		}
+		return canonical.ContentBlock{Type: canonical.BlockText, Text: t, CacheControl: rawField(blk, "cache_control")}, true, nil
+	case "thinking":
+		t, _ := blk["thinking"].(string)
+		sig, _ := blk["signature"].(string)
+		return canonical.ContentBlock{Type: canonical.BlockThinking, Thinking: t, Signature: sig}, true, nil
+	case "redacted_thinking":
+		d, _ := blk["data"].(string)
+		return canonical.ContentBlock{Type: canonical.BlockRedactedThinking, Data: d}, true, nil
+	case "tool_use":
+		id, _ := blk["id"].(string)
+		name, _ := blk["name"].(string)
+		if strings.TrimSpace(id) == "" || strings.TrimSpace(name) == "" {
+			return canonical.ContentBlock{}, false, fmt.Errorf("%w: tool_use missing id/name", ErrUnsupportedMessageShape)
+		}
+		input, _ := json.Marshal(blk["input"])
+		return canonical.ContentBlock{Type: canonical.BlockToolUse, ID: id, Name: name, Input: input}, true, nil
+	case "tool_result":
+		toolUseID, _ := blk["tool_use_id"].(string)
+		if strings.TrimSpace(toolUseID) == "" {
+			return canonical.ContentBlock{}, false, fmt.Errorf("%w: tool_result missing tool_use_id", ErrUnsupportedMessageShape)
+		}
+		b := canonical.ContentBlock{Type: canonical.BlockToolResult, ToolUseID: toolUseID, Content: rawField(blk, "content")}
+		if isErr, ok := blk["is_error"].(bool); ok {
+			b.IsError = &isErr
+		}
+		return b, true, nil
+	case "image":
+		b, err := anthropicImageToCanonical(blk)
+		return b, err == nil, err
+	default:
+		return canonical.ContentBlock{}, false, fmt.Errorf("%w: unsupported anthropic block type %q", ErrUnsupportedContentPart, typ)
+	}
+}
+
+func anthropicImageToCanonical(blk map[string]any) (canonical.ContentBlock, error) {
+	src, _ := blk["source"].(map[string]any)
+	if src == nil {
+		return canonical.ContentBlock{}, fmt.Errorf("%w: image block missing source", ErrUnsupportedContentPart)
+	}
+	st, _ := src["type"].(string)
+	switch strings.TrimSpace(st) {
+	case "base64":
+		mediaType, _ := src["media_type"].(string)
+		data, _ := src["data"].(string)
+		mediaType = strings.TrimSpace(mediaType)
+		data = strings.TrimSpace(data)
+		if mediaType == "" || data == "" {
+			return canonical.ContentBlock{}, fmt.Errorf("%w: base64 image missing media_type/data", ErrUnsupportedContentPart)
+		}
+		return canonical.ContentBlock{Type: canonical.BlockImage, MediaType: mediaType, Data: data}, nil
+	case "url":
+		u, _ := src["url"].(string)
+		u = strings.TrimSpace(u)
+		if u == "" {
+			return canonical.ContentBlock{}, fmt.Errorf("%w: url image missing url", ErrUnsupportedContentPart)
+		}
+		if !(strings.HasPrefix(u, "data:image/") || strings.HasPrefix(u, "https://")) {
+			return canonical.ContentBlock{}, fmt.Errorf("%w: image url must be https:// or data:image/*", ErrUnsupportedContentPart)
+		}
+		return canonical.ContentBlock{Type: canonical.BlockImage, URL: u}, nil
+	default:
+		return canonical.ContentBlock{}, fmt.Errorf("%w: unsupported image source type %q", ErrUnsupportedContentPart, st)
+	}
+}
+
+// canonicalBlockToAnthropic reports false for blocks Anthropic would
+// reject: thinking without a signature Anthropic issued. Omitting
+// previous-turn thinking is allowed.
+func canonicalBlockToAnthropic(b canonical.ContentBlock) (map[string]any, bool) {
+	switch b.Type {
+	case canonical.BlockText:
+		return textBlockWithCache(b), true
+	case canonical.BlockImage:
+		if b.URL != "" {
+			return map[string]any{"type": "image", "source": map[string]any{"type": "url", "url": b.URL}}, true
+		}
+		return map[string]any{"type": "image", "source": map[string]any{"type": "base64", "media_type": b.MediaType, "data": b.Data}}, true
+	case canonical.BlockToolUse:
+		return map[string]any{"type": "tool_use", "id": b.ID, "name": b.Name, "input": rawToAny(b.Input)}, true
+	case canonical.BlockToolResult:
+		isErr := b.IsError != nil && *b.IsError
+		return map[string]any{"type": "tool_result", "tool_use_id": b.ToolUseID, "content": rawToAny(b.Content), "is_error": isErr}, true
+	case canonical.BlockThinking:
+		if b.Signature == "" || strings.HasPrefix(b.Signature, syntheticSignaturePrefix) {
+			return nil, false
+		}
+		return map[string]any{"type": "thinking", "thinking": b.Thinking, "signature": b.Signature}, true
+	case canonical.BlockRedactedThinking:
+		return map[string]any{"type": "redacted_thinking", "data": b.Data}, true
+	}
+	return nil, false
+}
+
+func anthropicToolChoiceToCanonical(raw json.RawMessage) (*canonical.ToolChoice, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var v struct {
+		Type string `json:"type"`
+		Name string `json:"name"`
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("invalid anthropic tool_choice: %w", err)
+	}
+	switch v.Type {
+	case "auto", "none", "any":
+		return &canonical.ToolChoice{Type: v.Type}, nil
+	case "tool":
+		if strings.TrimSpace(v.Name) == "" {
+			return nil, fmt.Errorf("%w: tool_choice.tool missing name", ErrUnsupportedMessageShape)
+		}
+		return &canonical.ToolChoice{Type: v.Type, Name: v.Name}, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported anthropic tool_choice type %q", ErrUnsupportedMessageShape, v.Type)
+	}
+}
+
+func canonicalToolChoiceToAnthropic(tc *canonical.ToolChoice) json.RawMessage {
+	if tc == nil {
+		return nil
+	}
+	if tc.Type == "tool" {
+		b, _ := json.Marshal(map[string]any{"type": "tool", "name": tc.Name})
+		return b
+	}
+	return json.RawMessage(`{"type":"` + tc.Type + `"}`)
+}
+
+// reasoningToAnthropic derives a thinking config. The budget is kept below
+// maxTokens as Anthropic requires; nil is returned when there is no room
+// for the minimum budget.
+func reasoningToAnthropic(r *canonical.Reasoning, maxTokens int) *anthropicproto.ThinkingConfig {
+	if r == nil {
+		return nil
+	}
+	budget := r.BudgetTokens
+	if budget <= 0 {
+		budget = effortToBudget(r.Effort)
+	}
+	if budget >= maxTokens {
+		budget = maxTokens - 1
+	}
+	if budget < thinkingBudgetMin {
+		return nil
+	}
+	return &anthropicproto.ThinkingConfig{Type: "enabled", BudgetTokens: budget}
+}
+
+func textBlockWithCache(b canonical.ContentBlock) map[string]any {
+	blk := map[string]any{"type": "text", "text": b.Text}
+	if len(b.CacheControl) > 0 {
+		blk["cache_control"] = rawToAny(b.CacheControl)
+	}
+	return blk
+}
@@ -0,0 +1,70 @@
+package convert
+
+import (
+	"strings"
+
+	openaiproto "claude-gateway/src/internal/proto/openai"
+)
+
+// SupportsCacheControl reports whether the vendor accepts Anthropic-style
+// cache_control markers on OpenAI content parts.
+func (v Vendor) SupportsCacheControl() bool {
+	return v == VendorQwen || v == VendorOpenRouter
+}
+
+// ApplyVendorCacheControl removes the cache_control markers carried over
+// from an Anthropic request unless the vendor understands them.
+func ApplyVendorCacheControl(req *openaiproto.ChatCompletionsRequest, v Vendor) {
+	if v.SupportsCacheControl() {
+		return
+	}
+	StripCacheControl(req)
+}
+
+// StripCacheControl drops cache_control from every content part. Messages
+// whose parts are then plain text collapse back to a string, since several
+// OpenAI-compatible APIs only accept string content.
+func StripCacheControl(req *openaiproto.ChatCompletionsRequest) {
+	switch msgs := req.Messages.(type) {
+	case []map[string]any:
+		for _, m := range msgs {
+			stripMessageCacheControl(m)
+		}
+	case []any:
+		for _, mi := range msgs {
+			if m, ok := mi.(map[string]any); ok {
+				stripMessageCacheControl(m)
+			}
+		}
+	}
+}
+
+func stripMessageCacheControl(m map[string]any) {
+	parts, ok := m["content"].([]any)
+	if !ok {
+		return
+	}
+	stripped := false
+	textOnly := true
+	var text strings.Builder
+	for _, pi := range parts {
+		p, ok := pi.(map[string]any)
+		if !ok {
+			textOnly = false
+			continue
+		}
+		if _, ok := p["cache_control"]; ok {
+			delete(p, "cache_control")
+			stripped = true
+		}
+		if p["type"] != "text" {
+			textOnly = false
+			continue
+		}
+		t, _ := p["text"].(string)
+		text.WriteString(t)
+	}
+	if stripped && textOnly {
+		m["content"] = text.String()
+	}
+}
@@ -0,0 +1,59 @@
+package convert
+
+import (
+	"testing"
+
+	anthropicproto "claude-gateway/src/internal/proto/anthropic"
+)
+
+func TestCacheControlForwardedOrStripped(t *testing.T) {
+	ar := anthropicproto.MessageCreateRequest{
+		Model:     "claude-sonnet-4-5",
+		MaxTokens: 1024,
+		System: []any{
+			map[string]any{"type": "text", "text": "long policy", "cache_control": map[string]any{"type": "ephemeral"}},
+		},
+		Messages: []anthropicproto.Message{{Role: "user", Content: []any{
+			map[string]any{"type": "text", "text": "doc", "cache_control": map[string]any{"type": "ephemeral"}},
+			map[string]any{"type": "text", "text": " question"},
+		}}},
+	}
+
+	kept, err := AnthropicToOpenAIChatRequest(ar)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	ApplyVendorCacheControl(&kept, VendorQwen)
+	msgs := kept.Messages.([]map[string]any)
+	parts, ok := msgs[1]["content"].([]any)
+	if !ok || parts[0].(map[string]any)["cache_control"] == nil {
+		t.Fatalf("qwen should keep cache_control parts: %#v", msgs[1]["content"])
+	}
+
+	stripped, _ := AnthropicToOpenAIChatRequest(ar)
+	ApplyVendorCacheControl(&stripped, VendorDeepSeek)
+	msgs = stripped.Messages.([]map[string]any)
+	if msgs[0]["content"] != "long policy" || msgs[1]["content"] != "doc question" {
+		t.Fatalf("deepseek should get plain strings: %#v", msgs)
+	}
+}
+
+func TestCachedTokensUsageMapping(t *testing.T) {
+	or := OpenAIChatCompletionResponse{
+		Choices: []OpenAIChatChoice{{Message: OpenAIChatMessage{Role: "assistant", Content: "ok"}}},
+		Usage: &OpenAIChatUsage{
+			PromptTokens:        1000,
+			CompletionTokens:    10,
+			TotalTokens:         1010,
+			PromptTokensDetails: &OpenAIPromptTokensDetails{CachedTokens: 800},
+		},
+	}
+	ar := OpenAIResponseToAnthropic(or, "claude-sonnet-4-5")
+	if ar.Usage.InputTokens != 200 || ar.Usage.CacheReadInputTokens != 800 {
+		t.Fatalf("anthropic usage = %+v", ar.Usage)
+	}
+	back := AnthropicResponseToOpenAI(ar, false)
+	if back.Usage.PromptTokens != 1000 || back.Usage.PromptTokensDetails == nil || back.Usage.PromptTokensDetails.CachedTokens != 800 {
+		t.Fatalf("openai usage = %+v", back.Usage)
+	}
+}
@@ -0,0 +1,65 @@
+package convert
+
+import (
+	"encoding/json"
+	"testing"
+
+	"claude-gateway/src/internal/canonical"
+	anthropicproto "claude-gateway/src/internal/proto/anthropic"
+)
+
+func TestAnthropicRequestCanonicalRoundTrip(t *testing.T) {
+	temp := 0.2
+	ar := anthropicproto.MessageCreateRequest{
+		Model:       "claude",
+		MaxTokens:   256,
+		System:      "be brief",
+		Temperature: &temp,
+		StopSeqs:    []string{"END"},
+		Messages: []anthropicproto.Message{
+			{Role: "user", Content: "weather?"},
+			{Role: "assistant", Content: []any{
+				map[string]any{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": map[string]any{"city": "SF"}},
+			}},
+			{Role: "user", Content: []any{
+				map[string]any{"type": "tool_result", "tool_use_id": "toolu_1", "content": "sunny"},
+			}},
+		},
+	}
+	cr, err := AnthropicRequestToCanonical(ar)
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	if len(cr.Messages) != 3 || cr.Messages[1].Content[0].Type != canonical.BlockToolUse || cr.Messages[2].Content[0].ToolUseID != "toolu_1" {
+		t.Fatalf("messages = %+v", cr.Messages)
+	}
+	back, err := CanonicalToAnthropicRequest(cr)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	if back.MaxTokens != 256 || back.System != "be brief" || *back.Temperature != 0.2 || len(back.Messages) != 3 || back.StopSeqs[0] != "END" {
+		t.Fatalf("request = %+v", back)
+	}
+}
+
+// Tool results must directly follow the assistant's tool_calls, so text in
+// the same user turn goes after the tool messages.
+func TestCanonicalToOpenAIToolResultsFirst(t *testing.T) {
+	cr := canonical.Request{Messages: []canonical.Message{
+		{Role: "assistant", Content: []canonical.ContentBlock{
+			{Type: canonical.BlockToolUse, ID: "call_1", Name: "f", Input: json.RawMessage(`{}`)},
+		}},
+		{Role: "user", Content: []canonical.ContentBlock{
+			{Type: canonical.BlockText, Text: "and then?"},
+			{Type: canonical.BlockToolResult, ToolUseID: "call_1", Content: json.RawMessage(`"ok"`)},
+		}},
+	}}
+	or, err := CanonicalToOpenAIChatRequest(cr)
+	if err != nil {
+		t.Fatalf("encode: %v", err)
+	}
+	msgs, _ := or.Messages.([]map[string]any)
+	if len(msgs) != 3 || msgs[1]["role"] != "tool" || msgs[1]["content"] != "ok" || msgs[2]["role"] != "user" {
+		t.Fatalf("messages = %+v", or.Messages)
+	}
+}
@@ -136,7 +136,7 @@ func TestResponseConversion_StopReasonsAndToolCalls(t *testing.T) {
 		StopReason: "tool_use",
 		Usage:      AnthropicUsage{InputTokens: 1, OutputTokens: 2},
 	}
-	oresp := AnthropicResponseToOpenAI(ar)
+	oresp := AnthropicResponseToOpenAI(ar, false)
 	if len(oresp.Choices) != 1 {
 		t.Fatalf("expected 1 choice")
 	}
@@ -259,7 +259,7 @@ func TestAnthropicResponseToOpenAIResponses_Shape(t *testing.T) {
 		StopReason: "tool_use",
 		Usage:      AnthropicUsage{InputTokens: 3, OutputTokens: 4},
 	}
-	resp := AnthropicResponseToOpenAIResponses(ar, "gpt-5")
+	resp := AnthropicResponseToOpenAIResponses(ar, "gpt-5", false)
 	if resp.Object != "response" {
 		t.Fatalf("expected object response, got %q", resp.Object)
 	}
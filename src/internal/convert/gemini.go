@@ -1,152 +1,133 @@
 package convert
 
 import (
-	"encoding/json"
 	"strings"
-	"time"
-
-	"github.com/google/uuid"
 
+	"claude-gateway/src/internal/canonical"
 	anthropicproto "claude-gateway/src/internal/proto/anthropic"
 	openaiproto "claude-gateway/src/internal/proto/openai"
 )
 
-func AnthropicToGeminiRequest(ar anthropicproto.MessageCreateRequest) (GeminiGenerateContentRequest, string) {
-	sysText := ""
-	switch v := ar.System.(type) {
-	case string:
-		sysText = v
-	case nil:
-	default:
-		b, _ := json.Marshal(v)
-		sysText = string(b)
+// AnthropicToGeminiRequest converts through the canonical request.
+func AnthropicToGeminiRequest(ar anthropicproto.MessageCreateRequest) (GeminiGenerateContentRequest, error) {
+	cr, err := AnthropicRequestToCanonical(ar)
+	if err != nil {
+		return GeminiGenerateContentRequest{}, err
+	}
+	return CanonicalToGeminiRequest(cr), nil
+}
+
+// OpenAIToGeminiRequest converts through the canonical request.
+func OpenAIToGeminiRequest(or openaiproto.ChatCompletionsRequest) (GeminiGenerateContentRequest, error) {
+	cr, err := OpenAIChatRequestToCanonical(or)
+	if err != nil {
+		return GeminiGenerateContentRequest{}, err
 	}
+	return CanonicalToGeminiRequest(cr), nil
+}
 
-	contents := make([]GeminiContent, 0, len(ar.Messages))
-	for _, m := range ar.Messages {
+// CanonicalToGeminiRequest encodes a generateContent request. Parts are
+// text only: tool results are inlined as text and images are dropped.
+func CanonicalToGeminiRequest(cr canonical.Request) GeminiGenerateContentRequest {
+	contents := make([]GeminiContent, 0, len(cr.Messages))
+	for _, m := range cr.Messages {
 		role := "user"
 		if m.Role == "assistant" {
 			role = "model"
 		}
-		contents = append(contents, GeminiContent{
-			Role:  role,
-			Parts: []GeminiPart{{Text: anthropicContentToText(m.Content)}},
-		})
+		var b strings.Builder
+		for _, blk := range m.Content {
+			switch blk.Type {
+			case canonical.BlockText:
+				b.WriteString(blk.Text)
+			case canonical.BlockToolResult:
+				b.WriteString(toolResultText(blk.Content))
+			}
+		}
+		contents = append(contents, GeminiContent{Role: role, Parts: []GeminiPart{{Text: b.String()}}})
 	}
 
-	req := GeminiGenerateContentRequest{
-		Contents: contents,
-		GenerationConfig: &GeminiGenConfig{
-			Temperature: ar.Temperature,
-			TopP:        ar.TopP,
-		},
+	gc := &GeminiGenConfig{
+		Temperature:      cr.Temperature,
+		TopP:             cr.TopP,
+		TopK:             cr.TopK,
+		StopSequences:    cr.Stop,
+		Seed:             cr.Seed,
+		PresencePenalty:  cr.PresencePenalty,
+		FrequencyPenalty: cr.FrequencyPenalty,
+		ResponseLogprobs: cr.Logprobs,
+		Logprobs:         cr.TopLogprobs,
 	}
-	if strings.TrimSpace(sysText) != "" {
-		req.SystemInstruction = &GeminiContent{Parts: []GeminiPart{{Text: sysText}}}
+	if cr.MaxTokens > 0 {
+		maxTokens := cr.MaxTokens
+		gc.MaxTokens = &maxTokens
 	}
-	return req, ar.Model
-}
-
-func OpenAIToGeminiRequest(or openaiproto.ChatCompletionsRequest) (GeminiGenerateContentRequest, string) {
-	sysText := ""
-	contents := make([]GeminiContent, 0)
-
-	typed, ok := or.Messages.([]any)
-	if ok {
-		for _, raw := range typed {
-			m, ok := raw.(map[string]any)
-			if !ok {
-				continue
-			}
-			role, _ := m["role"].(string)
-			if role == "system" {
-				if s, ok := m["content"].(string); ok {
-					sysText += s
-				} else {
-					b, _ := json.Marshal(m["content"])
-					sysText += string(b)
-				}
-				continue
-			}
-			gRole := "user"
-			if role == "assistant" {
-				gRole = "model"
-			}
-			text := ""
-			if s, ok := m["content"].(string); ok {
-				text = s
-			} else {
-				b, _ := json.Marshal(m["content"])
-				text = string(b)
-			}
-			contents = append(contents, GeminiContent{Role: gRole, Parts: []GeminiPart{{Text: text}}})
-		}
+	if cr.N != nil && *cr.N > 1 {
+		gc.CandidateCount = cr.N
 	}
-
-	req := GeminiGenerateContentRequest{
-		Contents: contents,
-		GenerationConfig: &GeminiGenConfig{
-			Temperature: or.Temperature,
-			TopP:        or.TopP,
-			MaxTokens:   or.MaxTokens,
-		},
+	if rf, ok := parseResponseFormat(cr.ResponseFormat); ok {
+		gc.ResponseMimeType = "application/json"
+		gc.ResponseSchema = geminiResponseSchema(rf.Schema)
 	}
-	if strings.TrimSpace(sysText) != "" {
-		req.SystemInstruction = &GeminiContent{Parts: []GeminiPart{{Text: sysText}}}
+	req := GeminiGenerateContentRequest{Contents: contents, GenerationConfig: gc}
+	if sys := joinText(cr.System, "\n"); strings.TrimSpace(sys) != "" {
+		req.SystemInstruction = &GeminiContent{Parts: []GeminiPart{{Text: sys}}}
 	}
-	return req, or.Model
+	return req
 }
 
-func GeminiResponseText(gr GeminiGenerateContentResponse) (string, *GeminiUsage) {
+// GeminiResponseToCanonical decodes the first candidate.
+func GeminiResponseToCanonical(gr GeminiGenerateContentResponse, model string) canonical.Response {
 	if len(gr.Candidates) == 0 {
-		return "", gr.UsageMetadata
+		return geminiCandidateToCanonical(GeminiCandidate{}, model, gr.UsageMetadata)
 	}
+	return geminiCandidateToCanonical(gr.Candidates[0], model, gr.UsageMetadata)
+}
+
+func geminiCandidateToCanonical(c GeminiCandidate, model string, usage *GeminiUsage) canonical.Response {
 	var b strings.Builder
-	for _, p := range gr.Candidates[0].Content.Parts {
+	for _, p := range c.Content.Parts {
 		b.WriteString(p.Text)
 	}
-	return b.String(), gr.UsageMetadata
-}
-
-func GeminiTextToOpenAI(text string, model string, usage *GeminiUsage) OpenAIChatCompletionResponse {
-	out := OpenAIChatCompletionResponse{
-		ID:      "chatcmpl_" + uuid.NewString(),
-		Object:  "chat.completion",
-		Created: time.Now().Unix(),
-		Model:   model,
-		Choices: []OpenAIChatChoice{{
-			Index: 0,
-			Message: OpenAIChatMessage{
-				Role:    "assistant",
-				Content: text,
-			},
-			FinishReason: "stop",
-		}},
+	out := canonical.Response{
+		Model:      model,
+		Content:    []canonical.ContentBlock{{Type: canonical.BlockText, Text: b.String()}},
+		StopReason: geminiFinishReasonToCanonical(c.FinishReason),
 	}
 	if usage != nil {
-		out.Usage = &OpenAIChatUsage{
-			PromptTokens:     usage.PromptTokenCount,
-			CompletionTokens: usage.CandidatesTokenCount,
-			TotalTokens:      usage.TotalTokenCount,
+		out.Usage = &canonical.Usage{
+			InputTokens:     usage.PromptTokenCount,
+			OutputTokens:    usage.CandidatesTokenCount,
+			CacheReadTokens: usage.CachedContentTokenCount,
 		}
 	}
 	return out
 }
 
-func GeminiTextToAnthropic(text string, model string, usage *GeminiUsage) AnthropicMessageResponse {
-	ar := AnthropicMessageResponse{
-		ID:         "msg_" + uuid.NewString(),
-		Type:       "message",
-		Role:       "assistant",
-		Model:      model,
-		Content:    []map[string]any{{"type": "text", "text": text}},
-		StopReason: "end_turn",
-		Usage:      AnthropicUsage{},
+// GeminiResponseToOpenAI converts every candidate into its own choice, so
+// candidateCount (OpenAI's n) round-trips. Usage covers all candidates and
+// is reported once.
+func GeminiResponseToOpenAI(gr GeminiGenerateContentResponse, model string) OpenAIChatCompletionResponse {
+	out := CanonicalToOpenAIResponse(GeminiResponseToCanonical(gr, model), false)
+	for _, c := range gr.Candidates[min(1, len(gr.Candidates)):] {
+		more := CanonicalToOpenAIResponse(geminiCandidateToCanonical(c, model, nil), false)
+		out = MergeChatCompletions(out, more)
 	}
-	if usage != nil {
-		ar.Usage.InputTokens = usage.PromptTokenCount
-		ar.Usage.OutputTokens = usage.CandidatesTokenCount
-	}
-	return ar
+	return out
+}
+
+// GeminiResponseToAnthropic converts the first candidate.
+func GeminiResponseToAnthropic(gr GeminiGenerateContentResponse, model string) AnthropicMessageResponse {
+	return CanonicalToAnthropicResponse(GeminiResponseToCanonical(gr, model), model)
 }
 
+func geminiFinishReasonToCanonical(fr string) string {
+	switch fr {
+	case "MAX_TOKENS":
+		return "max_tokens"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII":
+		return "refusal"
+	default:
+		return "end_turn"
+	}
+}
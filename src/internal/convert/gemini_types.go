@@ -1,5 +1,7 @@
 package convert
 
+import "encoding/json"
+
 type GeminiGenerateContentRequest struct {
 	SystemInstruction *GeminiContent        `json:"systemInstruction,omitempty"`
 	Contents          []GeminiContent       `json:"contents"`
@@ -22,6 +24,18 @@ type GeminiGenConfig struct {
 	Temperature *float64 `json:"temperature,omitempty"`
 	TopP        *float64 `json:"topP,omitempty"`
 	MaxTokens   *int     `json:"maxOutputTokens,omitempty"`
+	TopK        *int     `json:"topK,omitempty"`
+	StopSequences    []string `json:"stopSequences,omitempty"`
+	Seed             *int64   `json:"seed,omitempty"`
+	PresencePenalty  *float64 `json:"presencePenalty,omitempty"`
+	FrequencyPenalty *float64 `json:"frequencyPenalty,omitempty"`
+	ResponseLogprobs bool     `json:"responseLogprobs,omitempty"`
+	Logprobs         *int     `json:"logprobs,omitempty"`
+	CandidateCount   *int     `json:"candidateCount,omitempty"`
+	// Structured output: responseMimeType "application/json", optionally
+	// constrained by an OpenAPI-subset responseSchema.
+	ResponseMimeType string          `json:"responseMimeType,omitempty"`
+	ResponseSchema   json.RawMessage `json:"responseSchema,omitempty"`
 }
 
 type GeminiGenerateContentResponse struct {
@@ -30,12 +44,16 @@ type GeminiGenerateContentResponse struct {
 }
 
 type GeminiCandidate struct {
-	Content GeminiContent `json:"content"`
+	Content      GeminiContent `json:"content"`
+	Index        int           `json:"index,omitempty"`
+	FinishReason string        `json:"finishReason,omitempty"`
 }
 
 type GeminiUsage struct {
 	PromptTokenCount     int `json:"promptTokenCount"`
 	CandidatesTokenCount int `json:"candidatesTokenCount"`
 	TotalTokenCount      int `json:"totalTokenCount"`
+	// CachedContentTokenCount is the part of the prompt served from cache.
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
 }
 
@@ -3,41 +3,51 @@ package convert
 import (
 	"encoding/json"
 	"strings"
+
+	"claude-gateway/src/internal/canonical"
 )
 
-func anthropicContentToText(content any) string {
-	switch v := content.(type) {
-	case nil:
-		return ""
-	case string:
-		return v
-	case []any:
-		var b strings.Builder
-		for _, item := range v {
-			m, ok := item.(map[string]any)
-			if !ok {
-				continue
-			}
-			if m["type"] == "text" {
-				if t, ok := m["text"].(string); ok {
-					b.WriteString(t)
-				}
-			}
-		}
-		return b.String()
-	case []map[string]any:
-		var b strings.Builder
-		for _, m := range v {
-			if m["type"] == "text" {
-				if t, ok := m["text"].(string); ok {
-					b.WriteString(t)
-				}
-			}
+// rawField returns m[key] re-encoded as JSON, or nil when absent.
+func rawField(m map[string]any, key string) json.RawMessage {
+	v, ok := m[key]
+	if !ok || v == nil {
+		return nil
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil
+	}
+	return b
+}
+
+// rawToAny decodes raw for embedding in map-shaped messages, nil when empty.
+func rawToAny(raw json.RawMessage) any {
+	if len(raw) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil
+	}
+	return v
+}
+
+func hasCacheControl(blocks []canonical.ContentBlock) bool {
+	for _, b := range blocks {
+		if len(b.CacheControl) > 0 {
+			return true
 		}
-		return b.String()
-	default:
-		j, _ := json.Marshal(v)
-		return string(j)
 	}
+	return false
 }
 
+// joinText concatenates the text blocks with sep.
+func joinText(blocks []canonical.ContentBlock, sep string) string {
+	var texts []string
+	for _, b := range blocks {
+		if b.Type == canonical.BlockText {
+			texts = append(texts, b.Text)
+		}
+	}
+	return strings.Join(texts, sep)
+}
@@ -0,0 +1,649 @@
+package convert
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"claude-gateway/src/internal/canonical"
+	openaiproto "claude-gateway/src/internal/proto/openai"
+)
+
+// OpenAIChatRequestToCanonical decodes a Chat Completions request.
+func OpenAIChatRequestToCanonical(or openaiproto.ChatCompletionsRequest) (canonical.Request, error) {
+	cr := canonical.Request{
+		Facade:           canonical.FacadeOpenAI,
+		Model:            or.Model,
+		Stream:           or.Stream,
+		Temperature:      or.Temperature,
+		TopP:             or.TopP,
+		Stop:             openAIStopToList(or.Stop),
+		Seed:             or.Seed,
+		PresencePenalty:  or.PresencePenalty,
+		FrequencyPenalty: or.FrequencyPenalty,
+		N:                or.N,
+		Logprobs:         or.Logprobs != nil && *or.Logprobs,
+		TopLogprobs:      or.TopLogprobs,
+		User:             or.User,
+		Reasoning:        openAIReasoningToCanonical(or),
+		ResponseFormat:   or.ResponseFormat,
+	}
+	if or.MaxTokens != nil && *or.MaxTokens > 0 {
+		cr.MaxTokens = *or.MaxTokens
+	}
+
+	var msgs []map[string]any
+	switch typed := or.Messages.(type) {
+	case []any:
+		for _, raw := range typed {
+			m, ok := raw.(map[string]any)
+			if !ok {
+				return canonical.Request{}, ErrUnsupportedMessageShape
+			}
+			msgs = append(msgs, m)
+		}
+	case []map[string]any:
+		msgs = typed
+	default:
+		return canonical.Request{}, ErrUnsupportedMessageShape
+	}
+	for _, m := range msgs {
+		role, _ := m["role"].(string)
+		role = strings.TrimSpace(role)
+		switch role {
+		case "":
+			return canonical.Request{}, fmt.Errorf("%w: missing role", ErrUnsupportedMessageShape)
+		case "system", "developer":
+			cr.System = append(cr.System, openAISystemToCanonical(m["content"])...)
+		case "user", "assistant":
+			msg := canonical.Message{Role: role}
+			if role == "assistant" {
+				if rc, _ := m["reasoning_content"].(string); rc != "" {
+					msg.Content = append(msg.Content, canonical.ContentBlock{Type: canonical.BlockThinking, Thinking: rc})
+				}
+			}
+			blocks, err := openAIContentToCanonical(m["content"])
+			if err != nil {
+				return canonical.Request{}, err
+			}
+			msg.Content = append(msg.Content, blocks...)
+			calls, err := openAIToolCallsToCanonical(m["tool_calls"])
+			if err != nil {
+				return canonical.Request{}, err
+			}
+			msg.Content = append(msg.Content, calls...)
+			cr.Messages = append(cr.Messages, msg)
+		case "tool":
+			toolCallID, _ := m["tool_call_id"].(string)
+			toolCallID = strings.TrimSpace(toolCallID)
+			if toolCallID == "" {
+				return canonical.Request{}, fmt.Errorf("%w: missing tool_call_id", ErrUnsupportedMessageShape)
+			}
+			content, _ := json.Marshal(stringifyJSONish(m["content"]))
+			cr.Messages = append(cr.Messages, canonical.Message{Role: "user", Content: []canonical.ContentBlock{{
+				Type:      canonical.BlockToolResult,
+				ToolUseID: toolCallID,
+				Content:   content,
+			}}})
+		default:
+			return canonical.Request{}, fmt.Errorf("%w: unsupported role %q", ErrUnsupportedMessageShape, role)
+		}
+	}
+
+	tools, err := openAIToolsToCanonical(or.Tools)
+	if err != nil {
+		return canonical.Request{}, err
+	}
+	cr.Tools = tools
+	tc, err := openAIToolChoiceToCanonical(or.ToolChoice)
+	if err != nil {
+		return canonical.Request{}, err
+	}
+	cr.ToolChoice = tc
+	return cr, nil
+}
+
+// CanonicalToOpenAIChatRequest encodes a Chat Completions request. Vendor
+// specifics are applied afterwards by ApplyVendorReasoning and
+// ApplyVendorCacheControl.
+func CanonicalToOpenAIChatRequest(cr canonical.Request) (openaiproto.ChatCompletionsRequest, error) {
+	outMsgs := make([]map[string]any, 0, len(cr.Messages)+1)
+	if sys := canonicalSystemToOpenAI(cr.System); sys != nil {
+		outMsgs = append(outMsgs, map[string]any{"role": "system", "content": sys})
+	}
+	for _, m := range cr.Messages {
+		outMsgs = append(outMsgs, canonicalMessageToOpenAI(m)...)
+	}
+
+	tools, err := canonicalToolsToOpenAI(cr.Tools)
+	if err != nil {
+		return openaiproto.ChatCompletionsRequest{}, err
+	}
+	req := openaiproto.ChatCompletionsRequest{
+		Model:            cr.Model,
+		Messages:         outMsgs,
+		Temperature:      cr.Temperature,
+		TopP:             cr.TopP,
+		Stream:           cr.Stream,
+		Tools:            tools,
+		ToolChoice:       canonicalToolChoiceToOpenAI(cr.ToolChoice),
+		ResponseFormat:   cr.ResponseFormat,
+		N:                cr.N,
+		Seed:             cr.Seed,
+		PresencePenalty:  cr.PresencePenalty,
+		FrequencyPenalty: cr.FrequencyPenalty,
+		TopLogprobs:      cr.TopLogprobs,
+		User:             cr.User,
+	}
+	if cr.MaxTokens > 0 {
+		maxTokens := cr.MaxTokens
+		req.MaxTokens = &maxTokens
+	}
+	if cr.Stream {
+		// Ask for the trailing usage chunk so streamed usage can be reported.
+		req.StreamOpts = json.RawMessage(`{"include_usage":true}`)
+	}
+	if len(cr.Stop) > 0 {
+		req.Stop, _ = json.Marshal(cr.Stop)
+	}
+	if cr.Logprobs {
+		on := true
+		req.Logprobs = &on
+	}
+	if r := cr.Reasoning; r != nil {
+		req.ReasoningEffort = r.Effort
+		if req.ReasoningEffort == "" {
+			req.ReasoningEffort = budgetToEffort(r.BudgetTokens)
+		}
+	}
+	return req, nil
+}
+
+// OpenAIResponseToCanonical decodes the first choice of a Chat Completions
+// response.
+func OpenAIResponseToCanonical(or OpenAIChatCompletionResponse) canonical.Response {
+	out := canonical.Response{ID: or.ID, Model: or.Model}
+	if u := or.Usage; u != nil {
+		out.Usage = &canonical.Usage{InputTokens: u.PromptTokens, OutputTokens: u.CompletionTokens}
+		if d := u.PromptTokensDetails; d != nil && d.CachedTokens > 0 && d.CachedTokens <= u.PromptTokens {
+			out.Usage.CacheReadTokens = d.CachedTokens
+		}
+	}
+	if len(or.Choices) == 0 {
+		out.StopReason = "end_turn"
+		return out
+	}
+	msg := or.Choices[0].Message
+	if strings.TrimSpace(msg.ReasoningContent) != "" {
+		out.Content = append(out.Content, canonical.ContentBlock{Type: canonical.BlockThinking, Thinking: msg.ReasoningContent})
+	}
+	text := stringifyJSONish(msg.Content)
+	if strings.TrimSpace(text) != "" {
+		out.Content = append(out.Content, canonical.ContentBlock{Type: canonical.BlockText, Text: text})
+	}
+	for _, tc := range msg.ToolCalls {
+		input := json.RawMessage(tc.Function.Arguments)
+		if !json.Valid(input) {
+			input = json.RawMessage(`{}`)
+		}
+		out.Content = append(out.Content, canonical.ContentBlock{Type: canonical.BlockToolUse, ID: tc.ID, Name: tc.Function.Name, Input: input})
+	}
+	out.StopReason = mapOpenAIFinishReasonToAnthropicStopReason(or.Choices[0].FinishReason, len(msg.ToolCalls) > 0)
+	return out
+}
+
+// CanonicalToOpenAIResponse encodes a Chat Completions response. When the
+// request was structured, a call of the structured-output tool becomes the
+// message content, replacing any text the model wrote around it.
+func CanonicalToOpenAIResponse(resp canonical.Response, structured bool) OpenAIChatCompletionResponse {
+	var b, reasoning strings.Builder
+	var toolCalls []OpenAIToolCall
+	var document *string
+	for _, blk := range resp.Content {
+		switch blk.Type {
+		case canonical.BlockText:
+			b.WriteString(blk.Text)
+		case canonical.BlockThinking:
+			reasoning.WriteString(blk.Thinking)
+		case canonical.BlockToolUse:
+			if structured && blk.Name == StructuredOutputTool {
+				out := compactJSON(blk.Input)
+				document = &out
+				continue
+			}
+			if strings.TrimSpace(blk.ID) == "" || strings.TrimSpace(blk.Name) == "" {
+				continue
+			}
+			toolCalls = append(toolCalls, OpenAIToolCall{
+				ID:   blk.ID,
+				Type: "function",
+				Function: OpenAIFunction{
+					Name:      blk.Name,
+					Arguments: compactJSON(blk.Input),
+				},
+			})
+		}
+	}
+
+	finish := mapAnthropicStopReasonToOpenAIFinishReason(resp.StopReason)
+	if len(toolCalls) > 0 {
+		finish = "tool_calls"
+	} else if document != nil && finish == "tool_calls" {
+		finish = "stop"
+	}
+	content := b.String()
+	if document != nil {
+		content = *document
+	}
+
+	msg := OpenAIChatMessage{
+		Role:             "assistant",
+		Content:          content,
+		ReasoningContent: reasoning.String(),
+		ToolCalls:        toolCalls,
+	}
+	return OpenAIChatCompletionResponse{
+		ID:      "chatcmpl_" + uuid.NewString(),
+		Object:  "chat.completion",
+		Created: time.Now().Unix(),
+		Model:   resp.Model,
+		Choices: []OpenAIChatChoice{{
+			Index:        0,
+			Message:      msg,
+			FinishReason: finish,
+		}},
+		Usage: canonicalUsageToOpenAI(resp.Usage),
+	}
+}
+
+// canonicalUsageToOpenAI reports cache reads as cached_tokens; both cache
+// counters are already part of prompt_tokens.
+func canonicalUsageToOpenAI(u *canonical.Usage) *OpenAIChatUsage {
+	if u == nil {
+		return nil
+	}
+	out := &OpenAIChatUsage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+	if u.CacheReadTokens > 0 {
+		out.PromptTokensDetails = &OpenAIPromptTokensDetails{CachedTokens: u.CacheReadTokens}
+	}
+	return out
+}
+
+// openAIReasoningToCanonical reads reasoning_effort or a vendor switch:
+// DeepSeek/GLM thinking objects, Qwen enable_thinking/thinking_budget.
+func openAIReasoningToCanonical(or openaiproto.ChatCompletionsRequest) *canonical.Reasoning {
+	switch {
+	case or.ReasoningEffort != "":
+		if strings.EqualFold(or.ReasoningEffort, "none") {
+			return nil
+		}
+		return &canonical.Reasoning{Effort: strings.ToLower(or.ReasoningEffort)}
+	case or.EnableThinking != nil && *or.EnableThinking:
+		r := &canonical.Reasoning{BudgetTokens: thinkingBudgetMedium}
+		if or.ThinkingBudget != nil && *or.ThinkingBudget > 0 {
+			r.BudgetTokens = *or.ThinkingBudget
+		}
+		return r
+	case len(or.Thinking) > 0:
+		var th struct {
+			Type string `json:"type"`
+		}
+		if err := json.Unmarshal(or.Thinking, &th); err != nil || th.Type != "enabled" {
+			return nil
+		}
+		return &canonical.Reasoning{BudgetTokens: thinkingBudgetMedium}
+	}
+	return nil
+}
+
+// openAISystemToCanonical keeps text parts as blocks; other shapes are
+// passed on as their JSON text.
+func openAISystemToCanonical(content any) []canonical.ContentBlock {
+	if parts, ok := content.([]any); ok {
+		var out []canonical.ContentBlock
+		for _, pi := range parts {
+			p, ok := pi.(map[string]any)
+			if !ok || p["type"] != "text" {
+				return []canonical.ContentBlock{{Type: canonical.BlockText, Text: stringifyJSONish(content)}}
+			}
+			t, _ := p["text"].(string)
+			out = append(out, canonical.ContentBlock{Type: canonical.BlockText, Text: t, CacheControl: rawField(p, "cache_control")})
+		}
+		return out
+	}
+	return []canonical.ContentBlock{{Type: canonical.BlockText, Text: stringifyJSONish(content)}}
+}
+
+// canonicalSystemToOpenAI returns the system content: a string, or a list
+// of text parts when some block carries cache_control.
+func canonicalSystemToOpenAI(system []canonical.ContentBlock) any {
+	if len(system) == 0 {
+		return nil
+	}
+	if hasCacheControl(system) {
+		parts := make([]any, 0, len(system))
+		for _, b := range system {
+			parts = append(parts, textBlockWithCache(b))
+		}
+		return parts
+	}
+	return joinText(system, "\n")
+}
+
+func openAIContentToCanonical(content any) ([]canonical.ContentBlock, error) {
+	switch v := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if strings.TrimSpace(v) == "" {
+			return nil, nil
+		}
+		return []canonical.ContentBlock{{Type: canonical.BlockText, Text: v}}, nil
+	case []any:
+		blocks := make([]canonical.ContentBlock, 0, len(v))
+		for _, it := range v {
+			m, ok := it.(map[string]any)
+			if !ok {
+				return nil, fmt.Errorf("%w: content part not object", ErrUnsupportedContentPart)
+			}
+			typ, _ := m["type"].(string)
+			switch typ {
+			case "text":
+				t, _ := m["text"].(string)
+				if t == "" {
+					continue
+				}
+				blocks = append(blocks, canonical.ContentBlock{Type: canonical.BlockText, Text: t, CacheControl: rawField(m, "cache_control")})
+			case "image_url":
+				img, _ := m["image_url"].(map[string]any)
+				u, _ := img["url"].(string)
+				if strings.TrimSpace(u) == "" {
+					return nil, fmt.Errorf("%w: image_url missing url", ErrUnsupportedContentPart)
+				}
+				if mediaType, data, ok := parseDataImageURL(u); ok {
+					blocks = append(blocks, canonical.ContentBlock{Type: canonical.BlockImage, MediaType: mediaType, Data: data})
+					break
+				}
+				if strings.HasPrefix(strings.TrimSpace(u), "https://") {
+					blocks = append(blocks, canonical.ContentBlock{Type: canonical.BlockImage, URL: u})
+					break
+				}
+				return nil, fmt.Errorf("%w: image_url must be data:image/*;base64 or https URL", ErrUnsupportedContentPart)
+			default:
+				return nil, fmt.Errorf("%w: unsupported content part type %q", ErrUnsupportedContentPart, typ)
+			}
+		}
+		return blocks, nil
+	default:
+		return nil, fmt.Errorf("%w: unsupported OpenAI content type %T", ErrUnsupportedContentPart, v)
+	}
+}
+
+func openAIToolCallsToCanonical(v any) ([]canonical.ContentBlock, error) {
+	if v == nil {
+		return nil, nil
+	}
+	raw, ok := v.([]any)
+	if !ok {
+		return nil, fmt.Errorf("%w: tool_calls is not array", ErrUnsupportedMessageShape)
+	}
+	out := make([]canonical.ContentBlock, 0, len(raw))
+	for _, it := range raw {
+		m, ok := it.(map[string]any)
+		if !ok {
+			return nil, fmt.Errorf("%w: tool_call is not object", ErrUnsupportedMessageShape)
+		}
+		id, _ := m["id"].(string)
+		typ, _ := m["type"].(string)
+		fn, _ := m["function"].(map[string]any)
+		name, _ := fn["name"].(string)
+		args, _ := fn["arguments"].(string)
+		if strings.TrimSpace(id) == "" || strings.TrimSpace(name) == "" {
+			return nil, fmt.Errorf("%w: tool_call missing id/name", ErrUnsupportedMessageShape)
+		}
+		if typ != "" && typ != "function" {
+			return nil, fmt.Errorf("%w: unsupported tool_call type %q", ErrUnsupportedMessageShape, typ)
+		}
+		input := json.RawMessage(`{}`)
+		if strings.TrimSpace(args) != "" {
+			var probe any
+			if err := json.Unmarshal([]byte(args), &probe); err != nil {
+				return nil, fmt.Errorf("%w: %v", ErrInvalidToolArguments, err)
+			}
+			input = json.RawMessage(args)
+		}
+		out = append(out, canonical.ContentBlock{Type: canonical.BlockToolUse, ID: id, Name: name, Input: input})
+	}
+	return out, nil
+}
+
+// canonicalMessageToOpenAI emits the message followed by one tool message
+// per tool_result. A user turn holding only tool results becomes just the
+// tool messages, which must directly follow the assistant's tool_calls.
+func canonicalMessageToOpenAI(m canonical.Message) []map[string]any {
+	var (
+		textParts      []string
+		reasoningParts []string
+		contentParts   []any
+		hasNonText     bool
+		hasCacheMarker bool
+		toolCalls      []map[string]any
+		toolMessages   []map[string]any
+	)
+	for _, b := range m.Content {
+		switch b.Type {
+		case canonical.BlockText:
+			if b.Text == "" {
+				continue
+			}
+			textParts = append(textParts, b.Text)
+			contentParts = append(contentParts, textBlockWithCache(b))
+			if len(b.CacheControl) > 0 {
+				hasCacheMarker = true
+			}
+		case canonical.BlockThinking:
+			if b.Thinking != "" {
+				reasoningParts = append(reasoningParts, b.Thinking)
+			}
+		case canonical.BlockImage:
+			u := b.URL
+			if u == "" {
+				u = "data:" + b.MediaType + ";base64," + b.Data
+			}
+			contentParts = append(contentParts, map[string]any{
+				"type":      "image_url",
+				"image_url": map[string]any{"url": u},
+			})
+			hasNonText = true
+		case canonical.BlockToolUse:
+			toolCalls = append(toolCalls, map[string]any{
+				"id":   b.ID,
+				"type": "function",
+				"function": map[string]any{
+					"name":      b.Name,
+					"arguments": compactJSON(b.Input),
+				},
+			})
+		case canonical.BlockToolResult:
+			content := toolResultText(b.Content)
+			toolMessages = append(toolMessages, map[string]any{
+				"role":         "tool",
+				"tool_call_id": b.ToolUseID,
+				"content":      content,
+			})
+		}
+		// Redacted thinking is encrypted reasoning only Anthropic can read.
+	}
+
+	var content any
+	if hasNonText || hasCacheMarker {
+		content = contentParts
+	} else {
+		content = strings.Join(textParts, "")
+	}
+
+	if m.Role == "user" {
+		out := toolMessages
+		if len(contentParts) > 0 || len(toolMessages) == 0 {
+			out = append(out, map[string]any{"role": "user", "content": content})
+		}
+		return out
+	}
+	msg := map[string]any{"role": m.Role, "content": content}
+	if len(reasoningParts) > 0 {
+		msg["reasoning_content"] = strings.Join(reasoningParts, "")
+	}
+	if len(toolCalls) > 0 {
+		msg["tool_calls"] = toolCalls
+	}
+	return append([]map[string]any{msg}, toolMessages...)
+}
+
+// toolResultText flattens Anthropic tool_result content to text; non-text
+// content is passed on as JSON.
+func toolResultText(raw json.RawMessage) string {
+	v := rawToAny(raw)
+	if s, ok := v.(string); ok {
+		return s
+	}
+	var b strings.Builder
+	if parts, ok := v.([]any); ok {
+		for _, it := range parts {
+			if m, ok := it.(map[string]any); ok && m["type"] == "text" {
+				s, _ := m["text"].(string)
+				b.WriteString(s)
+			}
+		}
+	}
+	if strings.TrimSpace(b.String()) == "" {
+		return stringifyJSONish(v)
+	}
+	return b.String()
+}
+
+type openAIToolDef struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string          `json:"name"`
+		Description string          `json:"description,omitempty"`
+		Parameters  json.RawMessage `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+func openAIToolsToCanonical(raw json.RawMessage) ([]canonical.Tool, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var tools []openAIToolDef
+	if err := json.Unmarshal(raw, &tools); err != nil {
+		return nil, fmt.Errorf("invalid tools: %w", err)
+	}
+	out := make([]canonical.Tool, 0, len(tools))
+	for _, t := range tools {
+		if t.Type != "" && t.Type != "function" {
+			return nil, fmt.Errorf("%w: unsupported OpenAI tool type %q", ErrUnsupportedContentPart, t.Type)
+		}
+		if strings.TrimSpace(t.Function.Name) == "" {
+			return nil, fmt.Errorf("%w: tool missing name", ErrUnsupportedContentPart)
+		}
+		out = append(out, canonical.Tool{
+			Name:        t.Function.Name,
+			Description: t.Function.Description,
+			InputSchema: t.Function.Parameters,
+		})
+	}
+	return out, nil
+}
+
+func canonicalToolsToOpenAI(tools []canonical.Tool) (json.RawMessage, error) {
+	if len(tools) == 0 {
+		return nil, nil
+	}
+	out := make([]map[string]any, 0, len(tools))
+	for _, t := range tools {
+		var params any = map[string]any{"type": "object", "properties": map[string]any{}}
+		if len(t.InputSchema) > 0 {
+			if err := json.Unmarshal(t.InputSchema, &params); err != nil {
+				return nil, fmt.Errorf("invalid tool input_schema for %q: %w", t.Name, err)
+			}
+		}
+		out = append(out, map[string]any{
+			"type": "function",
+			"function": map[string]any{
+				"name":        t.Name,
+				"description": t.Description,
+				"parameters":  params,
+			},
+		})
+	}
+	b, _ := json.Marshal(out)
+	return b, nil
+}
+
+func openAIToolChoiceToCanonical(raw json.RawMessage) (*canonical.ToolChoice, error) {
+	if len(raw) == 0 {
+		return nil, nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil, fmt.Errorf("invalid tool_choice: %w", err)
+	}
+	mode := ""
+	switch vv := v.(type) {
+	case string:
+		mode = vv
+	case map[string]any:
+		mode, _ = vv["type"].(string)
+		if mode == "function" {
+			fn, _ := vv["function"].(map[string]any)
+			name, _ := fn["name"].(string)
+			if strings.TrimSpace(name) == "" {
+				return nil, fmt.Errorf("%w: tool_choice.function missing name", ErrUnsupportedMessageShape)
+			}
+			return &canonical.ToolChoice{Type: "tool", Name: name}, nil
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported tool_choice shape", ErrUnsupportedMessageShape)
+	}
+	switch mode {
+	case "auto", "none":
+		return &canonical.ToolChoice{Type: mode}, nil
+	case "required":
+		return &canonical.ToolChoice{Type: "any"}, nil
+	}
+	return nil, fmt.Errorf("%w: unsupported tool_choice %q", ErrUnsupportedMessageShape, mode)
+}
+
+func canonicalToolChoiceToOpenAI(tc *canonical.ToolChoice) json.RawMessage {
+	if tc == nil {
+		return nil
+	}
+	switch tc.Type {
+	case "any":
+		return json.RawMessage(`"required"`)
+	case "tool":
+		b, _ := json.Marshal(map[string]any{"type": "function", "function": map[string]any{"name": tc.Name}})
+		return b
+	}
+	return json.RawMessage(`"` + tc.Type + `"`)
+}
+
+// compactJSON renders raw as compact JSON text, "{}" when empty.
+func compactJSON(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return "{}"
+	}
+	var b bytes.Buffer
+	if err := json.Compact(&b, raw); err != nil {
+		return string(raw)
+	}
+	return b.String()
+}
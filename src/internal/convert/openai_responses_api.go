@@ -1,11 +1,12 @@
 package convert
 
 import (
-	"encoding/json"
 	"strings"
 	"time"
 
 	"github.com/google/uuid"
+
+	"claude-gateway/src/internal/canonical"
 )
 
 type OpenAIResponsesResponse struct {
@@ -21,6 +22,7 @@ type OpenAIResponsesUsage struct {
 	InputTokens  int `json:"input_tokens"`
 	OutputTokens int `json:"output_tokens"`
 	TotalTokens  int `json:"total_tokens"`
+	InputTokensDetails *OpenAIPromptTokensDetails `json:"input_tokens_details,omitempty"`
 }
 
 type OpenAIResponsesItem struct {
@@ -35,32 +37,41 @@ type OpenAIResponsesItem struct {
 	Arguments string `json:"arguments,omitempty"`
 }
 
-func AnthropicResponseToOpenAIResponses(ar AnthropicMessageResponse, model string) OpenAIResponsesResponse {
+// AnthropicResponseToOpenAIResponses converts through the canonical
+// response.
+func AnthropicResponseToOpenAIResponses(ar AnthropicMessageResponse, model string, structured bool) OpenAIResponsesResponse {
+	return CanonicalToOpenAIResponses(AnthropicResponseToCanonical(ar), model, structured)
+}
+
+// CanonicalToOpenAIResponses encodes a Responses API response: function
+// calls first, then one message with the text. When the request was
+// structured, a call of the structured-output tool becomes the text.
+func CanonicalToOpenAIResponses(resp canonical.Response, model string, structured bool) OpenAIResponsesResponse {
 	var (
 		textParts []string
 		toolCalls []OpenAIResponsesItem
 	)
 
-	for _, blk := range ar.Content {
-		switch blk["type"] {
-		case "text":
-			if t, ok := blk["text"].(string); ok && t != "" {
-				textParts = append(textParts, t)
+	for _, blk := range resp.Content {
+		switch blk.Type {
+		case canonical.BlockText:
+			if blk.Text != "" {
+				textParts = append(textParts, blk.Text)
 			}
-		case "tool_use":
-			callID, _ := blk["id"].(string)
-			name, _ := blk["name"].(string)
-			input := blk["input"]
-			if strings.TrimSpace(callID) == "" || strings.TrimSpace(name) == "" {
+		case canonical.BlockToolUse:
+			if structured && blk.Name == StructuredOutputTool {
+				textParts = append(textParts, compactJSON(blk.Input))
+				continue
+			}
+			if strings.TrimSpace(blk.ID) == "" || strings.TrimSpace(blk.Name) == "" {
 				continue
 			}
-			argBytes, _ := json.Marshal(input)
 			toolCalls = append(toolCalls, OpenAIResponsesItem{
 				ID:        "fc_" + uuid.NewString(),
 				Type:      "function_call",
-				CallID:    callID,
-				Name:      name,
-				Arguments: string(argBytes),
+				CallID:    blk.ID,
+				Name:      blk.Name,
+				Arguments: compactJSON(blk.Input),
 			})
 		}
 	}
@@ -84,11 +95,13 @@ func AnthropicResponseToOpenAIResponses(ar AnthropicMessageResponse, model strin
 	}
 
 	var usage *OpenAIResponsesUsage
-	if ar.Usage.InputTokens != 0 || ar.Usage.OutputTokens != 0 {
+	if u := resp.Usage; u != nil && (u.InputTokens != 0 || u.OutputTokens != 0) {
+		cu := canonicalUsageToOpenAI(u)
 		usage = &OpenAIResponsesUsage{
-			InputTokens:  ar.Usage.InputTokens,
-			OutputTokens: ar.Usage.OutputTokens,
-			TotalTokens:  ar.Usage.InputTokens + ar.Usage.OutputTokens,
+			InputTokens:        cu.PromptTokens,
+			OutputTokens:       cu.CompletionTokens,
+			TotalTokens:        cu.TotalTokens,
+			InputTokensDetails: cu.PromptTokensDetails,
 		}
 	}
 
@@ -101,4 +114,3 @@ func AnthropicResponseToOpenAIResponses(ar AnthropicMessageResponse, model strin
 		Usage:     usage,
 	}
 }
-
@@ -0,0 +1,227 @@
+package convert
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+
+	"claude-gateway/src/internal/rawjson"
+)
+
+// Dialect names the client-facing request shape a parameter arrives in.
+type Dialect string
+
+const (
+	DialectAnthropic  Dialect = "anthropic"
+	DialectOpenAIChat Dialect = "openai_chat"
+	DialectResponses  Dialect = "openai_responses"
+)
+
+// ParamSupport says how a target provider handles a client parameter.
+type ParamSupport int
+
+const (
+	// ParamNative: the target has the parameter or a direct equivalent and
+	// the converter maps it.
+	ParamNative ParamSupport = iota
+	// ParamEmulated: the gateway reproduces the behaviour itself (n>1 via
+	// parallel upstream calls). Only non-streaming requests are emulated.
+	ParamEmulated
+	// ParamIgnored: no equivalent, but dropping it does not change the
+	// output (e.g. end-user ids for abuse tracking).
+	ParamIgnored
+	// ParamUnsupported: dropping it changes the output the client asked
+	// for. Pools with the reject policy refuse such requests.
+	ParamUnsupported
+)
+
+type paramRule struct {
+	dialect Dialect
+	name    string
+	// set reports whether the value actually asks for something; nil means
+	// any non-null value does.
+	set     func(json.RawMessage) bool
+	targets map[string]ParamSupport
+}
+
+// paramMatrix lists every sampling/output parameter whose support differs
+// between providers. Parameters every provider understands (temperature,
+// top_p, max tokens) are not listed. A target missing from targets is
+// ParamNative: that is always the case for the dialect's own provider.
+var paramMatrix = []paramRule{
+	{dialect: DialectAnthropic, name: "stop_sequences", set: nonEmptyArray,
+		targets: map[string]ParamSupport{"openai": ParamNative, "gemini": ParamNative}},
+	{dialect: DialectAnthropic, name: "top_k",
+		targets: map[string]ParamSupport{"openai": ParamUnsupported, "gemini": ParamNative}},
+	{dialect: DialectAnthropic, name: "metadata",
+		targets: map[string]ParamSupport{"openai": ParamNative, "gemini": ParamIgnored}},
+
+	{dialect: DialectOpenAIChat, name: "stop",
+		targets: map[string]ParamSupport{"anthropic": ParamNative, "gemini": ParamNative}},
+	{dialect: DialectOpenAIChat, name: "n", set: intAbove(1),
+		targets: map[string]ParamSupport{"anthropic": ParamEmulated, "gemini": ParamNative}},
+	{dialect: DialectOpenAIChat, name: "seed",
+		targets: map[string]ParamSupport{"anthropic": ParamUnsupported, "gemini": ParamNative}},
+	{dialect: DialectOpenAIChat, name: "presence_penalty", set: nonZeroNumber,
+		targets: map[string]ParamSupport{"anthropic": ParamUnsupported, "gemini": ParamNative}},
+	{dialect: DialectOpenAIChat, name: "frequency_penalty", set: nonZeroNumber,
+		targets: map[string]ParamSupport{"anthropic": ParamUnsupported, "gemini": ParamNative}},
+	{dialect: DialectOpenAIChat, name: "logprobs", set: isTrue,
+		targets: map[string]ParamSupport{"anthropic": ParamUnsupported, "gemini": ParamNative}},
+	{dialect: DialectOpenAIChat, name: "top_logprobs", set: intAbove(0),
+		targets: map[string]ParamSupport{"anthropic": ParamUnsupported, "gemini": ParamNative}},
+	{dialect: DialectOpenAIChat, name: "user",
+		targets: map[string]ParamSupport{"anthropic": ParamNative, "gemini": ParamIgnored}},
+
+	{dialect: DialectResponses, name: "top_logprobs", set: intAbove(0),
+		targets: map[string]ParamSupport{"anthropic": ParamUnsupported, "gemini": ParamUnsupported}},
+	{dialect: DialectResponses, name: "user",
+		targets: map[string]ParamSupport{"anthropic": ParamNative, "gemini": ParamIgnored}},
+}
+
+// ParamSupportFor reports how providerType handles a parameter of dialect d.
+// Parameters outside the matrix are ParamNative.
+func ParamSupportFor(d Dialect, name, providerType string) ParamSupport {
+	for _, r := range paramMatrix {
+		if r.dialect == d && r.name == name {
+			return r.targets[strings.ToLower(strings.TrimSpace(providerType))]
+		}
+	}
+	return ParamNative
+}
+
+// UnsupportedParams returns the parameters set in body that providerType
+// cannot honour, sorted by name. Emulated parameters count as unsupported
+// on streaming requests.
+func UnsupportedParams(d Dialect, body []byte, providerType string) []string {
+	stream := false
+	if v, ok := rawjson.Get(body, "stream"); ok {
+		stream = isTrue(v)
+	}
+	var out []string
+	for _, r := range paramMatrix {
+		if r.dialect != d {
+			continue
+		}
+		v, ok := rawjson.Get(body, r.name)
+		if !ok || isNull(v) {
+			continue
+		}
+		if r.set != nil && !r.set(v) {
+			continue
+		}
+		switch ParamSupportFor(d, r.name, providerType) {
+		case ParamUnsupported:
+			out = append(out, r.name)
+		case ParamEmulated:
+			if stream {
+				out = append(out, r.name)
+			}
+		}
+	}
+	sort.Strings(out)
+	return out
+}
+
+func isNull(v json.RawMessage) bool {
+	return strings.TrimSpace(string(v)) == "null"
+}
+
+func isTrue(v json.RawMessage) bool {
+	var b bool
+	return json.Unmarshal(v, &b) == nil && b
+}
+
+func nonZeroNumber(v json.RawMessage) bool {
+	var f float64
+	return json.Unmarshal(v, &f) == nil && f != 0
+}
+
+func nonEmptyArray(v json.RawMessage) bool {
+	var a []any
+	return json.Unmarshal(v, &a) == nil && len(a) > 0
+}
+
+func intAbove(n int) func(json.RawMessage) bool {
+	return func(v json.RawMessage) bool {
+		var i int
+		return json.Unmarshal(v, &i) == nil && i > n
+	}
+}
+
+// openAIStopToList normalizes OpenAI's stop, which is a string or an array
+// of strings.
+func openAIStopToList(raw json.RawMessage) []string {
+	if len(raw) == 0 || isNull(raw) {
+		return nil
+	}
+	var s string
+	if json.Unmarshal(raw, &s) == nil {
+		if s == "" {
+			return nil
+		}
+		return []string{s}
+	}
+	var list []string
+	if json.Unmarshal(raw, &list) != nil {
+		return nil
+	}
+	out := list[:0]
+	for _, s := range list {
+		if s != "" {
+			out = append(out, s)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// anthropicMetadataUserID extracts metadata.user_id.
+func anthropicMetadataUserID(raw json.RawMessage) string {
+	var md struct {
+		UserID string `json:"user_id"`
+	}
+	if len(raw) == 0 || json.Unmarshal(raw, &md) != nil {
+		return ""
+	}
+	return md.UserID
+}
+
+// MergeChatCompletions folds the results of parallel calls made to emulate
+// n>1 into one response: choices are renumbered in order and usage is
+// summed, since every call was billed for the full prompt.
+func MergeChatCompletions(first OpenAIChatCompletionResponse, rest ...OpenAIChatCompletionResponse) OpenAIChatCompletionResponse {
+	out := first
+	out.Choices = append([]OpenAIChatChoice(nil), first.Choices...)
+	usage := OpenAIChatUsage{}
+	details := OpenAIPromptTokensDetails{}
+	hasUsage, hasDetails := false, false
+	for _, r := range append([]OpenAIChatCompletionResponse{first}, rest...) {
+		if r.Usage == nil {
+			continue
+		}
+		hasUsage = true
+		usage.PromptTokens += r.Usage.PromptTokens
+		usage.CompletionTokens += r.Usage.CompletionTokens
+		usage.TotalTokens += r.Usage.TotalTokens
+		if r.Usage.PromptTokensDetails != nil {
+			hasDetails = true
+			details.CachedTokens += r.Usage.PromptTokensDetails.CachedTokens
+		}
+	}
+	for _, r := range rest {
+		out.Choices = append(out.Choices, r.Choices...)
+	}
+	for i := range out.Choices {
+		out.Choices[i].Index = i
+	}
+	if hasUsage {
+		if hasDetails {
+			usage.PromptTokensDetails = &details
+		}
+		out.Usage = &usage
+	}
+	return out
+}
@@ -0,0 +1,111 @@
+package convert
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	anthropicproto "claude-gateway/src/internal/proto/anthropic"
+	openaiproto "claude-gateway/src/internal/proto/openai"
+)
+
+func TestUnsupportedParams(t *testing.T) {
+	cases := []struct {
+		dialect  Dialect
+		body     string
+		provider string
+		want     []string
+	}{
+		{DialectOpenAIChat, `{"seed":1,"logprobs":true,"user":"u","stop":"x"}`, "anthropic", []string{"logprobs", "seed"}},
+		{DialectOpenAIChat, `{"seed":1,"logprobs":true}`, "openai", nil},
+		{DialectOpenAIChat, `{"seed":1,"logprobs":true,"user":"u"}`, "gemini", nil},
+		{DialectOpenAIChat, `{"n":1,"logprobs":false,"presence_penalty":0}`, "anthropic", nil},
+		{DialectOpenAIChat, `{"n":3}`, "anthropic", nil},
+		{DialectOpenAIChat, `{"n":3,"stream":true}`, "anthropic", []string{"n"}},
+		{DialectAnthropic, `{"top_k":5,"stop_sequences":["a"]}`, "openai", []string{"top_k"}},
+		{DialectAnthropic, `{"top_k":null}`, "openai", nil},
+		{DialectResponses, `{"top_logprobs":2}`, "anthropic", []string{"top_logprobs"}},
+	}
+	for _, c := range cases {
+		got := UnsupportedParams(c.dialect, []byte(c.body), c.provider)
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s %s -> %s: got %v, want %v", c.dialect, c.body, c.provider, got, c.want)
+		}
+	}
+}
+
+func TestStopAndUserMapping(t *testing.T) {
+	or := openaiproto.ChatCompletionsRequest{
+		Messages: []any{map[string]any{"role": "user", "content": "hi"}},
+		Stop:     json.RawMessage(`"END"`),
+		User:     "user-7",
+	}
+	ar, err := OpenAIToAnthropicMessageRequest(or)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if !reflect.DeepEqual(ar.StopSeqs, []string{"END"}) || string(ar.Metadata) != `{"user_id":"user-7"}` {
+		t.Fatalf("stop=%v metadata=%s", ar.StopSeqs, ar.Metadata)
+	}
+
+	back, err := AnthropicToOpenAIChatRequest(anthropicproto.MessageCreateRequest{
+		MaxTokens: 10,
+		StopSeqs:  []string{"a", "b"},
+		Metadata:  json.RawMessage(`{"user_id":"user-7"}`),
+	})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if string(back.Stop) != `["a","b"]` || back.User != "user-7" {
+		t.Fatalf("stop=%s user=%q", back.Stop, back.User)
+	}
+}
+
+func TestOpenAIParamsToGemini(t *testing.T) {
+	n, seed, pp := 2, int64(42), 0.5
+	logprobs := true
+	gr, err := OpenAIToGeminiRequest(openaiproto.ChatCompletionsRequest{
+		Messages:        []any{map[string]any{"role": "user", "content": "hi"}},
+		Stop:            json.RawMessage(`["x",""]`),
+		N:               &n,
+		Seed:            &seed,
+		PresencePenalty: &pp,
+		Logprobs:        &logprobs,
+	})
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	gc := gr.GenerationConfig
+	if !reflect.DeepEqual(gc.StopSequences, []string{"x"}) || *gc.CandidateCount != 2 || *gc.Seed != 42 || *gc.PresencePenalty != 0.5 || !gc.ResponseLogprobs {
+		t.Fatalf("generationConfig = %+v", gc)
+	}
+
+	out := GeminiResponseToOpenAI(GeminiGenerateContentResponse{Candidates: []GeminiCandidate{
+		{Content: GeminiContent{Parts: []GeminiPart{{Text: "one"}}}},
+		{Content: GeminiContent{Parts: []GeminiPart{{Text: "two"}}}, Index: 1, FinishReason: "MAX_TOKENS"},
+	}}, "gemini-pro")
+	if len(out.Choices) != 2 || out.Choices[1].Message.Content != "two" || out.Choices[1].Index != 1 || out.Choices[1].FinishReason != "length" {
+		t.Fatalf("choices = %+v", out.Choices)
+	}
+}
+
+func TestMergeChatCompletions(t *testing.T) {
+	one := OpenAIChatCompletionResponse{
+		Choices: []OpenAIChatChoice{{Message: OpenAIChatMessage{Content: "a"}}},
+		Usage:   &OpenAIChatUsage{PromptTokens: 10, CompletionTokens: 2, TotalTokens: 12},
+	}
+	two := OpenAIChatCompletionResponse{
+		Choices: []OpenAIChatChoice{{Message: OpenAIChatMessage{Content: "b"}}},
+		Usage:   &OpenAIChatUsage{PromptTokens: 10, CompletionTokens: 3, TotalTokens: 13, PromptTokensDetails: &OpenAIPromptTokensDetails{CachedTokens: 8}},
+	}
+	got := MergeChatCompletions(one, two)
+	if len(got.Choices) != 2 || got.Choices[1].Index != 1 || got.Choices[1].Message.Content != "b" {
+		t.Fatalf("choices = %+v", got.Choices)
+	}
+	if u := got.Usage; u.PromptTokens != 20 || u.CompletionTokens != 5 || u.TotalTokens != 25 || u.PromptTokensDetails.CachedTokens != 8 {
+		t.Fatalf("usage = %+v", u)
+	}
+	if one.Usage.PromptTokens != 10 {
+		t.Fatal("first response was modified")
+	}
+}
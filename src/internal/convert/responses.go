@@ -1,12 +1,6 @@
 package convert
 
-import (
-	"encoding/json"
-	"strings"
-	"time"
-
-	"github.com/google/uuid"
-)
+import "strings"
 
 type AnthropicMessageResponse struct {
 	ID           string              `json:"id"`
@@ -22,6 +16,14 @@ type AnthropicMessageResponse struct {
 type AnthropicUsage struct {
 	InputTokens  int `json:"input_tokens"`
 	OutputTokens int `json:"output_tokens"`
+	// Anthropic reports cached prompt tokens separately from input_tokens.
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens,omitempty"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens,omitempty"`
+}
+
+// PromptTokens is the whole prompt size, cached tokens included.
+func (u AnthropicUsage) PromptTokens() int {
+	return u.InputTokens + u.CacheCreationInputTokens + u.CacheReadInputTokens
 }
 
 type OpenAIChatCompletionResponse struct {
@@ -43,12 +45,20 @@ type OpenAIChatMessage struct {
 	Role      string         `json:"role"`
 	Content   any            `json:"content"`
 	ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
+	// ReasoningContent carries thinking, as DeepSeek-style APIs return it.
+	ReasoningContent string `json:"reasoning_content,omitempty"`
 }
 
 type OpenAIChatUsage struct {
 	PromptTokens     int `json:"prompt_tokens"`
 	CompletionTokens int `json:"completion_tokens"`
 	TotalTokens      int `json:"total_tokens"`
+	// PromptTokensDetails.CachedTokens is included in PromptTokens.
+	PromptTokensDetails *OpenAIPromptTokensDetails `json:"prompt_tokens_details,omitempty"`
+}
+
+type OpenAIPromptTokensDetails struct {
+	CachedTokens int `json:"cached_tokens"`
 }
 
 type OpenAIToolCall struct {
@@ -62,116 +72,14 @@ type OpenAIFunction struct {
 	Arguments string `json:"arguments"`
 }
 
-func AnthropicResponseToOpenAI(ar AnthropicMessageResponse) OpenAIChatCompletionResponse {
-	var b strings.Builder
-	var toolCalls []OpenAIToolCall
-	for _, blk := range ar.Content {
-		switch blk["type"] {
-		case "text":
-			if t, ok := blk["text"].(string); ok {
-				b.WriteString(t)
-			}
-		case "tool_use":
-			id, _ := blk["id"].(string)
-			name, _ := blk["name"].(string)
-			input := blk["input"]
-			argBytes, _ := json.Marshal(input)
-			if strings.TrimSpace(id) != "" && strings.TrimSpace(name) != "" {
-				toolCalls = append(toolCalls, OpenAIToolCall{
-					ID:   id,
-					Type: "function",
-					Function: OpenAIFunction{
-						Name:      name,
-						Arguments: string(argBytes),
-					},
-				})
-			}
-		}
-	}
-
-	finish := mapAnthropicStopReasonToOpenAIFinishReason(ar.StopReason)
-	if len(toolCalls) > 0 {
-		finish = "tool_calls"
-	}
-
-	usage := &OpenAIChatUsage{
-		PromptTokens:     ar.Usage.InputTokens,
-		CompletionTokens: ar.Usage.OutputTokens,
-		TotalTokens:      ar.Usage.InputTokens + ar.Usage.OutputTokens,
-	}
-
-	msg := OpenAIChatMessage{
-		Role:    "assistant",
-		Content: b.String(),
-	}
-	if len(toolCalls) > 0 {
-		msg.ToolCalls = toolCalls
-	}
-
-	return OpenAIChatCompletionResponse{
-		ID:      "chatcmpl_" + uuid.NewString(),
-		Object:  "chat.completion",
-		Created: time.Now().Unix(),
-		Model:   ar.Model,
-		Choices: []OpenAIChatChoice{{
-			Index: 0,
-			Message:      msg,
-			FinishReason: finish,
-		}},
-		Usage: usage,
-	}
+// AnthropicResponseToOpenAI converts through the canonical response.
+func AnthropicResponseToOpenAI(ar AnthropicMessageResponse, structured bool) OpenAIChatCompletionResponse {
+	return CanonicalToOpenAIResponse(AnthropicResponseToCanonical(ar), structured)
 }
 
+// OpenAIResponseToAnthropic converts through the canonical response.
 func OpenAIResponseToAnthropic(or OpenAIChatCompletionResponse, model string) AnthropicMessageResponse {
-	text := ""
-	var toolBlocks []map[string]any
-	finish := ""
-	if len(or.Choices) > 0 {
-		msg := or.Choices[0].Message
-		if s, ok := msg.Content.(string); ok {
-			text = s
-		} else if msg.Content != nil {
-			b, _ := json.Marshal(msg.Content)
-			text = string(b)
-		}
-		for _, tc := range msg.ToolCalls {
-			var input any
-			if strings.TrimSpace(tc.Function.Arguments) != "" {
-				_ = json.Unmarshal([]byte(tc.Function.Arguments), &input)
-			} else {
-				input = map[string]any{}
-			}
-			toolBlocks = append(toolBlocks, map[string]any{
-				"type":  "tool_use",
-				"id":    tc.ID,
-				"name":  tc.Function.Name,
-				"input": input,
-			})
-		}
-		finish = or.Choices[0].FinishReason
-	}
-	usage := AnthropicUsage{}
-	if or.Usage != nil {
-		usage.InputTokens = or.Usage.PromptTokens
-		usage.OutputTokens = or.Usage.CompletionTokens
-	}
-
-	contentBlocks := make([]map[string]any, 0, 1+len(toolBlocks))
-	if strings.TrimSpace(text) != "" {
-		contentBlocks = append(contentBlocks, map[string]any{"type": "text", "text": text})
-	}
-	contentBlocks = append(contentBlocks, toolBlocks...)
-
-	stopReason := mapOpenAIFinishReasonToAnthropicStopReason(finish, len(toolBlocks) > 0)
-	return AnthropicMessageResponse{
-		ID:         "msg_" + uuid.NewString(),
-		Type:       "message",
-		Role:       "assistant",
-		Model:      model,
-		Content:    contentBlocks,
-		StopReason: stopReason,
-		Usage:      usage,
-	}
+	return CanonicalToAnthropicResponse(OpenAIResponseToCanonical(or), model)
 }
 
 func mapAnthropicStopReasonToOpenAIFinishReason(sr string) string {
@@ -186,6 +94,8 @@ func mapAnthropicStopReasonToOpenAIFinishReason(sr string) string {
 		return "stop"
 	case "tool_use":
 		return "tool_calls"
+	case "refusal":
+		return "content_filter"
 	default:
 		return "stop"
 	}
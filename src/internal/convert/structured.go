@@ -0,0 +1,181 @@
+package convert
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	anthropicproto "claude-gateway/src/internal/proto/anthropic"
+)
+
+// StructuredOutputTool is the tool Anthropic upstreams are forced to call
+// when an OpenAI client asks for response_format json_object/json_schema.
+// Its input is the JSON document; converters told the request was
+// structured unwrap it back into message content, so the name is reserved
+// in such requests.
+const StructuredOutputTool = "json_response"
+
+// IsStructuredOutput reports whether a Chat Completions response_format
+// makes Anthropic upstreams answer through StructuredOutputTool.
+func IsStructuredOutput(responseFormat json.RawMessage) bool {
+	_, ok := parseResponseFormat(responseFormat)
+	return ok
+}
+
+// responseFormat is the normalised form of Chat Completions response_format
+// and Responses API text.format.
+type responseFormat struct {
+	Type        string // "json_object" or "json_schema"
+	Name        string
+	Description string
+	Schema      json.RawMessage
+}
+
+// parseResponseFormat reads a Chat Completions response_format. It reports
+// false for "text" and for anything it does not recognise.
+func parseResponseFormat(raw json.RawMessage) (responseFormat, bool) {
+	if len(raw) == 0 {
+		return responseFormat{}, false
+	}
+	var rf struct {
+		Type       string `json:"type"`
+		JSONSchema struct {
+			Name        string          `json:"name"`
+			Description string          `json:"description"`
+			Schema      json.RawMessage `json:"schema"`
+		} `json:"json_schema"`
+	}
+	if err := json.Unmarshal(raw, &rf); err != nil {
+		return responseFormat{}, false
+	}
+	switch rf.Type {
+	case "json_object":
+		return responseFormat{Type: rf.Type}, true
+	case "json_schema":
+		return responseFormat{
+			Type:        rf.Type,
+			Name:        rf.JSONSchema.Name,
+			Description: rf.JSONSchema.Description,
+			Schema:      rf.JSONSchema.Schema,
+		}, true
+	}
+	return responseFormat{}, false
+}
+
+// ResponsesTextFormatToResponseFormat converts the Responses API text field
+// ({"format":{"type":"json_schema","name":...,"schema":...}}) into the
+// equivalent Chat Completions response_format, or nil.
+func ResponsesTextFormatToResponseFormat(text json.RawMessage) json.RawMessage {
+	if len(text) == 0 {
+		return nil
+	}
+	var t struct {
+		Format struct {
+			Type        string          `json:"type"`
+			Name        string          `json:"name,omitempty"`
+			Description string          `json:"description,omitempty"`
+			Schema      json.RawMessage `json:"schema,omitempty"`
+			Strict      *bool           `json:"strict,omitempty"`
+		} `json:"format"`
+	}
+	if err := json.Unmarshal(text, &t); err != nil {
+		return nil
+	}
+	switch t.Format.Type {
+	case "json_object":
+		return json.RawMessage(`{"type":"json_object"}`)
+	case "json_schema":
+		b, _ := json.Marshal(map[string]any{
+			"type": "json_schema",
+			"json_schema": map[string]any{
+				"name":        t.Format.Name,
+				"description": t.Format.Description,
+				"schema":      t.Format.Schema,
+				"strict":      t.Format.Strict,
+			},
+		})
+		return b
+	}
+	return nil
+}
+
+// applyResponseFormatToAnthropic adds the structured-output tool to req and,
+// where Anthropic allows it, forces the model to call it. Forcing is skipped
+// when the client brought its own tools or enabled thinking, which does not
+// accept a forced tool_choice.
+func applyResponseFormatToAnthropic(req *anthropicproto.MessageCreateRequest, raw json.RawMessage) error {
+	rf, ok := parseResponseFormat(raw)
+	if !ok {
+		return nil
+	}
+	for _, t := range req.Tools {
+		if t.Name == StructuredOutputTool {
+			return fmt.Errorf("%w: tool name %q is reserved for response_format", ErrUnsupportedMessageShape, StructuredOutputTool)
+		}
+	}
+	schema := rf.Schema
+	if len(schema) == 0 {
+		schema = json.RawMessage(`{"type":"object"}`)
+	}
+	desc := "Respond by calling this tool with the complete answer as its input."
+	if rf.Name != "" {
+		desc = fmt.Sprintf("Respond with a %s object by calling this tool with it as the input.", rf.Name)
+	}
+	if rf.Description != "" {
+		desc += " " + rf.Description
+	}
+	forced := len(req.Tools) == 0 && req.Thinking == nil
+	req.Tools = append(req.Tools, anthropicproto.ToolDefinition{
+		Name:        StructuredOutputTool,
+		Description: desc,
+		InputSchema: schema,
+	})
+	if forced {
+		req.ToolChoice = json.RawMessage(`{"type":"tool","name":"` + StructuredOutputTool + `"}`)
+	}
+	return nil
+}
+
+// geminiResponseSchema strips JSON Schema keywords Gemini's OpenAPI subset
+// rejects.
+func geminiResponseSchema(raw json.RawMessage) json.RawMessage {
+	if len(raw) == 0 {
+		return nil
+	}
+	var v any
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return nil
+	}
+	b, _ := json.Marshal(stripSchemaKeywords(v))
+	return b
+}
+
+func stripSchemaKeywords(v any) any {
+	switch t := v.(type) {
+	case map[string]any:
+		for k := range t {
+			if k == "additionalProperties" || k == "strict" || strings.HasPrefix(k, "$") {
+				delete(t, k)
+			}
+		}
+		for k, child := range t {
+			if k == "properties" {
+				// Property names are user data, not keywords.
+				if props, ok := child.(map[string]any); ok {
+					for name, p := range props {
+						props[name] = stripSchemaKeywords(p)
+					}
+					continue
+				}
+			}
+			t[k] = stripSchemaKeywords(child)
+		}
+		return t
+	case []any:
+		for i := range t {
+			t[i] = stripSchemaKeywords(t[i])
+		}
+		return t
+	}
+	return v
+}
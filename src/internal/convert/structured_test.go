@@ -0,0 +1,93 @@
+package convert
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	openaiproto "claude-gateway/src/internal/proto/openai"
+)
+
+func TestResponseFormatToForcedTool(t *testing.T) {
+	or := openaiproto.ChatCompletionsRequest{
+		Model:          "gpt-4o",
+		Messages:       []any{map[string]any{"role": "user", "content": "weather?"}},
+		ResponseFormat: json.RawMessage(`{"type":"json_schema","json_schema":{"name":"weather","schema":{"type":"object","properties":{"temp":{"type":"number"}}}}}`),
+	}
+	ar, err := OpenAIToAnthropicMessageRequest(or)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if len(ar.Tools) != 1 || ar.Tools[0].Name != StructuredOutputTool || !strings.Contains(string(ar.Tools[0].InputSchema), `"temp"`) {
+		t.Fatalf("tools = %+v", ar.Tools)
+	}
+	if string(ar.ToolChoice) != `{"type":"tool","name":"json_response"}` {
+		t.Fatalf("tool_choice = %s", ar.ToolChoice)
+	}
+
+	resp := AnthropicResponseToOpenAI(AnthropicMessageResponse{
+		Content: []map[string]any{
+			{"type": "text", "text": "Here is the weather:"},
+			{
+				"type": "tool_use", "id": "toolu_1", "name": StructuredOutputTool,
+				"input": map[string]any{"temp": 21.5},
+			},
+		},
+		StopReason: "tool_use",
+	}, true)
+	msg := resp.Choices[0].Message
+	if msg.Content != `{"temp":21.5}` || len(msg.ToolCalls) != 0 || resp.Choices[0].FinishReason != "stop" {
+		t.Fatalf("unwrapped = %+v finish=%s", msg, resp.Choices[0].FinishReason)
+	}
+}
+
+func TestClientToolNamedLikeStructuredOutput(t *testing.T) {
+	or := openaiproto.ChatCompletionsRequest{
+		Model:    "gpt-4o",
+		Messages: []any{map[string]any{"role": "user", "content": "weather?"}},
+		Tools:    json.RawMessage(`[{"type":"function","function":{"name":"json_response","parameters":{"type":"object"}}}]`),
+	}
+	if _, err := OpenAIToAnthropicMessageRequest(or); err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if IsStructuredOutput(or.ResponseFormat) {
+		t.Fatal("request without response_format is structured")
+	}
+
+	ar := AnthropicMessageResponse{
+		Content: []map[string]any{
+			{"type": "text", "text": "Checking."},
+			{"type": "tool_use", "id": "toolu_1", "name": StructuredOutputTool, "input": map[string]any{"city": "Oslo"}},
+		},
+		StopReason: "tool_use",
+	}
+	resp := AnthropicResponseToOpenAI(ar, false)
+	msg := resp.Choices[0].Message
+	if msg.Content != "Checking." || len(msg.ToolCalls) != 1 || msg.ToolCalls[0].Function.Name != StructuredOutputTool || resp.Choices[0].FinishReason != "tool_calls" {
+		t.Fatalf("chat = %+v finish=%s", msg, resp.Choices[0].FinishReason)
+	}
+	rresp := AnthropicResponseToOpenAIResponses(ar, "gpt-4o", false)
+	if len(rresp.Output) != 2 || rresp.Output[0].Type != "function_call" || rresp.Output[0].Name != StructuredOutputTool {
+		t.Fatalf("responses = %+v", rresp.Output)
+	}
+}
+
+func TestResponseFormatToGemini(t *testing.T) {
+	text := json.RawMessage(`{"format":{"type":"json_schema","name":"w","schema":{"type":"object","additionalProperties":false,"properties":{"additionalProperties":{"type":"string","$comment":"x"}}}}}`)
+	or := openaiproto.ChatCompletionsRequest{
+		Messages:       []any{map[string]any{"role": "user", "content": "hi"}},
+		ResponseFormat: ResponsesTextFormatToResponseFormat(text),
+	}
+	gr, err := OpenAIToGeminiRequest(or)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	gc := gr.GenerationConfig
+	if gc.ResponseMimeType != "application/json" {
+		t.Fatalf("mime = %q", gc.ResponseMimeType)
+	}
+	want := `{"properties":{"additionalProperties":{"type":"string"}},"type":"object"}`
+	if string(gc.ResponseSchema) != want {
+		t.Fatalf("schema = %s, want %s", gc.ResponseSchema, want)
+	}
+}
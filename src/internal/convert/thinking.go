@@ -0,0 +1,156 @@
+package convert
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+
+	openaiproto "claude-gateway/src/internal/proto/openai"
+	"claude-gateway/src/internal/rawjson"
+)
+
+// Vendor identifies OpenAI-compatible APIs whose reasoning switches differ
+// from OpenAI's reasoning_effort.
+type Vendor string
+
+const (
+	VendorOpenAI   Vendor = "openai"
+	VendorDeepSeek Vendor = "deepseek"
+	VendorGLM      Vendor = "glm"
+	VendorQwen     Vendor = "qwen"
+	// VendorOpenRouter proxies many model families; it is detected by URL
+	// before the model name so "deepseek/..." slugs do not misfire.
+	VendorOpenRouter Vendor = "openrouter"
+)
+
+// DetectVendor guesses the vendor from the upstream model name and base URL.
+func DetectVendor(model, baseURL string) Vendor {
+	m := strings.ToLower(model)
+	u := strings.ToLower(baseURL)
+	switch {
+	case strings.Contains(u, "openrouter.ai"):
+		return VendorOpenRouter
+	case strings.Contains(m, "deepseek") || strings.Contains(u, "deepseek"):
+		return VendorDeepSeek
+	case strings.Contains(m, "glm") || strings.Contains(u, "bigmodel") || strings.Contains(u, "z.ai"):
+		return VendorGLM
+	case strings.Contains(m, "qwen") || strings.Contains(m, "qwq") || strings.Contains(u, "dashscope"):
+		return VendorQwen
+	}
+	return VendorOpenAI
+}
+
+// Budgets used when mapping reasoning_effort to Anthropic budget_tokens and
+// back. Anthropic's minimum budget is 1024.
+const (
+	thinkingBudgetLow    = 2048
+	thinkingBudgetMedium = 8192
+	thinkingBudgetHigh   = 24576
+	thinkingBudgetMin    = 1024
+)
+
+func budgetToEffort(budget int) string {
+	switch {
+	case budget <= 0:
+		return "medium"
+	case budget <= 4096:
+		return "low"
+	case budget <= 16384:
+		return "medium"
+	default:
+		return "high"
+	}
+}
+
+func effortToBudget(effort string) int {
+	switch strings.ToLower(strings.TrimSpace(effort)) {
+	case "minimal", "low":
+		return thinkingBudgetLow
+	case "high":
+		return thinkingBudgetHigh
+	default:
+		return thinkingBudgetMedium
+	}
+}
+
+// ApplyVendorReasoning rewrites the generic reasoning_effort set by
+// CanonicalToOpenAIChatRequest into the switch the vendor understands.
+func ApplyVendorReasoning(req *openaiproto.ChatCompletionsRequest, v Vendor) {
+	if req.ReasoningEffort == "" {
+		return
+	}
+	switch v {
+	case VendorDeepSeek, VendorGLM:
+		req.Thinking = json.RawMessage(`{"type":"enabled"}`)
+		req.ReasoningEffort = ""
+	case VendorQwen:
+		on := true
+		budget := effortToBudget(req.ReasoningEffort)
+		req.EnableThinking = &on
+		req.ThinkingBudget = &budget
+		req.ReasoningEffort = ""
+	}
+}
+
+// SyntheticThinkingSignature stands in for the signature Anthropic attaches
+// to thinking blocks when the thinking came from a non-Anthropic upstream.
+// It is opaque to clients and cannot be verified by Anthropic.
+func SyntheticThinkingSignature(thinking string) string {
+	sum := sha256.Sum256([]byte(thinking))
+	return syntheticSignaturePrefix + base64.RawStdEncoding.EncodeToString(sum[:])
+}
+
+// syntheticSignaturePrefix marks signatures made by the gateway; thinking
+// blocks carrying one are not sent back to Anthropic.
+const syntheticSignaturePrefix = "gw1."
+
+// StripSyntheticThinking removes the thinking blocks signed by the gateway
+// from an Anthropic Messages request body, which Anthropic would reject.
+// The body is returned unchanged when it has none.
+func StripSyntheticThinking(body []byte) ([]byte, error) {
+	raw, ok := rawjson.Get(body, "messages")
+	if !ok {
+		return body, nil
+	}
+	var msgs []json.RawMessage
+	if err := json.Unmarshal(raw, &msgs); err != nil {
+		return body, nil
+	}
+	stripped := false
+	for i, m := range msgs {
+		content, ok := rawjson.Get(m, "content")
+		if !ok {
+			continue
+		}
+		var blocks []json.RawMessage
+		if json.Unmarshal(content, &blocks) != nil {
+			continue
+		}
+		kept := blocks[:0:0]
+		for _, b := range blocks {
+			var blk struct {
+				Type      string `json:"type"`
+				Signature string `json:"signature"`
+			}
+			_ = json.Unmarshal(b, &blk)
+			if blk.Type == "thinking" && strings.HasPrefix(blk.Signature, syntheticSignaturePrefix) {
+				continue
+			}
+			kept = append(kept, b)
+		}
+		if len(kept) == len(blocks) {
+			continue
+		}
+		m, err := rawjson.Set(m, "content", kept)
+		if err != nil {
+			return nil, err
+		}
+		msgs[i] = m
+		stripped = true
+	}
+	if !stripped {
+		return body, nil
+	}
+	return rawjson.Set(body, "messages", msgs)
+}
@@ -0,0 +1,108 @@
+package convert
+
+import (
+	"strings"
+	"testing"
+
+	anthropicproto "claude-gateway/src/internal/proto/anthropic"
+	openaiproto "claude-gateway/src/internal/proto/openai"
+)
+
+func TestThinkingToVendorReasoning(t *testing.T) {
+	ar := anthropicproto.MessageCreateRequest{
+		Model:     "claude-sonnet-4-5",
+		MaxTokens: 32000,
+		Messages:  []anthropicproto.Message{{Role: "user", Content: "hi"}},
+		Thinking:  &anthropicproto.ThinkingConfig{Type: "enabled", BudgetTokens: 10000},
+	}
+	or, err := AnthropicToOpenAIChatRequest(ar)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if or.ReasoningEffort != "medium" {
+		t.Fatalf("reasoning_effort = %q, want medium", or.ReasoningEffort)
+	}
+
+	ds := or
+	ApplyVendorReasoning(&ds, DetectVendor("deepseek-reasoner", ""))
+	if ds.ReasoningEffort != "" || string(ds.Thinking) != `{"type":"enabled"}` {
+		t.Fatalf("deepseek flags: effort=%q thinking=%s", ds.ReasoningEffort, ds.Thinking)
+	}
+
+	qw := or
+	ApplyVendorReasoning(&qw, DetectVendor("qwen3-max", ""))
+	if qw.EnableThinking == nil || !*qw.EnableThinking || qw.ThinkingBudget == nil || *qw.ThinkingBudget != thinkingBudgetMedium {
+		t.Fatalf("qwen flags: %+v", qw)
+	}
+
+	oa := or
+	ApplyVendorReasoning(&oa, DetectVendor("o3", "https://api.openai.com/v1"))
+	if oa.ReasoningEffort != "medium" {
+		t.Fatalf("openai effort changed: %q", oa.ReasoningEffort)
+	}
+}
+
+func TestReasoningEffortToThinking(t *testing.T) {
+	maxTokens := 4000
+	temp := 0.5
+	or := openaiproto.ChatCompletionsRequest{
+		Model:           "gpt-4o",
+		Messages:        []any{map[string]any{"role": "user", "content": "hi"}},
+		MaxTokens:       &maxTokens,
+		Temperature:     &temp,
+		ReasoningEffort: "high",
+	}
+	ar, err := OpenAIToAnthropicMessageRequest(or)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if ar.Thinking == nil || ar.Thinking.BudgetTokens != maxTokens-1 {
+		t.Fatalf("thinking = %+v, want budget clamped below max_tokens", ar.Thinking)
+	}
+	if ar.Temperature != nil {
+		t.Fatalf("temperature should be dropped with thinking")
+	}
+
+	small := 512
+	or.MaxTokens = &small
+	ar, err = OpenAIToAnthropicMessageRequest(or)
+	if err != nil {
+		t.Fatalf("convert: %v", err)
+	}
+	if ar.Thinking != nil {
+		t.Fatalf("thinking should be dropped when max_tokens is too small")
+	}
+}
+
+func TestReasoningContentResponseRoundTrip(t *testing.T) {
+	or := OpenAIChatCompletionResponse{
+		Choices: []OpenAIChatChoice{{
+			Message:      OpenAIChatMessage{Role: "assistant", Content: "42", ReasoningContent: "think"},
+			FinishReason: "stop",
+		}},
+	}
+	ar := OpenAIResponseToAnthropic(or, "claude-sonnet-4-5")
+	if len(ar.Content) != 2 || ar.Content[0]["type"] != "thinking" || ar.Content[0]["signature"] == "" {
+		t.Fatalf("content = %+v", ar.Content)
+	}
+	back := AnthropicResponseToOpenAI(ar, false)
+	if back.Choices[0].Message.ReasoningContent != "think" {
+		t.Fatalf("reasoning_content = %q", back.Choices[0].Message.ReasoningContent)
+	}
+}
+
+func TestStripSyntheticThinking(t *testing.T) {
+	body := []byte(`{"model":"m","messages":[{"role":"user","content":"hi"},{"role":"assistant","content":[{"type":"thinking","thinking":"x","signature":"gw1.abc"},{"type":"thinking","thinking":"y","signature":"EqQB"},{"type":"text","text":"hello"}]}]}`)
+	out, err := StripSyntheticThinking(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "gw1.") || !strings.Contains(string(out), "EqQB") || !strings.Contains(string(out), "hello") {
+		t.Fatalf("stripped body = %s", out)
+	}
+
+	clean := []byte(`{"model":"m", "messages":[{"role":"user","content":"hi"}]}`)
+	if out, _ := StripSyntheticThinking(clean); string(out) != string(clean) {
+		t.Fatalf("body without synthetic thinking rewritten: %s", out)
+	}
+}
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS admin_audit_log (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  ts TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  action VARCHAR(64) NOT NULL,
+  target_type VARCHAR(32) NOT NULL,
+  target_id BIGINT UNSIGNED NULL,
+  src_ip VARCHAR(64) NULL,
+  user_agent VARCHAR(255) NULL,
+  detail_json JSON NULL,
+  KEY idx_audit_ts (ts),
+  KEY idx_audit_target (target_type, target_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
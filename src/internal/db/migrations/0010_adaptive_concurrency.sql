@@ -0,0 +1,5 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'credentials' AND COLUMN_NAME = 'adaptive_concurrency');
+SET @sql := IF(@exists = 0, 'ALTER TABLE credentials ADD COLUMN adaptive_concurrency BOOLEAN NOT NULL DEFAULT FALSE AFTER concurrency_limit', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
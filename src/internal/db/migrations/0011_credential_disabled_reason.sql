@@ -0,0 +1,9 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'credentials' AND COLUMN_NAME = 'disabled_reason');
+SET @sql := IF(@exists = 0, 'ALTER TABLE credentials ADD COLUMN disabled_reason VARCHAR(255) NULL AFTER enabled', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'credentials' AND COLUMN_NAME = 'disabled_at');
+SET @sql := IF(@exists = 0, 'ALTER TABLE credentials ADD COLUMN disabled_at DATETIME NULL AFTER disabled_reason', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
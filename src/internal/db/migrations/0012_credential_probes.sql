@@ -0,0 +1,25 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'providers' AND COLUMN_NAME = 'probe_interval_sec');
+SET @sql := IF(@exists = 0, 'ALTER TABLE providers ADD COLUMN probe_interval_sec INT NULL AFTER models_refreshed_at', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'providers' AND COLUMN_NAME = 'probe_model');
+SET @sql := IF(@exists = 0, 'ALTER TABLE providers ADD COLUMN probe_model VARCHAR(255) NULL AFTER probe_interval_sec', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+CREATE TABLE IF NOT EXISTS credential_probe_history (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  ts TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  credential_id BIGINT UNSIGNED NOT NULL,
+  provider_id BIGINT UNSIGNED NOT NULL,
+  ok BOOLEAN NOT NULL,
+  status INT NULL,
+  latency_ms BIGINT NULL,
+  ttft_ms BIGINT NULL,
+  tps DOUBLE NULL,
+  model VARCHAR(255) NULL,
+  error VARCHAR(1024) NULL,
+  KEY idx_probe_cred_ts (credential_id, ts),
+  KEY idx_probe_ts (ts)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
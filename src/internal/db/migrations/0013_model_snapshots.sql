@@ -0,0 +1,15 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'providers' AND COLUMN_NAME = 'models_refresh_interval_sec');
+SET @sql := IF(@exists = 0, 'ALTER TABLE providers ADD COLUMN models_refresh_interval_sec INT NULL AFTER models_refreshed_at', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+CREATE TABLE IF NOT EXISTS provider_model_snapshots (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  ts TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  provider_id BIGINT UNSIGNED NOT NULL,
+  models_json JSON NOT NULL,
+  added_json JSON NULL,
+  removed_json JSON NULL,
+  KEY idx_model_snapshots_provider_ts (provider_id, ts)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
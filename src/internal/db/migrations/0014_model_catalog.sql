@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS model_catalog (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  model VARCHAR(255) NOT NULL,
+  context_window INT NULL,
+  max_output_tokens INT NULL,
+  supports_tools BOOLEAN NULL,
+  supports_vision BOOLEAN NULL,
+  supports_thinking BOOLEAN NULL,
+  supports_json_mode BOOLEAN NULL,
+  notes VARCHAR(512) NULL,
+  created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+  updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+  UNIQUE KEY uniq_model_catalog_model (model)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,9 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'request_logs' AND COLUMN_NAME = 'cache_creation_tokens');
+SET @sql := IF(@exists = 0, 'ALTER TABLE request_logs ADD COLUMN cache_creation_tokens INT NULL AFTER output_tokens', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'request_logs' AND COLUMN_NAME = 'cache_read_tokens');
+SET @sql := IF(@exists = 0, 'ALTER TABLE request_logs ADD COLUMN cache_read_tokens INT NULL AFTER cache_creation_tokens', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
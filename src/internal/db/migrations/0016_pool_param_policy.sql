@@ -0,0 +1,5 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'pools' AND COLUMN_NAME = 'param_policy');
+SET @sql := IF(@exists = 0, 'ALTER TABLE pools ADD COLUMN param_policy VARCHAR(16) NOT NULL DEFAULT ''drop'' AFTER model_map_json', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
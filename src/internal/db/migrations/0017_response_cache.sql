@@ -0,0 +1,16 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'pools' AND COLUMN_NAME = 'response_cache_ttl_sec');
+SET @sql := IF(@exists = 0, 'ALTER TABLE pools ADD COLUMN response_cache_ttl_sec INT NOT NULL DEFAULT 0 AFTER param_policy', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'request_logs' AND COLUMN_NAME = 'cache_hit');
+SET @sql := IF(@exists = 0, 'ALTER TABLE request_logs ADD COLUMN cache_hit TINYINT(1) NOT NULL DEFAULT 0 AFTER cache_read_tokens', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+CREATE TABLE IF NOT EXISTS response_cache (
+  cache_key CHAR(64) NOT NULL PRIMARY KEY,
+  value MEDIUMBLOB NOT NULL,
+  expires_at DATETIME(3) NOT NULL,
+  KEY idx_response_cache_expires (expires_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
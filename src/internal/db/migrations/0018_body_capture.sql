@@ -0,0 +1,21 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'pools' AND COLUMN_NAME = 'capture_rate');
+SET @sql := IF(@exists = 0, 'ALTER TABLE pools ADD COLUMN capture_rate DOUBLE NOT NULL DEFAULT 0 AFTER response_cache_ttl_sec', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+CREATE TABLE IF NOT EXISTS request_captures (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  request_id VARCHAR(64) NOT NULL,
+  attempt INT NOT NULL DEFAULT 0,
+  pool_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  credential_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  upstream_status INT NOT NULL DEFAULT 0,
+  client_request MEDIUMBLOB NULL,
+  upstream_request MEDIUMBLOB NULL,
+  upstream_response MEDIUMBLOB NULL,
+  client_response MEDIUMBLOB NULL,
+  created_at DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  KEY idx_request_captures_request (request_id),
+  KEY idx_request_captures_created (created_at)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
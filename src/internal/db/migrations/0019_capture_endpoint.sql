@@ -0,0 +1,5 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'request_captures' AND COLUMN_NAME = 'endpoint');
+SET @sql := IF(@exists = 0, 'ALTER TABLE request_captures ADD COLUMN endpoint VARCHAR(64) NOT NULL DEFAULT '''' AFTER request_id', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
@@ -0,0 +1,39 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'pools' AND COLUMN_NAME = 'shadow_provider_id');
+SET @sql := IF(@exists = 0, 'ALTER TABLE pools ADD COLUMN shadow_provider_id BIGINT UNSIGNED NOT NULL DEFAULT 0 AFTER capture_rate', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'pools' AND COLUMN_NAME = 'shadow_model');
+SET @sql := IF(@exists = 0, 'ALTER TABLE pools ADD COLUMN shadow_model VARCHAR(255) NOT NULL DEFAULT '''' AFTER shadow_provider_id', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'pools' AND COLUMN_NAME = 'shadow_rate');
+SET @sql := IF(@exists = 0, 'ALTER TABLE pools ADD COLUMN shadow_rate DOUBLE NOT NULL DEFAULT 0 AFTER shadow_model', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+CREATE TABLE IF NOT EXISTS shadow_results (
+  id BIGINT UNSIGNED NOT NULL AUTO_INCREMENT PRIMARY KEY,
+  request_id VARCHAR(64) NOT NULL,
+  pool_id BIGINT UNSIGNED NOT NULL,
+  facade VARCHAR(32) NOT NULL,
+  stream TINYINT(1) NOT NULL DEFAULT 0,
+  primary_provider_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  primary_model VARCHAR(255) NOT NULL DEFAULT '',
+  primary_status INT NOT NULL DEFAULT 0,
+  primary_latency_ms BIGINT NOT NULL DEFAULT 0,
+  primary_input_tokens BIGINT NOT NULL DEFAULT 0,
+  primary_output_tokens BIGINT NOT NULL DEFAULT 0,
+  shadow_provider_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  shadow_credential_id BIGINT UNSIGNED NOT NULL DEFAULT 0,
+  shadow_model VARCHAR(255) NOT NULL DEFAULT '',
+  shadow_status INT NOT NULL DEFAULT 0,
+  shadow_latency_ms BIGINT NOT NULL DEFAULT 0,
+  shadow_input_tokens BIGINT NOT NULL DEFAULT 0,
+  shadow_output_tokens BIGINT NOT NULL DEFAULT 0,
+  shadow_error TEXT NULL,
+  shadow_output MEDIUMBLOB NULL,
+  created_at DATETIME(3) NOT NULL DEFAULT CURRENT_TIMESTAMP(3),
+  KEY idx_shadow_results_pool (pool_id, created_at),
+  KEY idx_shadow_results_request (request_id)
+) ENGINE=InnoDB DEFAULT CHARSET=utf8mb4 COLLATE=utf8mb4_unicode_ci;
@@ -0,0 +1,9 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'pools' AND COLUMN_NAME = 'overflow_strategy');
+SET @sql := IF(@exists = 0, 'ALTER TABLE pools ADD COLUMN overflow_strategy VARCHAR(16) NOT NULL DEFAULT ''reject'' AFTER shadow_rate', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'pools' AND COLUMN_NAME = 'overflow_model');
+SET @sql := IF(@exists = 0, 'ALTER TABLE pools ADD COLUMN overflow_model VARCHAR(255) NOT NULL DEFAULT '''' AFTER overflow_strategy', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
@@ -0,0 +1,5 @@
+SET @db := DATABASE();
+
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = @db AND TABLE_NAME = 'request_captures' AND COLUMN_NAME = 'client_request_redacted');
+SET @sql := IF(@exists = 0, 'ALTER TABLE request_captures ADD COLUMN client_request_redacted TINYINT(1) NOT NULL DEFAULT 0 AFTER client_request', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
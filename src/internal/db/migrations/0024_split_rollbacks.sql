@@ -0,0 +1,3 @@
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'pools' AND COLUMN_NAME = 'split_rollbacks_json');
+SET @sql := IF(@exists = 0, 'ALTER TABLE pools ADD COLUMN split_rollbacks_json JSON NULL AFTER traffic_splits_json', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
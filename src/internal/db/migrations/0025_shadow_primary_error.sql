@@ -0,0 +1,3 @@
+SET @exists := (SELECT COUNT(*) FROM INFORMATION_SCHEMA.COLUMNS WHERE TABLE_SCHEMA = DATABASE() AND TABLE_NAME = 'shadow_results' AND COLUMN_NAME = 'primary_error');
+SET @sql := IF(@exists = 0, 'ALTER TABLE shadow_results ADD COLUMN primary_error TEXT NULL AFTER primary_output_tokens', 'SELECT 1');
+PREPARE stmt FROM @sql; EXECUTE stmt; DEALLOCATE PREPARE stmt;
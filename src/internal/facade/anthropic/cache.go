@@ -0,0 +1,39 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"claude-gateway/src/internal/canonical"
+	"claude-gateway/src/internal/convert"
+	"claude-gateway/src/internal/pipeline"
+	"claude-gateway/src/internal/respcache"
+	"claude-gateway/src/internal/streamconv"
+)
+
+// cacheSpec makes a deterministic Messages request eligible for the
+// response cache; cached answers report model.
+func cacheSpec(r *http.Request, body []byte, model string) *pipeline.CacheSpec {
+	if !respcache.Cacheable(body) {
+		return nil
+	}
+	return &pipeline.CacheSpec{
+		Body:    body,
+		Refresh: strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache"),
+		Parse: func(b []byte) (canonical.Response, error) {
+			var ar convert.AnthropicMessageResponse
+			if err := json.Unmarshal(b, &ar); err != nil {
+				return canonical.Response{}, err
+			}
+			return convert.AnthropicResponseToCanonical(ar), nil
+		},
+		Decode: streamconv.DecodeAnthropic,
+		Render: func(resp canonical.Response) ([]byte, error) {
+			return json.Marshal(convert.CanonicalToAnthropicResponse(resp, model))
+		},
+		NewEncoder: func(w http.ResponseWriter, flusher http.Flusher) streamconv.Encoder {
+			return streamconv.NewAnthropicEncoder(w, flusher, model)
+		},
+	}
+}
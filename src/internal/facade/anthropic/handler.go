@@ -15,32 +15,78 @@ import (
 	"github.com/google/uuid"
 
 	"claude-gateway/src/internal/canonical"
+	"claude-gateway/src/internal/capture"
 	"claude-gateway/src/internal/convert"
+	"claude-gateway/src/internal/idempotency"
 	"claude-gateway/src/internal/logbus"
 	"claude-gateway/src/internal/metrics"
+	"claude-gateway/src/internal/pipeline"
 	anthropicproto "claude-gateway/src/internal/proto/anthropic"
-	"claude-gateway/src/internal/providers/anthropic"
-	geminiProvider "claude-gateway/src/internal/providers/gemini"
-	openaiProvider "claude-gateway/src/internal/providers/openai"
+	"claude-gateway/src/internal/rawjson"
+	"claude-gateway/src/internal/respcache"
 	"claude-gateway/src/internal/router"
+	"claude-gateway/src/internal/shadow"
 	"claude-gateway/src/internal/streamconv"
+	"claude-gateway/src/internal/truncate"
 )
 
 type Handler struct {
-	rtr *router.Router
-	m   *metrics.Metrics
-	bus *logbus.Bus
+	rtr  *router.Router
+	exec *pipeline.Executor
+	idem *idempotency.Store
 }
 
 func NewHandler(rtr *router.Router, m *metrics.Metrics, bus *logbus.Bus) *Handler {
-	return &Handler{rtr: rtr, m: m, bus: bus}
+	return &Handler{rtr: rtr, exec: pipeline.New(rtr, m, bus)}
+}
+
+// SetResponseCache sets the store of the response cache, which pools enable
+// by configuring a TTL. It must be called before serving.
+func (h *Handler) SetResponseCache(store respcache.Store) {
+	h.exec.SetCache(store)
+}
+
+// SetCapture sets where the bodies of requests sampled for capture are
+// stored. It must be called before serving.
+func (h *Handler) SetCapture(store capture.Store) {
+	h.exec.SetCapture(store)
+}
+
+// SetShadow sets where the results of requests mirrored to a pool's
+// shadow upstream are stored. It must be called before serving.
+func (h *Handler) SetShadow(store shadow.Store) {
+	h.exec.SetShadow(store)
+}
+
+// SetStreamKeepalive sets how long a stream may stay silent before the
+// client gets a keepalive; zero disables them. It must be called before
+// serving.
+func (h *Handler) SetStreamKeepalive(d time.Duration) {
+	h.exec.SetKeepalive(d)
+}
+
+// SetIdempotency enables Idempotency-Key support on the generation
+// endpoints. It must be called before serving.
+func (h *Handler) SetIdempotency(store *idempotency.Store) {
+	h.idem = store
 }
 
 func (h *Handler) Register(r chi.Router) {
-	r.Post("/messages", h.createMessage)
+	r.Post("/messages", h.idempotent(h.createMessage))
 	r.Get("/models", h.listModels)
 }
 
+// idempotent applies the Idempotency-Key store, if one is set, to next.
+func (h *Handler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.idem == nil {
+			next(w, r)
+			return
+		}
+		h.idem.Wrap(next, writeGatewayError)(w, r)
+	}
+}
+
 func (h *Handler) Routes() chi.Router {
 	r := chi.NewRouter()
 	h.Register(r)
@@ -75,364 +121,142 @@ func (h *Handler) createMessage(w http.ResponseWriter, r *http.Request) {
 	origModel := req.Model
 
 	clientKey, _ := ctx.Value(canonical.ContextKeyClientKey).(string)
-	srcIP := clientIP(r)
-	userAgent := strings.TrimSpace(r.UserAgent())
-	isTest := isTestRequest(r)
-	requestBytes := len(body)
-
-	publish := func(up router.RoutedUpstream, status int, latency time.Duration, errMsg string, inputTokens, outputTokens int64, responseBytes int, ttft int64, tps float64) {
-		if h.bus == nil {
-			return
+	preq := pipeline.Request{
+		ID:           requestID,
+		Facade:       canonical.FacadeAnthropic,
+		Endpoint:     r.URL.Path,
+		Model:        origModel,
+		Stream:       req.Stream,
+		RequestBytes: len(body),
+		Body:         body,
+		ClientKey:    clientKey,
+		Session:      strings.TrimSpace(r.Header.Get(router.SessionHeader)),
+		SrcIP:        clientIP(r),
+		UserAgent:    strings.TrimSpace(r.UserAgent()),
+		IsTest:       isTestRequest(r),
+		Need:         requirementsOf(body),
+		Cache:        cacheSpec(r, body, origModel),
+	}
+	preq.Truncate = func(contextWindow int) (int, bool) {
+		out, _, ok := truncate.Body(body, truncate.Anthropic, preq.Need.PromptTokens, contextWindow-req.MaxTokens, messageTokens)
+		if !ok {
+			return 0, false
 		}
-		h.bus.Publish(logbus.Event{
-			TS:            time.Now(),
-			RequestID:     requestID,
-			Facade:        string(canonical.FacadeAnthropic),
-			RequestModel:  origModel,
-			UpstreamModel: up.Model,
-			ProviderType:  up.ProviderType,
-			PoolID:        up.PoolID,
-			ProviderID:    up.ProviderID,
-			CredentialID:  up.CredentialID,
-			ClientKey:     clientKey,
-			SrcIP:         srcIP,
-			UserAgent:     userAgent,
-			IsTest:        isTest,
-			Stream:        req.Stream,
-			RequestBytes:  requestBytes,
-			ResponseBytes: responseBytes,
-			InputTokens:   inputTokens,
-			OutputTokens:  outputTokens,
-			Status:        status,
-			LatencyMs:     latency.Milliseconds(),
-			TTFTMs:        ttft,
-			TPS:           tps,
-			Error:         errMsg,
-		})
-	}
-
-	maxAttempts := 1
-	if !req.Stream {
-		maxAttempts = 2
-	}
-	exclude := map[uint64]bool{}
-
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		var up router.RoutedUpstream
-		var err error
-		if attempt == 0 {
-			up, err = h.rtr.PickUpstream(ctx, clientKey, string(canonical.FacadeAnthropic), req.Model)
-		} else {
-			up, err = h.rtr.PickUpstreamExclude(ctx, clientKey, string(canonical.FacadeAnthropic), req.Model, exclude)
+		var next anthropicproto.MessageCreateRequest
+		if err := json.Unmarshal(out, &next); err != nil {
+			return 0, false
 		}
-		if err != nil {
-			var noUpstreamErr *router.ErrNoAvailableUpstream
-			if errors.As(err, &noUpstreamErr) {
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusServiceUnavailable, "overloaded_error", noUpstreamErr.Error())
-				return
-			}
-			if errors.Is(err, router.ErrNotConfigured) {
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusServiceUnavailable, "overloaded_error", "gateway not configured")
-				return
+		body, req = out, next
+		return requirementsOf(body).PromptTokens, true
+	}
+	apiVer := firstNonEmpty(r.Header.Get("anthropic-version"), "2023-06-01")
+
+	// buildCall works on its own copy of body and req, so clamping for one
+	// upstream does not carry over to the next attempt.
+	buildCall := func(body []byte, req anthropicproto.MessageCreateRequest, up router.RoutedUpstream) (pipeline.Call, error) {
+		if n := up.MaxOutputTokens; n > 0 && req.MaxTokens > n {
+			req.MaxTokens = n
+			body = setJSONField(body, "max_tokens", n)
+			if req.Thinking != nil && req.Thinking.BudgetTokens >= n {
+				// budget_tokens must stay below max_tokens.
+				th := *req.Thinking
+				th.BudgetTokens = n - 1
+				req.Thinking = &th
+				if raw, ok := rawjson.Get(body, "thinking"); ok {
+					if raw, err := rawjson.Set(raw, "budget_tokens", th.BudgetTokens); err == nil {
+						body = setJSONField(body, "thinking", raw)
+					}
+				}
 			}
-			w.Header().Set("X-Request-Id", requestID)
-			writeError(w, http.StatusBadGateway, "api_error", "routing failed: "+err.Error())
-			return
 		}
-
-		start := time.Now()
-		status := 0
-		ok := false
+		if bad := rejectedParams(up, body); len(bad) > 0 {
+			return pipeline.Call{}, &pipeline.Error{Status: http.StatusBadRequest, Code: "unsupported_parameter", Message: "unsupported parameters for this upstream: " + strings.Join(bad, ", ")}
+		}
 
 		switch up.ProviderType {
 		case "anthropic":
-			targetBody := body
-			if strings.TrimSpace(up.Model) != "" && up.Model != req.Model {
-				req.Model = up.Model
-				b, err := json.Marshal(req)
-				if err != nil {
-					h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-					writeError(w, http.StatusInternalServerError, "api_error", "failed to build upstream request")
-					return
-				}
-				targetBody = b
-			}
-
-			timeout := up.Timeout
-			if timeout <= 0 {
-				timeout = 10 * time.Minute
-			}
-			uctx, cancel := context.WithTimeout(ctx, timeout)
-			resp, err := anthropic.DoMessages(uctx, anthropic.Upstream{
-				BaseURL: up.BaseURL,
-				APIKey:  string(up.APIKey),
-				Headers: up.Headers,
-				APIVer:  firstNonEmpty(r.Header.Get("anthropic-version"), "2023-06-01"),
-				Timeout: timeout,
-			}, targetBody)
+			targetBody, err := nativeRequestBody(body, req.Model, up.Model)
 			if err != nil {
-				cancel()
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				publish(up, 0, time.Since(start), "upstream_failed", 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeAnthropic), up.ProviderType, http.StatusBadGateway, time.Since(start))
-				exclude[up.CredentialID] = true
-				if attempt+1 < maxAttempts {
-					continue
-				}
-				writeError(w, http.StatusBadGateway, "api_error", "upstream request failed")
-				return
+				return pipeline.Call{}, err
 			}
-
-			status = resp.StatusCode
-			ok = status < 500 && status != http.StatusTooManyRequests
-			if !ok {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				exclude[up.CredentialID] = true
-				if attempt+1 < maxAttempts {
-					_ = resp.Body.Close()
-					cancel()
-					continue
-				}
+			call := pipeline.AnthropicMessages(up, apiVer, targetBody)
+			call.Native = true
+			call.Stream = func(w http.ResponseWriter, r io.Reader, start time.Time) (pipeline.Stats, error) {
+				return copyAnthropicSSEWithUsage(w, r, origModel, start)
 			}
-
-			copyHeader(w.Header(), resp.Header)
-			w.Header().Set("X-Request-Id", requestID)
-			if req.Stream {
-				w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
-				w.Header().Set("Cache-Control", "no-cache")
-				w.Header().Set("X-Accel-Buffering", "no")
-				w.Header().Del("Content-Length")
-				w.Header().Del("Content-Encoding")
+			call.Decode = func(_ context.Context, raw []byte) ([]byte, pipeline.Usage, error) {
+				return rewriteAnthropicResponseModel(raw, origModel), pipeline.ExtractAnthropicUsage(raw), nil
 			}
-			w.WriteHeader(resp.StatusCode)
-
-			if req.Stream {
-				var inTok, outTok int64
-				var respBytes int
-				var ttft int64
-				var tps float64
-				respBytes, inTok, outTok, ttft, tps, err = copyAnthropicSSEWithUsage(w, resp.Body, origModel, start)
-				_ = resp.Body.Close()
-				cancel()
-				okFinal := err == nil && ok
-				h.rtr.EndRequest(up.CredentialID, okFinal, status, time.Since(start))
-				h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeAnthropic), origModel, up.CredentialID, okFinal, status)
-				publish(up, status, time.Since(start), errString(err), inTok, outTok, respBytes, ttft, tps)
-				h.m.ObserveRequest(string(canonical.FacadeAnthropic), up.ProviderType, status, time.Since(start))
-				return
-			}
-			raw, _ := io.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-			cancel()
-			outRaw := rewriteAnthropicResponseModel(raw, origModel)
-			_, _ = w.Write(outRaw)
-			dur := time.Since(start)
-			h.rtr.EndRequest(up.CredentialID, ok, status, dur)
-			inTok, outTok := extractAnthropicUsage(raw)
-			h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeAnthropic), origModel, up.CredentialID, ok, status)
-			var tps float64
-			if outTok > 0 && dur.Seconds() > 0 {
-				tps = float64(outTok) / dur.Seconds()
-			}
-			publish(up, status, dur, "", inTok, outTok, len(outRaw), dur.Milliseconds(), tps)
-			h.m.ObserveRequest(string(canonical.FacadeAnthropic), up.ProviderType, status, dur)
-			return
+			return call, nil
 
 		case "openai":
 			oreq, err := convert.AnthropicToOpenAIChatRequest(req)
 			if err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
-				return
+				return pipeline.Call{}, &pipeline.Error{Status: http.StatusBadRequest, Code: "unsupported_request", Message: err.Error()}
 			}
 			oreq.Model = up.Model
 			oreq.Stream = req.Stream
+			vendor := convert.DetectVendor(up.Model, up.BaseURL)
+			convert.ApplyVendorReasoning(&oreq, vendor)
+			convert.ApplyVendorCacheControl(&oreq, vendor)
 			b, err := json.Marshal(oreq)
 			if err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				writeError(w, http.StatusInternalServerError, "api_error", "failed to build upstream request")
-				return
-			}
-
-			timeout := up.Timeout
-			if timeout <= 0 {
-				timeout = 10 * time.Minute
-			}
-			uctx, cancel := context.WithTimeout(ctx, timeout)
-			resp, err := openaiProvider.DoChatCompletions(uctx, openaiProvider.Upstream{
-				BaseURL: up.BaseURL,
-				APIKey:  string(up.APIKey),
-				Headers: up.Headers,
-			}, b)
-			if err != nil {
-				cancel()
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				publish(up, 0, time.Since(start), "upstream_failed", 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeAnthropic), up.ProviderType, http.StatusBadGateway, time.Since(start))
-				exclude[up.CredentialID] = true
-				if attempt+1 < maxAttempts {
-					continue
-				}
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusBadGateway, "api_error", "upstream request failed")
-				return
-			}
-			status = resp.StatusCode
-			ok = status < 500 && status != http.StatusTooManyRequests
-			if status < 200 || status >= 300 {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				publish(up, status, time.Since(start), "upstream_error", 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeAnthropic), up.ProviderType, status, time.Since(start))
-				exclude[up.CredentialID] = true
-				if !ok && attempt+1 < maxAttempts {
-					_ = resp.Body.Close()
-					cancel()
-					continue
+				return pipeline.Call{}, err
+			}
+			call := pipeline.OpenAIChatCompletions(up, b)
+			call.Stream = pipeline.ConvertStream(streamconv.OpenAIToAnthropic, origModel)
+			call.Decode = func(_ context.Context, raw []byte) ([]byte, pipeline.Usage, error) {
+				var oresp convert.OpenAIChatCompletionResponse
+				if err := json.Unmarshal(raw, &oresp); err != nil {
+					return nil, pipeline.Usage{}, err
 				}
-				_ = resp.Body.Close()
-				cancel()
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, mapStatusToAnthropic(status), mapTypeToAnthropic(status), "upstream error")
-				return
-			}
-
-			if req.Stream {
-				w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
-				w.Header().Set("Cache-Control", "no-cache")
-				w.Header().Set("X-Request-Id", requestID)
-				w.WriteHeader(http.StatusOK)
-				err := streamconv.OpenAIToAnthropic(w, resp.Body, origModel)
-				_ = resp.Body.Close()
-				cancel()
-				okFinal := err == nil && ok
-				h.rtr.EndRequest(up.CredentialID, okFinal, status, time.Since(start))
-				h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeAnthropic), origModel, up.CredentialID, okFinal, status)
-				publish(up, status, time.Since(start), errString(err), 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeAnthropic), up.ProviderType, status, time.Since(start))
-				return
+				out, err := json.Marshal(convert.OpenAIResponseToAnthropic(oresp, origModel))
+				return out, pipeline.ExtractOpenAIUsage(raw), err
 			}
-
-			raw, _ := io.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-			cancel()
-			var oresp convert.OpenAIChatCompletionResponse
-			if err := json.Unmarshal(raw, &oresp); err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				writeError(w, http.StatusBadGateway, "api_error", "invalid upstream response")
-				return
-			}
-			aresp := convert.OpenAIResponseToAnthropic(oresp, origModel)
-			outRaw, _ := json.Marshal(aresp)
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.Header().Set("X-Request-Id", requestID)
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(outRaw)
-			dur := time.Since(start)
-			h.rtr.EndRequest(up.CredentialID, true, status, dur)
-			inTok, outTok := extractOpenAIUsage(raw)
-			h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeAnthropic), origModel, up.CredentialID, true, status)
-			var tps float64
-			if outTok > 0 && dur.Seconds() > 0 {
-				tps = float64(outTok) / dur.Seconds()
-			}
-			publish(up, status, dur, "", inTok, outTok, len(outRaw), dur.Milliseconds(), tps)
-			h.m.ObserveRequest(string(canonical.FacadeAnthropic), up.ProviderType, status, dur)
-			return
+			return call, nil
 
 		case "gemini":
 			if req.Stream {
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				writeError(w, http.StatusNotImplemented, "api_error", "provider conversion not implemented yet (streaming requires conversion)")
-				return
+				return pipeline.Call{}, &pipeline.Error{Status: http.StatusNotImplemented, Code: "not_implemented", Message: "provider conversion not implemented yet (streaming requires conversion)"}
 			}
-			greq, model := convert.AnthropicToGeminiRequest(req)
-			model = up.Model
-			b, err := json.Marshal(greq)
+			greq, err := convert.AnthropicToGeminiRequest(req)
 			if err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				writeError(w, http.StatusInternalServerError, "api_error", "failed to build upstream request")
-				return
-			}
-
-			timeout := up.Timeout
-			if timeout <= 0 {
-				timeout = 10 * time.Minute
+				return pipeline.Call{}, &pipeline.Error{Status: http.StatusBadRequest, Code: "unsupported_request", Message: err.Error()}
 			}
-			uctx, cancel := context.WithTimeout(ctx, timeout)
-			resp, err := geminiProvider.DoGenerateContent(uctx, geminiProvider.Upstream{
-				BaseURL: up.BaseURL,
-				APIKey:  string(up.APIKey),
-				Headers: up.Headers,
-			}, model, b)
+			b, err := json.Marshal(greq)
 			if err != nil {
-				cancel()
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				publish(up, 0, time.Since(start), "upstream_failed", 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeAnthropic), up.ProviderType, http.StatusBadGateway, time.Since(start))
-				exclude[up.CredentialID] = true
-				if attempt+1 < maxAttempts {
-					continue
-				}
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusBadGateway, "api_error", "upstream request failed")
-				return
+				return pipeline.Call{}, err
 			}
-			status = resp.StatusCode
-			raw, _ := io.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-			cancel()
-			ok = status < 500 && status != http.StatusTooManyRequests
-			if status < 200 || status >= 300 {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				publish(up, status, time.Since(start), "upstream_error", 0, 0, len(raw), 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeAnthropic), up.ProviderType, status, time.Since(start))
-				exclude[up.CredentialID] = true
-				if !ok && attempt+1 < maxAttempts {
-					continue
+			call := pipeline.GeminiGenerateContent(up, up.Model, b)
+			call.Decode = func(_ context.Context, raw []byte) ([]byte, pipeline.Usage, error) {
+				var gres convert.GeminiGenerateContentResponse
+				if err := json.Unmarshal(raw, &gres); err != nil {
+					return nil, pipeline.Usage{}, err
 				}
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, mapStatusToAnthropic(status), mapTypeToAnthropic(status), "upstream error")
-				return
-			}
-			var gres convert.GeminiGenerateContentResponse
-			if err := json.Unmarshal(raw, &gres); err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				writeError(w, http.StatusBadGateway, "api_error", "invalid upstream response")
-				return
-			}
-			text, usage := convert.GeminiResponseText(gres)
-			aresp := convert.GeminiTextToAnthropic(text, origModel, usage)
-			outRaw, _ := json.Marshal(aresp)
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.Header().Set("X-Request-Id", requestID)
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(outRaw)
-			dur := time.Since(start)
-			h.rtr.EndRequest(up.CredentialID, true, status, dur)
-			var inTok, outTok int64
-			if usage != nil {
-				inTok = int64(usage.PromptTokenCount)
-				outTok = int64(usage.CandidatesTokenCount)
+				out, err := json.Marshal(convert.GeminiResponseToAnthropic(gres, origModel))
+				return out, pipeline.GeminiUsage(gres.UsageMetadata), err
 			}
-			h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeAnthropic), origModel, up.CredentialID, true, status)
-			var tps float64
-			if outTok > 0 && dur.Seconds() > 0 {
-				tps = float64(outTok) / dur.Seconds()
-			}
-			publish(up, status, dur, "", inTok, outTok, len(outRaw), dur.Milliseconds(), tps)
-			h.m.ObserveRequest(string(canonical.FacadeAnthropic), up.ProviderType, status, dur)
-			return
+			return call, nil
 
 		default:
-			h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-			w.Header().Set("X-Request-Id", requestID)
-			writeError(w, http.StatusNotImplemented, "api_error", "unknown provider")
-			return
+			return pipeline.Call{}, &pipeline.Error{Status: http.StatusNotImplemented, Code: "not_implemented", Message: "unknown provider"}
 		}
 	}
+
+	prepare := func(up router.RoutedUpstream) (pipeline.Call, error) {
+		return buildCall(body, req, up)
+	}
+	preq.FromBody = func(body []byte) (pipeline.Prepare, error) {
+		var req anthropicproto.MessageCreateRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+		return func(up router.RoutedUpstream) (pipeline.Call, error) {
+			return buildCall(body, req, up)
+		}, nil
+	}
+
+	h.exec.Execute(ctx, w, preq, prepare, writeGatewayError)
 }
 
 func (h *Handler) listModels(w http.ResponseWriter, r *http.Request) {
@@ -494,54 +318,6 @@ func firstNonEmpty(v, def string) string {
 	return v
 }
 
-func copyHeader(dst, src http.Header) {
-	for k, vs := range src {
-		for _, v := range vs {
-			dst.Add(k, v)
-		}
-	}
-}
-
-func errString(err error) string {
-	if err == nil {
-		return ""
-	}
-	return err.Error()
-}
-
-func mapStatusToAnthropic(upstreamStatus int) int {
-	if upstreamStatus == http.StatusTooManyRequests {
-		return http.StatusTooManyRequests
-	}
-	if upstreamStatus == http.StatusUnauthorized || upstreamStatus == http.StatusForbidden {
-		return http.StatusUnauthorized
-	}
-	if upstreamStatus >= 400 && upstreamStatus < 500 {
-		return http.StatusBadRequest
-	}
-	if upstreamStatus >= 500 {
-		return http.StatusBadGateway
-	}
-	return http.StatusBadGateway
-}
-
-func mapTypeToAnthropic(upstreamStatus int) string {
-	switch upstreamStatus {
-	case http.StatusUnauthorized, http.StatusForbidden:
-		return "authentication_error"
-	case http.StatusTooManyRequests:
-		return "rate_limit_error"
-	default:
-		if upstreamStatus >= 400 && upstreamStatus < 500 {
-			return "invalid_request_error"
-		}
-		if upstreamStatus >= 500 {
-			return "api_error"
-		}
-		return "api_error"
-	}
-}
-
 func clientIP(r *http.Request) string {
 	xff := strings.TrimSpace(r.Header.Get("X-Forwarded-For"))
 	if xff != "" {
@@ -563,80 +339,33 @@ func clientIP(r *http.Request) string {
 	return strings.TrimSpace(r.RemoteAddr)
 }
 
-func isTestRequest(r *http.Request) bool {
-	v := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Gateway-Test")))
-	return v == "1" || v == "true" || v == "yes"
-}
-
-func parseInt64(v any) int64 {
-	switch t := v.(type) {
-	case float64:
-		return int64(t)
-	case int:
-		return int64(t)
-	case int64:
-		return t
-	case json.Number:
-		i, _ := t.Int64()
-		return i
-	case string:
-		n := strings.TrimSpace(t)
-		if n == "" {
-			return 0
-		}
-		i, _ := json.Number(n).Int64()
-		return i
-	default:
-		return 0
-	}
-}
-
-func extractOpenAIUsage(raw []byte) (int64, int64) {
-	var root map[string]any
-	if err := json.Unmarshal(raw, &root); err != nil {
-		return 0, 0
-	}
-	u, _ := root["usage"].(map[string]any)
-	if u == nil {
-		return 0, 0
-	}
-	in := parseInt64(u["prompt_tokens"])
-	if in == 0 {
-		in = parseInt64(u["input_tokens"])
+// nativeRequestBody is the client's body as sent to an Anthropic upstream:
+// asking for upModel, and without the thinking blocks the gateway signed
+// when another upstream answered an earlier turn.
+func nativeRequestBody(body []byte, model, upModel string) ([]byte, error) {
+	out, err := convert.StripSyntheticThinking(body)
+	if err != nil {
+		return nil, err
 	}
-	out := parseInt64(u["completion_tokens"])
-	if out == 0 {
-		out = parseInt64(u["output_tokens"])
+	if strings.TrimSpace(upModel) != "" && upModel != model {
+		return rawjson.Set(out, "model", upModel)
 	}
-	return in, out
+	return out, nil
 }
 
-func extractAnthropicUsage(raw []byte) (int64, int64) {
-	var root map[string]any
-	if err := json.Unmarshal(raw, &root); err != nil {
-		return 0, 0
-	}
-	u, _ := root["usage"].(map[string]any)
-	if u == nil {
-		return 0, 0
-	}
-	in := parseInt64(u["input_tokens"])
-	out := parseInt64(u["output_tokens"])
-	return in, out
+func isTestRequest(r *http.Request) bool {
+	v := strings.ToLower(strings.TrimSpace(r.Header.Get("X-Gateway-Test")))
+	return v == "1" || v == "true" || v == "yes"
 }
 
 func rewriteAnthropicResponseModel(raw []byte, requestedModel string) []byte {
 	if strings.TrimSpace(requestedModel) == "" {
 		return raw
 	}
-	var root map[string]any
-	if err := json.Unmarshal(raw, &root); err != nil {
+	if _, ok := rawjson.Get(raw, "model"); !ok {
 		return raw
 	}
-	if _, ok := root["model"]; ok {
-		root["model"] = requestedModel
-	}
-	if out, err := json.Marshal(root); err == nil {
+	if out, err := rawjson.Set(raw, "model", requestedModel); err == nil {
 		return out
 	}
 	return raw
@@ -683,75 +412,81 @@ func rewriteAnthropicSSEBlockModel(block string, requestedModel string) string {
 	return strings.Join(outLines, "\n") + "\n"
 }
 
-func copyAnthropicSSEWithUsage(w http.ResponseWriter, r io.Reader, requestedModel string, startTime time.Time) (int, int64, int64, int64, float64, error) {
+func copyAnthropicSSEWithUsage(w http.ResponseWriter, r io.Reader, requestedModel string, startTime time.Time) (pipeline.Stats, error) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		n, err := io.Copy(w, r)
-		return int(n), 0, 0, 0, 0, err
+		return pipeline.Stats{ResponseBytes: int(n)}, err
 	}
 	br := bufio.NewReader(r)
 	var (
-		respBytes  int
-		inTok      int64
-		outTok     int64
-		ttft       int64
-		tps        float64
-		chunkCount int
+		st         pipeline.Stats
 		firstToken time.Time
 		lastToken  time.Time
+		sawStop    bool
 	)
 	for {
 		block, err := readSSEBlock(br)
 		if block != "" {
-			now := time.Now()
-			if ttft == 0 {
-				ttft = now.Sub(startTime).Milliseconds()
-				firstToken = now
+			var ev map[string]any
+			_ = json.Unmarshal([]byte(extractSSEData(block)), &ev)
+			if se := streamconv.AnthropicError(ev); se != nil {
+				st.ResponseBytes += streamconv.WriteAnthropicError(w, se)
+				return st, se
+			}
+
+			if ev["type"] == "message_stop" {
+				sawStop = true
+			}
+
+			// Upstream pings are keepalives, not tokens.
+			if ev != nil && ev["type"] != "ping" {
+				now := time.Now()
+				if st.TTFT == 0 {
+					st.TTFT = now.Sub(startTime).Milliseconds()
+				}
+				if ev["type"] == "content_block_delta" {
+					if firstToken.IsZero() {
+						firstToken = now
+					}
+					lastToken = now
+				}
 			}
-			lastToken = now
-			chunkCount++
 
 			block = rewriteAnthropicSSEBlockModel(block, requestedModel)
 			b := []byte(block)
 			n, werr := w.Write(b)
-			respBytes += n
+			st.ResponseBytes += n
 			if werr != nil {
-				return respBytes, inTok, outTok, ttft, tps, werr
+				return st, werr
 			}
 			n2, werr2 := w.Write([]byte("\n"))
-			respBytes += n2
+			st.ResponseBytes += n2
 			if werr2 != nil {
-				return respBytes, inTok, outTok, ttft, tps, werr2
+				return st, werr2
 			}
 			flusher.Flush()
 
-			data := extractSSEData(block)
-			if strings.TrimSpace(data) != "" {
-				var ev map[string]any
-				if err := json.Unmarshal([]byte(data), &ev); err == nil {
-					if u, _ := ev["usage"].(map[string]any); u != nil {
-						inTok = parseInt64(u["input_tokens"])
-						outTok = parseInt64(u["output_tokens"])
-					} else if msg, _ := ev["message"].(map[string]any); msg != nil {
-						if u2, _ := msg["usage"].(map[string]any); u2 != nil {
-							inTok = parseInt64(u2["input_tokens"])
-							outTok = parseInt64(u2["output_tokens"])
-						}
-					}
+			if u, _ := ev["usage"].(map[string]any); u != nil {
+				st.Usage.AddAnthropic(u)
+			} else if msg, _ := ev["message"].(map[string]any); msg != nil {
+				if u2, _ := msg["usage"].(map[string]any); u2 != nil {
+					st.Usage.AddAnthropic(u2)
 				}
 			}
 		}
 		if err != nil {
+			if err == io.EOF && sawStop {
+				st.TPS = streamconv.TokensPerSecond(int(st.Usage.Output), firstToken, lastToken)
+				return st, nil
+			}
 			if err == io.EOF {
-				if chunkCount > 1 && !lastToken.IsZero() && !firstToken.IsZero() {
-					dur := lastToken.Sub(firstToken).Seconds()
-					if dur > 0 {
-						tps = float64(chunkCount-1) / dur
-					}
-				}
-				return respBytes, inTok, outTok, ttft, tps, nil
+				// A stream cut before message_stop is not a complete response.
+				err = streamconv.ErrStreamInterrupted
 			}
-			return respBytes, inTok, outTok, ttft, tps, err
+			se := streamconv.AsStreamError(err)
+			st.ResponseBytes += streamconv.WriteAnthropicError(w, se)
+			return st, se
 		}
 	}
 }
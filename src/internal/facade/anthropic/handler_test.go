@@ -0,0 +1,70 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPassthroughPatchKeepsUnknownFields(t *testing.T) {
+	body := []byte(`{"model":"claude-sonnet-4-5","max_tokens":64000,"messages":[{"role":"user","content":"hi"}],` +
+		`"thinking":{"type":"enabled","budget_tokens":32000},"metadata":{"user_id":"u1"},"top_k":5,` +
+		`"service_tier":"auto","future_field":{"n":12345678901234567890}}`)
+
+	out := setJSONField(body, "model", "claude-opus-4-1")
+	out = setJSONField(out, "max_tokens", 8192)
+
+	var before, after map[string]json.RawMessage
+	if err := json.Unmarshal(body, &before); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(out, &after); err != nil {
+		t.Fatalf("patched body is invalid: %v\n%s", err, out)
+	}
+	if string(after["model"]) != `"claude-opus-4-1"` || string(after["max_tokens"]) != `8192` {
+		t.Fatalf("patches not applied: %s", out)
+	}
+	delete(before, "model")
+	delete(before, "max_tokens")
+	delete(after, "model")
+	delete(after, "max_tokens")
+	if !reflect.DeepEqual(before, after) {
+		t.Fatalf("unrelated fields changed:\nbefore %s\nafter  %s", body, out)
+	}
+}
+
+func TestNativeRequestBodyDropsGatewayThinking(t *testing.T) {
+	body := []byte(`{"model":"sonnet","max_tokens":1024,"messages":[{"role":"user","content":"hi"},` +
+		`{"role":"assistant","content":[{"type":"thinking","thinking":"from another upstream","signature":"gw1.abc"},{"type":"text","text":"hello"}]},` +
+		`{"role":"user","content":"again"}]}`)
+
+	out, err := nativeRequestBody(body, "sonnet", "claude-sonnet-4-5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(out), "gw1.") || !strings.Contains(string(out), `"model":"claude-sonnet-4-5"`) {
+		t.Fatalf("native body = %s", out)
+	}
+}
+
+func TestCopyAnthropicSSEReportsCutStream(t *testing.T) {
+	head := "event: message_start\ndata: {\"type\":\"message_start\",\"message\":{\"model\":\"m\"}}\n\n" +
+		"event: content_block_delta\ndata: {\"type\":\"content_block_delta\",\"index\":0,\"delta\":{\"type\":\"text_delta\",\"text\":\"Hel\"}}\n\n"
+
+	rr := httptest.NewRecorder()
+	if _, err := copyAnthropicSSEWithUsage(rr, strings.NewReader(head), "sonnet", time.Now()); err == nil {
+		t.Fatal("stream without message_stop reported as complete")
+	}
+	if !strings.Contains(rr.Body.String(), "event: error") {
+		t.Fatalf("no terminal error event: %s", rr.Body.String())
+	}
+
+	rr = httptest.NewRecorder()
+	full := head + "event: message_stop\ndata: {\"type\":\"message_stop\"}\n\n"
+	if _, err := copyAnthropicSSEWithUsage(rr, strings.NewReader(full), "sonnet", time.Now()); err != nil {
+		t.Fatalf("complete stream: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package anthropic
+
+import (
+	"encoding/json"
+
+	"claude-gateway/src/internal/convert"
+	"claude-gateway/src/internal/rawjson"
+	"claude-gateway/src/internal/router"
+)
+
+// requirementsOf inspects a Messages API body for the features the upstream
+// model has to support.
+func requirementsOf(body []byte) router.Requirements {
+	var root map[string]any
+	if err := json.Unmarshal(body, &root); err != nil {
+		return router.Requirements{}
+	}
+	var need router.Requirements
+	if tools, _ := root["tools"].([]any); len(tools) > 0 {
+		need.Tools = true
+	}
+	if th, _ := root["thinking"].(map[string]any); th != nil {
+		if t, _ := th["type"].(string); t == "enabled" {
+			need.Thinking = true
+		}
+	}
+	chars := countText(root["system"], &need) + countText(root["messages"], &need) + countText(root["tools"], &need)
+	need.PromptTokens = chars / 4
+	return need
+}
+
+// countText sums the length of the text in v, skipping base64 payloads, and
+// flags image content.
+func countText(v any, need *router.Requirements) int {
+	switch x := v.(type) {
+	case string:
+		return len(x)
+	case []any:
+		n := 0
+		for _, it := range x {
+			n += countText(it, need)
+		}
+		return n
+	case map[string]any:
+		if t, _ := x["type"].(string); t == "image" {
+			need.Vision = true
+			return 0
+		}
+		n := 0
+		for k, it := range x {
+			if k == "data" {
+				continue
+			}
+			n += countText(it, need)
+		}
+		return n
+	}
+	return 0
+}
+
+// messageTokens estimates a conversation entry the way requirementsOf
+// estimates the prompt.
+func messageTokens(raw json.RawMessage) int {
+	var v any
+	_ = json.Unmarshal(raw, &v)
+	return countText(v, &router.Requirements{}) / 4
+}
+
+// setJSONField replaces one top-level field of a JSON object, leaving the
+// body unchanged if it cannot be decoded.
+func setJSONField(body []byte, key string, value any) []byte {
+	out, err := rawjson.Set(body, key, value)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// rejectedParams lists the request parameters up cannot honour when its pool
+// rejects rather than drops them.
+func rejectedParams(up router.RoutedUpstream, body []byte) []string {
+	if up.ParamPolicy != router.ParamPolicyReject {
+		return nil
+	}
+	return convert.UnsupportedParams(convert.DialectAnthropic, body, up.ProviderType)
+}
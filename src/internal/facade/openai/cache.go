@@ -0,0 +1,42 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"claude-gateway/src/internal/canonical"
+	"claude-gateway/src/internal/convert"
+	"claude-gateway/src/internal/pipeline"
+	"claude-gateway/src/internal/respcache"
+	"claude-gateway/src/internal/streamconv"
+)
+
+// cacheSpec makes a deterministic Chat Completions request eligible for the
+// response cache; cached answers report model. Responses API requests are
+// never cached, as there is no Responses stream encoder to replay a cached
+// answer through.
+func cacheSpec(r *http.Request, d convert.Dialect, body []byte, model string) *pipeline.CacheSpec {
+	if d != convert.DialectOpenAIChat || !respcache.Cacheable(body) {
+		return nil
+	}
+	return &pipeline.CacheSpec{
+		Body:    body,
+		Refresh: strings.Contains(strings.ToLower(r.Header.Get("Cache-Control")), "no-cache"),
+		Parse: func(b []byte) (canonical.Response, error) {
+			var or convert.OpenAIChatCompletionResponse
+			if err := json.Unmarshal(b, &or); err != nil {
+				return canonical.Response{}, err
+			}
+			return convert.OpenAIResponseToCanonical(or), nil
+		},
+		Decode: streamconv.DecodeOpenAI,
+		Render: func(resp canonical.Response) ([]byte, error) {
+			resp.Model = model
+			return json.Marshal(convert.CanonicalToOpenAIResponse(resp, false))
+		},
+		NewEncoder: func(w http.ResponseWriter, flusher http.Flusher) streamconv.Encoder {
+			return streamconv.NewOpenAIEncoder(w, flusher, model, false)
+		},
+	}
+}
@@ -0,0 +1,20 @@
+package openai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"claude-gateway/src/internal/convert"
+)
+
+func TestCacheSpecSkipsResponsesAPI(t *testing.T) {
+	body := []byte(`{"model":"m","temperature":0,"input":"hi"}`)
+	r := httptest.NewRequest(http.MethodPost, "/v1/responses", nil)
+	if spec := cacheSpec(r, convert.DialectResponses, body, "m"); spec != nil {
+		t.Fatal("Responses API request is cacheable")
+	}
+	if spec := cacheSpec(r, convert.DialectOpenAIChat, body, "m"); spec == nil {
+		t.Fatal("deterministic Chat Completions request is not cacheable")
+	}
+}
@@ -0,0 +1,99 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	"claude-gateway/src/internal/convert"
+	"claude-gateway/src/internal/pipeline"
+	anthropicProvider "claude-gateway/src/internal/providers/anthropic"
+	"claude-gateway/src/internal/router"
+)
+
+// maxChoices bounds n where it is emulated, and maxParallelChoices the
+// calls made at once to emulate it. Upstreams that support n natively
+// enforce their own bound.
+const (
+	maxChoices         = 8
+	maxParallelChoices = 4
+)
+
+// extraAnthropicCompletions emulates n>1 against Anthropic, which returns a
+// single completion per call, by sending the converted request n more times,
+// a few at a time. Each call needs room under the credential's concurrency
+// limit and counts against it like a routed one. Any failed call fails the
+// whole set.
+func extraAnthropicCompletions(ctx context.Context, rtr *router.Router, up router.RoutedUpstream, body []byte, structured bool, n int) ([]convert.OpenAIChatCompletionResponse, pipeline.Usage, error) {
+	timeout := up.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	out := make([]convert.OpenAIChatCompletionResponse, n)
+	usage := make([]pipeline.Usage, n)
+	errs := make([]error, n)
+	sem := make(chan struct{}, maxParallelChoices)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			out[i], usage[i], errs[i] = anthropicCompletion(ctx, rtr, up, body, structured, timeout)
+		}(i)
+	}
+	wg.Wait()
+
+	var tok pipeline.Usage
+	for i := range out {
+		if errs[i] != nil {
+			return nil, pipeline.Usage{}, errs[i]
+		}
+		tok.Add(usage[i])
+	}
+	return out, tok, nil
+}
+
+// errNoChoiceSlot fails a set whose credential has no room for another call.
+var errNoChoiceSlot = &pipeline.Error{Status: http.StatusServiceUnavailable, Code: "upstream_unavailable", Message: "upstream is at its concurrency limit; retry with a smaller n"}
+
+func anthropicCompletion(ctx context.Context, rtr *router.Router, up router.RoutedUpstream, body []byte, structured bool, timeout time.Duration) (convert.OpenAIChatCompletionResponse, pipeline.Usage, error) {
+	if !rtr.BeginRequest(up.CredentialID) {
+		return convert.OpenAIChatCompletionResponse{}, pipeline.Usage{}, errNoChoiceSlot
+	}
+	start := time.Now()
+	resp, err := anthropicProvider.DoMessages(ctx, anthropicProvider.Upstream{
+		BaseURL: up.BaseURL,
+		APIKey:  string(up.APIKey),
+		Headers: up.Headers,
+		APIVer:  "2023-06-01",
+		Timeout: timeout,
+	}, body)
+	if err != nil {
+		rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
+		return convert.OpenAIChatCompletionResponse{}, pipeline.Usage{}, err
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	rtr.ObserveRateLimit(up.CredentialID, anthropicProvider.ParseRateLimit(resp.Header, time.Now()))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		ok := resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests
+		rtr.EndRequest(up.CredentialID, ok, resp.StatusCode, time.Since(start))
+		rtr.ReportFailure(ctx, up.CredentialID, anthropicProvider.ClassifyError(resp.StatusCode, raw))
+		return convert.OpenAIChatCompletionResponse{}, pipeline.Usage{}, fmt.Errorf("upstream status %d", resp.StatusCode)
+	}
+	rtr.EndRequest(up.CredentialID, true, resp.StatusCode, time.Since(start))
+	var aresp convert.AnthropicMessageResponse
+	if err := json.Unmarshal(raw, &aresp); err != nil {
+		return convert.OpenAIChatCompletionResponse{}, pipeline.Usage{}, err
+	}
+	return convert.AnthropicResponseToOpenAI(aresp, structured), pipeline.ExtractAnthropicUsage(raw), nil
+}
@@ -0,0 +1,20 @@
+package openai
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"claude-gateway/src/internal/router"
+)
+
+func TestChatCompletionsRejectsZeroN(t *testing.T) {
+	h := NewHandler(router.New(nil, nil, nil), nil, nil)
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", strings.NewReader(`{"model":"m","n":0,"messages":[{"role":"user","content":"hi"}]}`))
+	rec := httptest.NewRecorder()
+	h.chatCompletions(rec, req)
+	if rec.Code != http.StatusBadRequest || !strings.Contains(rec.Body.String(), "invalid_n") {
+		t.Fatalf("got %d %s", rec.Code, rec.Body.String())
+	}
+}
@@ -27,3 +27,21 @@ func writeError(w http.ResponseWriter, status int, typ, code, msg string) {
 	})
 }
 
+// writeGatewayError writes a pipeline error, deriving the OpenAI error type
+// from the status.
+func writeGatewayError(w http.ResponseWriter, status int, code string, msg string) {
+	writeError(w, status, errorType(status), code, msg)
+}
+
+func errorType(status int) string {
+	switch {
+	case status == http.StatusUnauthorized || status == http.StatusForbidden:
+		return "authentication_error"
+	case status == http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case status >= 400 && status < 500:
+		return "invalid_request_error"
+	default:
+		return "server_error"
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"log"
 	"net"
@@ -16,33 +17,79 @@ import (
 	"github.com/google/uuid"
 
 	"claude-gateway/src/internal/canonical"
+	"claude-gateway/src/internal/capture"
 	"claude-gateway/src/internal/convert"
+	"claude-gateway/src/internal/idempotency"
 	"claude-gateway/src/internal/logbus"
 	"claude-gateway/src/internal/metrics"
+	"claude-gateway/src/internal/pipeline"
 	openaiproto "claude-gateway/src/internal/proto/openai"
-	anthropicProvider "claude-gateway/src/internal/providers/anthropic"
-	geminiProvider "claude-gateway/src/internal/providers/gemini"
-	"claude-gateway/src/internal/providers/openai"
+	"claude-gateway/src/internal/rawjson"
+	"claude-gateway/src/internal/respcache"
 	"claude-gateway/src/internal/router"
+	"claude-gateway/src/internal/shadow"
 	"claude-gateway/src/internal/streamconv"
+	"claude-gateway/src/internal/truncate"
 )
 
 type Handler struct {
-	rtr *router.Router
-	m   *metrics.Metrics
-	bus *logbus.Bus
+	rtr  *router.Router
+	exec *pipeline.Executor
+	idem *idempotency.Store
 }
 
 func NewHandler(rtr *router.Router, m *metrics.Metrics, bus *logbus.Bus) *Handler {
-	return &Handler{rtr: rtr, m: m, bus: bus}
+	return &Handler{rtr: rtr, exec: pipeline.New(rtr, m, bus)}
+}
+
+// SetResponseCache sets the store of the response cache, which pools enable
+// by configuring a TTL. It must be called before serving.
+func (h *Handler) SetResponseCache(store respcache.Store) {
+	h.exec.SetCache(store)
+}
+
+// SetCapture sets where the bodies of requests sampled for capture are
+// stored. It must be called before serving.
+func (h *Handler) SetCapture(store capture.Store) {
+	h.exec.SetCapture(store)
+}
+
+// SetShadow sets where the results of requests mirrored to a pool's
+// shadow upstream are stored. It must be called before serving.
+func (h *Handler) SetShadow(store shadow.Store) {
+	h.exec.SetShadow(store)
+}
+
+// SetStreamKeepalive sets how long a stream may stay silent before the
+// client gets a keepalive; zero disables them. It must be called before
+// serving.
+func (h *Handler) SetStreamKeepalive(d time.Duration) {
+	h.exec.SetKeepalive(d)
+}
+
+// SetIdempotency enables Idempotency-Key support on the generation
+// endpoints. It must be called before serving.
+func (h *Handler) SetIdempotency(store *idempotency.Store) {
+	h.idem = store
 }
 
 func (h *Handler) Register(r chi.Router) {
-	r.Post("/chat/completions", h.chatCompletions)
-	r.Post("/responses", h.responses)
+	r.Post("/chat/completions", h.idempotent(h.chatCompletions))
+	r.Post("/responses", h.idempotent(h.responses))
 	r.Get("/models", h.listModels)
 }
 
+// idempotent applies the Idempotency-Key store, if one is set, to next.
+func (h *Handler) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if h.idem == nil {
+			next(w, r)
+			return
+		}
+		h.idem.Wrap(next, writeGatewayError)(w, r)
+	}
+}
+
 func (h *Handler) Routes() chi.Router {
 	r := chi.NewRouter()
 	h.Register(r)
@@ -74,361 +121,152 @@ func (h *Handler) chatCompletions(w http.ResponseWriter, r *http.Request) {
 		writeError(w, http.StatusBadRequest, "invalid_request_error", "missing_model", "model is required")
 		return
 	}
+	if req.N != nil && *req.N < 1 {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_n", "n must be at least 1")
+		return
+	}
 	origModel := req.Model
 
 	clientKey, _ := ctx.Value(canonical.ContextKeyClientKey).(string)
-	srcIP := clientIP(r)
-	userAgent := strings.TrimSpace(r.UserAgent())
-	isTest := isTestRequest(r)
-	requestBytes := len(body)
-
-	publish := func(up router.RoutedUpstream, status int, latency time.Duration, errMsg string, inputTokens, outputTokens int64, responseBytes int, ttft int64, tps float64) {
-		if h.bus == nil {
-			return
+	preq := pipeline.Request{
+		ID:           requestID,
+		Facade:       canonical.FacadeOpenAI,
+		Endpoint:     r.URL.Path,
+		Model:        origModel,
+		Stream:       req.Stream,
+		RequestBytes: len(body),
+		Body:         body,
+		ClientKey:    clientKey,
+		Session:      strings.TrimSpace(r.Header.Get(router.SessionHeader)),
+		SrcIP:        clientIP(r),
+		UserAgent:    strings.TrimSpace(r.UserAgent()),
+		IsTest:       isTestRequest(r),
+		Need:         requirementsOf(body),
+		Cache:        cacheSpec(r, convert.DialectOpenAIChat, body, origModel),
+	}
+	preq.Truncate = func(contextWindow int) (int, bool) {
+		out, _, ok := truncate.Body(body, truncate.OpenAIChat, preq.Need.PromptTokens, contextWindow-intValue(req.MaxTokens), messageTokens)
+		if !ok {
+			return 0, false
 		}
-		h.bus.Publish(logbus.Event{
-			TS:            time.Now(),
-			RequestID:     requestID,
-			Facade:        string(canonical.FacadeOpenAI),
-			RequestModel:  origModel,
-			UpstreamModel: up.Model,
-			ProviderType:  up.ProviderType,
-			PoolID:        up.PoolID,
-			ProviderID:    up.ProviderID,
-			CredentialID:  up.CredentialID,
-			ClientKey:     clientKey,
-			SrcIP:         srcIP,
-			UserAgent:     userAgent,
-			IsTest:        isTest,
-			Stream:        req.Stream,
-			RequestBytes:  requestBytes,
-			ResponseBytes: responseBytes,
-			InputTokens:   inputTokens,
-			OutputTokens:  outputTokens,
-			Status:        status,
-			LatencyMs:     latency.Milliseconds(),
-			TTFTMs:        ttft,
-			TPS:           tps,
-			Error:         errMsg,
-		})
-	}
-
-	maxAttempts := 1
-	if !req.Stream {
-		maxAttempts = 2
+		var next openaiproto.ChatCompletionsRequest
+		if err := json.Unmarshal(out, &next); err != nil {
+			return 0, false
+		}
+		body, req = out, next
+		return requirementsOf(body).PromptTokens, true
 	}
-	exclude := map[uint64]bool{}
 
-	for attempt := 0; attempt < maxAttempts; attempt++ {
-		var up router.RoutedUpstream
-		var err error
-		if attempt == 0 {
-			up, err = h.rtr.PickUpstream(ctx, clientKey, string(canonical.FacadeOpenAI), req.Model)
-		} else {
-			up, err = h.rtr.PickUpstreamExclude(ctx, clientKey, string(canonical.FacadeOpenAI), req.Model, exclude)
+	// Each attempt, and the shadow request, gets fresh copies of body and req.
+	buildCall := func(body []byte, req openaiproto.ChatCompletionsRequest, up router.RoutedUpstream) (pipeline.Call, error) {
+		if n := up.MaxOutputTokens; n > 0 && req.MaxTokens != nil && *req.MaxTokens > n {
+			req.MaxTokens = &n
+			body = setJSONField(body, "max_tokens", n)
 		}
-		if err != nil {
-			var noUpstreamErr *router.ErrNoAvailableUpstream
-			if errors.As(err, &noUpstreamErr) {
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusServiceUnavailable, "server_error", "no_available_upstream", noUpstreamErr.Error())
-				return
-			}
-			if errors.Is(err, router.ErrNotConfigured) {
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusServiceUnavailable, "server_error", "not_configured", "gateway not configured")
-				return
-			}
-			w.Header().Set("X-Request-Id", requestID)
-			writeError(w, http.StatusBadGateway, "server_error", "routing_failed", "routing failed: "+err.Error())
-			return
+		if bad := rejectedParams(up, convert.DialectOpenAIChat, body); len(bad) > 0 {
+			return pipeline.Call{}, &pipeline.Error{Status: http.StatusBadRequest, Code: "unsupported_parameter", Message: "unsupported parameters for this upstream: " + strings.Join(bad, ", ")}
 		}
 
-		start := time.Now()
-		status := 0
-		ok := false
-
 		switch up.ProviderType {
 		case "openai":
 			targetBody := body
 			if strings.TrimSpace(up.Model) != "" && up.Model != req.Model {
-				req.Model = up.Model
-				b, err := json.Marshal(req)
+				b, err := rawjson.Set(body, "model", up.Model)
 				if err != nil {
-					h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-					writeError(w, http.StatusInternalServerError, "server_error", "encode_failed", "failed to build upstream request")
-					return
+					return pipeline.Call{}, err
 				}
 				targetBody = b
 			}
 			if req.Stream {
 				targetBody = ensureOpenAIStreamIncludeUsage(targetBody)
 			}
-
-			timeout := up.Timeout
-			if timeout <= 0 {
-				timeout = 10 * time.Minute
-			}
-			uctx, cancel := context.WithTimeout(ctx, timeout)
-			resp, err := openai.DoChatCompletions(uctx, openai.Upstream{
-				BaseURL: up.BaseURL,
-				APIKey:  string(up.APIKey),
-				Headers: up.Headers,
-			}, targetBody)
-			if err != nil {
-				cancel()
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				publish(up, 0, time.Since(start), "upstream_failed", 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, http.StatusBadGateway, time.Since(start))
-				exclude[up.CredentialID] = true
-				if attempt+1 < maxAttempts {
-					continue
-				}
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusBadGateway, "server_error", "upstream_failed", "upstream request failed")
-				return
-			}
-
-			status = resp.StatusCode
-			ok = status < 500 && status != http.StatusTooManyRequests
-			if !ok {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				publish(up, status, time.Since(start), "upstream_unavailable", 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, status, time.Since(start))
-				exclude[up.CredentialID] = true
-				if attempt+1 < maxAttempts {
-					_ = resp.Body.Close()
-					cancel()
-					continue
-				}
-			}
-
-			copyHeader(w.Header(), resp.Header)
-			w.Header().Set("X-Request-Id", requestID)
-			w.WriteHeader(resp.StatusCode)
-
-			if req.Stream {
-				var inTok, outTok int64
-				var respBytes int
-				var ttft int64
-				var tps float64
-				respBytes, inTok, outTok, ttft, tps, err = copyOpenAISSEWithUsage(w, resp.Body, start)
-				_ = resp.Body.Close()
-				cancel()
-				okFinal := err == nil && ok
-				h.rtr.EndRequest(up.CredentialID, okFinal, status, time.Since(start))
-				h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeOpenAI), origModel, up.CredentialID, okFinal, status)
-				publish(up, status, time.Since(start), errString(err), inTok, outTok, respBytes, ttft, tps)
-				h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, status, time.Since(start))
-				return
-			}
-			raw, _ := io.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-			cancel()
-			_, _ = w.Write(raw)
-			h.rtr.EndRequest(up.CredentialID, ok, status, time.Since(start))
-			h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeOpenAI), origModel, up.CredentialID, ok, status)
-			inTok, outTok := extractOpenAIUsage(raw)
-			dur := time.Since(start)
-			var tps float64
-			if outTok > 0 && dur.Seconds() > 0 {
-				tps = float64(outTok) / dur.Seconds()
-			}
-			publish(up, status, dur, "", inTok, outTok, len(raw), dur.Milliseconds(), tps)
-			h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, status, time.Since(start))
-			return
+			return nativeOpenAICall(pipeline.OpenAIChatCompletions(up, targetBody)), nil
 
 		case "anthropic":
+			if req.N != nil && *req.N > maxChoices {
+				return pipeline.Call{}, &pipeline.Error{Status: http.StatusBadRequest, Code: "invalid_n", Message: fmt.Sprintf("n must be at most %d for this model", maxChoices)}
+			}
 			areq, err := convert.OpenAIToAnthropicMessageRequest(req)
 			if err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusBadRequest, "invalid_request_error", "unsupported_request", err.Error())
-				return
+				return pipeline.Call{}, &pipeline.Error{Status: http.StatusBadRequest, Code: "unsupported_request", Message: err.Error()}
 			}
 			areq.Model = up.Model
 			areq.Stream = req.Stream
 			b, err := json.Marshal(areq)
 			if err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				writeError(w, http.StatusInternalServerError, "server_error", "encode_failed", "failed to build upstream request")
-				return
-			}
-
-			timeout := up.Timeout
-			if timeout <= 0 {
-				timeout = 10 * time.Minute
-			}
-			uctx, cancel := context.WithTimeout(ctx, timeout)
-			resp, err := anthropicProvider.DoMessages(uctx, anthropicProvider.Upstream{
-				BaseURL: up.BaseURL,
-				APIKey:  string(up.APIKey),
-				Headers: up.Headers,
-				APIVer:  "2023-06-01",
-				Timeout: timeout,
-			}, b)
-			if err != nil {
-				cancel()
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				publish(up, 0, time.Since(start), "upstream_failed", 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, http.StatusBadGateway, time.Since(start))
-				exclude[up.CredentialID] = true
-				if attempt+1 < maxAttempts {
-					continue
+				return pipeline.Call{}, err
+			}
+			structured := convert.IsStructuredOutput(req.ResponseFormat)
+			call := pipeline.AnthropicMessages(up, "2023-06-01", b)
+			call.Stream = pipeline.ConvertStream(streamconv.AnthropicToOpenAI, up.Model)
+			if structured {
+				call.Stream = pipeline.ConvertStream(streamconv.AnthropicToOpenAIStructured, up.Model)
+			}
+			call.Decode = func(ctx context.Context, raw []byte) ([]byte, pipeline.Usage, error) {
+				var aresp convert.AnthropicMessageResponse
+				if err := json.Unmarshal(raw, &aresp); err != nil {
+					return nil, pipeline.Usage{}, err
 				}
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusBadGateway, "server_error", "upstream_failed", "upstream request failed")
-				return
-			}
-			status = resp.StatusCode
-			ok = status < 500 && status != http.StatusTooManyRequests
-			if status < 200 || status >= 300 {
-				_ = resp.Body.Close()
-				cancel()
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				publish(up, status, time.Since(start), "upstream_error", 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, status, time.Since(start))
-				exclude[up.CredentialID] = true
-				if !ok && attempt+1 < maxAttempts {
-					continue
+				oresp := convert.AnthropicResponseToOpenAI(aresp, structured)
+				tok := pipeline.ExtractAnthropicUsage(raw)
+				if req.N != nil && *req.N > 1 {
+					more, moreTok, err := extraAnthropicCompletions(ctx, h.rtr, up, b, structured, *req.N-1)
+					if errors.Is(err, errNoChoiceSlot) {
+						return nil, tok, errNoChoiceSlot
+					}
+					if err != nil {
+						return nil, tok, &pipeline.Error{Status: http.StatusBadGateway, Code: "upstream_failed", Message: "upstream request failed"}
+					}
+					oresp = convert.MergeChatCompletions(oresp, more...)
+					tok.Add(moreTok)
 				}
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, mapStatusToOpenAI(status), mapTypeToOpenAI(status), mapCodeToOpenAI(status), "upstream error")
-				return
+				out, err := json.Marshal(oresp)
+				return out, tok, err
 			}
-			if req.Stream {
-				w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
-				w.Header().Set("Cache-Control", "no-cache")
-				w.Header().Set("X-Request-Id", requestID)
-				w.WriteHeader(http.StatusOK)
-				err := streamconv.AnthropicToOpenAI(w, resp.Body, up.Model)
-				_ = resp.Body.Close()
-				cancel()
-				okFinal := err == nil && ok
-				h.rtr.EndRequest(up.CredentialID, okFinal, status, time.Since(start))
-				h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeOpenAI), origModel, up.CredentialID, okFinal, status)
-				publish(up, status, time.Since(start), errString(err), 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, status, time.Since(start))
-				return
-			}
-
-			raw, _ := io.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-			cancel()
-			var aresp convert.AnthropicMessageResponse
-			if err := json.Unmarshal(raw, &aresp); err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				writeError(w, http.StatusBadGateway, "server_error", "bad_upstream", "invalid upstream response")
-				return
-			}
-			oresp := convert.AnthropicResponseToOpenAI(aresp)
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.Header().Set("X-Request-Id", requestID)
-			w.WriteHeader(http.StatusOK)
-			_ = json.NewEncoder(w).Encode(oresp)
-			h.rtr.EndRequest(up.CredentialID, true, status, time.Since(start))
-			h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeOpenAI), origModel, up.CredentialID, true, status)
-			inTok, outTok := extractAnthropicUsage(raw)
-			dur := time.Since(start)
-			var tps float64
-			if outTok > 0 && dur.Seconds() > 0 {
-				tps = float64(outTok) / dur.Seconds()
-			}
-			publish(up, status, dur, "", inTok, outTok, len(raw), dur.Milliseconds(), tps)
-			h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, status, time.Since(start))
-			return
+			return call, nil
 
 		case "gemini":
 			if req.Stream {
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				writeError(w, http.StatusNotImplemented, "server_error", "not_implemented", "provider conversion not implemented yet (streaming requires conversion)")
-				return
+				return pipeline.Call{}, &pipeline.Error{Status: http.StatusNotImplemented, Code: "not_implemented", Message: "provider conversion not implemented yet (streaming requires conversion)"}
 			}
-			greq, model := convert.OpenAIToGeminiRequest(req)
-			model = up.Model
-			b, err := json.Marshal(greq)
+			greq, err := convert.OpenAIToGeminiRequest(req)
 			if err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				writeError(w, http.StatusInternalServerError, "server_error", "encode_failed", "failed to build upstream request")
-				return
-			}
-
-			timeout := up.Timeout
-			if timeout <= 0 {
-				timeout = 10 * time.Minute
+				return pipeline.Call{}, &pipeline.Error{Status: http.StatusBadRequest, Code: "unsupported_request", Message: err.Error()}
 			}
-			uctx, cancel := context.WithTimeout(ctx, timeout)
-			resp, err := geminiProvider.DoGenerateContent(uctx, geminiProvider.Upstream{
-				BaseURL: up.BaseURL,
-				APIKey:  string(up.APIKey),
-				Headers: up.Headers,
-			}, model, b)
+			b, err := json.Marshal(greq)
 			if err != nil {
-				cancel()
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				publish(up, 0, time.Since(start), "upstream_failed", 0, 0, 0, 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, http.StatusBadGateway, time.Since(start))
-				exclude[up.CredentialID] = true
-				if attempt+1 < maxAttempts {
-					continue
-				}
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, http.StatusBadGateway, "server_error", "upstream_failed", "upstream request failed")
-				return
+				return pipeline.Call{}, err
 			}
-			status = resp.StatusCode
-			raw, _ := io.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-			cancel()
-			ok = status < 500 && status != http.StatusTooManyRequests
-			if status < 200 || status >= 300 {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				publish(up, status, time.Since(start), "upstream_error", 0, 0, len(raw), 0, 0)
-				h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, status, time.Since(start))
-				exclude[up.CredentialID] = true
-				if !ok && attempt+1 < maxAttempts {
-					continue
+			call := pipeline.GeminiGenerateContent(up, up.Model, b)
+			call.Decode = func(_ context.Context, raw []byte) ([]byte, pipeline.Usage, error) {
+				var gres convert.GeminiGenerateContentResponse
+				if err := json.Unmarshal(raw, &gres); err != nil {
+					return nil, pipeline.Usage{}, err
 				}
-				w.Header().Set("X-Request-Id", requestID)
-				writeError(w, mapStatusToOpenAI(status), mapTypeToOpenAI(status), mapCodeToOpenAI(status), "upstream error")
-				return
-			}
-			var gres convert.GeminiGenerateContentResponse
-			if err := json.Unmarshal(raw, &gres); err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				writeError(w, http.StatusBadGateway, "server_error", "bad_upstream", "invalid upstream response")
-				return
+				out, err := json.Marshal(convert.GeminiResponseToOpenAI(gres, up.Model))
+				return out, pipeline.GeminiUsage(gres.UsageMetadata), err
 			}
-			text, usage := convert.GeminiResponseText(gres)
-			oresp := convert.GeminiTextToOpenAI(text, model, usage)
-			outRaw, _ := json.Marshal(oresp)
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.Header().Set("X-Request-Id", requestID)
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(outRaw)
-			h.rtr.EndRequest(up.CredentialID, true, status, time.Since(start))
-			var inTok, outTok int64
-			if usage != nil {
-				inTok = int64(usage.PromptTokenCount)
-				outTok = int64(usage.CandidatesTokenCount)
-			}
-			h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeOpenAI), origModel, up.CredentialID, true, status)
-			dur := time.Since(start)
-			var tps float64
-			if outTok > 0 && dur.Seconds() > 0 {
-				tps = float64(outTok) / dur.Seconds()
-			}
-			publish(up, status, dur, "", inTok, outTok, len(outRaw), dur.Milliseconds(), tps)
-			h.m.ObserveRequest(string(canonical.FacadeOpenAI), up.ProviderType, status, time.Since(start))
-			return
+			return call, nil
 
 		default:
-			h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-			w.Header().Set("X-Request-Id", requestID)
-			writeError(w, http.StatusNotImplemented, "server_error", "not_implemented", "unknown provider")
-			return
+			return pipeline.Call{}, &pipeline.Error{Status: http.StatusNotImplemented, Code: "not_implemented", Message: "unknown provider"}
 		}
 	}
+
+	prepare := func(up router.RoutedUpstream) (pipeline.Call, error) {
+		return buildCall(body, req, up)
+	}
+	preq.FromBody = func(body []byte) (pipeline.Prepare, error) {
+		var req openaiproto.ChatCompletionsRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+		return func(up router.RoutedUpstream) (pipeline.Call, error) {
+			return buildCall(body, req, up)
+		}, nil
+	}
+
+	h.exec.Execute(ctx, w, preq, prepare, writeGatewayError)
 }
 
 func (h *Handler) responses(w http.ResponseWriter, r *http.Request) {
@@ -456,6 +294,8 @@ func (h *Handler) responses(w http.ResponseWriter, r *http.Request) {
 		TopP            *float64        `json:"top_p,omitempty"`
 		Tools           json.RawMessage `json:"tools,omitempty"`
 		ToolChoice      json.RawMessage `json:"tool_choice,omitempty"`
+		Text            json.RawMessage `json:"text,omitempty"`
+		User            string          `json:"user,omitempty"`
 	}
 
 	var req responsesCreateRequest
@@ -470,69 +310,67 @@ func (h *Handler) responses(w http.ResponseWriter, r *http.Request) {
 	origModel := req.Model
 
 	clientKey, _ := ctx.Value(canonical.ContextKeyClientKey).(string)
-	srcIP := clientIP(r)
-	userAgent := strings.TrimSpace(r.UserAgent())
-	isTest := isTestRequest(r)
-	requestBytes := len(body)
-
-	publish := func(up router.RoutedUpstream, status int, latency time.Duration, errMsg string, inputTokens, outputTokens int64, responseBytes int, ttft int64, tps float64) {
-		if h.bus == nil {
-			return
+	preq := pipeline.Request{
+		ID:           requestID,
+		Facade:       canonical.FacadeOpenAI,
+		Endpoint:     r.URL.Path,
+		Model:        origModel,
+		Stream:       req.Stream,
+		RequestBytes: len(body),
+		Body:         body,
+		ClientKey:    clientKey,
+		Session:      strings.TrimSpace(r.Header.Get(router.SessionHeader)),
+		SrcIP:        clientIP(r),
+		UserAgent:    strings.TrimSpace(r.UserAgent()),
+		IsTest:       isTestRequest(r),
+		Need:         requirementsOf(body),
+		Cache:        cacheSpec(r, convert.DialectResponses, body, origModel),
+	}
+	preq.Truncate = func(contextWindow int) (int, bool) {
+		out, _, ok := truncate.Body(body, truncate.OpenAIResponses, preq.Need.PromptTokens, contextWindow-intValue(req.MaxOutputTokens), messageTokens)
+		if !ok {
+			return 0, false
 		}
-		h.bus.Publish(logbus.Event{
-			TS:            time.Now(),
-			RequestID:     requestID,
-			Facade:        string(canonical.FacadeOpenAI),
-			RequestModel:  origModel,
-			UpstreamModel: up.Model,
-			ProviderType:  up.ProviderType,
-			PoolID:        up.PoolID,
-			ProviderID:    up.ProviderID,
-			CredentialID:  up.CredentialID,
-			ClientKey:     clientKey,
-			SrcIP:         srcIP,
-			UserAgent:     userAgent,
-			IsTest:        isTest,
-			Stream:        req.Stream,
-			RequestBytes:  requestBytes,
-			ResponseBytes: responseBytes,
-			InputTokens:   inputTokens,
-			OutputTokens:  outputTokens,
-			Status:        status,
-			LatencyMs:     latency.Milliseconds(),
-			TTFTMs:        ttft,
-			TPS:           tps,
-			Error:         errMsg,
-		})
+		var next responsesCreateRequest
+		if err := json.Unmarshal(out, &next); err != nil {
+			return 0, false
+		}
+		body, req = out, next
+		return requirementsOf(body).PromptTokens, true
 	}
-	up, err := h.rtr.PickUpstream(ctx, clientKey, string(canonical.FacadeOpenAI), req.Model)
-	if err != nil {
-		var noUpstreamErr *router.ErrNoAvailableUpstream
-		if errors.As(err, &noUpstreamErr) {
-			writeError(w, http.StatusServiceUnavailable, "server_error", "no_available_upstream", noUpstreamErr.Error())
-			return
+
+	buildCall := func(body []byte, req responsesCreateRequest, up router.RoutedUpstream) (pipeline.Call, error) {
+		if n := up.MaxOutputTokens; n > 0 && req.MaxOutputTokens != nil && *req.MaxOutputTokens > n {
+			req.MaxOutputTokens = &n
+			body = setJSONField(body, "max_output_tokens", n)
 		}
-		if errors.Is(err, router.ErrNotConfigured) {
-			writeError(w, http.StatusServiceUnavailable, "server_error", "not_configured", "gateway not configured")
-			return
+		if bad := rejectedParams(up, convert.DialectResponses, body); len(bad) > 0 {
+			return pipeline.Call{}, &pipeline.Error{Status: http.StatusBadRequest, Code: "unsupported_parameter", Message: "unsupported parameters for this upstream: " + strings.Join(bad, ", ")}
 		}
-		writeError(w, http.StatusBadGateway, "server_error", "routing_failed", "routing failed: "+err.Error())
-		return
-	}
 
-	if up.ProviderType != "openai" {
-		if up.ProviderType == "anthropic" {
+		switch up.ProviderType {
+		case "openai":
+			targetBody := body
+			if strings.TrimSpace(up.Model) != "" && up.Model != req.Model {
+				b, err := rawjson.Set(body, "model", up.Model)
+				if err != nil {
+					return pipeline.Call{}, err
+				}
+				targetBody = b
+			}
 			if req.Stream {
-				writeError(w, http.StatusNotImplemented, "server_error", "not_implemented", "responses streaming conversion not implemented yet")
-				return
+				targetBody = ensureOpenAIStreamIncludeUsage(targetBody)
 			}
+			return nativeOpenAICall(pipeline.OpenAIResponses(up, targetBody)), nil
 
+		case "anthropic":
+			if req.Stream {
+				return pipeline.Call{}, &pipeline.Error{Status: http.StatusNotImplemented, Code: "not_implemented", Message: "responses streaming conversion not implemented yet"}
+			}
 			msgs, err := responsesInputToChatMessages(req.Input, req.Instructions)
 			if err != nil {
-				writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid_input", err.Error())
-				return
+				return pipeline.Call{}, &pipeline.Error{Status: http.StatusBadRequest, Code: "invalid_input", Message: err.Error()}
 			}
-
 			chatReq := openaiproto.ChatCompletionsRequest{
 				Model:       req.Model,
 				Messages:    msgs,
@@ -542,142 +380,56 @@ func (h *Handler) responses(w http.ResponseWriter, r *http.Request) {
 				Stream:      false,
 				Tools:       req.Tools,
 				ToolChoice:  req.ToolChoice,
-			}
+				User:        req.User,
 
+				ResponseFormat: convert.ResponsesTextFormatToResponseFormat(req.Text),
+			}
 			areq, err := convert.OpenAIToAnthropicMessageRequest(chatReq)
 			if err != nil {
-				writeError(w, http.StatusBadRequest, "invalid_request_error", "unsupported_request", err.Error())
-				return
+				return pipeline.Call{}, &pipeline.Error{Status: http.StatusBadRequest, Code: "unsupported_request", Message: err.Error()}
 			}
 			areq.Model = up.Model
 			areq.Stream = false
-
-			start := time.Now()
-			timeout := up.Timeout
-			if timeout <= 0 {
-				timeout = 10 * time.Minute
-			}
-			uctx, cancel := context.WithTimeout(ctx, timeout)
-			resp, err := anthropicProvider.DoMessages(uctx, anthropicProvider.Upstream{
-				BaseURL: up.BaseURL,
-				APIKey:  string(up.APIKey),
-				Headers: up.Headers,
-				APIVer:  "2023-06-01",
-				Timeout: timeout,
-			}, mustJSON(areq))
-			if err != nil {
-				cancel()
-				h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-				publish(up, 0, time.Since(start), "upstream_failed", 0, 0, 0, 0, 0)
-				writeError(w, http.StatusBadGateway, "server_error", "upstream_failed", "upstream request failed")
-				return
-			}
-			status := resp.StatusCode
-			raw, _ := io.ReadAll(resp.Body)
-			_ = resp.Body.Close()
-			cancel()
-			ok := status < 500 && status != http.StatusTooManyRequests
-			if status < 200 || status >= 300 {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				publish(up, status, time.Since(start), "upstream_error", 0, 0, len(raw), 0, 0)
-				writeError(w, mapStatusToOpenAI(status), mapTypeToOpenAI(status), mapCodeToOpenAI(status), "upstream error")
-				return
-			}
-
-			var aresp convert.AnthropicMessageResponse
-			if err := json.Unmarshal(raw, &aresp); err != nil {
-				h.rtr.EndRequest(up.CredentialID, false, status, time.Since(start))
-				publish(up, status, time.Since(start), "bad_upstream", 0, 0, len(raw), 0, 0)
-				writeError(w, http.StatusBadGateway, "server_error", "bad_upstream", "invalid upstream response")
-				return
-			}
-
-			oresp := convert.AnthropicResponseToOpenAIResponses(aresp, req.Model)
-			outRaw, _ := json.Marshal(oresp)
-			w.Header().Set("Content-Type", "application/json; charset=utf-8")
-			w.WriteHeader(http.StatusOK)
-			_, _ = w.Write(outRaw)
-			h.rtr.EndRequest(up.CredentialID, ok, status, time.Since(start))
-			inTok, outTok := extractAnthropicUsage(raw)
-			h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeOpenAI), origModel, up.CredentialID, ok, status)
-			dur := time.Since(start)
-			var tps float64
-			if outTok > 0 && dur.Seconds() > 0 {
-				tps = float64(outTok) / dur.Seconds()
+			call := pipeline.AnthropicMessages(up, "2023-06-01", mustJSON(areq))
+			call.Decode = func(_ context.Context, raw []byte) ([]byte, pipeline.Usage, error) {
+				var aresp convert.AnthropicMessageResponse
+				if err := json.Unmarshal(raw, &aresp); err != nil {
+					return nil, pipeline.Usage{}, err
+				}
+				out, err := json.Marshal(convert.AnthropicResponseToOpenAIResponses(aresp, origModel, convert.IsStructuredOutput(chatReq.ResponseFormat)))
+				return out, pipeline.ExtractAnthropicUsage(raw), err
 			}
-			publish(up, status, dur, "", inTok, outTok, len(outRaw), dur.Milliseconds(), tps)
-			return
-		}
+			return call, nil
 
-		writeError(w, http.StatusNotImplemented, "server_error", "not_implemented", "provider conversion not implemented yet for responses")
-		return
-	}
-
-	start := time.Now()
-	targetBody := body
-	if strings.TrimSpace(up.Model) != "" && up.Model != req.Model {
-		req.Model = up.Model
-		b, err := json.Marshal(req)
-		if err != nil {
-			h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-			publish(up, 0, time.Since(start), "encode_failed", 0, 0, 0, 0, 0)
-			writeError(w, http.StatusInternalServerError, "server_error", "encode_failed", "failed to build upstream request")
-			return
+		default:
+			return pipeline.Call{}, &pipeline.Error{Status: http.StatusNotImplemented, Code: "not_implemented", Message: "provider conversion not implemented yet for responses"}
 		}
-		targetBody = b
-	}
-	if req.Stream {
-		targetBody = ensureOpenAIStreamIncludeUsage(targetBody)
 	}
 
-	timeout := up.Timeout
-	if timeout <= 0 {
-		timeout = 10 * time.Minute
+	prepare := func(up router.RoutedUpstream) (pipeline.Call, error) {
+		return buildCall(body, req, up)
 	}
-	uctx, cancel := context.WithTimeout(ctx, timeout)
-	defer cancel()
-
-	resp, err := openai.DoResponses(uctx, openai.Upstream{
-		BaseURL: up.BaseURL,
-		APIKey:  string(up.APIKey),
-		Headers: up.Headers,
-	}, targetBody)
-	if err != nil {
-		h.rtr.EndRequest(up.CredentialID, false, 0, time.Since(start))
-		h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeOpenAI), origModel, up.CredentialID, false, 0)
-		publish(up, 0, time.Since(start), "upstream_failed", 0, 0, 0, 0, 0)
-		writeError(w, http.StatusBadGateway, "server_error", "upstream_failed", "upstream request failed")
-		return
+	preq.FromBody = func(body []byte) (pipeline.Prepare, error) {
+		var req responsesCreateRequest
+		if err := json.Unmarshal(body, &req); err != nil {
+			return nil, err
+		}
+		return func(up router.RoutedUpstream) (pipeline.Call, error) {
+			return buildCall(body, req, up)
+		}, nil
 	}
-	defer resp.Body.Close()
 
-	copyHeader(w.Header(), resp.Header)
-	w.WriteHeader(resp.StatusCode)
+	h.exec.Execute(ctx, w, preq, prepare, writeGatewayError)
+}
 
-	if req.Stream {
-		var respBytes int
-		var inTok, outTok int64
-		var ttft int64
-		var tps float64
-		respBytes, inTok, outTok, ttft, tps, err = copyOpenAISSEWithUsage(w, resp.Body, start)
-		okFinal := err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests
-		h.rtr.EndRequest(up.CredentialID, okFinal, resp.StatusCode, time.Since(start))
-		h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeOpenAI), origModel, up.CredentialID, okFinal, resp.StatusCode)
-		publish(up, resp.StatusCode, time.Since(start), errString(err), inTok, outTok, respBytes, ttft, tps)
-		return
-	}
-	raw, _ := io.ReadAll(resp.Body)
-	_, _ = w.Write(raw)
-	okFinal := resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests
-	h.rtr.EndRequest(up.CredentialID, okFinal, resp.StatusCode, time.Since(start))
-	inTok, outTok := extractOpenAIUsage(raw)
-	h.rtr.RecordRouteResult(up.PoolID, string(canonical.FacadeOpenAI), origModel, up.CredentialID, okFinal, resp.StatusCode)
-	dur := time.Since(start)
-	var tps float64
-	if outTok > 0 && dur.Seconds() > 0 {
-		tps = float64(outTok) / dur.Seconds()
+// nativeOpenAICall relays an OpenAI response unchanged.
+func nativeOpenAICall(call pipeline.Call) pipeline.Call {
+	call.Native = true
+	call.Stream = copyOpenAISSEWithUsage
+	call.Decode = func(_ context.Context, raw []byte) ([]byte, pipeline.Usage, error) {
+		return raw, pipeline.ExtractOpenAIUsage(raw), nil
 	}
-	publish(up, resp.StatusCode, dur, "", inTok, outTok, len(raw), dur.Milliseconds(), tps)
+	return call
 }
 
 func mustJSON(v any) []byte {
@@ -887,65 +639,6 @@ func (h *Handler) listModels(w http.ResponseWriter, r *http.Request) {
 	_ = json.NewEncoder(w).Encode(res)
 }
 
-func copyHeader(dst, src http.Header) {
-	for k, vs := range src {
-		for _, v := range vs {
-			dst.Add(k, v)
-		}
-	}
-}
-
-func errString(err error) string {
-	if err == nil {
-		return ""
-	}
-	return err.Error()
-}
-
-func mapStatusToOpenAI(upstreamStatus int) int {
-	if upstreamStatus == http.StatusTooManyRequests {
-		return http.StatusTooManyRequests
-	}
-	if upstreamStatus == http.StatusUnauthorized || upstreamStatus == http.StatusForbidden {
-		return http.StatusUnauthorized
-	}
-	if upstreamStatus >= 400 && upstreamStatus < 500 {
-		return http.StatusBadRequest
-	}
-	if upstreamStatus >= 500 {
-		return http.StatusBadGateway
-	}
-	return http.StatusBadGateway
-}
-
-func mapTypeToOpenAI(upstreamStatus int) string {
-	switch upstreamStatus {
-	case http.StatusUnauthorized, http.StatusForbidden:
-		return "authentication_error"
-	case http.StatusTooManyRequests:
-		return "rate_limit_error"
-	default:
-		if upstreamStatus >= 400 && upstreamStatus < 500 {
-			return "invalid_request_error"
-		}
-		return "server_error"
-	}
-}
-
-func mapCodeToOpenAI(upstreamStatus int) string {
-	switch upstreamStatus {
-	case http.StatusTooManyRequests:
-		return "rate_limit"
-	case http.StatusUnauthorized, http.StatusForbidden:
-		return "unauthorized"
-	default:
-		if upstreamStatus >= 500 {
-			return "upstream_error"
-		}
-		return "bad_request"
-	}
-}
-
 func clientIP(r *http.Request) string {
 	xff := strings.TrimSpace(r.Header.Get("X-Forwarded-For"))
 	if xff != "" {
@@ -972,162 +665,117 @@ func isTestRequest(r *http.Request) bool {
 	return v == "1" || v == "true" || v == "yes"
 }
 
-func parseInt64(v any) int64 {
-	switch t := v.(type) {
-	case float64:
-		return int64(t)
-	case int:
-		return int64(t)
-	case int64:
-		return t
-	case json.Number:
-		i, _ := t.Int64()
-		return i
-	case string:
-		n := strings.TrimSpace(t)
-		if n == "" {
-			return 0
-		}
-		i, _ := json.Number(n).Int64()
-		return i
-	default:
-		return 0
-	}
-}
-
-func extractOpenAIUsage(raw []byte) (int64, int64) {
-	var root map[string]any
-	if err := json.Unmarshal(raw, &root); err != nil {
-		return 0, 0
-	}
-	u, _ := root["usage"].(map[string]any)
-	if u == nil {
-		return 0, 0
-	}
-	in := parseInt64(u["prompt_tokens"])
-	if in == 0 {
-		in = parseInt64(u["input_tokens"])
-	}
-	out := parseInt64(u["completion_tokens"])
-	if out == 0 {
-		out = parseInt64(u["output_tokens"])
-	}
-	return in, out
-}
-
-func extractAnthropicUsage(raw []byte) (int64, int64) {
-	var root map[string]any
-	if err := json.Unmarshal(raw, &root); err != nil {
-		return 0, 0
-	}
-	u, _ := root["usage"].(map[string]any)
-	if u == nil {
-		return 0, 0
-	}
-	in := parseInt64(u["input_tokens"])
-	out := parseInt64(u["output_tokens"])
-	return in, out
-}
-
 func ensureOpenAIStreamIncludeUsage(body []byte) []byte {
-	var root map[string]any
-	if err := json.Unmarshal(body, &root); err != nil {
-		return body
-	}
-	so, _ := root["stream_options"].(map[string]any)
-	if so == nil {
-		so = map[string]any{}
-	}
-	if _, ok := so["include_usage"]; !ok {
-		so["include_usage"] = true
+	so, ok := rawjson.Get(body, "stream_options")
+	var out []byte
+	var err error
+	if !ok || string(so) == "null" {
+		out, err = rawjson.Set(body, "stream_options", json.RawMessage(`{"include_usage":true}`))
+	} else {
+		if _, ok := rawjson.Get(so, "include_usage"); ok {
+			return body
+		}
+		if so, err = rawjson.Set(so, "include_usage", true); err != nil {
+			return body
+		}
+		out, err = rawjson.Set(body, "stream_options", so)
 	}
-	root["stream_options"] = so
-	out, err := json.Marshal(root)
 	if err != nil {
 		return body
 	}
 	return out
 }
 
-func copyOpenAISSEWithUsage(w http.ResponseWriter, r io.Reader, startTime time.Time) (int, int64, int64, int64, float64, error) {
+func copyOpenAISSEWithUsage(w http.ResponseWriter, r io.Reader, startTime time.Time) (pipeline.Stats, error) {
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		n, err := io.Copy(w, r)
-		return int(n), 0, 0, 0, 0, err
+		return pipeline.Stats{ResponseBytes: int(n)}, err
 	}
 
 	br := bufio.NewReader(r)
 	var (
-		respBytes  int
-		inTok      int64
-		outTok     int64
-		ttft       int64
-		tps        float64
-		chunkCount int
+		st         pipeline.Stats
 		firstToken time.Time
 		lastToken  time.Time
+		finished   bool
 	)
 	for {
 		block, err := readSSEBlock(br)
 		if block != "" {
-			now := time.Now()
-			if ttft == 0 {
-				ttft = now.Sub(startTime).Milliseconds()
-				firstToken = now
+			var ev map[string]any
+			_ = json.Unmarshal([]byte(extractSSEData(block)), &ev)
+			if se := streamconv.OpenAIError(ev); se != nil {
+				st.ResponseBytes += streamconv.WriteOpenAIError(w, se)
+				return st, se
+			}
+			if openAIStreamFinished(ev) {
+				finished = true
+			}
+
+			// Comment-only blocks are keepalives, not tokens.
+			if extractSSEData(block) != "" {
+				now := time.Now()
+				if st.TTFT == 0 {
+					st.TTFT = now.Sub(startTime).Milliseconds()
+					firstToken = now
+				}
+				lastToken = now
 			}
-			lastToken = now
-			chunkCount++
 
 			b := []byte(block)
 			n, werr := w.Write(b)
-			respBytes += n
+			st.ResponseBytes += n
 			if werr != nil {
-				return respBytes, inTok, outTok, ttft, tps, werr
+				return st, werr
 			}
 			n2, werr2 := w.Write([]byte("\n"))
-			respBytes += n2
+			st.ResponseBytes += n2
 			if werr2 != nil {
-				return respBytes, inTok, outTok, ttft, tps, werr2
+				return st, werr2
 			}
 			flusher.Flush()
 
 			data := extractSSEData(block)
 			if data == "[DONE]" {
-				if chunkCount > 1 && !lastToken.IsZero() && !firstToken.IsZero() {
-					dur := lastToken.Sub(firstToken).Seconds()
-					if dur > 0 {
-						tps = float64(chunkCount-1) / dur
-					}
-				}
-				return respBytes, inTok, outTok, ttft, tps, nil
+				st.TPS = streamconv.TokensPerSecond(int(st.Usage.Output), firstToken, lastToken)
+				return st, nil
 			}
-			var chunk map[string]any
-			if err := json.Unmarshal([]byte(data), &chunk); err == nil {
-				if u, _ := chunk["usage"].(map[string]any); u != nil {
-					inTok = parseInt64(u["prompt_tokens"])
-					if inTok == 0 {
-						inTok = parseInt64(u["input_tokens"])
-					}
-					outTok = parseInt64(u["completion_tokens"])
-					if outTok == 0 {
-						outTok = parseInt64(u["output_tokens"])
-					}
-				}
+			if u, _ := ev["usage"].(map[string]any); u != nil {
+				st.Usage = pipeline.OpenAIUsage(u)
 			}
 		}
 		if err != nil {
+			if err == io.EOF && finished {
+				st.TPS = streamconv.TokensPerSecond(int(st.Usage.Output), firstToken, lastToken)
+				return st, nil
+			}
 			if err == io.EOF {
-				if chunkCount > 1 && !lastToken.IsZero() && !firstToken.IsZero() {
-					dur := lastToken.Sub(firstToken).Seconds()
-					if dur > 0 {
-						tps = float64(chunkCount-1) / dur
-					}
-				}
-				return respBytes, inTok, outTok, ttft, tps, nil
+				err = streamconv.ErrStreamInterrupted
 			}
-			return respBytes, inTok, outTok, ttft, tps, err
+			se := streamconv.AsStreamError(err)
+			st.ResponseBytes += streamconv.WriteOpenAIError(w, se)
+			return st, se
+		}
+	}
+}
+
+// openAIStreamFinished reports whether ev ends a response: a Chat
+// Completions chunk with a finish_reason, or a Responses API terminal
+// event. Streams cut before one, and before [DONE], were interrupted.
+func openAIStreamFinished(ev map[string]any) bool {
+	switch ev["type"] {
+	case "response.completed", "response.incomplete", "response.failed":
+		return true
+	}
+	choices, _ := ev["choices"].([]any)
+	for _, c := range choices {
+		choice, _ := c.(map[string]any)
+		if fr, _ := choice["finish_reason"].(string); fr != "" {
+			return true
 		}
 	}
+	return false
 }
 
 func readSSEBlock(r *bufio.Reader) (string, error) {
@@ -0,0 +1,28 @@
+package openai
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCopyOpenAISSEReportsCutStream(t *testing.T) {
+	cut := `data: {"choices":[{"index":0,"delta":{"content":"Hel"}}]}` + "\n\n"
+	rr := httptest.NewRecorder()
+	if _, err := copyOpenAISSEWithUsage(rr, strings.NewReader(cut), time.Now()); err == nil {
+		t.Fatal("stream without [DONE] or finish_reason reported as complete")
+	}
+	if !strings.Contains(rr.Body.String(), `"error"`) {
+		t.Fatalf("no terminal error chunk: %s", rr.Body.String())
+	}
+
+	finished := cut + `data: {"choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\n"
+	if _, err := copyOpenAISSEWithUsage(httptest.NewRecorder(), strings.NewReader(finished), time.Now()); err != nil {
+		t.Fatalf("stream with a finish_reason: %v", err)
+	}
+	responses := "event: response.completed\ndata: {\"type\":\"response.completed\",\"response\":{}}\n\n"
+	if _, err := copyOpenAISSEWithUsage(httptest.NewRecorder(), strings.NewReader(responses), time.Now()); err != nil {
+		t.Fatalf("completed Responses stream: %v", err)
+	}
+}
@@ -0,0 +1,111 @@
+package openai
+
+import (
+	"encoding/json"
+
+	"claude-gateway/src/internal/convert"
+	"claude-gateway/src/internal/rawjson"
+	"claude-gateway/src/internal/router"
+)
+
+// requirementsOf inspects a Chat Completions or Responses body for the
+// features the upstream model has to support.
+func requirementsOf(body []byte) router.Requirements {
+	var root map[string]any
+	if err := json.Unmarshal(body, &root); err != nil {
+		return router.Requirements{}
+	}
+	var need router.Requirements
+	if tools, _ := root["tools"].([]any); len(tools) > 0 {
+		need.Tools = true
+	}
+	if fns, _ := root["functions"].([]any); len(fns) > 0 {
+		need.Tools = true
+	}
+	if effort, _ := root["reasoning_effort"].(string); effort != "" {
+		need.Thinking = true
+	}
+	if rs, _ := root["reasoning"].(map[string]any); rs != nil {
+		need.Thinking = true
+	}
+	if rf, _ := root["response_format"].(map[string]any); rf != nil && isJSONFormat(rf) {
+		need.JSONMode = true
+	}
+	if text, _ := root["text"].(map[string]any); text != nil {
+		if f, _ := text["format"].(map[string]any); f != nil && isJSONFormat(f) {
+			need.JSONMode = true
+		}
+	}
+	chars := 0
+	for _, k := range []string{"messages", "input", "instructions", "tools", "functions"} {
+		chars += countText(root[k], &need)
+	}
+	need.PromptTokens = chars / 4
+	return need
+}
+
+func isJSONFormat(f map[string]any) bool {
+	t, _ := f["type"].(string)
+	return t == "json_object" || t == "json_schema"
+}
+
+// countText sums the length of the text in v, skipping inline image data,
+// and flags image content.
+func countText(v any, need *router.Requirements) int {
+	switch x := v.(type) {
+	case string:
+		return len(x)
+	case []any:
+		n := 0
+		for _, it := range x {
+			n += countText(it, need)
+		}
+		return n
+	case map[string]any:
+		if t, _ := x["type"].(string); t == "image_url" || t == "input_image" {
+			need.Vision = true
+			return 0
+		}
+		n := 0
+		for _, it := range x {
+			n += countText(it, need)
+		}
+		return n
+	}
+	return 0
+}
+
+// messageTokens estimates a conversation entry the way requirementsOf
+// estimates the prompt.
+func messageTokens(raw json.RawMessage) int {
+	var v any
+	_ = json.Unmarshal(raw, &v)
+	return countText(v, &router.Requirements{}) / 4
+}
+
+// intValue reads an optional request parameter, 0 when absent.
+func intValue(p *int) int {
+	if p == nil {
+		return 0
+	}
+	return *p
+}
+
+// setJSONField replaces one top-level field of a JSON object, leaving the
+// body unchanged if it cannot be decoded.
+func setJSONField(body []byte, key string, value any) []byte {
+	out, err := rawjson.Set(body, key, value)
+	if err != nil {
+		return body
+	}
+	return out
+}
+
+// rejectedParams lists the request parameters up cannot honour when its pool
+// rejects rather than drops them.
+func rejectedParams(up router.RoutedUpstream, d convert.Dialect, body []byte) []string {
+	if up.ParamPolicy != router.ParamPolicyReject {
+		return nil
+	}
+	return convert.UnsupportedParams(d, body, up.ProviderType)
+}
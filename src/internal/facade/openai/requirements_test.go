@@ -0,0 +1,30 @@
+package openai
+
+import "testing"
+
+func TestRequirementsOf(t *testing.T) {
+	body := []byte(`{"model":"gpt-4o","messages":[{"role":"user","content":[{"type":"text","text":"describe this"},{"type":"image_url","image_url":{"url":"data:image/png;base64,AAAA"}}]}],"tools":[{"type":"function","function":{"name":"f"}}],"response_format":{"type":"json_object"}}`)
+	need := requirementsOf(body)
+	if !need.Vision || !need.Tools || !need.JSONMode || need.Thinking {
+		t.Fatalf("unexpected requirements: %#v", need)
+	}
+	if need.PromptTokens <= 0 || need.PromptTokens > 20 {
+		t.Fatalf("expected a small prompt estimate ignoring image data, got %d", need.PromptTokens)
+	}
+}
+
+func TestPassthroughPatchKeepsUnknownFields(t *testing.T) {
+	body := []byte(`{"model":"gpt-4o","messages":[{"role":"user","content":"hi"}],"seed":12345678901234567890,` +
+		`"response_format":{"type":"json_object"},"parallel_tool_calls":false,"service_tier":"flex",` +
+		`"stream":true,"stream_options":{"include_obfuscation":false}}`)
+
+	out := setJSONField(body, "model", "gpt-4.1")
+	out = ensureOpenAIStreamIncludeUsage(out)
+
+	want := `{"model":"gpt-4.1","messages":[{"role":"user","content":"hi"}],"seed":12345678901234567890,` +
+		`"response_format":{"type":"json_object"},"parallel_tool_calls":false,"service_tier":"flex",` +
+		`"stream":true,"stream_options":{"include_obfuscation":false,"include_usage":true}}`
+	if string(out) != want {
+		t.Fatalf("got  %s\nwant %s", out, want)
+	}
+}
@@ -0,0 +1,263 @@
+// Package idempotency lets clients retry non-streaming requests safely by
+// sending an Idempotency-Key header: the first response under a key is kept
+// for a window and replayed to retries instead of calling upstream again.
+package idempotency
+
+import (
+	"bytes"
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"claude-gateway/src/internal/canonical"
+)
+
+// Header is the request header carrying the client's key.
+const Header = "Idempotency-Key"
+
+const (
+	DefaultWindow        = 24 * time.Hour
+	DefaultMaxBytes      = 64 << 20
+	DefaultMaxEntryBytes = 4 << 20
+
+	// maxKeyLen bounds the header value kept per entry.
+	maxKeyLen = 255
+	// maxBodyBytes matches the limit the facades put on request bodies.
+	maxBodyBytes = 20 << 20
+)
+
+// ErrorWriter writes an error in the client's protocol; facades pass their
+// gateway error writer.
+type ErrorWriter func(w http.ResponseWriter, status int, code, msg string)
+
+// Store keeps responses in process, evicting the oldest finished entries
+// beyond maxBytes. Keys are scoped to the client key and route, so clients
+// cannot see each other's responses.
+type Store struct {
+	window   time.Duration
+	maxBytes int
+	maxEntry int
+
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type entry struct {
+	key       string
+	sum       [sha256.Size]byte
+	done      chan struct{}
+	resp      *response
+	expiresAt time.Time
+	// size is what the entry counts against maxBytes once kept.
+	size int
+}
+
+// response is what a duplicate gets back. It is set before done is closed
+// and never changed afterwards.
+type response struct {
+	status int
+	header http.Header
+	body   []byte
+	// tooLarge marks a response that was not copied.
+	tooLarge bool
+}
+
+func New(window time.Duration, maxBytes, maxEntryBytes int) *Store {
+	if window <= 0 {
+		window = DefaultWindow
+	}
+	if maxBytes <= 0 {
+		maxBytes = DefaultMaxBytes
+	}
+	if maxEntryBytes <= 0 || maxEntryBytes > maxBytes {
+		maxEntryBytes = maxBytes
+	}
+	return &Store{window: window, maxBytes: maxBytes, maxEntry: maxEntryBytes, order: list.New(), items: map[string]*list.Element{}}
+}
+
+// Wrap makes next idempotent for requests that carry a key. Streaming
+// requests are passed through: a stream cannot be replayed once written.
+func (s *Store) Wrap(next http.HandlerFunc, writeErr ErrorWriter) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := strings.TrimSpace(r.Header.Get(Header))
+		if key == "" {
+			next(w, r)
+			return
+		}
+		if len(key) > maxKeyLen {
+			writeErr(w, http.StatusBadRequest, "invalid_idempotency_key", "Idempotency-Key must be at most 255 characters")
+			return
+		}
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxBodyBytes))
+		if err != nil {
+			writeErr(w, http.StatusBadRequest, "invalid_request", "failed to read request body")
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		if isStream(body) {
+			next(w, r)
+			return
+		}
+
+		clientKey, _ := r.Context().Value(canonical.ContextKeyClientKey).(string)
+		scoped := clientKey + "\x00" + r.URL.Path + "\x00" + key
+		e, leader := s.begin(scoped, sha256.Sum256(body))
+		if e == nil {
+			writeErr(w, http.StatusUnprocessableEntity, "idempotency_key_reused",
+				"Idempotency-Key was already used with a different request body")
+			return
+		}
+		if !leader {
+			select {
+			case <-e.done:
+				if e.resp.tooLarge {
+					writeErr(w, http.StatusConflict, "idempotency_response_unavailable",
+						"the original request with this Idempotency-Key finished, but its response was too large to keep")
+					return
+				}
+				e.resp.write(w)
+			case <-r.Context().Done():
+			}
+			return
+		}
+
+		// The original runs to completion even if its client goes away,
+		// so the retry that follows finds the response instead of paying
+		// for a second upstream call.
+		rec := &recorder{ResponseWriter: w, max: s.maxEntry}
+		defer func() {
+			s.finish(e, rec)
+		}()
+		next(rec, r.WithContext(context.WithoutCancel(r.Context())))
+	}
+}
+
+// begin returns the entry for key and whether the caller is the original
+// request. It returns nil when key was used with a different body.
+func (s *Store) begin(key string, sum [sha256.Size]byte) (*entry, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if el, ok := s.items[key]; ok {
+		e := el.Value.(*entry)
+		if e.resp == nil || time.Now().Before(e.expiresAt) {
+			if e.sum != sum {
+				return nil, false
+			}
+			return e, false
+		}
+		s.remove(el)
+	}
+	e := &entry{key: key, sum: sum, done: make(chan struct{})}
+	s.items[key] = s.order.PushFront(e)
+	return e, true
+}
+
+// finish hands the original's response to waiting duplicates and keeps it
+// for the window. Failures the client may retry, and responses too large
+// to keep, are forgotten so the next attempt runs again.
+func (s *Store) finish(e *entry, rec *recorder) {
+	status := rec.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	resp := &response{status: status, header: rec.Header().Clone(), body: rec.buf.Bytes(), tooLarge: rec.over}
+	keep := !rec.over && status < http.StatusInternalServerError && status != http.StatusTooManyRequests
+
+	s.mu.Lock()
+	e.resp = resp
+	e.expiresAt = time.Now().Add(s.window)
+	close(e.done)
+	if el, ok := s.items[e.key]; ok && el.Value == e {
+		if keep {
+			e.size = len(resp.body)
+			s.size += e.size
+			s.evict()
+		} else {
+			s.remove(el)
+		}
+	}
+	s.mu.Unlock()
+}
+
+// evict drops expired entries and then the oldest finished ones until the
+// store fits maxBytes. The caller must hold s.mu.
+func (s *Store) evict() {
+	now := time.Now()
+	for el := s.order.Back(); el != nil; {
+		prev := el.Prev()
+		if e := el.Value.(*entry); e.resp != nil && (s.size > s.maxBytes || now.After(e.expiresAt)) {
+			s.remove(el)
+		}
+		el = prev
+	}
+}
+
+// remove drops an entry. The caller must hold s.mu.
+func (s *Store) remove(el *list.Element) {
+	e := s.order.Remove(el).(*entry)
+	delete(s.items, e.key)
+	s.size -= e.size
+}
+
+func (r *response) write(w http.ResponseWriter) {
+	for k, v := range r.header {
+		w.Header()[k] = append([]string(nil), v...)
+	}
+	w.Header().Set("Idempotent-Replayed", "true")
+	w.WriteHeader(r.status)
+	_, _ = w.Write(r.body)
+}
+
+func isStream(body []byte) bool {
+	var req struct {
+		Stream bool `json:"stream"`
+	}
+	_ = json.Unmarshal(body, &req)
+	return req.Stream
+}
+
+// recorder passes the original's response through to its client and keeps
+// a copy of up to max bytes.
+type recorder struct {
+	http.ResponseWriter
+	status int
+	buf    bytes.Buffer
+	max    int
+	over   bool
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.over {
+		if r.buf.Len()+len(b) > r.max {
+			r.over = true
+			r.buf = bytes.Buffer{}
+		} else {
+			r.buf.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *recorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
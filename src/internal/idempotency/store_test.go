@@ -0,0 +1,127 @@
+package idempotency
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testErrorWriter(w http.ResponseWriter, status int, code, msg string) {
+	w.WriteHeader(status)
+	_, _ = w.Write([]byte(code))
+}
+
+func post(h http.HandlerFunc, key, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+	if key != "" {
+		req.Header.Set(Header, key)
+	}
+	rec := httptest.NewRecorder()
+	h(rec, req)
+	return rec
+}
+
+func TestReplaysStoredResponse(t *testing.T) {
+	var calls int32
+	h := New(time.Hour, 0, 0).Wrap(func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&calls, 1)
+		w.Header().Set("X-Request-Id", "req-1")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte{'0' + byte(n)})
+	}, testErrorWriter)
+
+	first := post(h, "k1", `{"model":"m"}`)
+	second := post(h, "k1", `{"model":"m"}`)
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	if second.Body.String() != first.Body.String() || second.Header().Get("X-Request-Id") != "req-1" {
+		t.Fatalf("replay = %q %v, want %q", second.Body.String(), second.Header(), first.Body.String())
+	}
+	if second.Header().Get("Idempotent-Replayed") != "true" {
+		t.Fatalf("replay not marked")
+	}
+
+	post(h, "", `{"model":"m"}`)
+	post(h, "k2", `{"model":"m"}`)
+	if calls != 3 {
+		t.Fatalf("handler ran %d times, want 3", calls)
+	}
+}
+
+func TestRejectsKeyReuseWithDifferentBody(t *testing.T) {
+	h := New(time.Hour, 0, 0).Wrap(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok"))
+	}, testErrorWriter)
+
+	post(h, "k", `{"model":"a"}`)
+	rec := post(h, "k", `{"model":"b"}`)
+	if rec.Code != http.StatusUnprocessableEntity || rec.Body.String() != "idempotency_key_reused" {
+		t.Fatalf("got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestConcurrentDuplicatesWaitForOriginal(t *testing.T) {
+	var calls int32
+	release := make(chan struct{})
+	h := New(time.Hour, 0, 0).Wrap(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	}, testErrorWriter)
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = post(h, "k", `{"model":"m"}`).Body.String()
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if calls != 1 {
+		t.Fatalf("handler ran %d times, want 1", calls)
+	}
+	for i, b := range bodies {
+		if b != "done" {
+			t.Fatalf("response %d = %q", i, b)
+		}
+	}
+}
+
+func TestRetryableFailuresAreNotKept(t *testing.T) {
+	var calls int32
+	h := New(time.Hour, 0, 0).Wrap(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		_, _ = w.Write([]byte("ok"))
+	}, testErrorWriter)
+
+	post(h, "k", `{"model":"m"}`)
+	if rec := post(h, "k", `{"model":"m"}`); rec.Code != http.StatusOK || calls != 2 {
+		t.Fatalf("retry got %d after %d calls", rec.Code, calls)
+	}
+}
+
+func TestStreamingRequestsPassThrough(t *testing.T) {
+	var calls int32
+	h := New(time.Hour, 0, 0).Wrap(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+	}, testErrorWriter)
+
+	post(h, "k", `{"model":"m","stream":true}`)
+	post(h, "k", `{"model":"m","stream":true}`)
+	if calls != 2 {
+		t.Fatalf("handler ran %d times, want 2", calls)
+	}
+}
@@ -30,11 +30,23 @@ type Event struct {
 	ResponseBytes int       `json:"response_bytes,omitempty"`
 	InputTokens   int64     `json:"input_tokens,omitempty"`
 	OutputTokens  int64     `json:"output_tokens,omitempty"`
-	Status        int       `json:"status"`
-	LatencyMs     int64     `json:"latency_ms"`
-	TTFTMs        int64     `json:"ttft_ms,omitempty"`
-	TPS           float64   `json:"tps,omitempty"`
-	Error         string    `json:"error,omitempty"`
+	// Cache token counts are subsets of InputTokens, which always counts the
+	// whole prompt whether or not it was served from the prompt cache.
+	CacheCreationTokens int64 `json:"cache_creation_tokens,omitempty"`
+	CacheReadTokens     int64 `json:"cache_read_tokens,omitempty"`
+	// CacheHit marks a response served from the response cache; no
+	// upstream was called and the token counts are zero.
+	CacheHit  bool    `json:"cache_hit,omitempty"`
+	Status    int     `json:"status"`
+	LatencyMs int64   `json:"latency_ms"`
+	TTFTMs    int64   `json:"ttft_ms,omitempty"`
+	TPS       float64 `json:"tps,omitempty"`
+	Error     string  `json:"error,omitempty"`
+
+	// Kind is empty for request logs. Other kinds (e.g. "model_diff") are
+	// only streamed to subscribers as named SSE events and never persisted.
+	Kind   string          `json:"kind,omitempty"`
+	Detail json.RawMessage `json:"detail,omitempty"`
 }
 
 type Bus struct {
@@ -59,6 +71,18 @@ func New(db *sql.DB, ringCap int) *Bus {
 }
 
 func (b *Bus) Publish(ev Event) {
+	if ev.Kind != "" {
+		b.mu.RLock()
+		for ch := range b.subs {
+			select {
+			case ch <- ev:
+			default:
+			}
+		}
+		b.mu.RUnlock()
+		return
+	}
+
 	b.mu.Lock()
 	if len(b.ring) < b.ringCap {
 		b.ring = append(b.ring, ev)
@@ -80,9 +104,9 @@ func (b *Bus) Publish(ev Event) {
 			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 			defer cancel()
 			_, err := b.db.ExecContext(ctx,
-				`INSERT INTO request_logs (request_id, pool_id, provider_id, credential_id, client_key, src_ip, user_agent, is_test, stream, request_bytes, response_bytes, facade, req_model, upstream_model, status, latency_ms, ttft_ms, tps, input_tokens, output_tokens, error_msg)
-				 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
-				ev.RequestID, ev.PoolID, ev.ProviderID, ev.CredentialID, ev.ClientKey, ev.SrcIP, ev.UserAgent, ev.IsTest, ev.Stream, ev.RequestBytes, ev.ResponseBytes, ev.Facade, ev.RequestModel, ev.UpstreamModel, ev.Status, ev.LatencyMs, ev.TTFTMs, ev.TPS, ev.InputTokens, ev.OutputTokens, ev.Error)
+				`INSERT INTO request_logs (request_id, pool_id, provider_id, credential_id, client_key, src_ip, user_agent, is_test, stream, request_bytes, response_bytes, facade, req_model, upstream_model, status, latency_ms, ttft_ms, tps, input_tokens, output_tokens, cache_creation_tokens, cache_read_tokens, cache_hit, error_msg)
+				 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+				ev.RequestID, ev.PoolID, ev.ProviderID, ev.CredentialID, ev.ClientKey, ev.SrcIP, ev.UserAgent, ev.IsTest, ev.Stream, ev.RequestBytes, ev.ResponseBytes, ev.Facade, ev.RequestModel, ev.UpstreamModel, ev.Status, ev.LatencyMs, ev.TTFTMs, ev.TPS, ev.InputTokens, ev.OutputTokens, ev.CacheCreationTokens, ev.CacheReadTokens, ev.CacheHit, ev.Error)
 			if err != nil {
 				log.Printf("failed to persist log: %v", err)
 			}
@@ -133,5 +157,8 @@ func (b *Bus) ServeSSE(w http.ResponseWriter, r *http.Request) {
 
 func writeSSE(w http.ResponseWriter, ev Event) {
 	b, _ := json.Marshal(ev)
+	if ev.Kind != "" {
+		_, _ = fmt.Fprintf(w, "event: %s\n", ev.Kind)
+	}
 	_, _ = fmt.Fprintf(w, "data: %s\n\n", b)
 }
@@ -14,6 +14,7 @@ type Metrics struct {
 
 	requestsTotal *prometheus.CounterVec
 	latencyMs     *prometheus.HistogramVec
+	concLimit     *prometheus.GaugeVec
 }
 
 func New() *Metrics {
@@ -29,8 +30,12 @@ func New() *Metrics {
 			Help:    "Request latency in milliseconds.",
 			Buckets: []float64{5, 10, 25, 50, 100, 250, 500, 1000, 2000, 5000, 10000, 30000},
 		}, []string{"facade", "provider", "status"}),
+		concLimit: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name: "claude_gateway_credential_concurrency_limit",
+			Help: "Effective concurrency limit per credential (adaptive credentials only).",
+		}, []string{"credential_id"}),
 	}
-	r.MustRegister(m.requestsTotal, m.latencyMs)
+	r.MustRegister(m.requestsTotal, m.latencyMs, m.concLimit)
 	return m
 }
 
@@ -43,3 +48,7 @@ func (m *Metrics) ObserveRequest(facade, provider string, status int, dur time.D
 	m.requestsTotal.WithLabelValues(facade, provider, s).Inc()
 	m.latencyMs.WithLabelValues(facade, provider, s).Observe(float64(dur.Milliseconds()))
 }
+
+func (m *Metrics) SetCredentialConcurrencyLimit(credentialID uint64, limit float64) {
+	m.concLimit.WithLabelValues(strconv.FormatUint(credentialID, 10)).Set(limit)
+}
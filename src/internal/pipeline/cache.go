@@ -0,0 +1,167 @@
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"claude-gateway/src/internal/canonical"
+	"claude-gateway/src/internal/respcache"
+	"claude-gateway/src/internal/router"
+	"claude-gateway/src/internal/streamconv"
+)
+
+// CacheSpec makes a request eligible for the pool's response cache. Entries
+// hold the canonical response, so a streamed request can be answered from
+// a non-streamed one and the other way round.
+type CacheSpec struct {
+	// Body is the client request the cache key is derived from.
+	Body []byte
+	// Refresh skips the lookup but still stores the new response, for
+	// requests sent with Cache-Control: no-cache.
+	Refresh bool
+
+	// Parse reads a non-streamed response in the client's protocol, and
+	// Decode a streamed one.
+	Parse  func(body []byte) (canonical.Response, error)
+	Decode streamconv.Decoder
+	// Render writes a cached response for a non-streamed request, and
+	// NewEncoder replays it for a streamed one.
+	Render     func(resp canonical.Response) ([]byte, error)
+	NewEncoder func(w http.ResponseWriter, flusher http.Flusher) streamconv.Encoder
+}
+
+// SetCache enables the response cache for pools that configure a TTL. It
+// must be called before serving.
+func (e *Executor) SetCache(store respcache.Store) {
+	e.cache = store
+}
+
+// cacheKey returns the key of req on up, or "" when the response is not
+// cached. Test requests and requests pinned to an upstream, such as
+// replays, neither read nor write the cache: their responses are not the
+// pool's.
+func (e *Executor) cacheKey(req Request, up router.RoutedUpstream) string {
+	if e.cache == nil || req.Cache == nil || up.ResponseCacheTTL <= 0 || up.Pinned || req.IsTest {
+		return ""
+	}
+	key, err := respcache.Key(up.PoolID, string(req.Facade), up.Model, req.Cache.Body)
+	if err != nil {
+		return ""
+	}
+	return key
+}
+
+// serveCached answers req from the cache and reports whether it did. The
+// picked credential is handed back unused.
+func (e *Executor) serveCached(ctx context.Context, w http.ResponseWriter, req Request, up router.RoutedUpstream, key string) bool {
+	raw, ok := e.cache.Get(ctx, key)
+	if !ok {
+		return false
+	}
+	var resp canonical.Response
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return false
+	}
+	var body []byte
+	if !req.Stream {
+		var err error
+		if body, err = req.Cache.Render(resp); err != nil {
+			return false
+		}
+	}
+
+	start := time.Now()
+	e.rtr.ReleaseRequest(up.CredentialID)
+	w.Header().Set("X-Cache", "HIT")
+	cw := &byteCounter{ResponseWriter: w}
+	if req.Stream {
+		w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.WriteHeader(http.StatusOK)
+		flusher, ok := w.(http.Flusher)
+		if !ok {
+			flusher = noFlush{}
+		}
+		streamconv.Replay(resp, req.Cache.NewEncoder(cw, flusher))
+	} else {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+		_, _ = cw.Write(body)
+	}
+
+	latency := time.Since(start)
+	e.m.ObserveRequest(string(req.Facade), "cache", http.StatusOK, latency)
+	// No credential was used, so none is charged in the request log.
+	up.CredentialID, up.ProviderID = 0, 0
+	e.publish(req, up, outcome{ok: true, status: http.StatusOK, latency: latency, stats: Stats{ResponseBytes: cw.n}}, true)
+	return true
+}
+
+// store caches a successful response. Streamed responses are captured as
+// written to the client and kept only when they ran to completion.
+func (e *Executor) store(ctx context.Context, req Request, up router.RoutedUpstream, key string, out []byte) {
+	var (
+		resp canonical.Response
+		err  error
+	)
+	if req.Stream {
+		resp, err = streamconv.Collect(bytes.NewReader(out), req.Cache.Decode)
+		if err == nil && resp.StopReason == "" {
+			return
+		}
+	} else {
+		resp, err = req.Cache.Parse(out)
+	}
+	if err != nil {
+		return
+	}
+	raw, err := json.Marshal(resp)
+	if err != nil {
+		return
+	}
+	e.cache.Set(ctx, key, raw, up.ResponseCacheTTL)
+}
+
+// maxCaptureBytes bounds the copy of a streamed response kept for the cache;
+// SSE framing makes it several times the size of the entry.
+const maxCaptureBytes = 4 * respcache.DefaultMaxEntryBytes
+
+// captureWriter keeps a copy of a streamed response for the cache, up to
+// max bytes.
+type captureWriter struct {
+	http.ResponseWriter
+	http.Flusher
+	buf  bytes.Buffer
+	max  int
+	over bool
+}
+
+func (c *captureWriter) Write(b []byte) (int, error) {
+	if !c.over {
+		if c.buf.Len()+len(b) > c.max {
+			c.over = true
+			c.buf = bytes.Buffer{}
+		} else {
+			c.buf.Write(b)
+		}
+	}
+	return c.ResponseWriter.Write(b)
+}
+
+type byteCounter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *byteCounter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += n
+	return n, err
+}
+
+type noFlush struct{}
+
+func (noFlush) Flush() {}
@@ -0,0 +1,30 @@
+package pipeline
+
+import (
+	"testing"
+	"time"
+
+	"claude-gateway/src/internal/respcache"
+	"claude-gateway/src/internal/router"
+)
+
+func TestCacheKeySkipsPinnedAndTestRequests(t *testing.T) {
+	e := New(router.New(nil, nil, nil), nil, nil)
+	e.SetCache(respcache.NewMemory(0, 0))
+	req := Request{Facade: "anthropic", Cache: &CacheSpec{Body: []byte(`{"model":"m"}`)}}
+	up := router.RoutedUpstream{PoolID: 1, Model: "m", ResponseCacheTTL: time.Minute}
+
+	if e.cacheKey(req, up) == "" {
+		t.Fatal("pool request not cached")
+	}
+	pinned := up
+	pinned.Pinned = true
+	if e.cacheKey(req, pinned) != "" {
+		t.Fatal("pinned request uses the pool's cache")
+	}
+	test := req
+	test.IsTest = true
+	if e.cacheKey(test, up) != "" {
+		t.Fatal("test request uses the pool's cache")
+	}
+}
@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	anthropicProvider "claude-gateway/src/internal/providers/anthropic"
+	geminiProvider "claude-gateway/src/internal/providers/gemini"
+	openaiProvider "claude-gateway/src/internal/providers/openai"
+	"claude-gateway/src/internal/router"
+	"claude-gateway/src/internal/streamconv"
+	"claude-gateway/src/internal/upstream"
+)
+
+// Call is one prepared upstream request together with how its response is
+// relayed to the client.
+type Call struct {
+	// Send performs the request; timeout is already applied to ctx.
+	Send           func(ctx context.Context, timeout time.Duration) (*http.Response, error)
+	ParseRateLimit func(h http.Header, now time.Time) upstream.RateLimit
+	ClassifyError  func(status int, body []byte) upstream.Failure
+	ParseError     func(body []byte) upstream.APIError
+	// Body is the request sent upstream, kept for body capture.
+	Body []byte
+
+	// Native calls speak the client's protocol: the upstream status and
+	// headers are relayed as-is, error responses included. Converted calls
+	// answer upstream errors with a gateway error instead.
+	Native bool
+	// Stream relays a streaming response body to w.
+	Stream func(w http.ResponseWriter, r io.Reader, start time.Time) (Stats, error)
+	// Decode turns a complete response body into the client's response. An
+	// *Error return is sent to the client as is.
+	Decode func(ctx context.Context, raw []byte) ([]byte, Usage, error)
+}
+
+// Stats describes a relayed streaming response.
+type Stats struct {
+	Usage         Usage
+	ResponseBytes int
+	// TTFT is the time to the first event in milliseconds.
+	TTFT int64
+	// TPS is output tokens per second; for streams, between the first and
+	// the last event.
+	TPS float64
+}
+
+// Converter is a streamconv conversion such as streamconv.OpenAIToAnthropic.
+type Converter func(w http.ResponseWriter, r io.Reader, model string, start time.Time) (streamconv.Summary, error)
+
+// ConvertStream relays a stream through conv, reporting model to the
+// client, and accounts it from the converter's summary.
+func ConvertStream(conv Converter, model string) func(w http.ResponseWriter, r io.Reader, start time.Time) (Stats, error) {
+	return func(w http.ResponseWriter, r io.Reader, start time.Time) (Stats, error) {
+		sum, err := conv(w, r, model, start)
+		return Stats{
+			Usage: Usage{
+				Input:         int64(sum.Usage.InputTokens),
+				Output:        int64(sum.Usage.OutputTokens),
+				CacheCreation: int64(sum.Usage.CacheCreationTokens),
+				CacheRead:     int64(sum.Usage.CacheReadTokens),
+			},
+			ResponseBytes: sum.Bytes,
+			TTFT:          sum.TTFT.Milliseconds(),
+			TPS:           sum.TPS,
+		}, err
+	}
+}
+
+// AnthropicMessages prepares a Messages API call.
+func AnthropicMessages(up router.RoutedUpstream, apiVer string, body []byte) Call {
+	return Call{
+		Send: func(ctx context.Context, timeout time.Duration) (*http.Response, error) {
+			return anthropicProvider.DoMessages(ctx, anthropicProvider.Upstream{
+				BaseURL: up.BaseURL,
+				APIKey:  string(up.APIKey),
+				Headers: up.Headers,
+				APIVer:  apiVer,
+				Timeout: timeout,
+			}, body)
+		},
+		ParseRateLimit: anthropicProvider.ParseRateLimit,
+		ClassifyError:  anthropicProvider.ClassifyError,
+		ParseError:     anthropicProvider.ParseError,
+		Body:           body,
+	}
+}
+
+// OpenAIChatCompletions prepares a Chat Completions call.
+func OpenAIChatCompletions(up router.RoutedUpstream, body []byte) Call {
+	return Call{
+		Send: func(ctx context.Context, _ time.Duration) (*http.Response, error) {
+			return openaiProvider.DoChatCompletions(ctx, openAIUpstream(up), body)
+		},
+		ParseRateLimit: openaiProvider.ParseRateLimit,
+		ClassifyError:  openaiProvider.ClassifyError,
+		ParseError:     openaiProvider.ParseError,
+		Body:           body,
+	}
+}
+
+// OpenAIResponses prepares a Responses API call.
+func OpenAIResponses(up router.RoutedUpstream, body []byte) Call {
+	return Call{
+		Send: func(ctx context.Context, _ time.Duration) (*http.Response, error) {
+			return openaiProvider.DoResponses(ctx, openAIUpstream(up), body)
+		},
+		ParseRateLimit: openaiProvider.ParseRateLimit,
+		ClassifyError:  openaiProvider.ClassifyError,
+		ParseError:     openaiProvider.ParseError,
+		Body:           body,
+	}
+}
+
+// GeminiGenerateContent prepares a generateContent call for model.
+func GeminiGenerateContent(up router.RoutedUpstream, model string, body []byte) Call {
+	return Call{
+		Send: func(ctx context.Context, _ time.Duration) (*http.Response, error) {
+			return geminiProvider.DoGenerateContent(ctx, geminiProvider.Upstream{
+				BaseURL: up.BaseURL,
+				APIKey:  string(up.APIKey),
+				Headers: up.Headers,
+			}, model, body)
+		},
+		ParseRateLimit: geminiProvider.ParseRateLimit,
+		ClassifyError:  geminiProvider.ClassifyError,
+		ParseError:     geminiProvider.ParseError,
+		Body:           body,
+	}
+}
+
+func openAIUpstream(up router.RoutedUpstream) openaiProvider.Upstream {
+	return openaiProvider.Upstream{
+		BaseURL: up.BaseURL,
+		APIKey:  string(up.APIKey),
+		Headers: up.Headers,
+	}
+}
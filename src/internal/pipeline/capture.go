@@ -0,0 +1,89 @@
+package pipeline
+
+import (
+	"io"
+	"net/http"
+
+	"claude-gateway/src/internal/capture"
+	"claude-gateway/src/internal/router"
+)
+
+// SetCapture enables body capture for pools that configure a capture rate.
+// It must be called before serving.
+func (e *Executor) SetCapture(store capture.Store) {
+	e.captures = store
+}
+
+// attemptCapture collects the bodies of one upstream attempt. A nil
+// *attemptCapture captures nothing.
+type attemptCapture struct {
+	c                capture.Capture
+	upstream, client *capture.Buffer
+}
+
+// startCapture samples an attempt for capture, returning nil when it is
+// not captured.
+func (e *Executor) startCapture(req Request, up router.RoutedUpstream, call Call, attempt int) *attemptCapture {
+	if e.captures == nil || !capture.Sample(up.CaptureRate) {
+		return nil
+	}
+	return &attemptCapture{
+		c: capture.Capture{
+			RequestID:       req.ID,
+			Endpoint:        req.Endpoint,
+			Attempt:         attempt,
+			PoolID:          up.PoolID,
+			CredentialID:    up.CredentialID,
+			ClientRequest:   capture.Clip(req.Body, capture.DefaultMaxPartBytes),
+			UpstreamRequest: capture.Clip(call.Body, capture.DefaultMaxPartBytes),
+		},
+		upstream: capture.NewBuffer(capture.DefaultMaxPartBytes),
+		client:   capture.NewBuffer(capture.DefaultMaxPartBytes),
+	}
+}
+
+// writer returns w, copying what the client is sent when capturing.
+func (a *attemptCapture) writer(w http.ResponseWriter) http.ResponseWriter {
+	if a == nil {
+		return w
+	}
+	return &teeWriter{ResponseWriter: w, buf: a.client}
+}
+
+// tee copies the upstream response body as it is read, which for streams
+// is the concatenated events.
+func (a *attemptCapture) tee(resp *http.Response) {
+	if a == nil {
+		return
+	}
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.TeeReader(resp.Body, a.upstream), resp.Body}
+}
+
+func (e *Executor) saveCapture(a *attemptCapture, upstreamStatus int) {
+	if a == nil {
+		return
+	}
+	a.c.UpstreamStatus = upstreamStatus
+	a.c.UpstreamResponse = a.upstream.Bytes()
+	a.c.ClientResponse = a.client.Bytes()
+	e.captures.Save(a.c)
+}
+
+type teeWriter struct {
+	http.ResponseWriter
+	buf *capture.Buffer
+}
+
+func (w *teeWriter) Write(b []byte) (int, error) {
+	_, _ = w.buf.Write(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *teeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
@@ -0,0 +1,480 @@
+// Package pipeline runs a decoded client request against the routed
+// upstreams. Facades decode requests and prepare provider calls; retries,
+// credential accounting, metrics and request logs live here so they behave
+// the same for every facade.
+package pipeline
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"claude-gateway/src/internal/canonical"
+	"claude-gateway/src/internal/capture"
+	"claude-gateway/src/internal/logbus"
+	"claude-gateway/src/internal/metrics"
+	"claude-gateway/src/internal/respcache"
+	"claude-gateway/src/internal/router"
+	"claude-gateway/src/internal/shadow"
+	"claude-gateway/src/internal/streamconv"
+	"claude-gateway/src/internal/upstream"
+)
+
+// Error is a failure answered with a gateway error. Code names the failure
+// (OpenAI's error code vocabulary); facades derive their error type from
+// Status.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e *Error) Error() string { return e.Message }
+
+// Request is the facade-independent description of a client request.
+type Request struct {
+	ID     string
+	Facade canonical.Facade
+	// Endpoint is the request path, such as /v1/messages.
+	Endpoint string
+	// Model is the model the client asked for.
+	Model        string
+	Stream       bool
+	RequestBytes int
+	ClientKey    string
+	Session      string
+	SrcIP        string
+	UserAgent    string
+	IsTest       bool
+	Need         router.Requirements
+	// Cache is nil for requests the response cache must not answer.
+	Cache *CacheSpec
+	// Body is the client request, kept for body capture.
+	Body []byte
+	// Truncate drops turns from the middle of the conversation so that
+	// the prompt and the requested output fit contextWindow, returning the
+	// new prompt estimate. It is nil when the facade cannot truncate.
+	Truncate func(contextWindow int) (promptTokens int, ok bool)
+	// FromBody builds the calls for a client body without the truncation or
+	// clamping earlier attempts applied; the shadow request uses it. Requests
+	// without it are not mirrored.
+	FromBody func(body []byte) (Prepare, error)
+}
+
+// Prepare builds the call for a picked upstream. Returning an *Error
+// rejects the request without counting against the credential.
+type Prepare func(up router.RoutedUpstream) (Call, error)
+
+// ErrorWriter writes a gateway error in the facade's format.
+type ErrorWriter func(w http.ResponseWriter, status int, code, msg string)
+
+type Executor struct {
+	rtr *router.Router
+	m   *metrics.Metrics
+	bus *logbus.Bus
+
+	keepalive time.Duration
+	cache     respcache.Store
+	captures  capture.Store
+	shadows   shadow.Store
+}
+
+func New(rtr *router.Router, m *metrics.Metrics, bus *logbus.Bus) *Executor {
+	return &Executor{rtr: rtr, m: m, bus: bus, keepalive: DefaultKeepalive}
+}
+
+// SetKeepalive sets how long a stream may stay silent before the client
+// gets a keepalive frame; zero disables keepalives. It must be called
+// before serving.
+func (e *Executor) SetKeepalive(d time.Duration) {
+	e.keepalive = d
+}
+
+// outcome is the accounting of one upstream attempt.
+type outcome struct {
+	ok      bool
+	status  int
+	errMsg  string
+	stats   Stats
+	latency time.Duration
+}
+
+// Execute routes req, sends the prepared call and relays the response,
+// retrying non-streaming requests once on another credential when the
+// upstream fails or is overloaded.
+func (e *Executor) Execute(ctx context.Context, w http.ResponseWriter, req Request, prepare Prepare, writeError ErrorWriter) {
+	w.Header().Set("X-Request-Id", req.ID)
+	if req.Session != "" {
+		ctx = router.WithSession(ctx, req.Session)
+	}
+
+	maxAttempts := 1
+	if !req.Stream {
+		maxAttempts = 2
+	}
+	exclude := map[uint64]bool{}
+
+	// The last attempt that reached an upstream is mirrored to the pool's
+	// shadow, if it has one.
+	var (
+		final   outcome
+		finalUp router.RoutedUpstream
+	)
+	finish := func(up router.RoutedUpstream, o outcome) {
+		e.finish(req, up, o)
+		final, finalUp = o, up
+	}
+	defer func() {
+		if finalUp.CredentialID != 0 {
+			e.mirror(ctx, req, finalUp, final)
+		}
+	}()
+
+	for attempt := 0; attempt < maxAttempts; attempt++ {
+		last := attempt+1 == maxAttempts
+		up, err := e.pick(ctx, w, &req, exclude)
+		if err != nil {
+			var (
+				noUpstreamErr *router.ErrNoAvailableUpstream
+				overflowErr   *router.ErrContextOverflow
+			)
+			switch {
+			case errors.As(err, &overflowErr):
+				writeError(w, http.StatusBadRequest, "context_length_exceeded", overflowErr.Error())
+			case errors.As(err, &noUpstreamErr):
+				writeError(w, http.StatusServiceUnavailable, "no_available_upstream", noUpstreamErr.Error())
+			case errors.Is(err, router.ErrNotConfigured):
+				writeError(w, http.StatusServiceUnavailable, "not_configured", "gateway not configured")
+			default:
+				writeError(w, http.StatusBadGateway, "routing_failed", "routing failed: "+err.Error())
+			}
+			return
+		}
+
+		key := e.cacheKey(req, up)
+		if key != "" && !req.Cache.Refresh && e.serveCached(ctx, w, req, up, key) {
+			return
+		}
+
+		call, err := prepare(up)
+		if err != nil {
+			pe := asError(err, http.StatusInternalServerError, "encode_failed", "failed to build upstream request")
+			e.abandon(req, up, outcome{status: pe.Status, errMsg: pe.Code})
+			writeError(w, pe.Status, pe.Code, pe.Message)
+			return
+		}
+		cp := e.startCapture(req, up, call, attempt)
+		cw := cp.writer(w)
+
+		start := time.Now()
+		timeout := up.Timeout
+		if timeout <= 0 {
+			timeout = 10 * time.Minute
+		}
+		uctx, cancel := context.WithTimeout(ctx, timeout)
+		resp, err := call.Send(uctx, timeout)
+		if err != nil {
+			cancel()
+			finish(up, outcome{errMsg: "upstream_failed", latency: time.Since(start)})
+			exclude[up.CredentialID] = true
+			if !last {
+				e.saveCapture(cp, 0)
+				continue
+			}
+			writeError(cw, http.StatusBadGateway, "upstream_failed", "upstream request failed")
+			e.saveCapture(cp, 0)
+			return
+		}
+		cp.tee(resp)
+
+		status := resp.StatusCode
+		e.rtr.ObserveRateLimit(up.CredentialID, call.ParseRateLimit(resp.Header, time.Now()))
+		if status >= 400 {
+			e.rtr.ReportFailure(ctx, up.CredentialID, call.ClassifyError(status, peekErrorBody(resp)))
+		}
+		ok := status < 500 && status != http.StatusTooManyRequests
+		success := status >= 200 && status < 300
+		if !ok || (!call.Native && !success) {
+			if !ok {
+				exclude[up.CredentialID] = true
+			}
+			if !ok && !last {
+				_ = resp.Body.Close()
+				cancel()
+				finish(up, outcome{status: status, errMsg: "upstream_unavailable", latency: time.Since(start)})
+				e.saveCapture(cp, status)
+				continue
+			}
+			if !call.Native {
+				raw, _ := io.ReadAll(resp.Body)
+				_ = resp.Body.Close()
+				cancel()
+				pe := upstreamError(status, call.ParseError(raw))
+				finish(up, outcome{status: status, errMsg: pe.Code, latency: time.Since(start), stats: Stats{ResponseBytes: len(raw)}})
+				writeError(cw, pe.Status, pe.Code, pe.Message)
+				e.saveCapture(cp, status)
+				return
+			}
+		}
+
+		res := e.relay(uctx, cw, req, up, key, call, resp, start, writeError)
+		_ = resp.Body.Close()
+		cancel()
+		res.ok = res.ok && ok
+		finish(up, res)
+		e.saveCapture(cp, status)
+		return
+	}
+}
+
+// relay writes a response the client can take: any native response, or a
+// successful converted one. Successful responses are stored under key
+// unless it is empty.
+func (e *Executor) relay(ctx context.Context, w http.ResponseWriter, req Request, up router.RoutedUpstream, key string, call Call, resp *http.Response, start time.Time, writeError ErrorWriter) outcome {
+	status := resp.StatusCode
+	if call.Native {
+		copyHeader(w.Header(), resp.Header)
+		w.Header().Set("X-Request-Id", req.ID)
+	}
+	if req.Stream {
+		if status >= 200 && status < 300 {
+			w.Header().Set("Content-Type", "text/event-stream; charset=utf-8")
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("X-Accel-Buffering", "no")
+			w.Header().Del("Content-Length")
+			w.Header().Del("Content-Encoding")
+		}
+		w.WriteHeader(status)
+		var body io.Reader = resp.Body
+		if flusher, ok := w.(http.Flusher); ok && e.keepalive > 0 {
+			frame := streamconv.Keepalive(req.Facade)
+			k := newKeepaliveReader(resp.Body, e.keepalive, func() {
+				_, _ = w.Write(frame)
+				flusher.Flush()
+			})
+			defer k.Close()
+			body = k
+		}
+		sw := w
+		var capture *captureWriter
+		if flusher, ok := w.(http.Flusher); ok && key != "" && status >= 200 && status < 300 {
+			capture = &captureWriter{ResponseWriter: w, Flusher: flusher, max: maxCaptureBytes}
+			sw = capture
+		}
+		stats, err := call.Stream(sw, body, start)
+		if err == nil && capture != nil && !capture.over {
+			e.store(ctx, req, up, key, capture.buf.Bytes())
+		}
+		return outcome{ok: err == nil, status: status, errMsg: errString(err), stats: stats, latency: time.Since(start)}
+	}
+
+	raw, _ := io.ReadAll(resp.Body)
+	out, tok, err := call.Decode(ctx, raw)
+	if err != nil {
+		// An *Error from Decode is the facade failing a good answer, as when
+		// the calls emulating n fail; those account for themselves.
+		var own *Error
+		pe := asError(err, http.StatusBadGateway, "bad_upstream", "invalid upstream response")
+		writeError(w, pe.Status, pe.Code, pe.Message)
+		return outcome{ok: errors.As(err, &own), status: status, errMsg: pe.Code, latency: time.Since(start), stats: Stats{Usage: tok, ResponseBytes: len(raw)}}
+	}
+	if !call.Native {
+		w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	}
+	w.WriteHeader(status)
+	_, _ = w.Write(out)
+	if key != "" && status >= 200 && status < 300 {
+		e.store(ctx, req, up, key, out)
+	}
+	dur := time.Since(start)
+	var tps float64
+	if tok.Output > 0 && dur.Seconds() > 0 {
+		tps = float64(tok.Output) / dur.Seconds()
+	}
+	return outcome{ok: true, status: status, latency: dur, stats: Stats{Usage: tok, ResponseBytes: len(out), TTFT: dur.Milliseconds(), TPS: tps}}
+}
+
+// pick routes req. When its prompt is too long for every upstream, the
+// pool's overflow strategy may route it to another model or truncate it,
+// once; the adapted request stays in effect for retries.
+func (e *Executor) pick(ctx context.Context, w http.ResponseWriter, req *Request, exclude map[uint64]bool) (router.RoutedUpstream, error) {
+	up, err := e.rtr.PickUpstreamWith(ctx, req.ClientKey, string(req.Facade), req.Model, exclude, req.Need)
+	var overflow *router.ErrContextOverflow
+	if !errors.As(err, &overflow) {
+		return up, err
+	}
+	switch overflow.Strategy {
+	case router.OverflowRoute:
+		if overflow.OverflowModel == "" || overflow.OverflowModel == req.Model {
+			return up, err
+		}
+		req.Model = overflow.OverflowModel
+		w.Header().Set("X-Context-Overflow", "routed")
+	case router.OverflowTruncate:
+		if req.Truncate == nil || overflow.ContextWindow <= 0 {
+			return up, err
+		}
+		tokens, ok := req.Truncate(overflow.ContextWindow)
+		if !ok {
+			return up, err
+		}
+		req.Need.PromptTokens = tokens
+		w.Header().Set("X-Context-Overflow", "truncated")
+	default:
+		return up, err
+	}
+	return e.rtr.PickUpstreamWith(ctx, req.ClientKey, string(req.Facade), req.Model, exclude, req.Need)
+}
+
+// finish records one attempt with the router, the metrics and the request
+// log.
+func (e *Executor) finish(req Request, up router.RoutedUpstream, o outcome) {
+	e.rtr.EndRequest(up.CredentialID, o.ok, o.status, o.latency)
+	switch {
+	case up.Pinned:
+	case up.SplitArm != "":
+		e.rtr.RecordSplitResult(up, o.ok, o.latency)
+	default:
+		e.rtr.RecordRouteResult(up.PoolID, string(req.Facade), req.Model, up.CredentialID, o.ok, o.status)
+	}
+	metricStatus := o.status
+	if metricStatus == 0 {
+		metricStatus = http.StatusBadGateway
+	}
+	e.m.ObserveRequest(string(req.Facade), up.ProviderType, metricStatus, o.latency)
+	e.publish(req, up, o, false)
+}
+
+// abandon records an attempt that was never sent upstream, as when its
+// request could not be built. The credential's slot is handed back without
+// an outcome: the upstream's health, route scores and splits are untouched.
+func (e *Executor) abandon(req Request, up router.RoutedUpstream, o outcome) {
+	e.rtr.ReleaseRequest(up.CredentialID)
+	e.m.ObserveRequest(string(req.Facade), up.ProviderType, o.status, o.latency)
+	e.publish(req, up, o, false)
+}
+
+// publish writes the request log of one attempt.
+func (e *Executor) publish(req Request, up router.RoutedUpstream, o outcome, cacheHit bool) {
+	if e.bus == nil {
+		return
+	}
+	e.bus.Publish(logbus.Event{
+		TS:                  time.Now(),
+		RequestID:           req.ID,
+		Facade:              string(req.Facade),
+		RequestModel:        req.Model,
+		UpstreamModel:       up.Model,
+		ProviderType:        up.ProviderType,
+		PoolID:              up.PoolID,
+		ProviderID:          up.ProviderID,
+		CredentialID:        up.CredentialID,
+		ClientKey:           req.ClientKey,
+		SrcIP:               req.SrcIP,
+		UserAgent:           req.UserAgent,
+		IsTest:              req.IsTest,
+		Stream:              req.Stream,
+		RequestBytes:        req.RequestBytes,
+		ResponseBytes:       o.stats.ResponseBytes,
+		InputTokens:         o.stats.Usage.Input,
+		OutputTokens:        o.stats.Usage.Output,
+		CacheCreationTokens: o.stats.Usage.CacheCreation,
+		CacheReadTokens:     o.stats.Usage.CacheRead,
+		CacheHit:            cacheHit,
+		Status:              o.status,
+		LatencyMs:           o.latency.Milliseconds(),
+		TTFTMs:              o.stats.TTFT,
+		TPS:                 o.stats.TPS,
+		Error:               o.errMsg,
+	})
+}
+
+// ClientStatus maps an upstream error status to the status returned to the
+// client of a converted call.
+func ClientStatus(upstreamStatus int) int {
+	switch {
+	case upstreamStatus == http.StatusTooManyRequests:
+		return http.StatusTooManyRequests
+	case upstreamStatus == http.StatusUnauthorized || upstreamStatus == http.StatusForbidden:
+		return http.StatusUnauthorized
+	case upstreamStatus >= 400 && upstreamStatus < 500:
+		return http.StatusBadRequest
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+// UpstreamErrorCode names an upstream error status.
+func UpstreamErrorCode(upstreamStatus int) string {
+	switch upstreamStatus {
+	case http.StatusTooManyRequests:
+		return "rate_limit"
+	case http.StatusUnauthorized, http.StatusForbidden:
+		return "unauthorized"
+	default:
+		if upstreamStatus >= 500 {
+			return "upstream_error"
+		}
+		return "bad_request"
+	}
+}
+
+// upstreamError turns an upstream error response into the gateway error
+// for a converted call. The status follows the error's kind where that is
+// more precise than the upstream status, so that facades derive the right
+// error type; the code and a sanitized message are passed through.
+func upstreamError(status int, ae upstream.APIError) *Error {
+	pe := &Error{Status: ClientStatus(status), Code: ae.Code, Message: "upstream error"}
+	switch ae.Kind() {
+	case "overloaded_error":
+		pe.Status = http.StatusServiceUnavailable
+	case "rate_limit_error":
+		pe.Status = http.StatusTooManyRequests
+	case "invalid_request_error", "request_too_large":
+		pe.Status = http.StatusBadRequest
+	}
+	if pe.Code == "" {
+		pe.Code = UpstreamErrorCode(status)
+	}
+	if msg := upstream.SanitizeMessage(ae.Message); msg != "" {
+		pe.Message = "upstream error: " + msg
+	}
+	return pe
+}
+
+func asError(err error, status int, code, msg string) *Error {
+	var pe *Error
+	if errors.As(err, &pe) {
+		return pe
+	}
+	return &Error{Status: status, Code: code, Message: msg}
+}
+
+// peekErrorBody reads the head of an error response for classification and
+// leaves resp.Body intact for the caller.
+func peekErrorBody(resp *http.Response) []byte {
+	raw, _ := io.ReadAll(io.LimitReader(resp.Body, 64<<10))
+	resp.Body = struct {
+		io.Reader
+		io.Closer
+	}{io.MultiReader(bytes.NewReader(raw), resp.Body), resp.Body}
+	return raw
+}
+
+func copyHeader(dst, src http.Header) {
+	for k, vs := range src {
+		for _, v := range vs {
+			dst.Add(k, v)
+		}
+	}
+}
+
+func errString(err error) string {
+	if err == nil {
+		return ""
+	}
+	return err.Error()
+}
@@ -0,0 +1,65 @@
+package pipeline
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+
+	"claude-gateway/src/internal/metrics"
+	"claude-gateway/src/internal/router"
+	"claude-gateway/src/internal/upstream"
+)
+
+func TestClientStatus(t *testing.T) {
+	cases := map[int]int{
+		http.StatusTooManyRequests:     http.StatusTooManyRequests,
+		http.StatusForbidden:           http.StatusUnauthorized,
+		http.StatusNotFound:            http.StatusBadRequest,
+		http.StatusInternalServerError: http.StatusBadGateway,
+	}
+	for in, want := range cases {
+		if got := ClientStatus(in); got != want {
+			t.Errorf("ClientStatus(%d) = %d, want %d", in, got, want)
+		}
+	}
+}
+
+func TestAsErrorKeepsPipelineErrors(t *testing.T) {
+	pe := &Error{Status: http.StatusBadRequest, Code: "unsupported_request", Message: "bad"}
+	if got := asError(fmt.Errorf("wrapped: %w", pe), http.StatusBadGateway, "bad_upstream", "x"); got != pe {
+		t.Fatalf("asError = %+v", got)
+	}
+	got := asError(errors.New("boom"), http.StatusBadGateway, "bad_upstream", "invalid upstream response")
+	if got.Status != http.StatusBadGateway || got.Code != "bad_upstream" {
+		t.Fatalf("asError = %+v", got)
+	}
+}
+
+func TestUpstreamErrorKeepsKindAndMessage(t *testing.T) {
+	got := upstreamError(http.StatusBadRequest, upstream.APIError{Type: "invalid_request_error", Code: "context_length_exceeded", Message: "maximum context length is 128000 tokens"})
+	if got.Status != http.StatusBadRequest || got.Code != "context_length_exceeded" || got.Message != "upstream error: maximum context length is 128000 tokens" {
+		t.Fatalf("upstreamError = %+v", got)
+	}
+	got = upstreamError(529, upstream.APIError{Type: "overloaded_error", Message: "Overloaded"})
+	if got.Status != http.StatusServiceUnavailable || got.Code != "upstream_error" {
+		t.Fatalf("upstreamError = %+v", got)
+	}
+	if got := upstreamError(http.StatusInternalServerError, upstream.APIError{}); got.Message != "upstream error" {
+		t.Fatalf("upstreamError = %+v", got)
+	}
+}
+
+func TestAbandonLeavesCredentialHealthAlone(t *testing.T) {
+	rtr := router.New(nil, nil, nil)
+	e := New(rtr, metrics.New(), nil)
+	rtr.BeginRequest(7)
+	rtr.BeginRequest(7)
+	rtr.EndRequest(7, false, http.StatusInternalServerError, 0)
+
+	e.abandon(Request{}, router.RoutedUpstream{CredentialID: 7}, outcome{status: http.StatusBadRequest, errMsg: "unsupported_parameter"})
+	st := rtr.CredentialStateOf(7)
+	if st.Inflight != 0 || st.Failures != 1 || st.Successes != 0 || !st.Open {
+		t.Fatalf("state after an unsent attempt = %+v", st)
+	}
+}
@@ -0,0 +1,82 @@
+package pipeline
+
+import (
+	"io"
+	"time"
+)
+
+// DefaultKeepalive is how long an upstream stream may stay silent before
+// the client gets a keepalive frame.
+const DefaultKeepalive = 15 * time.Second
+
+// keepaliveReader calls ping whenever a Read waits longer than interval.
+// Stream functions write to the client only between reads, so a ping
+// issued from Read always lands between two complete events and never
+// races their writes.
+type keepaliveReader struct {
+	r        io.Reader
+	interval time.Duration
+	ping     func()
+
+	chunks chan readResult
+	done   chan struct{}
+	buf    []byte
+	err    error
+}
+
+type readResult struct {
+	b   []byte
+	err error
+}
+
+func newKeepaliveReader(r io.Reader, interval time.Duration, ping func()) *keepaliveReader {
+	k := &keepaliveReader{r: r, interval: interval, ping: ping, chunks: make(chan readResult, 1), done: make(chan struct{})}
+	go k.pump()
+	return k
+}
+
+// pump reads ahead of Read until the upstream body fails or ends, or the
+// reader is closed.
+func (k *keepaliveReader) pump() {
+	for {
+		b := make([]byte, 32<<10)
+		n, err := k.r.Read(b)
+		select {
+		case k.chunks <- readResult{b: b[:n], err: err}:
+		case <-k.done:
+			return
+		}
+		if err != nil {
+			return
+		}
+	}
+}
+
+// Close stops the pump. The upstream body must be closed as well to
+// unblock a pending read.
+func (k *keepaliveReader) Close() {
+	close(k.done)
+}
+
+func (k *keepaliveReader) Read(p []byte) (int, error) {
+	if len(k.buf) == 0 && k.err == nil {
+		t := time.NewTimer(k.interval)
+		defer t.Stop()
+		for waiting := true; waiting; {
+			select {
+			case res := <-k.chunks:
+				k.buf, k.err = res.b, res.err
+				waiting = false
+			case <-t.C:
+				k.ping()
+				t.Reset(k.interval)
+			}
+		}
+	}
+	n := copy(p, k.buf)
+	k.buf = k.buf[n:]
+	if len(k.buf) == 0 && k.err != nil {
+		return n, k.err
+	}
+	return n, nil
+}
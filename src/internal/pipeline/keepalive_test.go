@@ -0,0 +1,27 @@
+package pipeline
+
+import (
+	"io"
+	"testing"
+	"time"
+)
+
+func TestKeepaliveReaderPingsDuringSilence(t *testing.T) {
+	pr, pw := io.Pipe()
+	pings := 0
+	k := newKeepaliveReader(pr, 10*time.Millisecond, func() { pings++ })
+	defer k.Close()
+
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		_, _ = pw.Write([]byte("data: x\n\n"))
+		_ = pw.Close()
+	}()
+	b, err := io.ReadAll(k)
+	if err != nil || string(b) != "data: x\n\n" {
+		t.Fatalf("read %q, %v", b, err)
+	}
+	if pings == 0 {
+		t.Fatal("expected pings while upstream was silent")
+	}
+}
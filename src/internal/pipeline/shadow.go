@@ -0,0 +1,153 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"time"
+
+	"claude-gateway/src/internal/capture"
+	"claude-gateway/src/internal/router"
+	"claude-gateway/src/internal/shadow"
+	"claude-gateway/src/internal/upstream"
+)
+
+// SetShadow enables mirroring for pools that configure a shadow upstream.
+// It must be called before serving.
+func (e *Executor) SetShadow(store shadow.Store) {
+	e.shadows = store
+}
+
+// mirror sends a sample of the pool's requests to its shadow upstream once
+// the primary attempt is over. Test requests and pinned replays are not
+// mirrored.
+func (e *Executor) mirror(ctx context.Context, req Request, primary router.RoutedUpstream, o outcome) {
+	if e.shadows == nil || primary.ShadowProviderID == 0 || primary.Pinned || req.IsTest || req.FromBody == nil || !capture.Sample(primary.ShadowRate) {
+		return
+	}
+	res := shadow.Result{
+		RequestID: req.ID,
+		PoolID:    primary.PoolID,
+		Facade:    string(req.Facade),
+		Stream:    req.Stream,
+		Primary: shadow.Outcome{
+			ProviderID:   primary.ProviderID,
+			Model:        primary.Model,
+			Status:       o.status,
+			LatencyMs:    o.latency.Milliseconds(),
+			InputTokens:  o.stats.Usage.Input,
+			OutputTokens: o.stats.Usage.Output,
+		},
+		Shadow:       shadow.Outcome{ProviderID: primary.ShadowProviderID, Model: primary.ShadowModel},
+		PrimaryError: o.errMsg,
+	}
+	go e.runShadow(context.WithoutCancel(ctx), req, primary, res)
+}
+
+func (e *Executor) runShadow(ctx context.Context, req Request, primary router.RoutedUpstream, res shadow.Result) {
+	defer func() { e.shadows.Save(res) }()
+
+	prepare, err := req.FromBody(req.Body)
+	if err != nil {
+		res.ShadowError = "encode_failed: " + err.Error()
+		return
+	}
+
+	pinned := router.WithPin(ctx, router.Pin{ProviderID: primary.ShadowProviderID, RespectLimits: true})
+	up, err := e.rtr.PickUpstreamWith(pinned, req.ClientKey, string(req.Facade), req.Model, nil, req.Need)
+	if err != nil {
+		res.ShadowError = err.Error()
+		return
+	}
+	if primary.ShadowModel != "" {
+		up.Model = primary.ShadowModel
+	}
+	up.CaptureRate, up.ResponseCacheTTL = 0, 0
+	res.ShadowCredentialID = up.CredentialID
+	res.Shadow.Model = up.Model
+
+	call, err := prepare(up)
+	if err != nil {
+		// Like a primary attempt that was never sent, this says nothing
+		// about the credential.
+		pe := asError(err, http.StatusInternalServerError, "encode_failed", "failed to build upstream request")
+		e.rtr.ReleaseRequest(up.CredentialID)
+		e.m.ObserveRequest(string(req.Facade), "shadow", pe.Status, 0)
+		res.Shadow.Status = pe.Status
+		res.ShadowError = pe.Message
+		return
+	}
+
+	o, out := e.shadowAttempt(ctx, req, call, up)
+	e.rtr.EndRequest(up.CredentialID, o.ok, o.status, o.latency)
+	metricStatus := o.status
+	if metricStatus == 0 {
+		metricStatus = http.StatusBadGateway
+	}
+	e.m.ObserveRequest(string(req.Facade), "shadow", metricStatus, o.latency)
+
+	res.Shadow.Status = o.status
+	res.Shadow.LatencyMs = o.latency.Milliseconds()
+	res.Shadow.InputTokens = o.stats.Usage.Input
+	res.Shadow.OutputTokens = o.stats.Usage.Output
+	res.ShadowError = o.errMsg
+	res.ShadowOutput = out
+}
+
+// shadowAttempt sends call to up and reads the response the client would
+// have got, which is returned instead of written.
+func (e *Executor) shadowAttempt(ctx context.Context, req Request, call Call, up router.RoutedUpstream) (outcome, []byte) {
+	timeout := up.Timeout
+	if timeout <= 0 {
+		timeout = 10 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := call.Send(ctx, timeout)
+	if err != nil {
+		return outcome{errMsg: "upstream_failed: " + upstream.SanitizeMessage(err.Error()), latency: time.Since(start)}, nil
+	}
+	defer resp.Body.Close()
+	status := resp.StatusCode
+	e.rtr.ObserveRateLimit(up.CredentialID, call.ParseRateLimit(resp.Header, time.Now()))
+
+	if status < 200 || status >= 300 {
+		raw, _ := io.ReadAll(io.LimitReader(resp.Body, capture.DefaultMaxPartBytes))
+		e.rtr.ReportFailure(ctx, up.CredentialID, call.ClassifyError(status, raw))
+		o := outcome{ok: status < 500 && status != http.StatusTooManyRequests, status: status, latency: time.Since(start)}
+		if o.errMsg = upstream.SanitizeMessage(call.ParseError(raw).Message); o.errMsg == "" {
+			o.errMsg = UpstreamErrorCode(status)
+		}
+		return o, raw
+	}
+
+	if req.Stream {
+		w := &discardWriter{header: http.Header{}, buf: capture.NewBuffer(capture.DefaultMaxPartBytes)}
+		stats, err := call.Stream(w, resp.Body, start)
+		return outcome{ok: err == nil, status: status, errMsg: errString(err), stats: stats, latency: time.Since(start)}, w.buf.Bytes()
+	}
+	raw, _ := io.ReadAll(resp.Body)
+	out, tok, err := call.Decode(ctx, raw)
+	latency := time.Since(start)
+	if err != nil {
+		return outcome{status: status, errMsg: "invalid upstream response", stats: Stats{Usage: tok}, latency: latency}, capture.Clip(raw, capture.DefaultMaxPartBytes)
+	}
+	return outcome{ok: true, status: status, stats: Stats{Usage: tok, ResponseBytes: len(out)}, latency: latency}, capture.Clip(out, capture.DefaultMaxPartBytes)
+}
+
+// discardWriter stands in for the client of a shadow request, keeping the
+// head of what it is sent.
+type discardWriter struct {
+	header http.Header
+	buf    *capture.Buffer
+}
+
+func (w *discardWriter) Header() http.Header { return w.header }
+
+func (w *discardWriter) WriteHeader(int) {}
+
+func (w *discardWriter) Write(b []byte) (int, error) { return w.buf.Write(b) }
+
+func (w *discardWriter) Flush() {}
@@ -0,0 +1,50 @@
+package pipeline
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"claude-gateway/src/internal/router"
+	"claude-gateway/src/internal/upstream"
+)
+
+func shadowCall(status int, body string) Call {
+	return Call{
+		Send: func(context.Context, time.Duration) (*http.Response, error) {
+			return &http.Response{StatusCode: status, Header: http.Header{}, Body: io.NopCloser(strings.NewReader(body))}, nil
+		},
+		ParseRateLimit: func(http.Header, time.Time) upstream.RateLimit { return upstream.RateLimit{} },
+		ClassifyError:  func(int, []byte) upstream.Failure { return upstream.Failure{} },
+		ParseError:     func([]byte) upstream.APIError { return upstream.APIError{Message: "model overloaded"} },
+		Decode: func(_ context.Context, raw []byte) ([]byte, Usage, error) {
+			return []byte("client:" + string(raw)), Usage{Input: 3, Output: 5}, nil
+		},
+		Stream: func(w http.ResponseWriter, r io.Reader, _ time.Time) (Stats, error) {
+			_, err := io.Copy(w, r)
+			return Stats{Usage: Usage{Output: 2}}, err
+		},
+	}
+}
+
+func TestShadowAttemptKeepsOutputFromClient(t *testing.T) {
+	e := New(router.New(nil, nil, nil), nil, nil)
+	up := router.RoutedUpstream{CredentialID: 7}
+	o, out := e.shadowAttempt(context.Background(), Request{}, shadowCall(200, "ok"), up)
+	if !o.ok || o.status != 200 || o.stats.Usage.Output != 5 || string(out) != "client:ok" {
+		t.Fatalf("non-streamed = %+v %q", o, out)
+	}
+
+	o, out = e.shadowAttempt(context.Background(), Request{Stream: true}, shadowCall(200, "data: x\n\n"), up)
+	if !o.ok || o.stats.Usage.Output != 2 || string(out) != "data: x\n\n" {
+		t.Fatalf("streamed = %+v %q", o, out)
+	}
+
+	o, _ = e.shadowAttempt(context.Background(), Request{}, shadowCall(529, `{}`), up)
+	if o.ok || o.status != 529 || o.errMsg != "model overloaded" {
+		t.Fatalf("failed = %+v", o)
+	}
+}
@@ -0,0 +1,130 @@
+package pipeline
+
+import (
+	"encoding/json"
+	"strings"
+
+	"claude-gateway/src/internal/convert"
+)
+
+// Usage is the token accounting of one response. Input counts the whole
+// prompt; CacheCreation and CacheRead are the parts of it written to or
+// served from the prompt cache.
+type Usage struct {
+	Input         int64
+	Output        int64
+	CacheCreation int64
+	CacheRead     int64
+
+	uncached int64
+}
+
+// AddAnthropic merges an Anthropic usage object. Streams spread usage over
+// message_start and message_delta, so only the fields present are taken.
+func (u *Usage) AddAnthropic(m map[string]any) {
+	if v, ok := m["input_tokens"]; ok {
+		u.uncached = parseInt64(v)
+	}
+	if v, ok := m["cache_creation_input_tokens"]; ok {
+		u.CacheCreation = parseInt64(v)
+	}
+	if v, ok := m["cache_read_input_tokens"]; ok {
+		u.CacheRead = parseInt64(v)
+	}
+	if v, ok := m["output_tokens"]; ok {
+		u.Output = parseInt64(v)
+	}
+	u.Input = u.uncached + u.CacheCreation + u.CacheRead
+}
+
+// Add sums o into u, for responses assembled from several upstream calls.
+func (u *Usage) Add(o Usage) {
+	u.Input += o.Input
+	u.Output += o.Output
+	u.CacheCreation += o.CacheCreation
+	u.CacheRead += o.CacheRead
+}
+
+// OpenAIUsage reads Chat Completions or Responses usage, where cached
+// tokens are already part of the prompt count.
+func OpenAIUsage(m map[string]any) Usage {
+	var u Usage
+	u.Input = parseInt64(m["prompt_tokens"])
+	if u.Input == 0 {
+		u.Input = parseInt64(m["input_tokens"])
+	}
+	u.Output = parseInt64(m["completion_tokens"])
+	if u.Output == 0 {
+		u.Output = parseInt64(m["output_tokens"])
+	}
+	details, _ := m["prompt_tokens_details"].(map[string]any)
+	if details == nil {
+		details, _ = m["input_tokens_details"].(map[string]any)
+	}
+	if details != nil {
+		u.CacheRead = parseInt64(details["cached_tokens"])
+	}
+	return u
+}
+
+// ExtractOpenAIUsage reads the usage of a Chat Completions or Responses
+// body.
+func ExtractOpenAIUsage(raw []byte) Usage {
+	var root map[string]any
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return Usage{}
+	}
+	u, _ := root["usage"].(map[string]any)
+	if u == nil {
+		return Usage{}
+	}
+	return OpenAIUsage(u)
+}
+
+// ExtractAnthropicUsage reads the usage of a Messages body.
+func ExtractAnthropicUsage(raw []byte) Usage {
+	var root map[string]any
+	if err := json.Unmarshal(raw, &root); err != nil {
+		return Usage{}
+	}
+	var tok Usage
+	if u, _ := root["usage"].(map[string]any); u != nil {
+		tok.AddAnthropic(u)
+	}
+	return tok
+}
+
+// GeminiUsage converts the usage metadata of a Gemini response.
+func GeminiUsage(u *convert.GeminiUsage) Usage {
+	if u == nil {
+		return Usage{}
+	}
+	return Usage{
+		Input:     int64(u.PromptTokenCount),
+		Output:    int64(u.CandidatesTokenCount),
+		CacheRead: int64(u.CachedContentTokenCount),
+	}
+}
+
+func parseInt64(v any) int64 {
+	switch t := v.(type) {
+	case float64:
+		return int64(t)
+	case int:
+		return int64(t)
+	case int64:
+		return t
+	case json.Number:
+		i, _ := t.Int64()
+		return i
+	case string:
+		n := strings.TrimSpace(t)
+		if n == "" {
+			return 0
+		}
+		i, _ := json.Number(n).Int64()
+		return i
+	default:
+		return 0
+	}
+}
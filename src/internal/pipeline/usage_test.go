@@ -0,0 +1,12 @@
+package pipeline
+
+import "testing"
+
+func TestStreamUsageMergesCacheCounts(t *testing.T) {
+	var tok Usage
+	tok.AddAnthropic(map[string]any{"input_tokens": 5.0, "cache_creation_input_tokens": 100.0, "cache_read_input_tokens": 900.0, "output_tokens": 1.0})
+	tok.AddAnthropic(map[string]any{"output_tokens": 42.0})
+	if tok.Input != 1005 || tok.Output != 42 || tok.CacheCreation != 100 || tok.CacheRead != 900 {
+		t.Fatalf("usage = %+v", tok)
+	}
+}
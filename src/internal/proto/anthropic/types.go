@@ -11,9 +11,18 @@ type MessageCreateRequest struct {
 	StopSeqs    []string         `json:"stop_sequences,omitempty"`
 	Temperature *float64         `json:"temperature,omitempty"`
 	TopP        *float64         `json:"top_p,omitempty"`
+	TopK        *int             `json:"top_k,omitempty"`
 	Stream      bool             `json:"stream,omitempty"`
 	Tools       []ToolDefinition `json:"tools,omitempty"`
 	ToolChoice  json.RawMessage  `json:"tool_choice,omitempty"`
+	Thinking    *ThinkingConfig  `json:"thinking,omitempty"`
+}
+
+// ThinkingConfig enables extended thinking. BudgetTokens must be at least
+// 1024 and below max_tokens.
+type ThinkingConfig struct {
+	Type         string `json:"type"`
+	BudgetTokens int    `json:"budget_tokens,omitempty"`
 }
 
 type Message struct {
@@ -9,8 +9,29 @@ type ChatCompletionsRequest struct {
 	Temperature *float64        `json:"temperature,omitempty"`
 	TopP        *float64        `json:"top_p,omitempty"`
 	Stream      bool            `json:"stream,omitempty"`
+	StreamOpts  json.RawMessage `json:"stream_options,omitempty"`
 	Tools       json.RawMessage `json:"tools,omitempty"`
 	ToolChoice  json.RawMessage `json:"tool_choice,omitempty"`
+	// ResponseFormat is kept raw; converters map json_object/json_schema to
+	// the target provider's structured-output mechanism.
+	ResponseFormat json.RawMessage `json:"response_format,omitempty"`
+
+	// Stop is a string or an array of strings.
+	Stop             json.RawMessage `json:"stop,omitempty"`
+	N                *int            `json:"n,omitempty"`
+	Seed             *int64          `json:"seed,omitempty"`
+	PresencePenalty  *float64        `json:"presence_penalty,omitempty"`
+	FrequencyPenalty *float64        `json:"frequency_penalty,omitempty"`
+	Logprobs         *bool           `json:"logprobs,omitempty"`
+	TopLogprobs      *int            `json:"top_logprobs,omitempty"`
+	User             string          `json:"user,omitempty"`
+
+	ReasoningEffort string `json:"reasoning_effort,omitempty"`
+	// Vendor reasoning switches for OpenAI-compatible APIs: DeepSeek and GLM
+	// take a thinking object, Qwen takes enable_thinking/thinking_budget.
+	Thinking       json.RawMessage `json:"thinking,omitempty"`
+	EnableThinking *bool           `json:"enable_thinking,omitempty"`
+	ThinkingBudget *int            `json:"thinking_budget,omitempty"`
 }
 
 type ResponsesRequest struct {
@@ -0,0 +1,42 @@
+package anthropic
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"claude-gateway/src/internal/upstream"
+)
+
+// ClassifyError inspects an Anthropic error response. Revoked keys surface as
+// authentication_error, and an empty balance as an invalid_request_error
+// mentioning the credit balance. A 401 whose body does not say so, as from a
+// proxy in between, is left to the circuit cooldown rather than disabling
+// the key.
+func ClassifyError(status int, body []byte) upstream.Failure {
+	e := ParseError(body)
+	typ, msg := e.Type, e.Message
+	lower := strings.ToLower(msg)
+
+	switch {
+	case typ == "authentication_error":
+		return upstream.Failure{Kind: upstream.FailureInvalidKey, Status: status, Message: msg}
+	case strings.Contains(lower, "credit balance is too low") || strings.Contains(lower, "credit balance too low"):
+		return upstream.Failure{Kind: upstream.FailureQuotaExhausted, Status: status, Message: msg}
+	case status == http.StatusForbidden && strings.Contains(lower, "disabled"):
+		return upstream.Failure{Kind: upstream.FailureInvalidKey, Status: status, Message: msg}
+	}
+	return upstream.Generic(status, msg)
+}
+
+// ParseError reads an Anthropic error body.
+func ParseError(body []byte) upstream.APIError {
+	var env struct {
+		Error struct {
+			Type    string `json:"type"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(body, &env)
+	return upstream.APIError{Type: env.Error.Type, Message: env.Error.Message}
+}
@@ -0,0 +1,35 @@
+package anthropic
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"claude-gateway/src/internal/upstream"
+)
+
+// ParseRateLimit reads retry-after and the anthropic-ratelimit-* headers.
+func ParseRateLimit(h http.Header, now time.Time) upstream.RateLimit {
+	return upstream.RateLimit{
+		RetryAfter: upstream.ParseRetryAfter(h, now),
+		Requests:   parseBudget(h, "requests"),
+		Tokens:     parseBudget(h, "tokens"),
+	}
+}
+
+func parseBudget(h http.Header, kind string) upstream.Budget {
+	var b upstream.Budget
+	remaining, ok := upstream.ParseInt(h, "anthropic-ratelimit-"+kind+"-remaining")
+	if !ok {
+		return b
+	}
+	b.Known = true
+	b.Remaining = remaining
+	b.Limit, _ = upstream.ParseInt(h, "anthropic-ratelimit-"+kind+"-limit")
+	if v := strings.TrimSpace(h.Get("anthropic-ratelimit-" + kind + "-reset")); v != "" {
+		if t, err := time.Parse(time.RFC3339, v); err == nil {
+			b.Reset = t
+		}
+	}
+	return b
+}
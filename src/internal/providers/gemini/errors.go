@@ -0,0 +1,49 @@
+package gemini
+
+import (
+	"encoding/json"
+	"strings"
+
+	"claude-gateway/src/internal/upstream"
+)
+
+// ClassifyError inspects a Google API error response. RESOURCE_EXHAUSTED is
+// left as rate limiting because per-minute quotas recover on their own.
+func ClassifyError(status int, body []byte) upstream.Failure {
+	e := ParseError(body)
+	msg := e.Message
+	lower := strings.ToLower(msg)
+	switch {
+	case e.Code == "API_KEY_INVALID" || e.Code == "API_KEY_SERVICE_BLOCKED":
+		return upstream.Failure{Kind: upstream.FailureInvalidKey, Status: status, Message: msg}
+	case strings.Contains(lower, "api key not valid") || strings.Contains(lower, "api key expired"):
+		return upstream.Failure{Kind: upstream.FailureInvalidKey, Status: status, Message: msg}
+	case e.Type == "PERMISSION_DENIED" && strings.Contains(lower, "suspended"):
+		return upstream.Failure{Kind: upstream.FailureInvalidKey, Status: status, Message: msg}
+	}
+	return upstream.Generic(status, msg)
+}
+
+// ParseError reads a Google API error body. The status (INVALID_ARGUMENT,
+// RESOURCE_EXHAUSTED, ...) is the type and the first detail reason the
+// code.
+func ParseError(body []byte) upstream.APIError {
+	var env struct {
+		Error struct {
+			Status  string `json:"status"`
+			Message string `json:"message"`
+			Details []struct {
+				Reason string `json:"reason"`
+			} `json:"details"`
+		} `json:"error"`
+	}
+	_ = json.Unmarshal(body, &env)
+	e := upstream.APIError{Type: env.Error.Status, Message: env.Error.Message}
+	for _, d := range env.Error.Details {
+		if d.Reason != "" {
+			e.Code = d.Reason
+			break
+		}
+	}
+	return e
+}
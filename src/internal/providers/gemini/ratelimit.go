@@ -0,0 +1,14 @@
+package gemini
+
+import (
+	"net/http"
+	"time"
+
+	"claude-gateway/src/internal/upstream"
+)
+
+// ParseRateLimit only understands retry-after; Gemini does not report quotas
+// in response headers.
+func ParseRateLimit(h http.Header, now time.Time) upstream.RateLimit {
+	return upstream.RateLimit{RetryAfter: upstream.ParseRetryAfter(h, now)}
+}
@@ -0,0 +1,60 @@
+package openai
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"claude-gateway/src/internal/upstream"
+)
+
+// ClassifyError inspects an OpenAI-compatible error response. An exhausted
+// balance is reported as a 429 with code insufficient_quota, which must not
+// be mistaken for ordinary rate limiting. Only a body naming the key as
+// invalid disables it: a bare 401 may come from a proxy or a wrong base URL.
+func ClassifyError(status int, body []byte) upstream.Failure {
+	e := ParseError(body)
+	code, msg := e.Code, e.Message
+
+	switch {
+	case code == "insufficient_quota" || e.Type == "insufficient_quota" || code == "billing_hard_limit_reached":
+		return upstream.Failure{Kind: upstream.FailureQuotaExhausted, Status: status, Message: msg}
+	case code == "invalid_api_key" || code == "account_deactivated" || e.Type == "authentication_error":
+		return upstream.Failure{Kind: upstream.FailureInvalidKey, Status: status, Message: msg}
+	case status == http.StatusForbidden && strings.Contains(strings.ToLower(msg), "deactivated"):
+		return upstream.Failure{Kind: upstream.FailureInvalidKey, Status: status, Message: msg}
+	}
+	return upstream.Generic(status, msg)
+}
+
+// ParseError reads an OpenAI error body. Compatible upstreams also send a
+// bare message string, and some send a numeric code.
+func ParseError(body []byte) upstream.APIError {
+	var env struct {
+		Error   json.RawMessage `json:"error"`
+		Message string          `json:"message"`
+	}
+	_ = json.Unmarshal(body, &env)
+	var obj struct {
+		Type    string `json:"type"`
+		Code    any    `json:"code"`
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(env.Error, &obj) != nil {
+		var msg string
+		_ = json.Unmarshal(env.Error, &msg)
+		if msg == "" {
+			msg = env.Message
+		}
+		return upstream.APIError{Message: msg}
+	}
+	e := upstream.APIError{Type: obj.Type, Message: obj.Message}
+	switch c := obj.Code.(type) {
+	case string:
+		e.Code = c
+	case float64:
+		e.Code = strconv.Itoa(int(c))
+	}
+	return e
+}
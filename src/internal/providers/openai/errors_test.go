@@ -0,0 +1,38 @@
+package openai
+
+import (
+	"testing"
+
+	"claude-gateway/src/internal/upstream"
+)
+
+func TestClassifyErrorQuotaIsNotRateLimit(t *testing.T) {
+	body := []byte(`{"error":{"message":"You exceeded your current quota","type":"insufficient_quota","code":"insufficient_quota"}}`)
+	if got := ClassifyError(429, body); got.Kind != upstream.FailureQuotaExhausted {
+		t.Fatalf("expected quota exhaustion, got %v", got.Kind)
+	}
+	body = []byte(`{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`)
+	if got := ClassifyError(429, body); got.Kind != upstream.FailureRateLimited {
+		t.Fatalf("expected rate limit, got %v", got.Kind)
+	}
+	body = []byte(`{"error":{"message":"Incorrect API key provided","code":"invalid_api_key"}}`)
+	if got := ClassifyError(401, body); got.Kind != upstream.FailureInvalidKey {
+		t.Fatalf("expected invalid key, got %v", got.Kind)
+	}
+	if got := ClassifyError(401, []byte(`<html>401 Authorization Required</html>`)); got.Permanent() {
+		t.Fatalf("bare 401 disables the key: %v", got.Kind)
+	}
+}
+
+func TestParseErrorShapes(t *testing.T) {
+	got := ParseError([]byte(`{"error":{"message":"too long","type":"invalid_request_error","code":"context_length_exceeded"}}`))
+	if got.Type != "invalid_request_error" || got.Code != "context_length_exceeded" || got.Message != "too long" {
+		t.Fatalf("object error = %+v", got)
+	}
+	if got := ParseError([]byte(`{"error":{"message":"busy","code":1305}}`)); got.Code != "1305" {
+		t.Fatalf("numeric code = %+v", got)
+	}
+	if got := ParseError([]byte(`{"error":"model not found"}`)); got.Message != "model not found" {
+		t.Fatalf("bare message = %+v", got)
+	}
+}
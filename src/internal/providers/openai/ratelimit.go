@@ -0,0 +1,36 @@
+package openai
+
+import (
+	"net/http"
+	"strings"
+	"time"
+
+	"claude-gateway/src/internal/upstream"
+)
+
+// ParseRateLimit reads retry-after and the x-ratelimit-* headers. OpenAI
+// reports resets as durations such as "6m0s" or "20ms".
+func ParseRateLimit(h http.Header, now time.Time) upstream.RateLimit {
+	return upstream.RateLimit{
+		RetryAfter: upstream.ParseRetryAfter(h, now),
+		Requests:   parseBudget(h, "requests", now),
+		Tokens:     parseBudget(h, "tokens", now),
+	}
+}
+
+func parseBudget(h http.Header, kind string, now time.Time) upstream.Budget {
+	var b upstream.Budget
+	remaining, ok := upstream.ParseInt(h, "x-ratelimit-remaining-"+kind)
+	if !ok {
+		return b
+	}
+	b.Known = true
+	b.Remaining = remaining
+	b.Limit, _ = upstream.ParseInt(h, "x-ratelimit-limit-"+kind)
+	if v := strings.TrimSpace(h.Get("x-ratelimit-reset-" + kind)); v != "" {
+		if d, err := time.ParseDuration(v); err == nil {
+			b.Reset = now.Add(d)
+		}
+	}
+	return b
+}
@@ -0,0 +1,142 @@
+// Package rawjson edits top-level fields of a JSON object in place, leaving
+// every other byte of the document untouched. Facades use it so that fields
+// the gateway does not model still reach the upstream verbatim.
+package rawjson
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+var ErrNotObject = errors.New("rawjson: body is not a JSON object")
+
+// span locates one member of the top-level object: the byte range of the
+// whole member (key through value) and of the value alone.
+type span struct {
+	memberStart, valueStart, valueEnd int
+}
+
+// scan returns the members of the top-level object keyed by name and the
+// offset of its closing brace.
+func scan(body []byte) (map[string]span, int, error) {
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.UseNumber()
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, 0, ErrNotObject
+	}
+	if d, ok := tok.(json.Delim); !ok || d != '{' {
+		return nil, 0, ErrNotObject
+	}
+	members := map[string]span{}
+	for dec.More() {
+		memberStart := skipSpaceAndComma(body, int(dec.InputOffset()))
+		tok, err := dec.Token()
+		if err != nil {
+			return nil, 0, err
+		}
+		key, _ := tok.(string)
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err != nil {
+			return nil, 0, err
+		}
+		end := int(dec.InputOffset())
+		members[key] = span{memberStart: memberStart, valueStart: end - len(raw), valueEnd: end}
+	}
+	if _, err := dec.Token(); err != nil {
+		return nil, 0, err
+	}
+	closing := int(dec.InputOffset()) - 1
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, 0, ErrNotObject
+	}
+	return members, closing, nil
+}
+
+func skipSpaceAndComma(b []byte, i int) int {
+	for i < len(b) {
+		switch b[i] {
+		case ' ', '\t', '\r', '\n', ',':
+			i++
+		default:
+			return i
+		}
+	}
+	return i
+}
+
+// Get returns the raw value of a top-level field.
+func Get(body []byte, key string) (json.RawMessage, bool) {
+	members, _, err := scan(body)
+	if err != nil {
+		return nil, false
+	}
+	s, ok := members[key]
+	if !ok {
+		return nil, false
+	}
+	return json.RawMessage(body[s.valueStart:s.valueEnd]), true
+}
+
+// Set replaces the value of a top-level field, appending the field if it is
+// absent. value is marshalled unless it is already a json.RawMessage.
+func Set(body []byte, key string, value any) ([]byte, error) {
+	members, closing, err := scan(body)
+	if err != nil {
+		return nil, err
+	}
+	v, ok := value.(json.RawMessage)
+	if !ok {
+		if v, err = json.Marshal(value); err != nil {
+			return nil, err
+		}
+	}
+	var out bytes.Buffer
+	if s, ok := members[key]; ok {
+		out.Grow(len(body) - (s.valueEnd - s.valueStart) + len(v))
+		out.Write(body[:s.valueStart])
+		out.Write(v)
+		out.Write(body[s.valueEnd:])
+		return out.Bytes(), nil
+	}
+	k, _ := json.Marshal(key)
+	out.Grow(len(body) + len(k) + len(v) + 2)
+	head := bytes.TrimRight(body[:closing], " \t\r\n")
+	out.Write(head)
+	if len(members) > 0 {
+		out.WriteByte(',')
+	}
+	out.Write(k)
+	out.WriteByte(':')
+	out.Write(v)
+	out.Write(body[len(head):])
+	return out.Bytes(), nil
+}
+
+// Delete removes a top-level field. A missing field is not an error.
+func Delete(body []byte, key string) ([]byte, error) {
+	members, _, err := scan(body)
+	if err != nil {
+		return nil, err
+	}
+	s, ok := members[key]
+	if !ok {
+		return body, nil
+	}
+	start, end := s.memberStart, skipSpaceAndComma(body, s.valueEnd)
+	if end < len(body) && body[end] == '}' {
+		// Last member: drop the comma before it instead of after it.
+		end = s.valueEnd
+		for start > 0 && bytes.IndexByte([]byte(" \t\r\n,"), body[start-1]) >= 0 {
+			start--
+			if body[start] == ',' {
+				break
+			}
+		}
+	}
+	out := make([]byte, 0, len(body)-(end-start))
+	out = append(out, body[:start]...)
+	return append(out, body[end:]...), nil
+}
@@ -0,0 +1,52 @@
+package rawjson
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSetKeepsOtherBytes(t *testing.T) {
+	in := `{"model": "a", "seed": 12345678901234567890, "thinking": {"type":"enabled"}}`
+	out, err := Set([]byte(in), "model", "b")
+	if err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	want := `{"model": "b", "seed": 12345678901234567890, "thinking": {"type":"enabled"}}`
+	if string(out) != want {
+		t.Fatalf("got %s, want %s", out, want)
+	}
+
+	out, err = Set([]byte(`{"a":1 }`), "b", json.RawMessage(`[1]`))
+	if err != nil || string(out) != `{"a":1,"b":[1] }` {
+		t.Fatalf("append: %s %v", out, err)
+	}
+	out, err = Set([]byte(`{}`), "b", 2)
+	if err != nil || string(out) != `{"b":2}` {
+		t.Fatalf("append to empty: %s %v", out, err)
+	}
+	if _, err := Set([]byte(`[1]`), "b", 2); err == nil {
+		t.Fatalf("expected error for non-object")
+	}
+}
+
+func TestGetAndDelete(t *testing.T) {
+	in := []byte(`{"a": {"x":1}, "b": 2, "c": 3}`)
+	if v, ok := Get(in, "a"); !ok || string(v) != `{"x":1}` {
+		t.Fatalf("Get a = %s %v", v, ok)
+	}
+	cases := map[string]string{
+		"a": `{"b": 2, "c": 3}`,
+		"b": `{"a": {"x":1}, "c": 3}`,
+		"c": `{"a": {"x":1}, "b": 2}`,
+		"z": `{"a": {"x":1}, "b": 2, "c": 3}`,
+	}
+	for key, want := range cases {
+		out, err := Delete(in, key)
+		if err != nil || string(out) != want {
+			t.Fatalf("Delete %s = %s %v, want %s", key, out, err, want)
+		}
+	}
+	if out, _ := Delete([]byte(`{"a":1}`), "a"); string(out) != `{}` {
+		t.Fatalf("Delete only = %s", out)
+	}
+}
@@ -0,0 +1,62 @@
+// Package respcache stores the complete responses of deterministic requests
+// so that repeating one does not cost another upstream call. Pools opt in
+// by setting a TTL; entries are kept in memory or in MySQL.
+package respcache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strconv"
+	"time"
+)
+
+// Store keeps cache entries. Stores are best-effort: a failed Get is a
+// miss and a failed Set is dropped.
+type Store interface {
+	Get(ctx context.Context, key string) ([]byte, bool)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration)
+}
+
+// DefaultMaxEntryBytes bounds a single cached response.
+const DefaultMaxEntryBytes = 1 << 20
+
+// Cacheable reports whether a request body asks for a deterministic
+// answer: temperature 0 and at most one choice.
+func Cacheable(body []byte) bool {
+	var req struct {
+		Temperature *float64 `json:"temperature"`
+		N           *int     `json:"n"`
+	}
+	if json.Unmarshal(body, &req) != nil {
+		return false
+	}
+	return req.Temperature != nil && *req.Temperature == 0 && (req.N == nil || *req.N <= 1)
+}
+
+// ignoredFields do not change the answer: the model is keyed by its mapped
+// name, and streamed and non-streamed requests share entries.
+var ignoredFields = []string{"model", "stream", "stream_options"}
+
+// Key identifies a response by pool, facade, upstream model and the request
+// body. The body is canonicalized so that key order and whitespace do not
+// matter.
+func Key(poolID uint64, facade, model string, body []byte) (string, error) {
+	// Numbers decode as float64, so 0 and 0.0 give the same key.
+	var fields map[string]any
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return "", err
+	}
+	for _, f := range ignoredFields {
+		delete(fields, f)
+	}
+	canon, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+	h := sha256.New()
+	h.Write([]byte(strconv.FormatUint(poolID, 10) + "\n" + facade + "\n" + model + "\n"))
+	h.Write(canon)
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
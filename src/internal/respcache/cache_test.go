@@ -0,0 +1,59 @@
+package respcache
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestKeyIgnoresOrderModelAndStream(t *testing.T) {
+	a, err := Key(1, "openai", "gpt-4o", []byte(`{"model":"alias","temperature":0,"messages":[{"role":"user","content":"hi"}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := Key(1, "openai", "gpt-4o", []byte(`{"messages":[{"content":"hi","role":"user"}], "stream":true, "temperature":0.0, "model":"other"}`))
+	if a != b {
+		t.Fatalf("keys differ: %s %s", a, b)
+	}
+	if c, _ := Key(2, "openai", "gpt-4o", []byte(`{"temperature":0,"messages":[{"role":"user","content":"hi"}]}`)); c == a {
+		t.Fatal("pools must not share entries")
+	}
+}
+
+func TestCacheable(t *testing.T) {
+	cases := map[string]bool{
+		`{"temperature":0}`:       true,
+		`{"temperature":0,"n":1}`: true,
+		`{"temperature":0,"n":2}`: false,
+		`{"temperature":0.7}`:     false,
+		`{"messages":[]}`:         false,
+	}
+	for body, want := range cases {
+		if got := Cacheable([]byte(body)); got != want {
+			t.Errorf("Cacheable(%s) = %v, want %v", body, got, want)
+		}
+	}
+}
+
+func TestMemoryEvictsAndExpires(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(10, 6)
+	m.Set(ctx, "a", []byte("aaaa"), time.Minute)
+	m.Set(ctx, "b", []byte("bbbb"), time.Minute)
+	m.Set(ctx, "big", []byte("1234567"), time.Minute)
+	if _, ok := m.Get(ctx, "big"); ok {
+		t.Fatal("entry over the entry limit was stored")
+	}
+	m.Set(ctx, "c", []byte("cccc"), time.Minute)
+	if _, ok := m.Get(ctx, "a"); ok {
+		t.Fatal("least recently used entry was not evicted")
+	}
+	if v, ok := m.Get(ctx, "c"); !ok || string(v) != "cccc" {
+		t.Fatalf("Get(c) = %q, %v", v, ok)
+	}
+	m.Set(ctx, "d", []byte("d"), time.Nanosecond)
+	time.Sleep(time.Millisecond)
+	if _, ok := m.Get(ctx, "d"); ok {
+		t.Fatal("expired entry was served")
+	}
+}
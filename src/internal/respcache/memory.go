@@ -0,0 +1,72 @@
+package respcache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory is an in-process Store that evicts the least recently used
+// entries beyond maxBytes.
+type Memory struct {
+	maxBytes int
+	maxEntry int
+
+	mu    sync.Mutex
+	size  int
+	order *list.List
+	items map[string]*list.Element
+}
+
+type memEntry struct {
+	key       string
+	value     []byte
+	expiresAt time.Time
+}
+
+func NewMemory(maxBytes, maxEntryBytes int) *Memory {
+	if maxEntryBytes <= 0 || maxEntryBytes > maxBytes {
+		maxEntryBytes = maxBytes
+	}
+	return &Memory{maxBytes: maxBytes, maxEntry: maxEntryBytes, order: list.New(), items: map[string]*list.Element{}}
+}
+
+func (m *Memory) Get(_ context.Context, key string) ([]byte, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false
+	}
+	e := el.Value.(*memEntry)
+	if time.Now().After(e.expiresAt) {
+		m.remove(el)
+		return nil, false
+	}
+	m.order.MoveToFront(el)
+	return e.value, true
+}
+
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) {
+	if len(value) > m.maxEntry || ttl <= 0 {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+	m.items[key] = m.order.PushFront(&memEntry{key: key, value: value, expiresAt: time.Now().Add(ttl)})
+	m.size += len(value)
+	for m.size > m.maxBytes {
+		m.remove(m.order.Back())
+	}
+}
+
+// remove drops an entry. The caller must hold m.mu.
+func (m *Memory) remove(el *list.Element) {
+	e := m.order.Remove(el).(*memEntry)
+	delete(m.items, e.key)
+	m.size -= len(e.value)
+}
@@ -0,0 +1,60 @@
+package respcache
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// purgeEvery is how many writes pass between deletions of expired rows.
+const purgeEvery = 100
+
+// MySQL is a Store in the response_cache table, shared by every gateway
+// instance on the database.
+type MySQL struct {
+	db       *sql.DB
+	maxEntry int
+	writes   atomic.Int64
+}
+
+func NewMySQL(db *sql.DB, maxEntryBytes int) *MySQL {
+	if maxEntryBytes <= 0 {
+		maxEntryBytes = DefaultMaxEntryBytes
+	}
+	return &MySQL{db: db, maxEntry: maxEntryBytes}
+}
+
+func (m *MySQL) Get(ctx context.Context, key string) ([]byte, bool) {
+	var value []byte
+	err := m.db.QueryRowContext(ctx, `SELECT value FROM response_cache WHERE cache_key = ? AND expires_at > ?`, key, time.Now().UTC()).Scan(&value)
+	if err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("response cache get: %v", err)
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+func (m *MySQL) Set(ctx context.Context, key string, value []byte, ttl time.Duration) {
+	if len(value) > m.maxEntry || ttl <= 0 {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	now := time.Now().UTC()
+	_, err := m.db.ExecContext(ctx,
+		`INSERT INTO response_cache (cache_key, value, expires_at) VALUES (?, ?, ?)
+		 ON DUPLICATE KEY UPDATE value = VALUES(value), expires_at = VALUES(expires_at)`,
+		key, value, now.Add(ttl))
+	if err != nil {
+		log.Printf("response cache set: %v", err)
+		return
+	}
+	if m.writes.Add(1)%purgeEvery == 0 {
+		if _, err := m.db.ExecContext(ctx, `DELETE FROM response_cache WHERE expires_at <= ? LIMIT 1000`, now); err != nil {
+			log.Printf("response cache purge: %v", err)
+		}
+	}
+}
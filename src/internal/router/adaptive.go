@@ -0,0 +1,77 @@
+package router
+
+import (
+	"math"
+	"net/http"
+)
+
+// Adaptive (AIMD) concurrency: the effective limit grows by roughly one slot
+// per window of successful requests and is cut multiplicatively when the
+// upstream signals overload, never exceeding the configured limit.
+const (
+	adaptiveDefaultCeiling   = 64
+	adaptiveMinLimit         = 1.0
+	adaptiveBackoffOverload  = 0.5
+	adaptiveBackoffLatency   = 0.8
+	adaptiveLatencySpikeMult = 2.5
+)
+
+func adaptiveCeiling(cred credentialRow) float64 {
+	if cred.ConcurrencyLimit > 0 {
+		return float64(cred.ConcurrencyLimit)
+	}
+	return adaptiveDefaultCeiling
+}
+
+// effectiveConcurrencyLimit returns the limit to enforce for cred, or 0 when
+// the credential is unlimited.
+func (r *Router) effectiveConcurrencyLimit(cred credentialRow) int {
+	if !cred.AdaptiveConcurrency {
+		return cred.ConcurrencyLimit
+	}
+	ceiling := adaptiveCeiling(cred)
+	st := r.loadCredentialState(cred.ID)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.adaptiveLimit <= 0 || st.adaptiveLimit > ceiling {
+		st.adaptiveLimit = ceiling
+	}
+	return int(math.Max(adaptiveMinLimit, math.Floor(st.adaptiveLimit)))
+}
+
+// adjustAdaptiveLimit applies one AIMD step to cred, the zero row when the
+// credential is no longer configured. The caller must hold st.mu, and so
+// must resolve cred before taking it.
+func (r *Router) adjustAdaptiveLimit(cred credentialRow, st *credentialState, ok bool, status int, latMs, prevEWMA float64) {
+	if !cred.AdaptiveConcurrency {
+		return
+	}
+	ceiling := adaptiveCeiling(cred)
+	limit := st.adaptiveLimit
+	if limit <= 0 || limit > ceiling {
+		limit = ceiling
+	}
+
+	switch {
+	case status == http.StatusTooManyRequests || status == http.StatusServiceUnavailable || status == 529:
+		limit *= adaptiveBackoffOverload
+	case ok && prevEWMA > 0 && latMs > prevEWMA*adaptiveLatencySpikeMult:
+		limit *= adaptiveBackoffLatency
+	case ok:
+		limit += 1 / limit
+	default:
+		return
+	}
+	limit = math.Min(ceiling, math.Max(adaptiveMinLimit, limit))
+	st.adaptiveLimit = limit
+	if r.m != nil {
+		r.m.SetCredentialConcurrencyLimit(cred.ID, math.Floor(limit))
+	}
+}
+
+func (r *Router) cachedCredential(credentialID uint64) (credentialRow, bool) {
+	r.cacheMu.RLock()
+	defer r.cacheMu.RUnlock()
+	cred, ok := r.cache.credentials[credentialID]
+	return cred, ok
+}
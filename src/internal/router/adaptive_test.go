@@ -0,0 +1,43 @@
+package router
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveLimitBacksOffAndRecovers(t *testing.T) {
+	r := New(nil, nil, nil)
+	cred := credentialRow{ID: 5, ConcurrencyLimit: 8, AdaptiveConcurrency: true, Enabled: true}
+	r.cache.credentials = map[uint64]credentialRow{5: cred}
+
+	if got := r.effectiveConcurrencyLimit(cred); got != 8 {
+		t.Fatalf("expected initial limit 8, got %d", got)
+	}
+
+	r.startRequest(5)
+	r.EndRequest(5, false, http.StatusTooManyRequests, 100*time.Millisecond)
+	if got := r.effectiveConcurrencyLimit(cred); got != 4 {
+		t.Fatalf("expected limit 4 after 429, got %d", got)
+	}
+
+	for i := 0; i < 8; i++ {
+		r.startRequest(5)
+		r.EndRequest(5, true, http.StatusOK, 100*time.Millisecond)
+	}
+	if got := r.effectiveConcurrencyLimit(cred); got != 5 {
+		t.Fatalf("expected limit 5 after a window of successes, got %d", got)
+	}
+}
+
+func TestAdaptiveLimitIgnoredWhenDisabled(t *testing.T) {
+	r := New(nil, nil, nil)
+	cred := credentialRow{ID: 6, ConcurrencyLimit: 3, Enabled: true}
+	r.cache.credentials = map[uint64]credentialRow{6: cred}
+
+	r.startRequest(6)
+	r.EndRequest(6, false, http.StatusTooManyRequests, 100*time.Millisecond)
+	if got := r.effectiveConcurrencyLimit(cred); got != 3 {
+		t.Fatalf("expected static limit 3, got %d", got)
+	}
+}
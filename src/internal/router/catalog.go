@@ -0,0 +1,103 @@
+package router
+
+import (
+	"context"
+	"database/sql"
+)
+
+// ModelCaps describes what an upstream model supports. A nil capability is
+// unknown and treated as supported, so an empty catalog changes nothing.
+type ModelCaps struct {
+	ContextWindow   int
+	MaxOutputTokens int
+	Tools           *bool
+	Vision          *bool
+	Thinking        *bool
+	JSONMode        *bool
+}
+
+// Requirements are the features a request needs from the upstream model.
+type Requirements struct {
+	Tools        bool
+	Vision       bool
+	Thinking     bool
+	JSONMode     bool
+	PromptTokens int
+}
+
+// Satisfies reports whether the model can serve req, and if not, which
+// feature is missing.
+func (c ModelCaps) Satisfies(req Requirements) (bool, string) {
+	switch {
+	case req.Tools && c.Tools != nil && !*c.Tools:
+		return false, "tools"
+	case req.Vision && c.Vision != nil && !*c.Vision:
+		return false, "vision"
+	case req.Thinking && c.Thinking != nil && !*c.Thinking:
+		return false, "thinking"
+	case req.JSONMode && c.JSONMode != nil && !*c.JSONMode:
+		return false, "json_mode"
+	case c.ContextWindow > 0 && req.PromptTokens > c.ContextWindow:
+		return false, "context_window"
+	}
+	return true, ""
+}
+
+func loadCatalog(ctx context.Context, db *sql.DB, out map[string]ModelCaps) error {
+	rows, err := db.QueryContext(ctx, `SELECT model, context_window, max_output_tokens, supports_tools, supports_vision, supports_thinking, supports_json_mode FROM model_catalog`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			model                           string
+			ctxWin, maxOut                  sql.NullInt64
+			tools, vision, thinking, jsonMd sql.NullBool
+		)
+		if err := rows.Scan(&model, &ctxWin, &maxOut, &tools, &vision, &thinking, &jsonMd); err != nil {
+			return err
+		}
+		out[model] = ModelCaps{
+			ContextWindow:   int(ctxWin.Int64),
+			MaxOutputTokens: int(maxOut.Int64),
+			Tools:           nullBoolPtr(tools),
+			Vision:          nullBoolPtr(vision),
+			Thinking:        nullBoolPtr(thinking),
+			JSONMode:        nullBoolPtr(jsonMd),
+		}
+	}
+	return rows.Err()
+}
+
+func nullBoolPtr(v sql.NullBool) *bool {
+	if !v.Valid {
+		return nil
+	}
+	b := v.Bool
+	return &b
+}
+
+// resolveUpstreamModel applies the provider and pool model maps and falls
+// back to the closest listed model. ok is false when the provider lists its
+// models and none fits.
+func resolveUpstreamModel(prov providerRow, pool poolRow, model string) (string, bool) {
+	upModel := model
+	if mapped, ok := prov.ModelMap[model]; ok && mapped != "" {
+		upModel = mapped
+	}
+	if mapped, ok := pool.ModelMap[model]; ok && mapped != "" {
+		upModel = mapped
+	}
+	if len(prov.Models) > 0 {
+		if _, ok := prov.Models[upModel]; !ok {
+			fb := pickModelForAlias(prov.Models, model)
+			if fb == "" {
+				return upModel, false
+			}
+			upModel = fb
+		}
+	}
+	return upModel, true
+}
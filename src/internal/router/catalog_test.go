@@ -0,0 +1,70 @@
+package router
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPickSkipsModelsLackingFeatures(t *testing.T) {
+	no := false
+	cfg := loadedConfig{
+		providers: map[uint64]providerRow{
+			1: {ID: 1, ModelMap: map[string]string{"claude": "text-only-model"}},
+			2: {ID: 2, ModelMap: map[string]string{"claude": "vision-model"}},
+		},
+		credentials: map[uint64]credentialRow{
+			10: {ID: 10, ProviderID: 1, Enabled: true},
+			20: {ID: 20, ProviderID: 2, Enabled: true},
+		},
+		catalog: map[string]ModelCaps{
+			"text-only-model": {Vision: &no, MaxOutputTokens: 4096},
+			"vision-model":    {ContextWindow: 1000},
+		},
+	}
+	pool := poolRow{ID: 1, CredentialIDs: []uint64{10, 20}, Enabled: true}
+	r := New(nil, nil, nil)
+
+	for i := 0; i < 4; i++ {
+		id, err := r.pickCredentialFromPool(cfg, pool, "anthropic", "claude", nil, Requirements{Vision: true})
+		if err != nil || id != 20 {
+			t.Fatalf("expected vision-capable credential 20, got %d (%v)", id, err)
+		}
+	}
+	if _, err := r.pickCredentialFromPool(cfg, pool, "anthropic", "claude", nil, Requirements{Vision: true, PromptTokens: 5000}); err == nil {
+		t.Fatalf("expected no upstream for an oversized vision prompt")
+	}
+}
+
+func TestPickFallsThroughTiersLackingFeatures(t *testing.T) {
+	cfg := loadedConfig{
+		providers: map[uint64]providerRow{
+			1: {ID: 1, ModelMap: map[string]string{"claude": "short-model"}},
+			2: {ID: 2, ModelMap: map[string]string{"claude": "long-model"}},
+		},
+		credentials: map[uint64]credentialRow{
+			10: {ID: 10, ProviderID: 1, Enabled: true},
+			20: {ID: 20, ProviderID: 2, Enabled: true},
+		},
+		providerCreds: map[uint64][]uint64{1: {10}, 2: {20}},
+		catalog: map[string]ModelCaps{
+			"short-model": {ContextWindow: 1000},
+			"long-model":  {ContextWindow: 100000},
+		},
+	}
+	pool := poolRow{ID: 1, Enabled: true, Tiers: []Tier{
+		{Name: "t1", Strategy: "priority", Items: []TierItem{{ProviderID: 1}}},
+		{Name: "t2", Strategy: "priority", Items: []TierItem{{ProviderID: 2}}},
+	}}
+	r := New(nil, nil, nil)
+
+	if id, err := r.pickCredentialFromPool(cfg, pool, "anthropic", "claude", nil, Requirements{PromptTokens: 5000}); err != nil || id != 20 {
+		t.Fatalf("expected the long-context tier, got %d (%v)", id, err)
+	}
+	if id, err := r.pickCredentialFromPool(cfg, pool, "anthropic", "claude", nil, Requirements{PromptTokens: 100}); err != nil || id != 10 {
+		t.Fatalf("expected the first tier for a short prompt, got %d (%v)", id, err)
+	}
+	r.OpenCircuit(10, time.Minute)
+	if _, err := r.pickCredentialFromPool(cfg, pool, "anthropic", "claude", nil, Requirements{PromptTokens: 100}); err == nil {
+		t.Fatal("unavailable first tier fell through to the next")
+	}
+}
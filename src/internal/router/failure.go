@@ -0,0 +1,39 @@
+package router
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"claude-gateway/src/internal/upstream"
+)
+
+// ReportFailure acts on a classified upstream error. Permanent failures
+// (exhausted quota, revoked key) disable the credential in the database so it
+// is not retried after every cooldown; transient ones are left to EndRequest.
+func (r *Router) ReportFailure(ctx context.Context, credentialID uint64, f upstream.Failure) {
+	if credentialID == 0 || !f.Permanent() {
+		return
+	}
+
+	// Keep the credential out of rotation until the config reload drops it.
+	st := r.loadCredentialState(credentialID)
+	st.mu.Lock()
+	st.openUntil = time.Now().Add(2 * r.cacheTTL)
+	st.mu.Unlock()
+	r.forgetRoutesTo(credentialID)
+
+	if r.db == nil {
+		return
+	}
+	reason := f.Reason()
+	_, err := r.db.ExecContext(context.WithoutCancel(ctx),
+		`UPDATE credentials SET enabled = 0, disabled_reason = ?, disabled_at = NOW() WHERE id = ? AND enabled = 1`,
+		reason, credentialID)
+	if err != nil {
+		log.Printf("failed to disable credential %d (%s): %v", credentialID, reason, err)
+		return
+	}
+	log.Printf("credential %d disabled: %s", credentialID, reason)
+	r.InvalidateConfig()
+}
@@ -0,0 +1,113 @@
+package router
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// StaleMapping is a ModelMap entry whose target model is not listed by any
+// provider it can route to.
+type StaleMapping struct {
+	Scope   string // "provider" or "pool"
+	OwnerID uint64
+	Alias   string
+	Target  string
+}
+
+// StaleModelMappings reports ModelMap entries that point at models the
+// upstream catalogs no longer list. Providers without a known catalog are
+// never reported. Callers that have just stored a model list invalidate the
+// config first.
+func (r *Router) StaleModelMappings(ctx context.Context) ([]StaleMapping, error) {
+	cfg, err := r.getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return staleModelMappings(cfg), nil
+}
+
+// InvalidateConfig forces the next routing decision to reload providers,
+// credentials and pools from the database.
+func (r *Router) InvalidateConfig() {
+	r.cacheMu.Lock()
+	r.cache.loadedAt = time.Time{}
+	r.cacheMu.Unlock()
+}
+
+func staleModelMappings(cfg loadedConfig) []StaleMapping {
+	var out []StaleMapping
+	for _, prov := range cfg.providers {
+		if len(prov.Models) == 0 {
+			continue
+		}
+		for alias, target := range prov.ModelMap {
+			if target != "" && !prov.Models[target] {
+				out = append(out, StaleMapping{Scope: "provider", OwnerID: prov.ID, Alias: alias, Target: target})
+			}
+		}
+	}
+
+	for _, pool := range cfg.pools {
+		if len(pool.ModelMap) == 0 {
+			continue
+		}
+		provs := poolProviderIDs(cfg, pool)
+		for alias, target := range pool.ModelMap {
+			if target == "" || len(provs) == 0 {
+				continue
+			}
+			listed, known := false, true
+			for _, pid := range provs {
+				prov, ok := cfg.providers[pid]
+				if !ok || len(prov.Models) == 0 {
+					known = false
+					break
+				}
+				if prov.Models[target] {
+					listed = true
+					break
+				}
+			}
+			if known && !listed {
+				out = append(out, StaleMapping{Scope: "pool", OwnerID: pool.ID, Alias: alias, Target: target})
+			}
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Scope != out[j].Scope {
+			return out[i].Scope < out[j].Scope
+		}
+		if out[i].OwnerID != out[j].OwnerID {
+			return out[i].OwnerID < out[j].OwnerID
+		}
+		return out[i].Alias < out[j].Alias
+	})
+	return out
+}
+
+func poolProviderIDs(cfg loadedConfig, pool poolRow) []uint64 {
+	seen := map[uint64]bool{}
+	var out []uint64
+	add := func(id uint64) {
+		if id != 0 && !seen[id] {
+			seen[id] = true
+			out = append(out, id)
+		}
+	}
+	if len(pool.Tiers) > 0 {
+		for _, t := range pool.Tiers {
+			for _, it := range t.Items {
+				add(it.ProviderID)
+			}
+		}
+		return out
+	}
+	for _, cid := range pool.CredentialIDs {
+		if cred, ok := cfg.credentials[cid]; ok {
+			add(cred.ProviderID)
+		}
+	}
+	return out
+}
@@ -0,0 +1,26 @@
+package router
+
+import "testing"
+
+func TestStaleModelMappings(t *testing.T) {
+	cfg := loadedConfig{
+		providers: map[uint64]providerRow{
+			1: {ID: 1, ModelMap: map[string]string{"claude-sonnet": "claude-3-5-sonnet-20240620"}, Models: map[string]bool{"claude-sonnet-4-20250514": true}},
+			2: {ID: 2, ModelMap: map[string]string{"gpt": "gpt-4o"}},
+		},
+		credentials: map[uint64]credentialRow{10: {ID: 10, ProviderID: 1}},
+		pools: map[uint64]poolRow{
+			5: {ID: 5, CredentialIDs: []uint64{10}, ModelMap: map[string]string{"fast": "claude-3-haiku", "big": "claude-sonnet-4-20250514"}},
+		},
+	}
+	got := staleModelMappings(cfg)
+	if len(got) != 2 {
+		t.Fatalf("expected 2 stale mappings, got %#v", got)
+	}
+	if got[0].Scope != "pool" || got[0].Alias != "fast" {
+		t.Fatalf("unexpected first mapping %#v", got[0])
+	}
+	if got[1].Scope != "provider" || got[1].OwnerID != 1 || got[1].Target != "claude-3-5-sonnet-20240620" {
+		t.Fatalf("unexpected second mapping %#v", got[1])
+	}
+}
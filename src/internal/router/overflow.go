@@ -0,0 +1,80 @@
+package router
+
+import "fmt"
+
+// Pool settings for prompts too long for every upstream of the requested
+// model: reject them, route them to the pool's OverflowModel, or drop
+// turns from the middle of the conversation until the prompt fits.
+const (
+	OverflowReject   = "reject"
+	OverflowRoute    = "route"
+	OverflowTruncate = "truncate"
+)
+
+// ErrContextOverflow is returned instead of ErrNoAvailableUpstream when an
+// upstream could serve the request but for the length of its prompt.
+type ErrContextOverflow struct {
+	PoolID        uint64
+	PoolName      string
+	Model         string
+	PromptTokens  int
+	ContextWindow int
+	Strategy      string
+	OverflowModel string
+}
+
+func (e *ErrContextOverflow) Error() string {
+	return fmt.Sprintf("prompt of about %d tokens exceeds the %d-token context window of every upstream for model %s in pool %s",
+		e.PromptTokens, e.ContextWindow, e.Model, e.PoolName)
+}
+
+// contextOverflow tells whether a failed pick failed only on the prompt
+// length, by picking again as if the prompt were empty.
+func (r *Router) contextOverflow(cfg loadedConfig, pool poolRow, facade, model string, exclude map[uint64]bool, need Requirements) *ErrContextOverflow {
+	if need.PromptTokens == 0 {
+		return nil
+	}
+	relaxed := need
+	relaxed.PromptTokens = 0
+	if _, err := r.pickCredentialFromPool(cfg, pool, facade, model, exclude, relaxed); err != nil {
+		return nil
+	}
+	return &ErrContextOverflow{
+		PoolID:        pool.ID,
+		PoolName:      pool.Name,
+		Model:         model,
+		PromptTokens:  need.PromptTokens,
+		ContextWindow: largestContextWindow(cfg, pool, model),
+		Strategy:      pool.OverflowStrategy,
+		OverflowModel: pool.OverflowModel,
+	}
+}
+
+// largestContextWindow is the largest catalog context window among the
+// models the pool's providers would be asked for.
+func largestContextWindow(cfg loadedConfig, pool poolRow, model string) int {
+	provIDs := map[uint64]bool{}
+	for _, tier := range pool.Tiers {
+		if tierAppliesToModel(tier, model) {
+			for _, it := range tier.Items {
+				provIDs[it.ProviderID] = true
+			}
+		}
+	}
+	if len(pool.Tiers) == 0 {
+		for _, id := range pool.CredentialIDs {
+			provIDs[cfg.credentials[id].ProviderID] = true
+		}
+	}
+	best := 0
+	for id := range provIDs {
+		prov, ok := cfg.providers[id]
+		if !ok {
+			continue
+		}
+		if upModel, ok := resolveUpstreamModel(prov, pool, model); ok {
+			best = max(best, cfg.catalog[upModel].ContextWindow)
+		}
+	}
+	return best
+}
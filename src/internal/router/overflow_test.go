@@ -0,0 +1,84 @@
+package router
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"testing"
+	"time"
+
+	"claude-gateway/src/internal/crypto"
+)
+
+func TestContextOverflow(t *testing.T) {
+	cfg := loadedConfig{
+		providers: map[uint64]providerRow{
+			1: {ID: 1, ModelMap: map[string]string{"sonnet": "small"}},
+			2: {ID: 2, ModelMap: map[string]string{"sonnet": "medium"}},
+		},
+		credentials: map[uint64]credentialRow{
+			10: {ID: 10, ProviderID: 1, Enabled: true},
+			20: {ID: 20, ProviderID: 2, Enabled: true},
+		},
+		catalog: map[string]ModelCaps{
+			"small":  {ContextWindow: 1000},
+			"medium": {ContextWindow: 4000},
+		},
+	}
+	pool := poolRow{ID: 1, Name: "p", CredentialIDs: []uint64{10, 20}, OverflowStrategy: OverflowTruncate, Enabled: true}
+	r := New(nil, nil, nil)
+
+	if id, err := r.pickCredentialFromPool(cfg, pool, "anthropic", "sonnet", nil, Requirements{PromptTokens: 3000}); err != nil || id != 20 {
+		t.Fatalf("prompt fits the medium model, got %d, %v", id, err)
+	}
+	o := r.contextOverflow(cfg, pool, "anthropic", "sonnet", nil, Requirements{PromptTokens: 5000})
+	if o == nil || o.ContextWindow != 4000 || o.Strategy != OverflowTruncate {
+		t.Fatalf("overflow = %+v", o)
+	}
+	if o := r.contextOverflow(cfg, pool, "anthropic", "sonnet", map[uint64]bool{10: true, 20: true}, Requirements{PromptTokens: 5000}); o != nil {
+		t.Fatal("overflow reported with no available credential")
+	}
+}
+
+func TestPromptFittingALaterTierIsNotAnOverflow(t *testing.T) {
+	cipher, err := crypto.NewAESGCMFromBase64Key(base64.StdEncoding.EncodeToString(make([]byte, 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := cipher.Encrypt([]byte("sk-test"))
+	pool := poolRow{ID: 1, Name: "p", ClientKey: "ck", OverflowStrategy: OverflowTruncate, Enabled: true, Tiers: []Tier{
+		{Name: "t1", Strategy: "priority", Items: []TierItem{{ProviderID: 1}}},
+		{Name: "t2", Strategy: "priority", Items: []TierItem{{ProviderID: 2}}},
+	}}
+	r := New(nil, nil, cipher)
+	r.cache = loadedConfig{
+		loadedAt: time.Now(),
+		providers: map[uint64]providerRow{
+			1: {ID: 1, ModelMap: map[string]string{"sonnet": "small"}},
+			2: {ID: 2, ModelMap: map[string]string{"sonnet": "large"}},
+		},
+		credentials: map[uint64]credentialRow{
+			10: {ID: 10, ProviderID: 1, APIKeyCiphertext: key, Enabled: true},
+			20: {ID: 20, ProviderID: 2, APIKeyCiphertext: key, Enabled: true},
+		},
+		providerCreds:   map[uint64][]uint64{1: {10}, 2: {20}},
+		pools:           map[uint64]poolRow{1: pool},
+		poolByClientKey: map[string]poolRow{"ck": pool},
+		catalog: map[string]ModelCaps{
+			"small": {ContextWindow: 1000},
+			"large": {ContextWindow: 100000},
+		},
+	}
+
+	// Only an ErrContextOverflow makes the executor truncate or reroute and
+	// set X-Context-Overflow.
+	up, err := r.pickUpstream(context.Background(), "ck", "anthropic", "sonnet", nil, Requirements{PromptTokens: 5000})
+	if err != nil || up.CredentialID != 20 || up.Model != "large" {
+		t.Fatalf("got %+v, %v; want the large model of tier t2", up, err)
+	}
+	_, err = r.pickUpstream(context.Background(), "ck", "anthropic", "sonnet", nil, Requirements{PromptTokens: 200000})
+	var overflow *ErrContextOverflow
+	if !errors.As(err, &overflow) || overflow.ContextWindow != 100000 {
+		t.Fatalf("oversized prompt: %v", err)
+	}
+}
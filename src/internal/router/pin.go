@@ -0,0 +1,91 @@
+package router
+
+import (
+	"context"
+	"sort"
+	"time"
+)
+
+// Pin sends a request to a given provider or credential instead of the
+// one its pool would pick; the pool still supplies the model mapping and
+// settings. Admin replays pin requests through the context, so clients
+// cannot.
+type Pin struct {
+	ProviderID   uint64
+	CredentialID uint64
+	// RespectLimits keeps credentials with an open circuit or at their
+	// concurrency limit out, as for routed requests. Shadow requests set
+	// it; admin replays override both.
+	RespectLimits bool
+}
+
+type pinKey struct{}
+
+// WithPin returns a context whose requests are routed to pin.
+func WithPin(ctx context.Context, pin Pin) context.Context {
+	return context.WithValue(ctx, pinKey{}, pin)
+}
+
+func pinFrom(ctx context.Context) (Pin, bool) {
+	pin, ok := ctx.Value(pinKey{}).(Pin)
+	return pin, ok && (pin.ProviderID != 0 || pin.CredentialID != 0)
+}
+
+// pinnedCredential resolves a pin to an enabled credential, preferring the
+// best scored one of a provider whose circuit is closed. Unless the pin
+// respects limits, circuits and concurrency limits do not rule a
+// credential out: a pin is an explicit choice.
+func (r *Router) pinnedCredential(cfg loadedConfig, pool poolRow, model string, pin Pin, exclude map[uint64]bool) (uint64, error) {
+	noUpstream := &ErrNoAvailableUpstream{Reason: "pinned_upstream_unavailable", PoolID: pool.ID, PoolName: pool.Name, Model: model, ProviderID: pin.ProviderID}
+	if pin.CredentialID != 0 {
+		cred, ok := cfg.credentials[pin.CredentialID]
+		if !ok || exclude[pin.CredentialID] || (pin.ProviderID != 0 && cred.ProviderID != pin.ProviderID) {
+			return 0, noUpstream
+		}
+		if pin.RespectLimits {
+			if r.isCredentialOpen(pin.CredentialID, time.Now()) {
+				return 0, noUpstream
+			}
+			if limit := r.effectiveConcurrencyLimit(cred); limit > 0 && r.getInflight(pin.CredentialID) >= int64(limit) {
+				return 0, noUpstream
+			}
+		}
+		return pin.CredentialID, nil
+	}
+	if pin.RespectLimits {
+		// As for split arms, a single-provider view of the pool applies the
+		// usual availability checks and stays out of the route cache.
+		view := pool
+		view.ID = 0
+		view.Tiers = []Tier{{Name: "pin", Strategy: "priority", Items: []TierItem{{ProviderID: pin.ProviderID}}}}
+		credID, err := r.pickCredentialFromPool(cfg, view, "", model, exclude, Requirements{})
+		if err != nil {
+			return 0, noUpstream
+		}
+		return credID, nil
+	}
+
+	ids := append([]uint64(nil), cfg.providerCreds[pin.ProviderID]...)
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	now := time.Now()
+	var (
+		best      uint64
+		bestScore float64
+		bestOpen  bool
+	)
+	for _, id := range ids {
+		cred, ok := cfg.credentials[id]
+		if !ok || exclude[id] {
+			continue
+		}
+		open := r.isCredentialOpen(id, now)
+		score := r.credentialScore(id, cred.Weight)
+		if best == 0 || (bestOpen && !open) || (open == bestOpen && score > bestScore) {
+			best, bestScore, bestOpen = id, score, open
+		}
+	}
+	if best == 0 {
+		return 0, noUpstream
+	}
+	return best, nil
+}
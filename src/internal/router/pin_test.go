@@ -0,0 +1,66 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestPinnedCredential(t *testing.T) {
+	cfg := loadedConfig{
+		credentials: map[uint64]credentialRow{
+			10: {ID: 10, ProviderID: 1, Enabled: true},
+			20: {ID: 20, ProviderID: 2, Enabled: true},
+			21: {ID: 21, ProviderID: 2, Enabled: true},
+		},
+		providerCreds: map[uint64][]uint64{1: {10}, 2: {21, 20}},
+	}
+	pool := poolRow{ID: 1, CredentialIDs: []uint64{10}, Enabled: true}
+	r := New(nil, nil, nil)
+
+	if id, err := r.pinnedCredential(cfg, pool, "m", Pin{CredentialID: 21}, nil); err != nil || id != 21 {
+		t.Fatalf("credential pin = %d, %v", id, err)
+	}
+	if _, err := r.pinnedCredential(cfg, pool, "m", Pin{ProviderID: 1, CredentialID: 21}, nil); err == nil {
+		t.Fatal("credential of another provider accepted")
+	}
+	id, err := r.pinnedCredential(cfg, pool, "m", Pin{ProviderID: 2}, map[uint64]bool{20: true})
+	if err != nil || id != 21 {
+		t.Fatalf("provider pin = %d, %v", id, err)
+	}
+	if _, err := r.pinnedCredential(cfg, pool, "m", Pin{ProviderID: 3}, nil); err == nil {
+		t.Fatal("provider without credentials accepted")
+	}
+
+	if _, ok := pinFrom(WithPin(context.Background(), Pin{})); ok {
+		t.Fatal("empty pin is in effect")
+	}
+}
+
+func TestPinRespectingLimits(t *testing.T) {
+	cfg := loadedConfig{
+		providers: map[uint64]providerRow{2: {ID: 2}},
+		credentials: map[uint64]credentialRow{
+			20: {ID: 20, ProviderID: 2, Enabled: true},
+			21: {ID: 21, ProviderID: 2, Enabled: true},
+		},
+		providerCreds: map[uint64][]uint64{2: {20, 21}},
+	}
+	pool := poolRow{ID: 1, Enabled: true}
+	r := New(nil, nil, nil)
+	r.OpenCircuit(20, time.Minute)
+
+	if _, err := r.pinnedCredential(cfg, pool, "m", Pin{CredentialID: 20, RespectLimits: true}, nil); err == nil {
+		t.Fatal("credential with an open circuit picked")
+	}
+	if id, err := r.pinnedCredential(cfg, pool, "m", Pin{ProviderID: 2, RespectLimits: true}, nil); err != nil || id != 21 {
+		t.Fatalf("provider pin = %d, %v", id, err)
+	}
+	r.OpenCircuit(21, time.Minute)
+	if _, err := r.pinnedCredential(cfg, pool, "m", Pin{ProviderID: 2, RespectLimits: true}, nil); err == nil {
+		t.Fatal("provider with every circuit open picked")
+	}
+	if _, err := r.pinnedCredential(cfg, pool, "m", Pin{ProviderID: 2}, nil); err != nil {
+		t.Fatalf("admin pin ruled out by circuits: %v", err)
+	}
+}
@@ -0,0 +1,60 @@
+package router
+
+import (
+	"time"
+
+	"claude-gateway/src/internal/upstream"
+)
+
+const (
+	// A 429 cooldown taken from the upstream's reset time is capped so a bogus
+	// header cannot park a credential for hours.
+	maxRateLimitCooldown = 10 * time.Minute
+	// Below this fraction of remaining budget the credential is down-weighted.
+	lowHeadroom = 0.1
+	// rateLimitFresh bounds how long a snapshot is trusted once observed.
+	rateLimitFresh = 10 * time.Minute
+)
+
+// ObserveRateLimit records the rate-limit headers of an upstream response.
+// Call it before EndRequest so a 429 can use the reported reset time.
+func (r *Router) ObserveRateLimit(credentialID uint64, rl upstream.RateLimit) {
+	if credentialID == 0 || rl.Empty() {
+		return
+	}
+	st := r.loadCredentialState(credentialID)
+	st.mu.Lock()
+	st.rateLimit = rl
+	st.rateLimitAt = time.Now()
+	st.mu.Unlock()
+}
+
+// rateLimitCooldown returns the cooldown deadline reported by the upstream,
+// or the zero time when the last snapshot does not carry one. The caller must
+// hold st.mu.
+func (st *credentialState) rateLimitCooldown(now time.Time) time.Time {
+	if st.rateLimitAt.IsZero() || now.Sub(st.rateLimitAt) > 5*time.Second {
+		return time.Time{}
+	}
+	until := st.rateLimit.ResumeAt(st.rateLimitAt)
+	if until.IsZero() || !until.After(now) {
+		return time.Time{}
+	}
+	if until.Sub(now) > maxRateLimitCooldown {
+		until = now.Add(maxRateLimitCooldown)
+	}
+	return until
+}
+
+// headroomPenalty scales the score of credentials that are about to exhaust
+// their upstream budget. The caller must hold st.mu.
+func (st *credentialState) headroomPenalty(now time.Time) float64 {
+	if st.rateLimitAt.IsZero() || now.Sub(st.rateLimitAt) > rateLimitFresh {
+		return 1
+	}
+	h := st.rateLimit.Headroom(now)
+	if h >= lowHeadroom {
+		return 1
+	}
+	return 0.05 + 0.95*(h/lowHeadroom)
+}
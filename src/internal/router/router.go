@@ -15,6 +15,7 @@ import (
 
 	"claude-gateway/src/internal/crypto"
 	"claude-gateway/src/internal/metrics"
+	"claude-gateway/src/internal/upstream"
 )
 
 var ErrNotConfigured = errors.New("gateway not configured")
@@ -65,6 +66,9 @@ type Router struct {
 	routeCacheMu sync.RWMutex
 	routeCache   map[string]routeCacheEntry
 	routeCacheTT time.Duration
+
+	splitMu sync.Mutex
+	splits  map[string]*splitState
 }
 
 func New(db *sql.DB, m *metrics.Metrics, cipher *crypto.AESGCM) *Router {
@@ -76,6 +80,7 @@ func New(db *sql.DB, m *metrics.Metrics, cipher *crypto.AESGCM) *Router {
 		poolStates:   make(map[uint64]*poolState),
 		routeCache:   make(map[string]routeCacheEntry),
 		routeCacheTT: 90 * time.Second,
+		splits:       make(map[string]*splitState),
 	}
 }
 
@@ -94,8 +99,41 @@ type RoutedUpstream struct {
 	Model        string
 	Headers      map[string]string
 	Timeout      time.Duration
+
+	// MaxOutputTokens is the catalog limit of Model, or 0 when unknown.
+	// Facades clamp the request's max_tokens to it.
+	MaxOutputTokens int
+
+	// ParamPolicy is the pool's ParamPolicy* setting for request parameters
+	// the upstream cannot honour.
+	ParamPolicy string
+	// ResponseCacheTTL is how long the pool caches deterministic responses,
+	// or 0 when it does not.
+	ResponseCacheTTL time.Duration
+	// CaptureRate is the share of the pool's requests whose bodies are
+	// captured, from 0 (none) to 1 (all).
+	CaptureRate float64
+	// Shadow mirrors ShadowRate of the pool's requests to ShadowProviderID,
+	// asking for ShadowModel when it is set.
+	ShadowProviderID uint64
+	ShadowModel      string
+	ShadowRate       float64
+	// Pinned marks an upstream chosen by a Pin rather than the pool, whose
+	// results must not steer the pool's routing.
+	Pinned bool
+	// SplitArm is the arm of a traffic split the upstream was picked for,
+	// or empty. Its results go to RecordSplitResult.
+	SplitArm string
+	split    *splitState
 }
 
+// Pool settings for parameters a cross-provider upstream does not support:
+// drop them silently, or reject the request with invalid_request_error.
+const (
+	ParamPolicyDrop   = "drop"
+	ParamPolicyReject = "reject"
+)
+
 func (r *Router) GetPoolModels(ctx context.Context, clientKey string) ([]string, error) {
 	cfg, err := r.getConfig(ctx)
 	if err != nil {
@@ -175,11 +213,17 @@ func (r *Router) GetPoolModels(ctx context.Context, clientKey string) ([]string,
 }
 
 func (r *Router) PickUpstream(ctx context.Context, clientKey, facade, model string) (RoutedUpstream, error) {
-	return r.pickUpstream(ctx, clientKey, facade, model, nil)
+	return r.pickUpstream(ctx, clientKey, facade, model, nil, Requirements{})
 }
 
 func (r *Router) PickUpstreamExclude(ctx context.Context, clientKey, facade, model string, exclude map[uint64]bool) (RoutedUpstream, error) {
-	return r.pickUpstream(ctx, clientKey, facade, model, exclude)
+	return r.pickUpstream(ctx, clientKey, facade, model, exclude, Requirements{})
+}
+
+// PickUpstreamWith only considers providers whose resolved model can serve
+// the features in need, according to the model catalog.
+func (r *Router) PickUpstreamWith(ctx context.Context, clientKey, facade, model string, exclude map[uint64]bool, need Requirements) (RoutedUpstream, error) {
+	return r.pickUpstream(ctx, clientKey, facade, model, exclude, need)
 }
 
 func (r *Router) RecordRouteResult(poolID uint64, facade, model string, credentialID uint64, ok bool, status int) {
@@ -210,9 +254,11 @@ func (r *Router) EndRequest(credentialID uint64, ok bool, status int, latency ti
 	if credentialID == 0 {
 		return
 	}
-	v, _ := r.credState.LoadOrStore(credentialID, &credentialState{})
-	st := v.(*credentialState)
+	st := r.loadCredentialState(credentialID)
 	atomic.AddInt64(&st.inflight, -1)
+	// Resolved before st.mu is taken: getConfig holds cacheMu while it
+	// reloads.
+	cred, _ := r.cachedCredential(credentialID)
 
 	now := time.Now()
 	st.mu.Lock()
@@ -223,6 +269,7 @@ func (r *Router) EndRequest(credentialID uint64, ok bool, status int, latency ti
 	st.lastStatus = status
 	st.total++
 	latMs := float64(latency.Milliseconds())
+	prevEWMA := st.ewmaLatency
 	if st.ewmaLatency == 0 {
 		st.ewmaLatency = latMs
 	} else if latMs > 0 {
@@ -233,15 +280,27 @@ func (r *Router) EndRequest(credentialID uint64, ok bool, status int, latency ti
 		st.successes++
 		st.failures = 0
 		st.openUntil = time.Time{}
+		r.adjustAdaptiveLimit(cred, st, true, status, latMs, prevEWMA)
 		return
 	}
 
+	r.adjustAdaptiveLimit(cred, st, false, status, latMs, prevEWMA)
+	st.recordFailure(now, status)
+}
+
+// recordFailure bumps the failure count and opens the circuit for a duration
+// that depends on the kind of failure. The caller must hold st.mu.
+func (st *credentialState) recordFailure(now time.Time, status int) {
 	st.failures++
 	st.lastErrorAt = now
 	switch status {
 	case http.StatusUnauthorized, http.StatusForbidden:
 		st.openUntil = now.Add(15 * time.Minute)
 	case http.StatusTooManyRequests:
+		if until := st.rateLimitCooldown(now); !until.IsZero() {
+			st.openUntil = until
+			break
+		}
 		d := time.Duration(2*(1<<minInt(st.failures, 6))) * time.Second
 		if d > 2*time.Minute {
 			d = 2 * time.Minute
@@ -282,7 +341,7 @@ func (r *Router) credentialScore(credentialID uint64, baseWeight int) float64 {
 		latPenalty = 1.0 / (1.0 + st.ewmaLatency/2500.0)
 	}
 	inflightPenalty := 1.0 / (1.0 + inflight)
-	return float64(baseWeight) * health * failPenalty * latPenalty * inflightPenalty
+	return float64(baseWeight) * health * failPenalty * latPenalty * inflightPenalty * st.headroomPenalty(time.Now())
 }
 
 func minInt(a, b int) int {
@@ -292,7 +351,7 @@ func minInt(a, b int) int {
 	return b
 }
 
-func (r *Router) pickUpstream(ctx context.Context, clientKey, facade, model string, exclude map[uint64]bool) (RoutedUpstream, error) {
+func (r *Router) pickUpstream(ctx context.Context, clientKey, facade, model string, exclude map[uint64]bool, need Requirements) (RoutedUpstream, error) {
 	cfg, err := r.getConfig(ctx)
 	if err != nil {
 		return RoutedUpstream{}, err
@@ -306,7 +365,23 @@ func (r *Router) pickUpstream(ctx context.Context, clientKey, facade, model stri
 		return RoutedUpstream{}, ErrUnauthorized
 	}
 
-	credID, err := r.pickCredentialFromPool(cfg, pool, facade, model, exclude)
+	var (
+		credID uint64
+		arm    string
+		split  *splitState
+		found  bool
+	)
+	pin, pinned := pinFrom(ctx)
+	if pinned {
+		credID, err = r.pinnedCredential(cfg, pool, model, pin, exclude)
+	} else if credID, arm, split, found = r.pickSplit(ctx, cfg, pool, clientKey, model, exclude, need); !found {
+		credID, err = r.pickCredentialFromPool(cfg, pool, facade, model, exclude, need)
+		if err != nil {
+			if overflow := r.contextOverflow(cfg, pool, facade, model, exclude, need); overflow != nil {
+				return RoutedUpstream{}, overflow
+			}
+		}
+	}
 	if err != nil {
 		return RoutedUpstream{}, err
 	}
@@ -324,20 +399,7 @@ func (r *Router) pickUpstream(ctx context.Context, clientKey, facade, model stri
 		headers[k] = v
 	}
 
-	upModel := model
-	if mapped, ok := prov.ModelMap[model]; ok && mapped != "" {
-		upModel = mapped
-	}
-	if mapped, ok := pool.ModelMap[model]; ok && mapped != "" {
-		upModel = mapped
-	}
-	if len(prov.Models) > 0 {
-		if _, ok := prov.Models[upModel]; !ok {
-			if fb := pickModelForAlias(prov.Models, model); fb != "" {
-				upModel = fb
-			}
-		}
-	}
+	upModel, _ := resolveUpstreamModel(prov, pool, model)
 
 	r.startRequest(credID)
 
@@ -351,21 +413,78 @@ func (r *Router) pickUpstream(ctx context.Context, clientKey, facade, model stri
 		Model:        upModel,
 		Headers:      headers,
 		Timeout:      0,
+
+		MaxOutputTokens: cfg.catalog[upModel].MaxOutputTokens,
+		ParamPolicy:     pool.ParamPolicy,
+
+		ResponseCacheTTL: time.Duration(pool.ResponseCacheTTLSec) * time.Second,
+		CaptureRate:      pool.CaptureRate,
+		ShadowProviderID: pool.ShadowProviderID,
+		ShadowModel:      pool.ShadowModel,
+		ShadowRate:       pool.ShadowRate,
+		Pinned:           pinned,
+		SplitArm:         arm,
+		split:            split,
 	}, nil
 }
 
+// ReleaseRequest hands back a picked credential that was not used, as when
+// the response cache answered the request, without recording an outcome.
+func (r *Router) ReleaseRequest(credentialID uint64) {
+	if credentialID == 0 {
+		return
+	}
+	atomic.AddInt64(&r.loadCredentialState(credentialID).inflight, -1)
+}
+
+// BeginRequest counts a further call on an already picked credential, as a
+// facade makes when it fans one request out. Like routing, it refuses while
+// the circuit is open or the credential is at its concurrency limit,
+// adaptive or fixed, and then counts nothing. Each call it allows must be
+// ended with EndRequest.
+func (r *Router) BeginRequest(credentialID uint64) bool {
+	if credentialID == 0 {
+		return true
+	}
+	if r.isCredentialOpen(credentialID, time.Now()) {
+		return false
+	}
+	st := r.loadCredentialState(credentialID)
+	n := atomic.AddInt64(&st.inflight, 1)
+	if cred, ok := r.cachedCredential(credentialID); ok {
+		if limit := r.effectiveConcurrencyLimit(cred); limit > 0 && n > int64(limit) {
+			atomic.AddInt64(&st.inflight, -1)
+			return false
+		}
+	}
+	return true
+}
+
 func (r *Router) startRequest(credentialID uint64) {
-	v, _ := r.credState.LoadOrStore(credentialID, &credentialState{})
-	st := v.(*credentialState)
+	st := r.loadCredentialState(credentialID)
 	atomic.AddInt64(&st.inflight, 1)
 }
 
-func (r *Router) pickCredentialFromPool(cfg loadedConfig, pool poolRow, facade, model string, exclude map[uint64]bool) (uint64, error) {
+func (r *Router) pickCredentialFromPool(cfg loadedConfig, pool poolRow, facade, model string, exclude map[uint64]bool, need Requirements) (uint64, error) {
 	now := time.Now()
 
 	// Track why credentials were skipped
 	reasons := make(map[string]int)
 
+	// supports checks the catalog entry of the model a provider would be
+	// asked for.
+	supports := func(prov providerRow) (bool, string) {
+		upModel, _ := resolveUpstreamModel(prov, pool, model)
+		caps, ok := cfg.catalog[upModel]
+		if !ok {
+			return true, ""
+		}
+		if ok, missing := caps.Satisfies(need); !ok {
+			return false, "model_lacks_" + missing
+		}
+		return true, ""
+	}
+
 	isAvailable := func(credID uint64) (bool, string) {
 		if exclude != nil && exclude[credID] {
 			return false, "excluded"
@@ -380,17 +499,29 @@ func (r *Router) pickCredentialFromPool(cfg loadedConfig, pool poolRow, facade,
 		if r.isCredentialOpen(credID, now) {
 			return false, "rate_limited_or_error_cooldown"
 		}
-		if cred.ConcurrencyLimit > 0 && r.getInflight(credID) >= int64(cred.ConcurrencyLimit) {
+		if limit := r.effectiveConcurrencyLimit(cred); limit > 0 && r.getInflight(credID) >= int64(limit) {
 			return false, "concurrency_limit_reached"
 		}
 		return true, ""
 	}
 
+	// usable is isAvailable for a credential picked outside the tiers,
+	// whose provider was not checked against the catalog.
+	usable := func(credID uint64) (bool, string) {
+		if ok, reason := isAvailable(credID); !ok {
+			return false, reason
+		}
+		if ok, reason := supports(cfg.providers[cfg.credentials[credID].ProviderID]); !ok {
+			return false, reason
+		}
+		return true, ""
+	}
+
 	key := r.routeKey(pool.ID, facade, model)
 	r.routeCacheMu.RLock()
 	if ent, ok := r.routeCache[key]; ok {
 		if now.Before(ent.expiresAt) {
-			if ok, _ := isAvailable(ent.credentialID); ok {
+			if ok, _ := usable(ent.credentialID); ok {
 				r.routeCacheMu.RUnlock()
 				return ent.credentialID, nil
 			}
@@ -411,6 +542,10 @@ func (r *Router) pickCredentialFromPool(cfg loadedConfig, pool poolRow, facade,
 
 	// Try Tiers first if configured
 	if len(pool.Tiers) > 0 {
+		// A provider whose model lacks a required capability is not a
+		// candidate; unlike unavailable credentials, it does not stop the
+		// search at its tier.
+		capReasons := make(map[string]int)
 		for _, tier := range pool.Tiers {
 			if !tierAppliesToModel(tier, model) {
 				continue
@@ -426,21 +561,12 @@ func (r *Router) pickCredentialFromPool(cfg loadedConfig, pool poolRow, facade,
 				if !ok {
 					return 0, 0, false
 				}
-				upModel := model
-				if mapped, ok := prov.ModelMap[model]; ok && mapped != "" {
-					upModel = mapped
-				}
-				if mapped, ok := pool.ModelMap[model]; ok && mapped != "" {
-					upModel = mapped
+				if _, ok := resolveUpstreamModel(prov, pool, model); !ok {
+					return 0, 0, false
 				}
-				if len(prov.Models) > 0 {
-					if _, ok := prov.Models[upModel]; !ok {
-						fb := pickModelForAlias(prov.Models, model)
-						if fb == "" {
-							return 0, 0, false
-						}
-						upModel = fb
-					}
+				if ok, reason := supports(prov); !ok {
+					capReasons[reason]++
+					return 0, 0, false
 				}
 				creds := cfg.providerCreds[provID]
 				if len(creds) == 0 {
@@ -532,8 +658,16 @@ func (r *Router) pickCredentialFromPool(cfg loadedConfig, pool poolRow, facade,
 				}
 			}
 		}
+		reason := "no tiers matched this model or all providers in matched tiers failed"
+		if len(capReasons) > 0 {
+			var rList []string
+			for k, v := range capReasons {
+				rList = append(rList, fmt.Sprintf("%s(%d)", k, v))
+			}
+			reason = strings.Join(rList, ", ")
+		}
 		return 0, &ErrNoAvailableUpstream{
-			Reason:   "no tiers matched this model or all providers in matched tiers failed",
+			Reason:   reason,
 			PoolID:   pool.ID,
 			PoolName: pool.Name,
 			Model:    model,
@@ -562,7 +696,7 @@ func (r *Router) pickCredentialFromPool(cfg loadedConfig, pool poolRow, facade,
 	for i := uint64(0); i < n; i++ {
 		idx := (count + i) % n
 		cid := ids[idx]
-		if ok, reason := isAvailable(cid); ok {
+		if ok, reason := usable(cid); ok {
 			return cid, nil
 		} else {
 			reasons[reason]++
@@ -689,7 +823,7 @@ func (r *Router) isCredentialOpen(credentialID uint64, now time.Time) bool {
 	st := v.(*credentialState)
 	st.mu.Lock()
 	defer st.mu.Unlock()
-	return !st.openUntil.IsZero() && now.Before(st.openUntil)
+	return st.isOpen(now)
 }
 
 func (r *Router) getPoolState(poolID uint64) *poolState {
@@ -724,6 +858,7 @@ func (r *Router) getConfig(ctx context.Context) (loadedConfig, error) {
 		return loadedConfig{}, err
 	}
 	r.cache = c
+	r.pruneSplits(c)
 	return c, nil
 }
 
@@ -744,6 +879,14 @@ type credentialState struct {
 	lastErrorAt time.Time
 	lastLatency time.Duration
 	lastStatus  int
+
+	manualOpen  bool
+	manualUntil time.Time
+
+	adaptiveLimit float64
+
+	rateLimit   upstream.RateLimit
+	rateLimitAt time.Time
 }
 
 type loadedConfig struct {
@@ -754,6 +897,7 @@ type loadedConfig struct {
 	providerCreds   map[uint64][]uint64
 	pools           map[uint64]poolRow
 	poolByClientKey map[string]poolRow
+	catalog         map[string]ModelCaps
 }
 
 type providerRow struct {
@@ -766,12 +910,13 @@ type providerRow struct {
 }
 
 type credentialRow struct {
-	ID               uint64
-	ProviderID       uint64
-	APIKeyCiphertext []byte
-	Weight           int
-	ConcurrencyLimit int
-	Enabled          bool
+	ID                  uint64
+	ProviderID          uint64
+	APIKeyCiphertext    []byte
+	Weight              int
+	ConcurrencyLimit    int
+	AdaptiveConcurrency bool
+	Enabled             bool
 }
 
 type poolRow struct {
@@ -780,9 +925,19 @@ type poolRow struct {
 	ClientKey             string
 	Strategy              string
 	Tiers                 []Tier
+	Splits                []SplitRule
+	SplitRollbacks        map[string]splitRollback
 	CredentialIDs         []uint64
 	ExpandedCredentialIDs []uint64
 	ModelMap              map[string]string
+	ParamPolicy           string
+	ResponseCacheTTLSec   int
+	CaptureRate           float64
+	ShadowProviderID      uint64
+	ShadowModel           string
+	ShadowRate            float64
+	OverflowStrategy      string
+	OverflowModel         string
 	Enabled               bool
 }
 
@@ -806,6 +961,7 @@ func loadConfig(ctx context.Context, db *sql.DB) (loadedConfig, error) {
 		providerCreds:   map[uint64][]uint64{},
 		pools:           map[uint64]poolRow{},
 		poolByClientKey: map[string]poolRow{},
+		catalog:         map[string]ModelCaps{},
 	}
 
 	if err := loadProviders(ctx, db, cfg.providers); err != nil {
@@ -817,6 +973,9 @@ func loadConfig(ctx context.Context, db *sql.DB) (loadedConfig, error) {
 	if err := loadPools(ctx, db, cfg.pools, cfg.poolByClientKey); err != nil {
 		return loadedConfig{}, err
 	}
+	if err := loadCatalog(ctx, db, cfg.catalog); err != nil {
+		return loadedConfig{}, err
+	}
 
 	expandPoolWeights(cfg.pools, cfg.credentials)
 	return cfg, nil
@@ -854,7 +1013,7 @@ func loadProviders(ctx context.Context, db *sql.DB, out map[uint64]providerRow)
 }
 
 func loadCredentials(ctx context.Context, db *sql.DB, out map[uint64]credentialRow, provOut map[uint64][]uint64) error {
-	rows, err := db.QueryContext(ctx, `SELECT id, provider_id, api_key_ciphertext, weight, concurrency_limit, enabled FROM credentials WHERE enabled = 1`)
+	rows, err := db.QueryContext(ctx, `SELECT id, provider_id, api_key_ciphertext, weight, concurrency_limit, adaptive_concurrency, enabled FROM credentials WHERE enabled = 1`)
 	if err != nil {
 		return err
 	}
@@ -867,21 +1026,23 @@ func loadCredentials(ctx context.Context, db *sql.DB, out map[uint64]credentialR
 			blob       []byte
 			weight     int
 			conc       sql.NullInt64
+			adaptive   bool
 			enabled    bool
 		)
-		if err := rows.Scan(&id, &providerID, &blob, &weight, &conc, &enabled); err != nil {
+		if err := rows.Scan(&id, &providerID, &blob, &weight, &conc, &adaptive, &enabled); err != nil {
 			return err
 		}
 		if weight <= 0 {
 			weight = 1
 		}
 		out[id] = credentialRow{
-			ID:               id,
-			ProviderID:       providerID,
-			APIKeyCiphertext: blob,
-			Weight:           weight,
-			ConcurrencyLimit: int(conc.Int64),
-			Enabled:          enabled,
+			ID:                  id,
+			ProviderID:          providerID,
+			APIKeyCiphertext:    blob,
+			Weight:              weight,
+			ConcurrencyLimit:    int(conc.Int64),
+			AdaptiveConcurrency: adaptive,
+			Enabled:             enabled,
 		}
 		provOut[providerID] = append(provOut[providerID], id)
 	}
@@ -889,7 +1050,7 @@ func loadCredentials(ctx context.Context, db *sql.DB, out map[uint64]credentialR
 }
 
 func loadPools(ctx context.Context, db *sql.DB, out map[uint64]poolRow, byKey map[string]poolRow) error {
-	rows, err := db.QueryContext(ctx, `SELECT id, name, client_key, strategy, tiers_json, credential_ids_json, model_map_json, enabled FROM pools WHERE enabled = 1`)
+	rows, err := db.QueryContext(ctx, `SELECT id, name, client_key, strategy, tiers_json, traffic_splits_json, split_rollbacks_json, credential_ids_json, model_map_json, param_policy, response_cache_ttl_sec, capture_rate, shadow_provider_id, shadow_model, shadow_rate, overflow_strategy, overflow_model, enabled FROM pools WHERE enabled = 1`)
 	if err != nil {
 		return err
 	}
@@ -902,27 +1063,51 @@ func loadPools(ctx context.Context, db *sql.DB, out map[uint64]poolRow, byKey ma
 			ckey      string
 			strategy  string
 			tiersJSON []byte
+			splitJSON []byte
+			rollJSON  []byte
 			idsJSON   []byte
 			mmJSON    []byte
+			policy    string
+			cacheTTL  int
+			capture   float64
+			shadowID  uint64
+			shadowMdl string
+			shadowPct float64
+			overflow  string
+			overModel string
 			enabled   bool
 		)
-		if err := rows.Scan(&id, &name, &ckey, &strategy, &tiersJSON, &idsJSON, &mmJSON, &enabled); err != nil {
+		if err := rows.Scan(&id, &name, &ckey, &strategy, &tiersJSON, &splitJSON, &rollJSON, &idsJSON, &mmJSON, &policy, &cacheTTL, &capture, &shadowID, &shadowMdl, &shadowPct, &overflow, &overModel, &enabled); err != nil {
 			return err
 		}
 		var ids []uint64
 		_ = json.Unmarshal(idsJSON, &ids)
 		var tiers []Tier
 		_ = unmarshalMaybeJSONString(tiersJSON, &tiers)
+		var splits []SplitRule
+		_ = unmarshalMaybeJSONString(splitJSON, &splits)
+		var rollbacks map[string]splitRollback
+		_ = unmarshalMaybeJSONString(rollJSON, &rollbacks)
 
 		p := poolRow{
-			ID:            id,
-			Name:          name,
-			ClientKey:     ckey,
-			Strategy:      strategy,
-			Tiers:         tiers,
-			CredentialIDs: ids,
-			ModelMap:      parseStringMapJSON(mmJSON),
-			Enabled:       enabled,
+			ID:                  id,
+			Name:                name,
+			ClientKey:           ckey,
+			Strategy:            strategy,
+			Tiers:               tiers,
+			Splits:              splits,
+			SplitRollbacks:      currentSplitRollbacks(splits, rollbacks),
+			CredentialIDs:       ids,
+			ModelMap:            parseStringMapJSON(mmJSON),
+			ParamPolicy:         policy,
+			ResponseCacheTTLSec: cacheTTL,
+			CaptureRate:         capture,
+			ShadowProviderID:    shadowID,
+			ShadowModel:         shadowMdl,
+			ShadowRate:          shadowPct,
+			OverflowStrategy:    overflow,
+			OverflowModel:       overModel,
+			Enabled:             enabled,
 		}
 		out[id] = p
 		if p.ClientKey != "" {
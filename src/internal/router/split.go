@@ -0,0 +1,427 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SessionHeader carries the client's session, by which traffic splits
+// with Sticky set to SplitStickySession keep a session on one arm.
+const SessionHeader = "X-Session-Id"
+
+// Split stickiness: none sends exactly CanaryPercent of requests to the
+// canary; client_key and session keep each client key or session on one
+// arm.
+const (
+	SplitStickyNone      = ""
+	SplitStickyClientKey = "client_key"
+	SplitStickySession   = "session"
+)
+
+// Split arms, as reported in RoutedUpstream.SplitArm.
+const (
+	SplitArmControl = "control"
+	SplitArmCanary  = "canary"
+)
+
+const (
+	defaultSplitMinSamples    = 50
+	defaultSplitMaxErrorRatio = 2
+	defaultSplitMaxP95Ratio   = 1.5
+	// splitWindow is how many recent results per arm rollback looks at.
+	splitWindow = 500
+	// splitErrorFloor is the lowest control error rate canaries are held
+	// to, so one canary error against a clean control is not a rollback.
+	splitErrorFloor = 0.01
+)
+
+// SplitRule sends CanaryPercent of a pool's traffic for Models (all models
+// when empty) to CanaryProviderID and the rest to ControlProviderID,
+// ahead of the pool's tiers and regardless of health scores. When an arm's
+// provider has no available credential, the canary falls back to control
+// and control to the tiers.
+//
+// Once both arms have MinSamples results, the canary is rolled back to 0%
+// if its error rate exceeds MaxErrorRatio times control's, or its p95
+// latency MaxP95Ratio times control's. Zero values take the defaults (50,
+// 2 and 1.5); a negative ratio disables its check.
+type SplitRule struct {
+	Models            []string `json:"models,omitempty"`
+	ControlProviderID uint64   `json:"control_provider_id"`
+	CanaryProviderID  uint64   `json:"canary_provider_id"`
+	CanaryPercent     float64  `json:"canary_percent"`
+	Sticky            string   `json:"sticky,omitempty"`
+	MinSamples        int      `json:"min_samples,omitempty"`
+	MaxErrorRatio     float64  `json:"max_error_ratio,omitempty"`
+	MaxP95Ratio       float64  `json:"max_p95_ratio,omitempty"`
+}
+
+// ValidateSplitRules checks rules as an admin saves them.
+func ValidateSplitRules(rules []SplitRule) error {
+	for i, rule := range rules {
+		switch {
+		case rule.ControlProviderID == 0 || rule.CanaryProviderID == 0:
+			return fmt.Errorf("split %d: control_provider_id and canary_provider_id are required", i+1)
+		case rule.ControlProviderID == rule.CanaryProviderID:
+			return fmt.Errorf("split %d: canary and control must be different providers", i+1)
+		case rule.CanaryPercent < 0 || rule.CanaryPercent > 100:
+			return fmt.Errorf("split %d: canary_percent must be between 0 and 100", i+1)
+		case rule.MinSamples < 0:
+			return fmt.Errorf("split %d: min_samples must not be negative", i+1)
+		}
+		switch rule.Sticky {
+		case SplitStickyNone, SplitStickyClientKey, SplitStickySession:
+		default:
+			return fmt.Errorf("split %d: sticky must be empty, client_key or session", i+1)
+		}
+	}
+	return nil
+}
+
+func (rule SplitRule) appliesTo(model string) bool {
+	return tierAppliesToModel(Tier{Models: rule.Models}, model)
+}
+
+type sessionKey struct{}
+
+// WithSession returns a context whose requests carry session, for splits
+// sticky by session.
+func WithSession(ctx context.Context, session string) context.Context {
+	return context.WithValue(ctx, sessionKey{}, session)
+}
+
+func sessionFrom(ctx context.Context) string {
+	s, _ := ctx.Value(sessionKey{}).(string)
+	return s
+}
+
+// splitState is the running state of one rule of one pool. Editing the
+// rule starts a new state, which also undoes a rollback.
+type splitState struct {
+	counter uint64
+	key     string
+	hash    string
+	rule    SplitRule
+
+	mu           sync.Mutex
+	arms         [2]splitArmWindow
+	rolledBack   bool
+	rolledBackAt time.Time
+	reason       string
+}
+
+// splitArmWindow keeps an arm's latest results in a ring.
+type splitArmWindow struct {
+	failed  [splitWindow]bool
+	latency [splitWindow]time.Duration
+	next    int
+	n       int
+}
+
+func (w *splitArmWindow) add(failed bool, latency time.Duration) {
+	w.failed[w.next], w.latency[w.next] = failed, latency
+	w.next = (w.next + 1) % splitWindow
+	if w.n < splitWindow {
+		w.n++
+	}
+}
+
+// stats returns the error rate and the p95 latency of successes.
+func (w *splitArmWindow) stats() (errRate float64, p95 time.Duration, successes int) {
+	if w.n == 0 {
+		return 0, 0, 0
+	}
+	var errs int
+	lat := make([]time.Duration, 0, w.n)
+	for i := 0; i < w.n; i++ {
+		if w.failed[i] {
+			errs++
+			continue
+		}
+		lat = append(lat, w.latency[i])
+	}
+	if len(lat) > 0 {
+		sort.Slice(lat, func(i, j int) bool { return lat[i] < lat[j] })
+		p95 = lat[int(math.Ceil(float64(len(lat))*0.95))-1]
+	}
+	return float64(errs) / float64(w.n), p95, len(lat)
+}
+
+func (r *Router) getSplitState(pool poolRow, rule SplitRule) *splitState {
+	key := splitKey(pool.ID, rule)
+	r.splitMu.Lock()
+	defer r.splitMu.Unlock()
+	if st, ok := r.splits[key]; ok {
+		return st
+	}
+	st := &splitState{key: key, hash: splitRuleHash(rule), rule: rule}
+	if rb, ok := pool.SplitRollbacks[st.hash]; ok {
+		st.rolledBack, st.rolledBackAt, st.reason = true, rb.At, rb.Reason
+	}
+	r.splits[key] = st
+	return st
+}
+
+func splitKey(poolID uint64, rule SplitRule) string {
+	raw, _ := json.Marshal(rule)
+	return fmt.Sprintf("%d|%s", poolID, raw)
+}
+
+// splitRuleHash names a rule in its pool's stored rollbacks.
+func splitRuleHash(rule SplitRule) string {
+	raw, _ := json.Marshal(rule)
+	h := fnv.New64a()
+	_, _ = h.Write(raw)
+	return fmt.Sprintf("%016x", h.Sum64())
+}
+
+// splitRollback is a rolled back canary as stored in the pool's
+// split_rollbacks_json, keyed by the hash of its rule, so that rollbacks
+// survive restarts.
+type splitRollback struct {
+	Reason string    `json:"reason"`
+	At     time.Time `json:"rolled_back_at"`
+}
+
+// currentSplitRollbacks drops stored rollbacks of rules that have since
+// been edited or removed.
+func currentSplitRollbacks(rules []SplitRule, stored map[string]splitRollback) map[string]splitRollback {
+	out := map[string]splitRollback{}
+	for _, rule := range rules {
+		h := splitRuleHash(rule)
+		if rb, ok := stored[h]; ok {
+			out[h] = rb
+		}
+	}
+	return out
+}
+
+// pruneSplits forgets the states of rules that no enabled pool has any
+// more.
+func (r *Router) pruneSplits(cfg loadedConfig) {
+	keep := map[string]bool{}
+	for _, pool := range cfg.pools {
+		for _, rule := range pool.Splits {
+			keep[splitKey(pool.ID, rule)] = true
+		}
+	}
+	r.splitMu.Lock()
+	defer r.splitMu.Unlock()
+	for key := range r.splits {
+		if !keep[key] {
+			delete(r.splits, key)
+		}
+	}
+}
+
+// saveSplitRollback stores a rollback on its pool.
+func (r *Router) saveSplitRollback(poolID uint64, hash string, rb splitRollback) {
+	if r.db == nil {
+		return
+	}
+	raw, _ := json.Marshal(rb)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	_, err := r.db.ExecContext(ctx,
+		`UPDATE pools SET split_rollbacks_json = JSON_SET(COALESCE(split_rollbacks_json, JSON_OBJECT()), ?, CAST(? AS JSON)) WHERE id = ?`,
+		`$."`+hash+`"`, string(raw), poolID)
+	if err != nil {
+		log.Printf("pool %d: failed to store canary rollback: %v", poolID, err)
+	}
+}
+
+// pickSplit routes a request covered by one of the pool's split rules.
+// It reports false when no rule applies or neither arm has an available
+// credential, leaving the request to the pool's tiers.
+func (r *Router) pickSplit(ctx context.Context, cfg loadedConfig, pool poolRow, clientKey, model string, exclude map[uint64]bool, need Requirements) (uint64, string, *splitState, bool) {
+	for _, rule := range pool.Splits {
+		if !rule.appliesTo(model) {
+			continue
+		}
+		st := r.getSplitState(pool, rule)
+		arms := []string{SplitArmControl}
+		if st.canary(r.splitStickyValue(ctx, rule, clientKey)) {
+			arms = []string{SplitArmCanary, SplitArmControl}
+		}
+		for _, arm := range arms {
+			providerID := rule.ControlProviderID
+			if arm == SplitArmCanary {
+				providerID = rule.CanaryProviderID
+			}
+			// A single-provider view of the pool picks the arm's best
+			// credential with the usual availability and catalog checks;
+			// its zero ID keeps it out of the pool's route cache.
+			view := pool
+			view.ID = 0
+			view.Tiers = []Tier{{Name: "split:" + arm, Strategy: "priority", Items: []TierItem{{ProviderID: providerID}}}}
+			if credID, err := r.pickCredentialFromPool(cfg, view, "", model, exclude, need); err == nil {
+				return credID, arm, st, true
+			}
+		}
+		return 0, "", nil, false
+	}
+	return 0, "", nil, false
+}
+
+func (r *Router) splitStickyValue(ctx context.Context, rule SplitRule, clientKey string) string {
+	switch rule.Sticky {
+	case SplitStickyClientKey:
+		return clientKey
+	case SplitStickySession:
+		return sessionFrom(ctx)
+	}
+	return ""
+}
+
+// canary decides whether a request goes to the canary. Without a sticky
+// value requests are counted off, so the canary gets exactly its share.
+func (st *splitState) canary(sticky string) bool {
+	rule := st.rule
+	st.mu.Lock()
+	rolledBack := st.rolledBack
+	st.mu.Unlock()
+	if rolledBack || rule.CanaryPercent <= 0 {
+		return false
+	}
+	if sticky != "" {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(st.key + "|" + sticky))
+		return float64(h.Sum64()%10000) < rule.CanaryPercent*100
+	}
+	n := atomic.AddUint64(&st.counter, 1)
+	return math.Floor(float64(n)*rule.CanaryPercent/100) > math.Floor(float64(n-1)*rule.CanaryPercent/100)
+}
+
+// RecordSplitResult feeds the outcome of a split request to its rule's
+// rollback check.
+func (r *Router) RecordSplitResult(up RoutedUpstream, ok bool, latency time.Duration) {
+	st := up.split
+	if st == nil {
+		return
+	}
+	arm := 0
+	if up.SplitArm == SplitArmCanary {
+		arm = 1
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.arms[arm].add(!ok, latency)
+	if st.rolledBack {
+		return
+	}
+	if reason := st.rollbackReason(); reason != "" {
+		st.rolledBack, st.rolledBackAt, st.reason = true, time.Now(), reason
+		log.Printf("pool %d: canary provider %d rolled back: %s", up.PoolID, st.rule.CanaryProviderID, reason)
+		go r.saveSplitRollback(up.PoolID, st.hash, splitRollback{Reason: reason, At: st.rolledBackAt})
+	}
+}
+
+// rollbackReason compares the canary with control. The caller must hold
+// st.mu.
+func (st *splitState) rollbackReason() string {
+	rule := st.rule
+	minSamples := rule.MinSamples
+	if minSamples == 0 {
+		minSamples = defaultSplitMinSamples
+	}
+	control, canary := &st.arms[0], &st.arms[1]
+	if control.n < minSamples || canary.n < minSamples {
+		return ""
+	}
+	ctlErr, ctlP95, ctlOK := control.stats()
+	canErr, canP95, canOK := canary.stats()
+
+	maxErr := rule.MaxErrorRatio
+	if maxErr == 0 {
+		maxErr = defaultSplitMaxErrorRatio
+	}
+	if maxErr > 0 && canErr > math.Max(ctlErr, splitErrorFloor)*maxErr {
+		return fmt.Sprintf("error rate %.1f%% against control %.1f%%", canErr*100, ctlErr*100)
+	}
+	maxP95 := rule.MaxP95Ratio
+	if maxP95 == 0 {
+		maxP95 = defaultSplitMaxP95Ratio
+	}
+	if maxP95 > 0 && ctlOK >= minSamples && canOK >= minSamples && float64(canP95) > float64(ctlP95)*maxP95 {
+		return fmt.Sprintf("p95 latency %dms against control %dms", canP95.Milliseconds(), ctlP95.Milliseconds())
+	}
+	return ""
+}
+
+// SplitArmStatus is an arm's recent results.
+type SplitArmStatus struct {
+	ProviderID uint64  `json:"provider_id"`
+	Requests   int     `json:"requests"`
+	ErrorRate  float64 `json:"error_rate"`
+	P95Ms      int64   `json:"p95_ms"`
+}
+
+// SplitStatus is the running state of a pool's split rule.
+type SplitStatus struct {
+	Rule          SplitRule      `json:"rule"`
+	CanaryPercent float64        `json:"effective_canary_percent"`
+	Control       SplitArmStatus `json:"control"`
+	Canary        SplitArmStatus `json:"canary"`
+	RolledBack    bool           `json:"rolled_back"`
+	RolledBackAt  *time.Time     `json:"rolled_back_at,omitempty"`
+	Reason        string         `json:"reason,omitempty"`
+}
+
+// SplitStatusOf reports the split rules of an enabled pool.
+func (r *Router) SplitStatusOf(ctx context.Context, poolID uint64) ([]SplitStatus, error) {
+	cfg, err := r.getConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return r.splitStatusOf(cfg, poolID)
+}
+
+func (r *Router) splitStatusOf(cfg loadedConfig, poolID uint64) ([]SplitStatus, error) {
+	out := []SplitStatus{}
+	pool := cfg.pools[poolID]
+	for _, rule := range pool.Splits {
+		st := r.getSplitState(pool, rule)
+		st.mu.Lock()
+		s := SplitStatus{Rule: rule, CanaryPercent: rule.CanaryPercent, RolledBack: st.rolledBack, Reason: st.reason}
+		if st.rolledBack {
+			at := st.rolledBackAt
+			s.CanaryPercent, s.RolledBackAt = 0, &at
+		}
+		for i, arm := range []*SplitArmStatus{&s.Control, &s.Canary} {
+			errRate, p95, _ := st.arms[i].stats()
+			arm.Requests, arm.ErrorRate, arm.P95Ms = st.arms[i].n, errRate, p95.Milliseconds()
+		}
+		st.mu.Unlock()
+		s.Control.ProviderID, s.Canary.ProviderID = rule.ControlProviderID, rule.CanaryProviderID
+		out = append(out, s)
+	}
+	return out, nil
+}
+
+// ResetSplits forgets the results and rollbacks of a pool's split rules,
+// sending canaries their configured share again.
+func (r *Router) ResetSplits(ctx context.Context, poolID uint64) error {
+	if r.db != nil {
+		if _, err := r.db.ExecContext(ctx, `UPDATE pools SET split_rollbacks_json = NULL WHERE id = ?`, poolID); err != nil {
+			return err
+		}
+		r.InvalidateConfig()
+	}
+	prefix := fmt.Sprintf("%d|", poolID)
+	r.splitMu.Lock()
+	defer r.splitMu.Unlock()
+	for key := range r.splits {
+		if len(key) > len(prefix) && key[:len(prefix)] == prefix {
+			delete(r.splits, key)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func splitConfig(rule SplitRule) (loadedConfig, poolRow) {
+	pool := poolRow{ID: 1, Splits: []SplitRule{rule}, Enabled: true}
+	cfg := loadedConfig{
+		pools:     map[uint64]poolRow{1: pool},
+		providers: map[uint64]providerRow{1: {ID: 1}, 2: {ID: 2}},
+		credentials: map[uint64]credentialRow{
+			10: {ID: 10, ProviderID: 1, Enabled: true},
+			20: {ID: 20, ProviderID: 2, Enabled: true},
+		},
+		providerCreds: map[uint64][]uint64{1: {10}, 2: {20}},
+	}
+	return cfg, pool
+}
+
+func TestSplitSendsExactShareToCanary(t *testing.T) {
+	cfg, pool := splitConfig(SplitRule{Models: []string{"sonnet"}, ControlProviderID: 1, CanaryProviderID: 2, CanaryPercent: 5})
+	r := New(nil, nil, nil)
+
+	canary := 0
+	for i := 0; i < 1000; i++ {
+		id, arm, _, ok := r.pickSplit(context.Background(), cfg, pool, "key", "sonnet", nil, Requirements{})
+		if !ok {
+			t.Fatal("split did not apply")
+		}
+		if (arm == SplitArmCanary) != (id == 20) {
+			t.Fatalf("arm %s picked credential %d", arm, id)
+		}
+		if arm == SplitArmCanary {
+			canary++
+		}
+	}
+	if canary != 50 {
+		t.Fatalf("canary got %d of 1000 requests, want 50", canary)
+	}
+	if _, _, _, ok := r.pickSplit(context.Background(), cfg, pool, "key", "haiku", nil, Requirements{}); ok {
+		t.Fatal("split applied to another model")
+	}
+}
+
+func TestSplitStickyBySession(t *testing.T) {
+	cfg, pool := splitConfig(SplitRule{ControlProviderID: 1, CanaryProviderID: 2, CanaryPercent: 50, Sticky: SplitStickySession})
+	r := New(nil, nil, nil)
+
+	arms := map[string]bool{}
+	for s := 0; s < 20; s++ {
+		ctx := WithSession(context.Background(), fmt.Sprintf("session-%d", s))
+		_, first, _, _ := r.pickSplit(ctx, cfg, pool, "key", "m", nil, Requirements{})
+		for i := 0; i < 5; i++ {
+			if _, arm, _, _ := r.pickSplit(ctx, cfg, pool, "key", "m", nil, Requirements{}); arm != first {
+				t.Fatalf("session %d moved from %s to %s", s, first, arm)
+			}
+		}
+		arms[first] = true
+	}
+	if !arms[SplitArmCanary] || !arms[SplitArmControl] {
+		t.Fatalf("20 sessions all on one arm: %v", arms)
+	}
+}
+
+func TestSplitCanaryFallsBackToControl(t *testing.T) {
+	cfg, pool := splitConfig(SplitRule{ControlProviderID: 1, CanaryProviderID: 2, CanaryPercent: 100})
+	r := New(nil, nil, nil)
+
+	id, arm, _, ok := r.pickSplit(context.Background(), cfg, pool, "key", "m", map[uint64]bool{20: true}, Requirements{})
+	if !ok || id != 10 || arm != SplitArmControl {
+		t.Fatalf("got %d %s %v, want control credential 10", id, arm, ok)
+	}
+	if _, _, _, ok := r.pickSplit(context.Background(), cfg, pool, "key", "m", map[uint64]bool{10: true, 20: true}, Requirements{}); ok {
+		t.Fatal("split applied with no available credential")
+	}
+}
+
+func TestSplitRollsBackFailingCanary(t *testing.T) {
+	cfg, pool := splitConfig(SplitRule{ControlProviderID: 1, CanaryProviderID: 2, CanaryPercent: 50, MinSamples: 10})
+	r := New(nil, nil, nil)
+
+	for i := 0; i < 40; i++ {
+		_, arm, st, _ := r.pickSplit(context.Background(), cfg, pool, "key", "m", nil, Requirements{})
+		up := RoutedUpstream{PoolID: pool.ID, SplitArm: arm, split: st}
+		r.RecordSplitResult(up, arm == SplitArmControl || i%4 != 1, 100*time.Millisecond)
+	}
+	status, err := r.splitStatusOf(cfg, pool.ID)
+	if err != nil || len(status) != 1 || !status[0].RolledBack || status[0].CanaryPercent != 0 {
+		t.Fatalf("status = %+v, %v", status, err)
+	}
+	for i := 0; i < 10; i++ {
+		if _, arm, _, _ := r.pickSplit(context.Background(), cfg, pool, "key", "m", nil, Requirements{}); arm != SplitArmControl {
+			t.Fatal("rolled back canary still gets traffic")
+		}
+	}
+
+	if err := r.ResetSplits(context.Background(), pool.ID); err != nil {
+		t.Fatal(err)
+	}
+	if status, _ := r.splitStatusOf(cfg, pool.ID); status[0].RolledBack {
+		t.Fatal("reset kept the rollback")
+	}
+}
+
+func TestSplitRollbackOutlivesItsState(t *testing.T) {
+	rule := SplitRule{ControlProviderID: 1, CanaryProviderID: 2, CanaryPercent: 100}
+	cfg, pool := splitConfig(rule)
+	pool.SplitRollbacks = currentSplitRollbacks(pool.Splits, map[string]splitRollback{
+		splitRuleHash(rule): {Reason: "error rate", At: time.Now()},
+		"stale":             {Reason: "old rule"},
+	})
+	cfg.pools[pool.ID] = pool
+	if len(pool.SplitRollbacks) != 1 {
+		t.Fatalf("rollbacks of other rules kept: %v", pool.SplitRollbacks)
+	}
+	r := New(nil, nil, nil)
+
+	if _, arm, _, _ := r.pickSplit(context.Background(), cfg, pool, "key", "m", nil, Requirements{}); arm != SplitArmControl {
+		t.Fatal("stored rollback not applied")
+	}
+
+	edited := pool
+	edited.Splits = []SplitRule{{ControlProviderID: 1, CanaryProviderID: 2, CanaryPercent: 50}}
+	cfg.pools[pool.ID] = edited
+	r.pruneSplits(cfg)
+	if len(r.splits) != 0 {
+		t.Fatalf("state of a removed rule kept: %v", r.splits)
+	}
+}
+
+func TestSplitRollsBackSlowCanary(t *testing.T) {
+	st := &splitState{rule: SplitRule{MinSamples: 20}}
+	for i := 0; i < 20; i++ {
+		st.arms[0].add(false, 100*time.Millisecond)
+		st.arms[1].add(false, 140*time.Millisecond)
+	}
+	if reason := st.rollbackReason(); reason != "" {
+		t.Fatalf("rolled back within the p95 ratio: %s", reason)
+	}
+	st.arms[1].add(false, time.Second)
+	st.arms[1].add(false, time.Second)
+	if reason := st.rollbackReason(); reason == "" {
+		t.Fatal("slow canary not rolled back")
+	}
+}
+
+func TestValidateSplitRules(t *testing.T) {
+	bad := []SplitRule{
+		{ControlProviderID: 1, CanaryPercent: 5},
+		{ControlProviderID: 1, CanaryProviderID: 1, CanaryPercent: 5},
+		{ControlProviderID: 1, CanaryProviderID: 2, CanaryPercent: 101},
+		{ControlProviderID: 1, CanaryProviderID: 2, Sticky: "ip"},
+	}
+	for _, rule := range bad {
+		if ValidateSplitRules([]SplitRule{rule}) == nil {
+			t.Fatalf("accepted %+v", rule)
+		}
+	}
+	if err := ValidateSplitRules([]SplitRule{{ControlProviderID: 1, CanaryProviderID: 2, CanaryPercent: 5, Sticky: SplitStickyClientKey}}); err != nil {
+		t.Fatal(err)
+	}
+}
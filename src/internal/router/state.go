@@ -0,0 +1,157 @@
+package router
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+type CredentialState struct {
+	CredentialID  uint64
+	Inflight      int64
+	Successes     int64
+	Failures      int
+	Total         int64
+	EWMALatencyMs float64
+	LastLatencyMs int64
+	LastStatus    int
+	LastSeen      time.Time
+	LastErrorAt   time.Time
+	OpenUntil     time.Time
+	Open          bool
+	ManualOpen    bool
+	ManualUntil   time.Time
+	// AdaptiveLimit is the current AIMD limit; zero until the credential has
+	// been routed with adaptive concurrency enabled.
+	AdaptiveLimit float64
+}
+
+// CredentialStates returns a snapshot of the runtime state of every credential
+// the router has seen since start (or since its stats were last reset).
+func (r *Router) CredentialStates() []CredentialState {
+	now := time.Now()
+	var out []CredentialState
+	r.credState.Range(func(k, v any) bool {
+		out = append(out, snapshotCredential(k.(uint64), v.(*credentialState), now))
+		return true
+	})
+	sort.Slice(out, func(i, j int) bool { return out[i].CredentialID < out[j].CredentialID })
+	return out
+}
+
+func (r *Router) CredentialStateOf(credentialID uint64) CredentialState {
+	v, ok := r.credState.Load(credentialID)
+	if !ok {
+		return CredentialState{CredentialID: credentialID}
+	}
+	return snapshotCredential(credentialID, v.(*credentialState), time.Now())
+}
+
+// OpenCircuit drains a credential: it is skipped by routing until d elapses,
+// or until CloseCircuit is called when d is zero. Successful requests that are
+// still in flight do not close a manually opened circuit.
+func (r *Router) OpenCircuit(credentialID uint64, d time.Duration) CredentialState {
+	st := r.loadCredentialState(credentialID)
+	st.mu.Lock()
+	st.manualOpen = true
+	st.manualUntil = time.Time{}
+	if d > 0 {
+		st.manualUntil = time.Now().Add(d)
+	}
+	st.mu.Unlock()
+	r.forgetRoutesTo(credentialID)
+	return r.CredentialStateOf(credentialID)
+}
+
+// CloseCircuit clears both a manual drain and any automatic cooldown.
+func (r *Router) CloseCircuit(credentialID uint64) CredentialState {
+	st := r.loadCredentialState(credentialID)
+	st.mu.Lock()
+	st.manualOpen = false
+	st.manualUntil = time.Time{}
+	st.openUntil = time.Time{}
+	st.failures = 0
+	st.mu.Unlock()
+	return r.CredentialStateOf(credentialID)
+}
+
+// ResetCredentialStats zeroes the health statistics used for scoring. The
+// circuit state and the inflight counter are left untouched.
+func (r *Router) ResetCredentialStats(credentialID uint64) CredentialState {
+	st := r.loadCredentialState(credentialID)
+	st.mu.Lock()
+	st.failures = 0
+	st.successes = 0
+	st.total = 0
+	st.ewmaLatency = 0
+	st.lastLatency = 0
+	st.lastStatus = 0
+	st.lastSeen = time.Time{}
+	st.lastErrorAt = time.Time{}
+	st.mu.Unlock()
+	return r.CredentialStateOf(credentialID)
+}
+
+func (r *Router) loadCredentialState(credentialID uint64) *credentialState {
+	v, _ := r.credState.LoadOrStore(credentialID, &credentialState{})
+	return v.(*credentialState)
+}
+
+func (r *Router) forgetRoutesTo(credentialID uint64) {
+	r.routeCacheMu.Lock()
+	for k, ent := range r.routeCache {
+		if ent.credentialID == credentialID {
+			delete(r.routeCache, k)
+		}
+	}
+	r.routeCacheMu.Unlock()
+}
+
+func snapshotCredential(id uint64, st *credentialState, now time.Time) CredentialState {
+	out := CredentialState{
+		CredentialID: id,
+		Inflight:     atomic.LoadInt64(&st.inflight),
+	}
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	out.Successes = st.successes
+	out.Failures = st.failures
+	out.Total = st.total
+	out.EWMALatencyMs = st.ewmaLatency
+	out.LastLatencyMs = st.lastLatency.Milliseconds()
+	out.LastStatus = st.lastStatus
+	out.LastSeen = st.lastSeen
+	out.LastErrorAt = st.lastErrorAt
+	out.OpenUntil = st.openUntil
+	out.ManualOpen = st.manualOpen
+	out.ManualUntil = st.manualUntil
+	out.Open = st.isOpen(now)
+	out.AdaptiveLimit = st.adaptiveLimit
+	return out
+}
+
+func (st *credentialState) isOpen(now time.Time) bool {
+	if st.manualOpen && (st.manualUntil.IsZero() || now.Before(st.manualUntil)) {
+		return true
+	}
+	return !st.openUntil.IsZero() && now.Before(st.openUntil)
+}
+
+// ObserveProbe feeds a background health probe into the credential state.
+// Probes do not count as traffic, but a passing probe ends an automatic
+// cooldown early and a failing one extends it. Manual drains are untouched.
+func (r *Router) ObserveProbe(credentialID uint64, ok bool, status int) {
+	if credentialID == 0 {
+		return
+	}
+	now := time.Now()
+	st := r.loadCredentialState(credentialID)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if ok {
+		st.failures = 0
+		st.openUntil = time.Time{}
+		return
+	}
+	st.recordFailure(now, status)
+}
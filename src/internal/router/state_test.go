@@ -0,0 +1,107 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"claude-gateway/src/internal/upstream"
+)
+
+func TestManualCircuitSurvivesSuccess(t *testing.T) {
+	r := New(nil, nil, nil)
+	r.startRequest(7)
+	r.OpenCircuit(7, 0)
+
+	r.EndRequest(7, true, http.StatusOK, 100*time.Millisecond)
+	if !r.isCredentialOpen(7, time.Now()) {
+		t.Fatalf("expected manual drain to stay open after a success")
+	}
+
+	st := r.CloseCircuit(7)
+	if st.Open || st.ManualOpen {
+		t.Fatalf("expected circuit closed, got %#v", st)
+	}
+}
+
+func TestResetCredentialStatsKeepsCircuit(t *testing.T) {
+	r := New(nil, nil, nil)
+	r.startRequest(3)
+	r.EndRequest(3, false, http.StatusInternalServerError, time.Second)
+	if !r.isCredentialOpen(3, time.Now()) {
+		t.Fatalf("expected cooldown after 5xx")
+	}
+
+	st := r.ResetCredentialStats(3)
+	if st.Total != 0 || st.Failures != 0 || st.EWMALatencyMs != 0 {
+		t.Fatalf("expected stats reset, got %#v", st)
+	}
+	if !st.Open {
+		t.Fatalf("expected reset to leave the cooldown in place")
+	}
+}
+
+func TestRateLimitResetDrivesCooldown(t *testing.T) {
+	r := New(nil, nil, nil)
+	r.startRequest(9)
+	r.ObserveRateLimit(9, upstream.RateLimit{RetryAfter: 45 * time.Second})
+	r.EndRequest(9, false, http.StatusTooManyRequests, 100*time.Millisecond)
+
+	st := r.CredentialStateOf(9)
+	if d := time.Until(st.OpenUntil); d < 40*time.Second || d > 45*time.Second {
+		t.Fatalf("expected cooldown from retry-after, got %v", d)
+	}
+}
+
+func TestReportFailureDrainsPermanentOnly(t *testing.T) {
+	r := New(nil, nil, nil)
+	r.ReportFailure(context.Background(), 4, upstream.Failure{Kind: upstream.FailureRateLimited, Status: 429})
+	if r.isCredentialOpen(4, time.Now()) {
+		t.Fatalf("expected transient failure to be left to EndRequest")
+	}
+	r.ReportFailure(context.Background(), 4, upstream.Failure{Kind: upstream.FailureQuotaExhausted, Status: 429})
+	if !r.isCredentialOpen(4, time.Now()) {
+		t.Fatalf("expected permanent failure to take the credential out of rotation")
+	}
+}
+
+func TestPassingProbeEndsCooldown(t *testing.T) {
+	r := New(nil, nil, nil)
+	r.startRequest(8)
+	r.EndRequest(8, false, http.StatusBadGateway, time.Second)
+	r.OpenCircuit(11, 0)
+
+	r.ObserveProbe(8, true, http.StatusOK)
+	r.ObserveProbe(11, true, http.StatusOK)
+	if r.isCredentialOpen(8, time.Now()) {
+		t.Fatalf("expected passing probe to end the cooldown")
+	}
+	if !r.isCredentialOpen(11, time.Now()) {
+		t.Fatalf("expected manual drain to survive a passing probe")
+	}
+}
+
+func TestBeginRequestRespectsLimits(t *testing.T) {
+	r := New(nil, nil, nil)
+	r.cache = loadedConfig{loadedAt: time.Now(), credentials: map[uint64]credentialRow{
+		7: {ID: 7, Enabled: true, ConcurrencyLimit: 2},
+	}}
+	r.startRequest(7)
+
+	if !r.BeginRequest(7) {
+		t.Fatal("call under the concurrency limit refused")
+	}
+	if r.BeginRequest(7) {
+		t.Fatal("call over the concurrency limit allowed")
+	}
+	if got := r.getInflight(7); got != 2 {
+		t.Fatalf("inflight = %d, want 2", got)
+	}
+
+	r.EndRequest(7, true, http.StatusOK, 0)
+	r.OpenCircuit(7, time.Minute)
+	if r.BeginRequest(7) {
+		t.Fatal("call on an open circuit allowed")
+	}
+}
@@ -0,0 +1,166 @@
+package shadow
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"sync/atomic"
+	"time"
+
+	"claude-gateway/src/internal/capture"
+)
+
+// purgeEvery is how many saves pass between deletions of old results.
+const purgeEvery = 100
+
+// MySQL stores results in the shadow_results table. Shadow output is
+// redacted and compressed like a body capture.
+type MySQL struct {
+	db        *sql.DB
+	redact    *capture.Redactor
+	retention time.Duration
+	saves     atomic.Int64
+}
+
+func NewMySQL(db *sql.DB, redact *capture.Redactor, retention time.Duration) *MySQL {
+	if retention <= 0 {
+		retention = capture.DefaultRetention
+	}
+	return &MySQL{db: db, redact: redact, retention: retention}
+}
+
+func (m *MySQL) Save(r Result) {
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+		now := time.Now().UTC()
+		_, err := m.db.ExecContext(ctx,
+			`INSERT INTO shadow_results (request_id, pool_id, facade, stream,
+			   primary_provider_id, primary_model, primary_status, primary_latency_ms, primary_input_tokens, primary_output_tokens, primary_error,
+			   shadow_provider_id, shadow_credential_id, shadow_model, shadow_status, shadow_latency_ms, shadow_input_tokens, shadow_output_tokens,
+			   shadow_error, shadow_output, created_at)
+			 VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			r.RequestID, r.PoolID, r.Facade, r.Stream,
+			r.Primary.ProviderID, r.Primary.Model, r.Primary.Status, r.Primary.LatencyMs, r.Primary.InputTokens, r.Primary.OutputTokens, nullString(r.PrimaryError),
+			r.Shadow.ProviderID, r.ShadowCredentialID, r.Shadow.Model, r.Shadow.Status, r.Shadow.LatencyMs, r.Shadow.InputTokens, r.Shadow.OutputTokens,
+			nullString(r.ShadowError), capture.Compress(m.redact.Redact(r.ShadowOutput)), now)
+		if err != nil {
+			log.Printf("shadow result save: %v", err)
+			return
+		}
+		if m.saves.Add(1)%purgeEvery == 0 {
+			if _, err := m.db.ExecContext(ctx, `DELETE FROM shadow_results WHERE created_at < ? LIMIT 1000`, now.Add(-m.retention)); err != nil {
+				log.Printf("shadow result purge: %v", err)
+			}
+		}
+	}()
+}
+
+func nullString(s string) any {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// Report compares a pool's primary and shadow traffic. Latency and token
+// differences are averaged over requests both upstreams answered.
+type Report struct {
+	Requests            int64   `json:"requests"`
+	PrimaryErrorRate    float64 `json:"primary_error_rate"`
+	ShadowErrorRate     float64 `json:"shadow_error_rate"`
+	PrimaryLatencyMs    float64 `json:"primary_avg_latency_ms"`
+	ShadowLatencyMs     float64 `json:"shadow_avg_latency_ms"`
+	LatencyDiffMs       float64 `json:"avg_latency_diff_ms"`
+	InputTokensDiff     float64 `json:"avg_input_tokens_diff"`
+	OutputTokensDiff    float64 `json:"avg_output_tokens_diff"`
+	PrimaryOutputTokens float64 `json:"primary_avg_output_tokens"`
+	ShadowOutputTokens  float64 `json:"shadow_avg_output_tokens"`
+}
+
+// LoadReport aggregates the results of a pool since a time. A request
+// counts as an error for an upstream that answered a non-2xx/3xx status or
+// failed afterwards, such as a stream cut short.
+func LoadReport(ctx context.Context, db *sql.DB, poolID uint64, since time.Time) (Report, error) {
+	const (
+		primaryErr = `(primary_status NOT BETWEEN 200 AND 399 OR primary_error IS NOT NULL)`
+		shadowErr  = `(shadow_status NOT BETWEEN 200 AND 399 OR shadow_error IS NOT NULL)`
+		bothOK     = `(NOT ` + primaryErr + ` AND NOT ` + shadowErr + `)`
+	)
+	var (
+		rep                                  Report
+		primaryErrs, shadowErrs              sql.NullInt64
+		pLat, sLat, latDiff, inDiff, outDiff sql.NullFloat64
+		pOut, sOut                           sql.NullFloat64
+	)
+	err := db.QueryRowContext(ctx,
+		`SELECT COUNT(*),
+		   SUM(`+primaryErr+`), SUM(`+shadowErr+`),
+		   AVG(primary_latency_ms), AVG(shadow_latency_ms),
+		   AVG(CASE WHEN `+bothOK+` THEN shadow_latency_ms - primary_latency_ms END),
+		   AVG(CASE WHEN `+bothOK+` THEN shadow_input_tokens - primary_input_tokens END),
+		   AVG(CASE WHEN `+bothOK+` THEN shadow_output_tokens - primary_output_tokens END),
+		   AVG(CASE WHEN `+bothOK+` THEN primary_output_tokens END),
+		   AVG(CASE WHEN `+bothOK+` THEN shadow_output_tokens END)
+		 FROM shadow_results WHERE pool_id = ? AND created_at >= ?`,
+		poolID, since.UTC()).Scan(&rep.Requests, &primaryErrs, &shadowErrs, &pLat, &sLat, &latDiff, &inDiff, &outDiff, &pOut, &sOut)
+	if err != nil {
+		return Report{}, err
+	}
+	if rep.Requests > 0 {
+		rep.PrimaryErrorRate = float64(primaryErrs.Int64) / float64(rep.Requests)
+		rep.ShadowErrorRate = float64(shadowErrs.Int64) / float64(rep.Requests)
+	}
+	rep.PrimaryLatencyMs, rep.ShadowLatencyMs = pLat.Float64, sLat.Float64
+	rep.LatencyDiffMs, rep.InputTokensDiff, rep.OutputTokensDiff = latDiff.Float64, inDiff.Float64, outDiff.Float64
+	rep.PrimaryOutputTokens, rep.ShadowOutputTokens = pOut.Float64, sOut.Float64
+	return rep, nil
+}
+
+// Record is a stored result as the admin API shows it.
+type Record struct {
+	ID           uint64    `json:"id"`
+	RequestID    string    `json:"request_id"`
+	Facade       string    `json:"facade"`
+	Stream       bool      `json:"stream"`
+	Primary      Outcome   `json:"primary"`
+	Shadow       Outcome   `json:"shadow"`
+	PrimaryError string    `json:"primary_error,omitempty"`
+	ShadowError  string    `json:"shadow_error,omitempty"`
+	ShadowOutput string    `json:"shadow_output,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// LoadRecent returns a pool's latest results, newest first.
+func LoadRecent(ctx context.Context, db *sql.DB, poolID uint64, limit int) ([]Record, error) {
+	rows, err := db.QueryContext(ctx,
+		`SELECT id, request_id, facade, stream,
+		   primary_provider_id, primary_model, primary_status, primary_latency_ms, primary_input_tokens, primary_output_tokens, primary_error,
+		   shadow_provider_id, shadow_model, shadow_status, shadow_latency_ms, shadow_input_tokens, shadow_output_tokens,
+		   shadow_error, shadow_output, created_at
+		 FROM shadow_results WHERE pool_id = ? ORDER BY id DESC LIMIT ?`, poolID, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	out := []Record{}
+	for rows.Next() {
+		var (
+			rec                Record
+			primaryErr, errMsg sql.NullString
+			output             []byte
+		)
+		p, s := &rec.Primary, &rec.Shadow
+		if err := rows.Scan(&rec.ID, &rec.RequestID, &rec.Facade, &rec.Stream,
+			&p.ProviderID, &p.Model, &p.Status, &p.LatencyMs, &p.InputTokens, &p.OutputTokens, &primaryErr,
+			&s.ProviderID, &s.Model, &s.Status, &s.LatencyMs, &s.InputTokens, &s.OutputTokens,
+			&errMsg, &output, &rec.CreatedAt); err != nil {
+			return nil, err
+		}
+		rec.PrimaryError = primaryErr.String
+		rec.ShadowError = errMsg.String
+		rec.ShadowOutput = capture.Decompress(output)
+		out = append(out, rec)
+	}
+	return out, rows.Err()
+}
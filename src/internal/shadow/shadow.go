@@ -0,0 +1,46 @@
+// Package shadow records how a candidate upstream answers a sample of a
+// pool's real traffic. Shadow requests are sent after the client has its
+// response and their answers are never returned; each is stored next to
+// the primary outcome of the same request for comparison.
+package shadow
+
+// Outcome is how one upstream handled a request.
+type Outcome struct {
+	ProviderID uint64 `json:"provider_id"`
+	Model      string `json:"model"`
+	// Status is 0 when the upstream could not be reached.
+	Status       int   `json:"status"`
+	LatencyMs    int64 `json:"latency_ms"`
+	InputTokens  int64 `json:"input_tokens"`
+	OutputTokens int64 `json:"output_tokens"`
+}
+
+// OK reports whether the upstream answered successfully.
+func (o Outcome) OK() bool {
+	return o.Status >= 200 && o.Status < 400
+}
+
+// Result pairs the primary outcome of a request with its shadow's.
+type Result struct {
+	RequestID string
+	PoolID    uint64
+	Facade    string
+	Stream    bool
+
+	Primary Outcome
+	Shadow  Outcome
+
+	// PrimaryError and ShadowError are set when an upstream failed, even
+	// one that had already answered 200 before its stream broke.
+	PrimaryError       string
+	ShadowCredentialID uint64
+	ShadowError        string
+	// ShadowOutput is the response the shadow produced, as the client
+	// would have received it.
+	ShadowOutput []byte
+}
+
+// Store saves results. Saving is best-effort and must not block.
+type Store interface {
+	Save(r Result)
+}
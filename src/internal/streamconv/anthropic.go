@@ -0,0 +1,270 @@
+package streamconv
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"claude-gateway/src/internal/canonical"
+	"claude-gateway/src/internal/convert"
+)
+
+// DecodeAnthropic reads a Messages stream. Anthropic sends input and cache
+// counts in message_start and the final output count in message_delta, so
+// usage is merged across both. An in-band error event is returned as a
+// StreamError, and a stream that ends before message_stop as
+// ErrStreamInterrupted.
+func DecodeAnthropic(r io.Reader, emit func(canonical.Event)) error {
+	usage := map[string]any{}
+	started, sawStop := false, false
+
+	br := bufio.NewReader(r)
+	for done := false; !done; {
+		// The last block may end at EOF without a blank line.
+		block, err := readSSEBlock(br)
+		if err == io.EOF {
+			done = true
+		} else if err != nil {
+			return err
+		}
+
+		data := extractSSEData(block)
+		if data == "" {
+			continue
+		}
+
+		var ev map[string]any
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			continue
+		}
+		if !started && ev["type"] != "message_start" && ev["type"] != "error" {
+			emit(canonical.Event{Type: canonical.EventMessageStart})
+		}
+		started = true
+
+		idx, _ := ev["index"].(float64)
+		switch ev["type"] {
+		case "message_start":
+			msg, _ := ev["message"].(map[string]any)
+			if u, ok := msg["usage"].(map[string]any); ok {
+				mergeUsage(usage, u)
+			}
+			emit(canonical.Event{Type: canonical.EventMessageStart, Usage: anthropicUsageToCanonical(usage)})
+		case "content_block_start":
+			contentBlock, _ := ev["content_block"].(map[string]any)
+			if contentBlock == nil {
+				continue
+			}
+			blk := canonical.ContentBlock{}
+			blk.Type, _ = contentBlock["type"].(string)
+			blk.ID, _ = contentBlock["id"].(string)
+			blk.Name, _ = contentBlock["name"].(string)
+			emit(canonical.Event{Type: canonical.EventBlockStart, Index: int(idx), Block: &blk})
+		case "content_block_delta":
+			delta, _ := ev["delta"].(map[string]any)
+			if delta == nil {
+				continue
+			}
+			out := canonical.Event{Type: canonical.EventBlockDelta, Index: int(idx)}
+			switch delta["type"] {
+			case "text_delta":
+				out.DeltaType = canonical.DeltaText
+				out.Delta, _ = delta["text"].(string)
+			case "thinking_delta":
+				out.DeltaType = canonical.DeltaThinking
+				out.Delta, _ = delta["thinking"].(string)
+			case "input_json_delta":
+				out.DeltaType = canonical.DeltaInputJSON
+				out.Delta, _ = delta["partial_json"].(string)
+			case "signature_delta":
+				out.DeltaType = canonical.DeltaSignature
+				out.Delta, _ = delta["signature"].(string)
+			default:
+				continue
+			}
+			emit(out)
+		case "content_block_stop":
+			emit(canonical.Event{Type: canonical.EventBlockStop, Index: int(idx)})
+		case "message_delta":
+			if u, ok := ev["usage"].(map[string]any); ok {
+				mergeUsage(usage, u)
+			}
+			out := canonical.Event{Type: canonical.EventMessageDelta}
+			if len(usage) > 0 {
+				out.Usage = anthropicUsageToCanonical(usage)
+			}
+			if d, ok := ev["delta"].(map[string]any); ok {
+				out.StopReason, _ = d["stop_reason"].(string)
+			}
+			emit(out)
+		case "message_stop":
+			sawStop = true
+			emit(canonical.Event{Type: canonical.EventMessageStop})
+		case "error":
+			return AnthropicError(ev)
+		}
+	}
+	if !sawStop {
+		return ErrStreamInterrupted
+	}
+	return nil
+}
+
+// anthropicEncoder writes Messages stream events.
+type anthropicEncoder struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	model   string
+
+	// thinking collects the text of open thinking blocks that have not
+	// received a signature yet.
+	thinking map[int]*strings.Builder
+	stopped  bool
+}
+
+// NewAnthropicEncoder returns an Encoder writing Messages stream events
+// that report model.
+func NewAnthropicEncoder(w http.ResponseWriter, flusher http.Flusher, model string) Encoder {
+	return &anthropicEncoder{w: w, flusher: flusher, model: model, thinking: map[int]*strings.Builder{}}
+}
+
+func (e *anthropicEncoder) Event(ev canonical.Event) {
+	switch ev.Type {
+	case canonical.EventMessageStart:
+		usage := map[string]any{"input_tokens": 0, "output_tokens": 0}
+		if ev.Usage != nil {
+			usage = canonicalUsageToAnthropic(ev.Usage)
+		}
+		writeAnthropicEvent(e.w, "message_start", map[string]any{
+			"type": "message_start",
+			"message": map[string]any{
+				"id":            "msg_" + uuid.NewString(),
+				"type":          "message",
+				"role":          "assistant",
+				"content":       []any{},
+				"model":         e.model,
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         usage,
+			},
+		})
+	case canonical.EventBlockStart:
+		var contentBlock map[string]any
+		switch ev.Block.Type {
+		case canonical.BlockThinking:
+			contentBlock = map[string]any{"type": "thinking", "thinking": ""}
+			e.thinking[ev.Index] = &strings.Builder{}
+		case canonical.BlockToolUse:
+			contentBlock = map[string]any{"type": "tool_use", "id": ev.Block.ID, "name": ev.Block.Name, "input": map[string]any{}}
+		default:
+			contentBlock = map[string]any{"type": "text", "text": ""}
+		}
+		writeAnthropicEvent(e.w, "content_block_start", map[string]any{
+			"type":          "content_block_start",
+			"index":         ev.Index,
+			"content_block": contentBlock,
+		})
+	case canonical.EventBlockDelta:
+		var delta map[string]any
+		switch ev.DeltaType {
+		case canonical.DeltaText:
+			delta = map[string]any{"type": "text_delta", "text": ev.Delta}
+		case canonical.DeltaThinking:
+			if b := e.thinking[ev.Index]; b != nil {
+				b.WriteString(ev.Delta)
+			}
+			delta = map[string]any{"type": "thinking_delta", "thinking": ev.Delta}
+		case canonical.DeltaInputJSON:
+			delta = map[string]any{"type": "input_json_delta", "partial_json": ev.Delta}
+		case canonical.DeltaSignature:
+			delete(e.thinking, ev.Index)
+			delta = map[string]any{"type": "signature_delta", "signature": ev.Delta}
+		default:
+			return
+		}
+		e.writeDelta(ev.Index, delta)
+	case canonical.EventBlockStop:
+		// A thinking block gets a signature before it closes; it is
+		// synthetic because the reasoning did not come from Anthropic.
+		if b := e.thinking[ev.Index]; b != nil {
+			e.writeDelta(ev.Index, map[string]any{
+				"type":      "signature_delta",
+				"signature": convert.SyntheticThinkingSignature(b.String()),
+			})
+			delete(e.thinking, ev.Index)
+		}
+		writeAnthropicEvent(e.w, "content_block_stop", map[string]any{
+			"type":  "content_block_stop",
+			"index": ev.Index,
+		})
+	case canonical.EventMessageDelta:
+		messageDelta := map[string]any{
+			"type":  "message_delta",
+			"delta": map[string]any{"stop_reason": ev.StopReason},
+		}
+		if ev.Usage != nil {
+			messageDelta["usage"] = canonicalUsageToAnthropic(ev.Usage)
+		}
+		writeAnthropicEvent(e.w, "message_delta", messageDelta)
+	case canonical.EventMessageStop:
+		writeAnthropicEvent(e.w, "message_stop", map[string]any{"type": "message_stop"})
+		e.stopped = true
+	case canonical.EventError:
+		WriteAnthropicError(e.w, ev.Err)
+		e.stopped = true
+	}
+	e.flusher.Flush()
+}
+
+func (e *anthropicEncoder) Close() {
+	if !e.stopped {
+		writeAnthropicEvent(e.w, "message_stop", map[string]any{"type": "message_stop"})
+		e.flusher.Flush()
+	}
+}
+
+func (e *anthropicEncoder) writeDelta(idx int, delta map[string]any) {
+	writeAnthropicEvent(e.w, "content_block_delta", map[string]any{
+		"type":  "content_block_delta",
+		"index": idx,
+		"delta": delta,
+	})
+}
+
+func mergeUsage(dst, src map[string]any) {
+	for k, v := range src {
+		dst[k] = v
+	}
+}
+
+// anthropicUsageToCanonical folds the cache counters into InputTokens.
+func anthropicUsageToCanonical(u map[string]any) *canonical.Usage {
+	creation := intOf(u["cache_creation_input_tokens"])
+	read := intOf(u["cache_read_input_tokens"])
+	return &canonical.Usage{
+		InputTokens:         intOf(u["input_tokens"]) + creation + read,
+		OutputTokens:        intOf(u["output_tokens"]),
+		CacheCreationTokens: creation,
+		CacheReadTokens:     read,
+	}
+}
+
+// canonicalUsageToAnthropic splits the cache counters out of input_tokens,
+// as Anthropic counts them separately.
+func canonicalUsageToAnthropic(u *canonical.Usage) map[string]any {
+	out := map[string]any{
+		"input_tokens":  u.InputTokens - u.CacheCreationTokens - u.CacheReadTokens,
+		"output_tokens": u.OutputTokens,
+	}
+	if u.CacheCreationTokens > 0 {
+		out["cache_creation_input_tokens"] = u.CacheCreationTokens
+	}
+	if u.CacheReadTokens > 0 {
+		out["cache_read_input_tokens"] = u.CacheReadTokens
+	}
+	return out
+}
@@ -2,9 +2,15 @@ package streamconv
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"testing/iotest"
+	"time"
+
+	"claude-gateway/src/internal/canonical"
 )
 
 func TestAnthropicToOpenAI_ToolUseStreaming(t *testing.T) {
@@ -27,7 +33,7 @@ func TestAnthropicToOpenAI_ToolUseStreaming(t *testing.T) {
 	}, "\n")
 
 	rec := httptest.NewRecorder()
-	if err := AnthropicToOpenAI(rec, bytes.NewReader([]byte(in)), "gpt-4"); err != nil {
+	if _, err := AnthropicToOpenAI(rec, bytes.NewReader([]byte(in)), "gpt-4", time.Now()); err != nil {
 		t.Fatalf("convert failed: %v", err)
 	}
 	out := rec.Body.String()
@@ -54,7 +60,7 @@ func TestOpenAIToAnthropic_ToolCallsStreaming(t *testing.T) {
 	}, "\n")
 
 	rec := httptest.NewRecorder()
-	if err := OpenAIToAnthropic(rec, bytes.NewReader([]byte(in)), "claude-3"); err != nil {
+	if _, err := OpenAIToAnthropic(rec, bytes.NewReader([]byte(in)), "claude-3", time.Now()); err != nil {
 		t.Fatalf("convert failed: %v", err)
 	}
 	out := rec.Body.String()
@@ -66,3 +72,165 @@ func TestOpenAIToAnthropic_ToolCallsStreaming(t *testing.T) {
 	}
 }
 
+
+func TestAnthropicToOpenAI_StructuredOutputAsContent(t *testing.T) {
+	in := strings.Join([]string{
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"tool_use","id":"toolu_1","name":"json_response","input":{}}}`,
+		"",
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"input_json_delta","partial_json":"{\"a\":1}"}}`,
+		"",
+		`data: {"type":"message_delta","delta":{"stop_reason":"tool_use"}}`,
+		"",
+		`data: {"type":"message_stop"}`,
+		"",
+	}, "\n")
+	rr := httptest.NewRecorder()
+	if _, err := AnthropicToOpenAIStructured(rr, strings.NewReader(in), "gpt-4o", time.Now()); err != nil {
+		t.Fatalf("AnthropicToOpenAIStructured: %v", err)
+	}
+	out := rr.Body.String()
+	if !strings.Contains(out, `"content":"{\"a\":1}"`) || strings.Contains(out, "tool_calls") {
+		t.Fatalf("structured output not streamed as content: %s", out)
+	}
+	if !strings.Contains(out, `"finish_reason":"stop"`) {
+		t.Fatalf("expected stop finish reason: %s", out)
+	}
+
+	// Without response_format, json_response is one of the client's tools.
+	rr = httptest.NewRecorder()
+	if _, err := AnthropicToOpenAI(rr, strings.NewReader(in), "gpt-4o", time.Now()); err != nil {
+		t.Fatalf("AnthropicToOpenAI: %v", err)
+	}
+	out = rr.Body.String()
+	if !strings.Contains(out, `"name":"json_response"`) || !strings.Contains(out, `"finish_reason":"tool_calls"`) {
+		t.Fatalf("client tool not streamed as a tool call: %s", out)
+	}
+}
+
+func TestAnthropicToOpenAI_SummaryUsage(t *testing.T) {
+	in := strings.Join([]string{
+		`data: {"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":5,"cache_creation_input_tokens":100,"cache_read_input_tokens":900,"output_tokens":1}}}`,
+		"",
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}`,
+		"",
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hi"}}`,
+		"",
+		`data: {"type":"content_block_stop","index":0}`,
+		"",
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":42}}`,
+		"",
+		`data: {"type":"message_stop"}`,
+		"",
+	}, "\n")
+	rr := httptest.NewRecorder()
+	sum, err := AnthropicToOpenAI(rr, strings.NewReader(in), "gpt-4o", time.Now())
+	if err != nil {
+		t.Fatalf("AnthropicToOpenAI: %v", err)
+	}
+	u := sum.Usage
+	if u.InputTokens != 1005 || u.OutputTokens != 42 || u.CacheCreationTokens != 100 || u.CacheReadTokens != 900 {
+		t.Fatalf("usage = %+v", u)
+	}
+	if sum.Bytes != rr.Body.Len() || sum.TTFT <= 0 {
+		t.Fatalf("summary = %+v", sum)
+	}
+	if !strings.Contains(rr.Body.String(), `"prompt_tokens":1005`) {
+		t.Fatalf("usage chunk missing: %s", rr.Body.String())
+	}
+}
+
+func TestOpenAIToAnthropic_BrokenStreamEndsWithError(t *testing.T) {
+	in := `data: {"choices":[{"index":0,"delta":{"content":"Hel"}}]}` + "\n\n"
+	rr := httptest.NewRecorder()
+	_, err := OpenAIToAnthropic(rr, io.MultiReader(strings.NewReader(in), iotest.ErrReader(io.ErrUnexpectedEOF)), "claude-3", time.Now())
+	var se *canonical.StreamError
+	if !errors.As(err, &se) || se.Type != "api_error" {
+		t.Fatalf("err = %v", err)
+	}
+	out := rr.Body.String()
+	if !strings.HasSuffix(out, "event: error\ndata: {\"error\":{\"message\":\"upstream stream interrupted\",\"type\":\"api_error\"},\"type\":\"error\"}\n\n") {
+		t.Fatalf("missing terminal error event: %s", out)
+	}
+	if strings.Contains(out, "message_stop") {
+		t.Fatalf("failed stream must not stop normally: %s", out)
+	}
+}
+
+func TestOpenAIToAnthropic_StreamCutBeforeDoneIsInterrupted(t *testing.T) {
+	in := `data: {"choices":[{"index":0,"delta":{"content":"Hel"}}]}` + "\n\n"
+	rr := httptest.NewRecorder()
+	if _, err := OpenAIToAnthropic(rr, strings.NewReader(in), "claude-3", time.Now()); err == nil {
+		t.Fatal("stream without [DONE] or finish_reason reported as complete")
+	}
+	if strings.Contains(rr.Body.String(), "end_turn") {
+		t.Fatalf("cut stream ended as end_turn: %s", rr.Body.String())
+	}
+}
+
+func TestAnthropicToOpenAI_StreamCutBeforeStopIsInterrupted(t *testing.T) {
+	in := `data: {"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":5}}}` + "\n\n" +
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hel"}}` + "\n\n"
+	rr := httptest.NewRecorder()
+	if _, err := AnthropicToOpenAI(rr, strings.NewReader(in), "gpt-4o", time.Now()); err == nil {
+		t.Fatal("stream without message_stop reported as complete")
+	}
+	if strings.Contains(rr.Body.String(), `"finish_reason":"stop"`) {
+		t.Fatalf("cut stream finished normally: %s", rr.Body.String())
+	}
+}
+
+func TestAnthropicToOpenAI_InBandError(t *testing.T) {
+	in := strings.Join([]string{
+		`data: {"type":"message_start","message":{"id":"msg_1","usage":{"input_tokens":5,"output_tokens":0}}}`,
+		"",
+		"event: error",
+		`data: {"type":"error","error":{"type":"overloaded_error","message":"Overloaded"}}`,
+		"",
+	}, "\n")
+	rr := httptest.NewRecorder()
+	_, err := AnthropicToOpenAI(rr, strings.NewReader(in), "gpt-4o", time.Now())
+	if err == nil || err.Error() != "overloaded_error: Overloaded" {
+		t.Fatalf("err = %v", err)
+	}
+	out := rr.Body.String()
+	if !strings.HasSuffix(out, `data: {"error":{"code":"overloaded_error","message":"Overloaded","type":"server_error"}}`+"\n\ndata: [DONE]\n\n") {
+		t.Fatalf("missing error chunk: %s", out)
+	}
+	if strings.Contains(out, "finish_reason") {
+		t.Fatalf("failed stream must not finish normally: %s", out)
+	}
+}
+
+func TestReplayCollectRoundTrip(t *testing.T) {
+	want := canonical.Response{
+		Content: []canonical.ContentBlock{
+			{Type: canonical.BlockText, Text: "Checking."},
+			{Type: canonical.BlockToolUse, ID: "toolu_1", Name: "get_weather", Input: []byte(`{"location":"SF"}`)},
+		},
+		StopReason: "tool_use",
+		Usage:      &canonical.Usage{InputTokens: 10, OutputTokens: 5},
+	}
+	rr := httptest.NewRecorder()
+	Replay(want, NewAnthropicEncoder(rr, rr, "claude-3"))
+	got, err := Collect(rr.Body, DecodeAnthropic)
+	if err != nil {
+		t.Fatalf("Collect: %v", err)
+	}
+	if len(got.Content) != 2 || got.Content[0].Text != "Checking." || string(got.Content[1].Input) != `{"location":"SF"}` || got.Content[1].Name != "get_weather" {
+		t.Fatalf("content = %+v", got.Content)
+	}
+	if got.StopReason != "tool_use" || got.Usage == nil || got.Usage.InputTokens != 10 || got.Usage.OutputTokens != 5 {
+		t.Fatalf("response = %+v", got)
+	}
+}
+
+func TestTokensPerSecond(t *testing.T) {
+	first := time.Now()
+	if got := TokensPerSecond(100, first, first.Add(2*time.Second)); got != 50 {
+		t.Fatalf("TokensPerSecond = %v, want 50", got)
+	}
+	if got := TokensPerSecond(100, first, first); got != 0 {
+		t.Fatalf("single delta rated %v", got)
+	}
+}
@@ -0,0 +1,105 @@
+package streamconv
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+
+	"claude-gateway/src/internal/canonical"
+	"claude-gateway/src/internal/upstream"
+)
+
+// ErrStreamInterrupted is returned by decoders when the upstream stream
+// ends before the response is complete.
+var ErrStreamInterrupted = errors.New("upstream stream ended before the response was complete")
+
+// AsStreamError returns err as a StreamError. Errors other than in-band
+// upstream errors mean the upstream stream broke and become api_error; the
+// client is not shown them, as they may name upstream hosts.
+func AsStreamError(err error) *canonical.StreamError {
+	var se *canonical.StreamError
+	if errors.As(err, &se) {
+		return se
+	}
+	log.Printf("upstream stream interrupted: %v", err)
+	return &canonical.StreamError{Type: "api_error", Message: "upstream stream interrupted"}
+}
+
+// AnthropicError reads an in-band Messages error event, or returns nil.
+func AnthropicError(ev map[string]any) *canonical.StreamError {
+	if ev["type"] != "error" {
+		return nil
+	}
+	e, _ := ev["error"].(map[string]any)
+	typ, _ := e["type"].(string)
+	msg, _ := e["message"].(string)
+	return &canonical.StreamError{Type: normalizeErrorType(typ, ""), Message: upstream.SanitizeMessage(msg)}
+}
+
+// OpenAIError reads an in-band error chunk, or returns nil. Compatible
+// upstreams send either an error object or a bare message.
+func OpenAIError(chunk map[string]any) *canonical.StreamError {
+	switch e := chunk["error"].(type) {
+	case map[string]any:
+		typ, _ := e["type"].(string)
+		code, _ := e["code"].(string)
+		msg, _ := e["message"].(string)
+		return &canonical.StreamError{Type: normalizeErrorType(typ, code), Message: upstream.SanitizeMessage(msg)}
+	case string:
+		return &canonical.StreamError{Type: "api_error", Message: upstream.SanitizeMessage(e)}
+	}
+	return nil
+}
+
+// normalizeErrorType maps an upstream error type or code onto Anthropic's
+// error types.
+func normalizeErrorType(typ, code string) string {
+	if kind := (upstream.APIError{Type: typ, Code: code}).Kind(); kind != "" {
+		return kind
+	}
+	return "api_error"
+}
+
+// openAIErrorType maps an Anthropic error type onto OpenAI's.
+func openAIErrorType(typ string) string {
+	switch typ {
+	case "rate_limit_error", "invalid_request_error", "authentication_error":
+		return typ
+	case "permission_error":
+		return "authentication_error"
+	case "not_found_error", "request_too_large":
+		return "invalid_request_error"
+	default:
+		return "server_error"
+	}
+}
+
+// WriteAnthropicError ends a Messages stream with an error event and
+// returns the bytes written.
+func WriteAnthropicError(w http.ResponseWriter, se *canonical.StreamError) int {
+	b, _ := json.Marshal(map[string]any{
+		"type":  "error",
+		"error": map[string]any{"type": se.Type, "message": se.Message},
+	})
+	n, _ := w.Write([]byte("event: error\ndata: " + string(b) + "\n\n"))
+	flush(w)
+	return n
+}
+
+// WriteOpenAIError ends a Chat Completions stream with an error chunk and
+// [DONE], and returns the bytes written.
+func WriteOpenAIError(w http.ResponseWriter, se *canonical.StreamError) int {
+	b, _ := json.Marshal(map[string]any{
+		"error": map[string]any{"message": se.Message, "type": openAIErrorType(se.Type), "code": se.Type},
+	})
+	n, _ := w.Write([]byte("data: " + string(b) + "\n\ndata: [DONE]\n\n"))
+	flush(w)
+	return n
+}
+
+func flush(w http.ResponseWriter) {
+	if f, ok := w.(http.Flusher); ok {
+		f.Flush()
+	}
+}
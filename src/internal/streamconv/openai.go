@@ -0,0 +1,350 @@
+package streamconv
+
+import (
+	"bufio"
+	"encoding/json"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"claude-gateway/src/internal/canonical"
+	"claude-gateway/src/internal/convert"
+)
+
+// DecodeOpenAI reads a Chat Completions stream. OpenAI chunks have no
+// blocks, so they are derived: reasoning, text and tool calls each open a
+// block, and starting a block of another kind closes the open ones.
+// Consecutive tool calls may stream in parallel and stay open together.
+// An in-band error chunk is returned as a StreamError, and a stream that
+// ends with neither [DONE] nor a finish_reason as ErrStreamInterrupted.
+func DecodeOpenAI(r io.Reader, emit func(canonical.Event)) error {
+	d := openAIDecoder{emit: emit}
+	toolIndexByID := map[string]int{}
+	toolIndexByPos := map[int]int{}
+	finishReason := ""
+	sawDone := false
+	var usage *canonical.Usage
+
+	emit(canonical.Event{Type: canonical.EventMessageStart})
+
+	br := bufio.NewReader(r)
+	for done := false; !done; {
+		// The last block may end at EOF without a blank line.
+		block, err := readSSEBlock(br)
+		if err == io.EOF {
+			done = true
+		} else if err != nil {
+			return err
+		}
+
+		data := extractSSEData(block)
+		if data == "" {
+			continue
+		}
+		if data == "[DONE]" {
+			sawDone = true
+			break
+		}
+
+		var chunk map[string]any
+		if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+			continue
+		}
+		if se := OpenAIError(chunk); se != nil {
+			return se
+		}
+		if u, ok := chunk["usage"].(map[string]any); ok {
+			usage = openAIUsageToCanonical(u)
+		}
+		choices, _ := chunk["choices"].([]any)
+		if len(choices) == 0 {
+			continue
+		}
+		c0, _ := choices[0].(map[string]any)
+		delta, _ := c0["delta"].(map[string]any)
+		if delta != nil {
+			// Reasoning from OpenAI-compatible upstreams (DeepSeek, GLM, Qwen)
+			if reasoning, ok := delta["reasoning_content"].(string); ok && reasoning != "" {
+				idx := d.start(canonical.ContentBlock{Type: canonical.BlockThinking})
+				emit(canonical.Event{Type: canonical.EventBlockDelta, Index: idx, DeltaType: canonical.DeltaThinking, Delta: reasoning})
+			}
+			if text, ok := delta["content"].(string); ok && text != "" {
+				idx := d.start(canonical.ContentBlock{Type: canonical.BlockText})
+				emit(canonical.Event{Type: canonical.EventBlockDelta, Index: idx, DeltaType: canonical.DeltaText, Delta: text})
+			}
+			if tcRaw, ok := delta["tool_calls"].([]any); ok && len(tcRaw) > 0 {
+				for _, tci := range tcRaw {
+					tc, ok := tci.(map[string]any)
+					if !ok {
+						continue
+					}
+					id, _ := tc["id"].(string)
+					pos := -1
+					if p, ok := tc["index"].(float64); ok {
+						pos = int(p)
+					}
+					idx, known := toolIndexByID[id]
+					if !known && strings.TrimSpace(id) == "" {
+						// Continuation chunks usually carry only the tool index.
+						idx, known = toolIndexByPos[pos]
+						if !known {
+							continue
+						}
+					}
+					fn, _ := tc["function"].(map[string]any)
+					if !known {
+						name, _ := fn["name"].(string)
+						idx = d.start(canonical.ContentBlock{Type: canonical.BlockToolUse, ID: id, Name: name})
+						toolIndexByID[id] = idx
+						if pos >= 0 {
+							toolIndexByPos[pos] = idx
+						}
+					}
+					if args, _ := fn["arguments"].(string); args != "" {
+						emit(canonical.Event{Type: canonical.EventBlockDelta, Index: idx, DeltaType: canonical.DeltaInputJSON, Delta: args})
+					}
+				}
+			}
+		}
+		if fr, ok := c0["finish_reason"].(string); ok && fr != "" {
+			// Keep reading: with include_usage the usage chunk follows.
+			finishReason = fr
+		}
+	}
+
+	if !sawDone && finishReason == "" {
+		return ErrStreamInterrupted
+	}
+
+	stopReason := "end_turn"
+	switch strings.TrimSpace(finishReason) {
+	case "tool_calls":
+		stopReason = "tool_use"
+	case "length":
+		stopReason = "max_tokens"
+	case "content_filter":
+		stopReason = "refusal"
+	}
+
+	d.stop()
+	emit(canonical.Event{Type: canonical.EventMessageDelta, StopReason: stopReason, Usage: usage})
+	emit(canonical.Event{Type: canonical.EventMessageStop})
+	return nil
+}
+
+type openAIDecoder struct {
+	emit func(canonical.Event)
+	next int
+	kind string
+	open []int
+}
+
+// start opens a block and returns its index. Text and thinking deltas
+// reuse the open block of their kind.
+func (d *openAIDecoder) start(blk canonical.ContentBlock) int {
+	if len(d.open) > 0 && d.kind == blk.Type && blk.Type != canonical.BlockToolUse {
+		return d.open[0]
+	}
+	if d.kind != blk.Type {
+		d.stop()
+	}
+	idx := d.next
+	d.next++
+	d.kind = blk.Type
+	d.open = append(d.open, idx)
+	d.emit(canonical.Event{Type: canonical.EventBlockStart, Index: idx, Block: &blk})
+	return idx
+}
+
+func (d *openAIDecoder) stop() {
+	for _, idx := range d.open {
+		d.emit(canonical.Event{Type: canonical.EventBlockStop, Index: idx})
+	}
+	d.open = d.open[:0]
+	d.kind = ""
+}
+
+// openAIEncoder writes Chat Completions chunks.
+type openAIEncoder struct {
+	w       http.ResponseWriter
+	flusher http.Flusher
+	id      string
+	created int64
+	model   string
+
+	sentRole     bool
+	finishReason string
+	// tools maps block indexes to tool_calls positions and ids.
+	tools   map[int]openAITool
+	nextPos int
+	// In a structured request, blocks of the structured-output tool stream
+	// as plain content.
+	structuredOutput bool
+	structured       map[int]bool
+	usage            *canonical.Usage
+}
+
+type openAITool struct {
+	pos int
+	id  string
+}
+
+// NewOpenAIEncoder returns an Encoder writing Chat Completions chunks that
+// report model. structured is whether the request set response_format.
+func NewOpenAIEncoder(w http.ResponseWriter, flusher http.Flusher, model string, structured bool) Encoder {
+	return &openAIEncoder{
+		w:                w,
+		flusher:          flusher,
+		id:               "chatcmpl_" + uuid.NewString(),
+		created:          time.Now().Unix(),
+		model:            model,
+		finishReason:     "stop",
+		tools:            map[int]openAITool{},
+		structuredOutput: structured,
+		structured:       map[int]bool{},
+	}
+}
+
+func (e *openAIEncoder) Event(ev canonical.Event) {
+	if ev.Type == canonical.EventError {
+		WriteOpenAIError(e.w, ev.Err)
+		e.flusher.Flush()
+		return
+	}
+	if !e.sentRole {
+		e.chunk(map[string]any{"role": "assistant"}, nil)
+		e.sentRole = true
+	}
+	switch ev.Type {
+	case canonical.EventMessageStart:
+		e.usage = ev.Usage
+	case canonical.EventBlockStart:
+		blk := ev.Block
+		switch blk.Type {
+		case canonical.BlockThinking:
+			e.chunk(map[string]any{"reasoning_content": ""}, nil)
+		case canonical.BlockToolUse:
+			if strings.TrimSpace(blk.ID) == "" || strings.TrimSpace(blk.Name) == "" {
+				return
+			}
+			if e.structuredOutput && blk.Name == convert.StructuredOutputTool {
+				e.structured[ev.Index] = true
+				return
+			}
+			t := openAITool{pos: e.nextPos, id: blk.ID}
+			e.nextPos++
+			e.tools[ev.Index] = t
+			e.chunk(map[string]any{"tool_calls": []any{map[string]any{
+				"index": t.pos,
+				"id":    t.id,
+				"type":  "function",
+				"function": map[string]any{
+					"name":      blk.Name,
+					"arguments": "",
+				},
+			}}}, nil)
+		}
+	case canonical.EventBlockDelta:
+		if ev.Delta == "" {
+			return
+		}
+		switch ev.DeltaType {
+		case canonical.DeltaThinking:
+			e.chunk(map[string]any{"reasoning_content": ev.Delta}, nil)
+		case canonical.DeltaText:
+			e.chunk(map[string]any{"content": ev.Delta}, nil)
+		case canonical.DeltaInputJSON:
+			if e.structured[ev.Index] {
+				e.chunk(map[string]any{"content": ev.Delta}, nil)
+				return
+			}
+			t, ok := e.tools[ev.Index]
+			if !ok {
+				return
+			}
+			e.chunk(map[string]any{"tool_calls": []any{map[string]any{
+				"index": t.pos,
+				"id":    t.id,
+				"type":  "function",
+				"function": map[string]any{
+					"arguments": ev.Delta,
+				},
+			}}}, nil)
+		}
+	case canonical.EventMessageDelta:
+		if ev.Usage != nil {
+			e.usage = ev.Usage
+		}
+		switch ev.StopReason {
+		case "":
+		case "max_tokens":
+			e.finishReason = "length"
+		case "tool_use":
+			e.finishReason = "tool_calls"
+			if len(e.tools) == 0 && len(e.structured) > 0 {
+				e.finishReason = "stop"
+			}
+		case "refusal":
+			e.finishReason = "content_filter"
+		default:
+			e.finishReason = "stop"
+		}
+	}
+}
+
+func (e *openAIEncoder) Close() {
+	e.chunk(map[string]any{}, &e.finishReason)
+	if u := e.usage; u != nil {
+		usage := map[string]any{
+			"prompt_tokens":     u.InputTokens,
+			"completion_tokens": u.OutputTokens,
+			"total_tokens":      u.InputTokens + u.OutputTokens,
+		}
+		if u.CacheReadTokens > 0 {
+			usage["prompt_tokens_details"] = map[string]any{"cached_tokens": u.CacheReadTokens}
+		}
+		writeOpenAIChunk(e.w, map[string]any{
+			"id":      e.id,
+			"object":  "chat.completion.chunk",
+			"created": e.created,
+			"model":   e.model,
+			"choices": []any{},
+			"usage":   usage,
+		})
+	}
+	_, _ = e.w.Write([]byte("data: [DONE]\n\n"))
+	e.flusher.Flush()
+}
+
+func (e *openAIEncoder) chunk(delta map[string]any, finishReason *string) {
+	choice := map[string]any{"index": 0, "delta": delta}
+	if finishReason != nil {
+		choice["finish_reason"] = *finishReason
+	}
+	writeOpenAIChunk(e.w, map[string]any{
+		"id":      e.id,
+		"object":  "chat.completion.chunk",
+		"created": e.created,
+		"model":   e.model,
+		"choices": []any{choice},
+	})
+	e.flusher.Flush()
+}
+
+// openAIUsageToCanonical reads a usage object; cached_tokens is part of
+// prompt_tokens.
+func openAIUsageToCanonical(u map[string]any) *canonical.Usage {
+	out := &canonical.Usage{
+		InputTokens:  intOf(u["prompt_tokens"]),
+		OutputTokens: intOf(u["completion_tokens"]),
+	}
+	if d, ok := u["prompt_tokens_details"].(map[string]any); ok {
+		if cached := intOf(d["cached_tokens"]); cached <= out.InputTokens {
+			out.CacheReadTokens = cached
+		}
+	}
+	return out
+}
@@ -5,6 +5,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
 func TestOpenAIToAnthropic(t *testing.T) {
@@ -18,7 +19,7 @@ func TestOpenAIToAnthropic(t *testing.T) {
 	}, "\n")
 
 	rr := httptest.NewRecorder()
-	if err := OpenAIToAnthropic(rr, strings.NewReader(in), "claude-sonnet-4-5"); err != nil {
+	if _, err := OpenAIToAnthropic(rr, strings.NewReader(in), "claude-sonnet-4-5", time.Now()); err != nil {
 		t.Fatalf("OpenAIToAnthropic: %v", err)
 	}
 
@@ -48,7 +49,7 @@ func TestAnthropicToOpenAI(t *testing.T) {
 	}, "\n")
 
 	rr := httptest.NewRecorder()
-	if err := AnthropicToOpenAI(rr, bytes.NewReader([]byte(in)), "gpt-4o"); err != nil {
+	if _, err := AnthropicToOpenAI(rr, bytes.NewReader([]byte(in)), "gpt-4o", time.Now()); err != nil {
 		t.Fatalf("AnthropicToOpenAI: %v", err)
 	}
 
@@ -64,3 +65,70 @@ func TestAnthropicToOpenAI(t *testing.T) {
 	}
 }
 
+
+func TestOpenAIToAnthropic_ReasoningThenText(t *testing.T) {
+	in := strings.Join([]string{
+		"data: {\"choices\":[{\"index\":0,\"delta\":{\"reasoning_content\":\"hmm\"}}]}",
+		"",
+		"data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"}}]}",
+		"",
+		"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"id\":\"call_1\",\"function\":{\"name\":\"f\",\"arguments\":\"\"}}]}}]}",
+		"",
+		"data: {\"choices\":[{\"index\":0,\"delta\":{\"tool_calls\":[{\"index\":0,\"function\":{\"arguments\":\"{}\"}}]},\"finish_reason\":\"tool_calls\"}]}",
+		"",
+		"data: [DONE]",
+		"",
+	}, "\n")
+
+	rr := httptest.NewRecorder()
+	if _, err := OpenAIToAnthropic(rr, strings.NewReader(in), "claude-sonnet-4-5", time.Now()); err != nil {
+		t.Fatalf("OpenAIToAnthropic: %v", err)
+	}
+	out := rr.Body.String()
+
+	order := []string{
+		"\"index\":0,\"type\":\"content_block_start\"",
+		"\"thinking\":\"hmm\"",
+		"\"type\":\"signature_delta\"",
+		"\"index\":0,\"type\":\"content_block_stop\"",
+		"\"index\":1,\"type\":\"content_block_start\"",
+		"\"text\":\"Hi\"",
+		"\"index\":1,\"type\":\"content_block_stop\"",
+		"\"index\":2,\"type\":\"content_block_start\"",
+		"\"partial_json\":\"{}\"",
+		"\"index\":2,\"type\":\"content_block_stop\"",
+		"\"stop_reason\":\"tool_use\"",
+	}
+	pos := 0
+	for _, want := range order {
+		i := strings.Index(out[pos:], want)
+		if i < 0 {
+			t.Fatalf("missing %s after offset %d: %s", want, pos, out)
+		}
+		pos += i + len(want)
+	}
+}
+
+func TestOpenAIToAnthropic_UsageSplitsCachedTokens(t *testing.T) {
+	in := strings.Join([]string{
+		"data: {\"choices\":[{\"index\":0,\"delta\":{\"content\":\"Hi\"},\"finish_reason\":\"stop\"}]}",
+		"",
+		"data: {\"choices\":[],\"usage\":{\"prompt_tokens\":100,\"completion_tokens\":5,\"prompt_tokens_details\":{\"cached_tokens\":80}}}",
+		"",
+		"data: [DONE]",
+		"",
+	}, "\n")
+
+	rr := httptest.NewRecorder()
+	sum, err := OpenAIToAnthropic(rr, strings.NewReader(in), "claude-sonnet-4-5", time.Now())
+	if err != nil {
+		t.Fatalf("OpenAIToAnthropic: %v", err)
+	}
+	if sum.Usage.InputTokens != 100 || sum.Usage.OutputTokens != 5 || sum.Usage.CacheReadTokens != 80 || sum.Bytes != rr.Body.Len() || sum.TTFT <= 0 {
+		t.Fatalf("summary = %+v", sum)
+	}
+	out := rr.Body.String()
+	if !strings.Contains(out, `"usage":{"cache_read_input_tokens":80,"input_tokens":20,"output_tokens":5}`) {
+		t.Fatalf("usage not split: %s", out)
+	}
+}
@@ -0,0 +1,87 @@
+package streamconv
+
+import (
+	"encoding/json"
+	"io"
+
+	"claude-gateway/src/internal/canonical"
+)
+
+// Replay writes a complete response to enc as a stream, one delta per
+// block.
+func Replay(resp canonical.Response, enc Encoder) {
+	enc.Event(canonical.Event{Type: canonical.EventMessageStart, Usage: resp.Usage})
+	for i, blk := range resp.Content {
+		start := canonical.ContentBlock{Type: blk.Type, ID: blk.ID, Name: blk.Name}
+		enc.Event(canonical.Event{Type: canonical.EventBlockStart, Index: i, Block: &start})
+		delta := func(typ, s string) {
+			if s != "" {
+				enc.Event(canonical.Event{Type: canonical.EventBlockDelta, Index: i, DeltaType: typ, Delta: s})
+			}
+		}
+		switch blk.Type {
+		case canonical.BlockText:
+			delta(canonical.DeltaText, blk.Text)
+		case canonical.BlockThinking:
+			delta(canonical.DeltaThinking, blk.Thinking)
+			delta(canonical.DeltaSignature, blk.Signature)
+		case canonical.BlockToolUse:
+			delta(canonical.DeltaInputJSON, string(blk.Input))
+		}
+		enc.Event(canonical.Event{Type: canonical.EventBlockStop, Index: i})
+	}
+	enc.Event(canonical.Event{Type: canonical.EventMessageDelta, StopReason: resp.StopReason, Usage: resp.Usage})
+	enc.Event(canonical.Event{Type: canonical.EventMessageStop})
+	enc.Close()
+}
+
+// Collect decodes a complete stream into the response it describes.
+func Collect(r io.Reader, dec Decoder) (canonical.Response, error) {
+	var (
+		resp  canonical.Response
+		input = map[int][]byte{}
+		index = map[int]int{}
+	)
+	err := dec(r, func(ev canonical.Event) {
+		if ev.Usage != nil {
+			u := *ev.Usage
+			resp.Usage = &u
+		}
+		switch ev.Type {
+		case canonical.EventBlockStart:
+			index[ev.Index] = len(resp.Content)
+			resp.Content = append(resp.Content, *ev.Block)
+		case canonical.EventBlockDelta:
+			i, ok := index[ev.Index]
+			if !ok {
+				return
+			}
+			blk := &resp.Content[i]
+			switch ev.DeltaType {
+			case canonical.DeltaText:
+				blk.Text += ev.Delta
+			case canonical.DeltaThinking:
+				blk.Thinking += ev.Delta
+			case canonical.DeltaSignature:
+				blk.Signature += ev.Delta
+			case canonical.DeltaInputJSON:
+				input[i] = append(input[i], ev.Delta...)
+			}
+		case canonical.EventMessageDelta:
+			if ev.StopReason != "" {
+				resp.StopReason = ev.StopReason
+			}
+		}
+	})
+	for i, raw := range input {
+		if json.Valid(raw) {
+			resp.Content[i].Input = raw
+		}
+	}
+	for i := range resp.Content {
+		if resp.Content[i].Type == canonical.BlockToolUse && len(resp.Content[i].Input) == 0 {
+			resp.Content[i].Input = json.RawMessage("{}")
+		}
+	}
+	return resp, err
+}
@@ -0,0 +1,125 @@
+package streamconv
+
+import (
+	"io"
+	"net/http"
+	"time"
+
+	"claude-gateway/src/internal/canonical"
+)
+
+// Decoder reads an upstream stream and emits canonical events until the
+// stream ends. Every block it opens is closed before EventMessageDelta.
+type Decoder func(r io.Reader, emit func(canonical.Event)) error
+
+// Encoder writes canonical events in a client's protocol. EventError is
+// the last event of a failed stream and must be well-formed in that
+// protocol.
+type Encoder interface {
+	Event(ev canonical.Event)
+	// Close ends the stream once the decoder has returned successfully.
+	Close()
+}
+
+// Summary is the accounting of a converted stream.
+type Summary struct {
+	// Usage is the last usage the upstream reported.
+	Usage canonical.Usage
+	// TTFT is the time from the request start to the first content delta.
+	TTFT time.Duration
+	// TPS is output tokens per second between the first and the last
+	// content delta.
+	TPS float64
+	// Bytes counts what was written to the client.
+	Bytes int
+}
+
+// Keepalive returns the frame that keeps an idle stream to a facade's
+// client open: Anthropic's ping event, or an SSE comment, which OpenAI
+// clients skip.
+func Keepalive(f canonical.Facade) []byte {
+	if f == canonical.FacadeAnthropic {
+		return []byte("event: ping\ndata: {\"type\":\"ping\"}\n\n")
+	}
+	return []byte(": keepalive\n\n")
+}
+
+// Convert pipes r through dec into enc. Supporting a new dialect takes one
+// Decoder and one Encoder rather than a converter per pair. When the
+// upstream breaks off or reports an error, the client gets an error event
+// instead of a cut connection, and the error is returned as a StreamError.
+func Convert(r io.Reader, dec Decoder, enc Encoder) error {
+	if err := dec(r, enc.Event); err != nil {
+		se := AsStreamError(err)
+		enc.Event(canonical.Event{Type: canonical.EventError, Err: se})
+		return se
+	}
+	enc.Close()
+	return nil
+}
+
+// convertMetered runs Convert with the encoder built on a byte-counting
+// writer and summarizes the stream.
+func convertMetered(w http.ResponseWriter, r io.Reader, start time.Time, dec Decoder, newEncoder func(w http.ResponseWriter, flusher http.Flusher) Encoder) (Summary, error) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		n, err := io.Copy(w, r)
+		return Summary{Bytes: int(n)}, err
+	}
+	cw := &countingWriter{ResponseWriter: w}
+	m := &meter{enc: newEncoder(cw, flusher), start: start}
+	err := Convert(r, dec, m)
+	m.sum.Bytes = cw.n
+	m.sum.TPS = TokensPerSecond(m.sum.Usage.OutputTokens, m.first, m.last)
+	return m.sum, err
+}
+
+// TokensPerSecond is the output rate of a stream whose content deltas
+// spanned first to last, or 0 when the span is too short to measure.
+func TokensPerSecond(outputTokens int, first, last time.Time) float64 {
+	if outputTokens <= 0 || first.IsZero() {
+		return 0
+	}
+	if dur := last.Sub(first).Seconds(); dur > 0 {
+		return float64(outputTokens) / dur
+	}
+	return 0
+}
+
+// meter is an Encoder that records usage and timing on the way through.
+type meter struct {
+	enc         Encoder
+	start       time.Time
+	sum         Summary
+	deltas      int
+	first, last time.Time
+}
+
+func (m *meter) Event(ev canonical.Event) {
+	if ev.Type == canonical.EventBlockDelta && ev.Delta != "" && ev.DeltaType != canonical.DeltaSignature {
+		now := time.Now()
+		if m.deltas == 0 {
+			m.first = now
+			m.sum.TTFT = now.Sub(m.start)
+		}
+		m.last = now
+		m.deltas++
+	}
+	if ev.Usage != nil {
+		m.sum.Usage = *ev.Usage
+	}
+	m.enc.Event(ev)
+}
+
+func (m *meter) Close() { m.enc.Close() }
+
+type countingWriter struct {
+	http.ResponseWriter
+	n int
+}
+
+func (w *countingWriter) Write(b []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(b)
+	w.n += n
+	return n, err
+}
@@ -0,0 +1,210 @@
+// Package truncate fits a conversation into a smaller context window by
+// dropping whole turns from its middle: the opening request and the latest
+// turns are kept, and a tool call is never kept without its result or the
+// other way round.
+package truncate
+
+import (
+	"encoding/json"
+
+	"claude-gateway/src/internal/rawjson"
+)
+
+// Message is what truncation needs to know of a conversation entry.
+type Message struct {
+	Role string
+	// Calls are the IDs of the tool calls the entry makes, Results the IDs
+	// of the calls it answers.
+	Calls   []string
+	Results []string
+}
+
+// Format locates the conversation in a request body and reads its entries.
+type Format struct {
+	Field    string
+	Describe func(json.RawMessage) Message
+}
+
+var (
+	Anthropic       = Format{Field: "messages", Describe: describeAnthropic}
+	OpenAIChat      = Format{Field: "messages", Describe: describeOpenAIChat}
+	OpenAIResponses = Format{Field: "input", Describe: describeOpenAIResponses}
+)
+
+// Body drops entries from the middle of body's conversation until the
+// prompt, estimated at prompt tokens of which each entry accounts for
+// tokens(entry), fits budget. It returns the new body and how many entries
+// were dropped, or false when even the opening and the last turn do not
+// fit.
+func Body(body []byte, f Format, prompt, budget int, tokens func(json.RawMessage) int) ([]byte, int, bool) {
+	raw, ok := rawjson.Get(body, f.Field)
+	if !ok {
+		return nil, 0, false
+	}
+	var entries []json.RawMessage
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, 0, false
+	}
+	msgs := make([]Message, len(entries))
+	sizes := make([]int, len(entries))
+	overhead := prompt
+	for i, e := range entries {
+		msgs[i] = f.Describe(e)
+		sizes[i] = tokens(e)
+		overhead -= sizes[i]
+	}
+	if overhead < 0 {
+		overhead = 0
+	}
+	keep, ok := Middle(msgs, sizes, budget-overhead)
+	if !ok {
+		return nil, 0, false
+	}
+	kept := make([]json.RawMessage, 0, len(keep))
+	for _, i := range keep {
+		kept = append(kept, entries[i])
+	}
+	out, err := rawjson.Set(body, f.Field, kept)
+	if err != nil {
+		return nil, 0, false
+	}
+	return out, len(entries) - len(kept), true
+}
+
+// Middle returns the indexes of the entries to keep so that their sizes
+// add up to at most budget. Entries are grouped into turns that are kept
+// or dropped together; the turns up to the first user entry and the last
+// turn are always kept, and the oldest of the others go first.
+func Middle(msgs []Message, sizes []int, budget int) ([]int, bool) {
+	turns := group(msgs)
+	if len(turns) == 0 {
+		return nil, false
+	}
+	head := 0
+	for t, turn := range turns {
+		if hasRole(msgs[turn.start:turn.end], "user") {
+			head = t
+			break
+		}
+	}
+	total := 0
+	for _, s := range sizes {
+		total += s
+	}
+	dropped := map[int]bool{}
+	for t := head + 1; t < len(turns)-1 && total > budget; t++ {
+		for i := turns[t].start; i < turns[t].end; i++ {
+			total -= sizes[i]
+		}
+		dropped[t] = true
+	}
+	if total > budget {
+		return nil, false
+	}
+	var keep []int
+	for t, turn := range turns {
+		if dropped[t] {
+			continue
+		}
+		for i := turn.start; i < turn.end; i++ {
+			keep = append(keep, i)
+		}
+	}
+	return keep, true
+}
+
+type turn struct{ start, end int }
+
+// group splits msgs into turns: each entry on its own, except that entries
+// from a tool call to its result are joined.
+func group(msgs []Message) []turn {
+	var turns []turn
+	callAt := map[string]int{}
+	for i, m := range msgs {
+		start := i
+		for _, id := range m.Results {
+			if at, ok := callAt[id]; ok && at < start {
+				start = at
+			}
+		}
+		for len(turns) > 0 && turns[len(turns)-1].end > start {
+			start = min(start, turns[len(turns)-1].start)
+			turns = turns[:len(turns)-1]
+		}
+		turns = append(turns, turn{start: start, end: i + 1})
+		for _, id := range m.Calls {
+			callAt[id] = i
+		}
+	}
+	return turns
+}
+
+func hasRole(msgs []Message, role string) bool {
+	for _, m := range msgs {
+		if m.Role == role {
+			return true
+		}
+	}
+	return false
+}
+
+func describeAnthropic(raw json.RawMessage) Message {
+	var m struct {
+		Role    string          `json:"role"`
+		Content json.RawMessage `json:"content"`
+	}
+	_ = json.Unmarshal(raw, &m)
+	out := Message{Role: m.Role}
+	var blocks []struct {
+		Type      string `json:"type"`
+		ID        string `json:"id"`
+		ToolUseID string `json:"tool_use_id"`
+	}
+	if json.Unmarshal(m.Content, &blocks) == nil {
+		for _, b := range blocks {
+			switch b.Type {
+			case "tool_use", "server_tool_use":
+				out.Calls = append(out.Calls, b.ID)
+			case "tool_result":
+				out.Results = append(out.Results, b.ToolUseID)
+			}
+		}
+	}
+	return out
+}
+
+func describeOpenAIChat(raw json.RawMessage) Message {
+	var m struct {
+		Role      string `json:"role"`
+		ToolCalls []struct {
+			ID string `json:"id"`
+		} `json:"tool_calls"`
+		ToolCallID string `json:"tool_call_id"`
+	}
+	_ = json.Unmarshal(raw, &m)
+	out := Message{Role: m.Role}
+	for _, tc := range m.ToolCalls {
+		out.Calls = append(out.Calls, tc.ID)
+	}
+	if m.ToolCallID != "" {
+		out.Results = append(out.Results, m.ToolCallID)
+	}
+	return out
+}
+
+func describeOpenAIResponses(raw json.RawMessage) Message {
+	var item struct {
+		Type   string `json:"type"`
+		Role   string `json:"role"`
+		CallID string `json:"call_id"`
+	}
+	_ = json.Unmarshal(raw, &item)
+	out := Message{Role: item.Role}
+	switch item.Type {
+	case "function_call", "custom_tool_call":
+		out.Calls = append(out.Calls, item.CallID)
+	case "function_call_output", "custom_tool_call_output":
+		out.Results = append(out.Results, item.CallID)
+	}
+	return out
+}
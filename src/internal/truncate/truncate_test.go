@@ -0,0 +1,80 @@
+package truncate
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestMiddleKeepsToolPairsTogether(t *testing.T) {
+	msgs := []Message{
+		{Role: "system"},
+		{Role: "user"},
+		{Role: "assistant", Calls: []string{"a", "b"}},
+		{Role: "tool", Results: []string{"a"}},
+		{Role: "tool", Results: []string{"b"}},
+		{Role: "assistant"},
+		{Role: "user"},
+	}
+	sizes := []int{1, 10, 10, 10, 10, 10, 10}
+
+	keep, ok := Middle(msgs, sizes, 35)
+	if !ok || !reflect.DeepEqual(keep, []int{0, 1, 5, 6}) {
+		t.Fatalf("keep = %v, %v", keep, ok)
+	}
+	if keep, ok := Middle(msgs, sizes, 61); !ok || len(keep) != len(msgs) {
+		t.Fatalf("fitting conversation truncated: %v, %v", keep, ok)
+	}
+	if _, ok := Middle(msgs, sizes, 20); ok {
+		t.Fatal("opening and last turn cannot fit in 20")
+	}
+}
+
+func TestMiddleNeverSplitsTheLastToolCall(t *testing.T) {
+	msgs := []Message{
+		{Role: "user"},
+		{Role: "assistant"},
+		{Role: "assistant", Calls: []string{"x"}},
+		{Role: "user", Results: []string{"x"}},
+	}
+	keep, ok := Middle(msgs, []int{5, 5, 5, 5}, 15)
+	if !ok || !reflect.DeepEqual(keep, []int{0, 2, 3}) {
+		t.Fatalf("keep = %v, %v", keep, ok)
+	}
+}
+
+func TestBodyAnthropic(t *testing.T) {
+	body := []byte(`{"model":"m","system":"be brief","messages":[` +
+		`{"role":"user","content":"task"},` +
+		`{"role":"assistant","content":[{"type":"tool_use","id":"t1","name":"read","input":{}}]},` +
+		`{"role":"user","content":[{"type":"tool_result","tool_use_id":"t1","content":"long output"}]},` +
+		`{"role":"assistant","content":"done reading"},` +
+		`{"role":"user","content":"next"}]}`)
+	tokens := func(json.RawMessage) int { return 100 }
+
+	out, dropped, ok := Body(body, Anthropic, 520, 330, tokens)
+	if !ok || dropped != 2 {
+		t.Fatalf("dropped %d, %v", dropped, ok)
+	}
+	var got struct {
+		System   string            `json:"system"`
+		Messages []json.RawMessage `json:"messages"`
+	}
+	if err := json.Unmarshal(out, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got.System != "be brief" || len(got.Messages) != 3 {
+		t.Fatalf("got %s", out)
+	}
+	for _, m := range got.Messages {
+		if d := describeAnthropic(m); len(d.Calls) > 0 || len(d.Results) > 0 {
+			t.Fatalf("kept half of a tool call: %s", out)
+		}
+	}
+}
+
+func TestBodyResponsesStringInput(t *testing.T) {
+	if _, _, ok := Body([]byte(`{"input":"hello"}`), OpenAIResponses, 100, 10, func(json.RawMessage) int { return 1 }); ok {
+		t.Fatal("string input cannot be truncated")
+	}
+}
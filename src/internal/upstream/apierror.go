@@ -0,0 +1,72 @@
+package upstream
+
+import (
+	"regexp"
+	"strings"
+)
+
+// APIError is the type, code and message an upstream put in an error body.
+// Providers differ in which of the three they fill.
+type APIError struct {
+	Type    string
+	Code    string
+	Message string
+}
+
+// Kind maps the error onto Anthropic's error types, which facades
+// translate into their own. It returns "" when neither type nor code is
+// recognised.
+func (e APIError) Kind() string {
+	for _, s := range []string{e.Type, e.Code} {
+		s = strings.ToLower(strings.TrimSpace(s))
+		switch {
+		case s == "":
+		case strings.Contains(s, "overloaded"), s == "unavailable":
+			return "overloaded_error"
+		case strings.Contains(s, "rate_limit"), s == "insufficient_quota", s == "resource_exhausted":
+			return "rate_limit_error"
+		case strings.Contains(s, "invalid_request"), s == "invalid_argument", s == "failed_precondition",
+			s == "context_length_exceeded", s == "string_above_max_length",
+			strings.Contains(s, "content_policy"), s == "content_filter":
+			return "invalid_request_error"
+		case strings.Contains(s, "authentication"), s == "invalid_api_key", s == "unauthenticated":
+			return "authentication_error"
+		case s == "permission_error", s == "permission_denied":
+			return "permission_error"
+		case s == "not_found_error", s == "not_found", s == "model_not_found":
+			return "not_found_error"
+		case s == "request_too_large":
+			return s
+		}
+	}
+	return ""
+}
+
+var redactions = []struct {
+	re   *regexp.Regexp
+	repl string
+}{
+	{regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=\-]+`), "Bearer [redacted]"},
+	{regexp.MustCompile(`\b(?:sk|rk|pk)-[A-Za-z0-9_*\-]{8,}`), "[redacted]"},
+	{regexp.MustCompile(`\bAIza[0-9A-Za-z_\-]{20,}`), "[redacted]"},
+	{regexp.MustCompile(`(?i)\b((?:api[_-]?key|key|token|secret|password)["']?\s*[:=]\s*["']?)[^\s"'&,;)]+`), "${1}[redacted]"},
+	{regexp.MustCompile(`(?i)\bhttps?://[^\s"'<>()]+`), "[redacted-url]"},
+	{regexp.MustCompile(`\b(?:\d{1,3}\.){3}\d{1,3}(?::\d+)?\b`), "[redacted-host]"},
+}
+
+// maxMessageLen bounds an upstream message relayed to a client.
+const maxMessageLen = 500
+
+// SanitizeMessage prepares an upstream error message for a client: API
+// keys, tokens, URLs and addresses are redacted, since they name the
+// gateway's credentials and upstream endpoints rather than the client's.
+func SanitizeMessage(msg string) string {
+	msg = strings.TrimSpace(msg)
+	for _, r := range redactions {
+		msg = r.re.ReplaceAllString(msg, r.repl)
+	}
+	if len(msg) > maxMessageLen {
+		msg = strings.ToValidUTF8(msg[:maxMessageLen], "") + "..."
+	}
+	return msg
+}
@@ -0,0 +1,27 @@
+package upstream
+
+import "testing"
+
+func TestAPIErrorKind(t *testing.T) {
+	cases := map[APIError]string{
+		{Type: "invalid_request_error", Code: "context_length_exceeded"}: "invalid_request_error",
+		{Code: "content_policy_violation"}:                               "invalid_request_error",
+		{Type: "RESOURCE_EXHAUSTED"}:                                     "rate_limit_error",
+		{Type: "overloaded_error"}:                                       "overloaded_error",
+		{Type: "UNAVAILABLE"}:                                            "overloaded_error",
+		{Type: "server_error"}:                                           "",
+	}
+	for in, want := range cases {
+		if got := in.Kind(); got != want {
+			t.Errorf("%+v.Kind() = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestSanitizeMessage(t *testing.T) {
+	in := "Incorrect API key provided: sk-proj-abc123****wxyz. See https://internal.example:8443/v1 or 10.0.0.12:9000 (Authorization: Bearer eyJhbGci.x.y, api_key=AIzaSyA1234567890abcdefghij)"
+	want := "Incorrect API key provided: [redacted]. See [redacted-url] or [redacted-host] (Authorization: Bearer [redacted], api_key=[redacted])"
+	if got := SanitizeMessage(in); got != want {
+		t.Fatalf("SanitizeMessage = %q", got)
+	}
+}
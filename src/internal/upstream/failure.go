@@ -0,0 +1,65 @@
+package upstream
+
+import "strings"
+
+type FailureKind int
+
+const (
+	FailureNone FailureKind = iota
+	FailureTransient
+	FailureRateLimited
+	// FailureQuotaExhausted and FailureInvalidKey do not recover by waiting;
+	// the credential needs a top-up or a replacement key.
+	FailureQuotaExhausted
+	FailureInvalidKey
+)
+
+func (k FailureKind) String() string {
+	switch k {
+	case FailureTransient:
+		return "transient"
+	case FailureRateLimited:
+		return "rate_limited"
+	case FailureQuotaExhausted:
+		return "quota_exhausted"
+	case FailureInvalidKey:
+		return "invalid_key"
+	default:
+		return "none"
+	}
+}
+
+type Failure struct {
+	Kind    FailureKind
+	Status  int
+	Message string
+}
+
+func (f Failure) Permanent() bool {
+	return f.Kind == FailureQuotaExhausted || f.Kind == FailureInvalidKey
+}
+
+// Reason is stored alongside an auto-disabled credential, with the message
+// sanitized and cut on a rune boundary.
+func (f Failure) Reason() string {
+	msg := SanitizeMessage(f.Message)
+	if msg == "" {
+		return f.Kind.String()
+	}
+	if len(msg) > 200 {
+		msg = strings.ToValidUTF8(msg[:200], "")
+	}
+	return f.Kind.String() + ": " + msg
+}
+
+// Generic classifies by status code alone.
+func Generic(status int, message string) Failure {
+	switch {
+	case status >= 200 && status < 400:
+		return Failure{Kind: FailureNone, Status: status}
+	case status == 429:
+		return Failure{Kind: FailureRateLimited, Status: status, Message: message}
+	default:
+		return Failure{Kind: FailureTransient, Status: status, Message: message}
+	}
+}
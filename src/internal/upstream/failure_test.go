@@ -0,0 +1,24 @@
+package upstream
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestFailureReason(t *testing.T) {
+	f := Failure{Kind: FailureQuotaExhausted, Status: 429, Message: "You exceeded your current quota"}
+	if !f.Permanent() {
+		t.Fatalf("expected quota exhaustion to be permanent")
+	}
+	if got := f.Reason(); got != "quota_exhausted: You exceeded your current quota" {
+		t.Fatalf("unexpected reason %q", got)
+	}
+	long := Failure{Kind: FailureInvalidKey, Message: strings.Repeat("é", 150)}
+	if got := long.Reason(); !utf8.ValidString(got) || len(got) > len("invalid_key: ")+200 {
+		t.Fatalf("reason not cut on a rune boundary: %q", got)
+	}
+	if Generic(429, "").Permanent() {
+		t.Fatalf("expected plain 429 to be transient")
+	}
+}
@@ -0,0 +1,95 @@
+package upstream
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Budget is one rate-limit dimension (requests or tokens) as reported by the
+// upstream. Known is false when the response carried no header for it.
+type Budget struct {
+	Known     bool
+	Limit     int64
+	Remaining int64
+	Reset     time.Time
+}
+
+// RateLimit is the provider-neutral view of an upstream's rate-limit headers.
+type RateLimit struct {
+	RetryAfter time.Duration
+	Requests   Budget
+	Tokens     Budget
+}
+
+func (rl RateLimit) Empty() bool {
+	return rl.RetryAfter <= 0 && !rl.Requests.Known && !rl.Tokens.Known
+}
+
+// ResumeAt returns when the credential is expected to accept requests again
+// after a 429, or the zero time when the headers do not say.
+func (rl RateLimit) ResumeAt(now time.Time) time.Time {
+	if rl.RetryAfter > 0 {
+		return now.Add(rl.RetryAfter)
+	}
+	var at time.Time
+	for _, b := range []Budget{rl.Requests, rl.Tokens} {
+		if b.Known && b.Remaining <= 0 && b.Reset.After(now) && b.Reset.After(at) {
+			at = b.Reset
+		}
+	}
+	return at
+}
+
+// Headroom returns the smallest remaining/limit fraction over the known
+// budgets that have not reset yet, or 1 when nothing is known.
+func (rl RateLimit) Headroom(now time.Time) float64 {
+	h := 1.0
+	for _, b := range []Budget{rl.Requests, rl.Tokens} {
+		if !b.Known || b.Limit <= 0 {
+			continue
+		}
+		if !b.Reset.IsZero() && !now.Before(b.Reset) {
+			continue
+		}
+		f := float64(b.Remaining) / float64(b.Limit)
+		if f < 0 {
+			f = 0
+		}
+		if f < h {
+			h = f
+		}
+	}
+	return h
+}
+
+// ParseRetryAfter accepts both the delay-seconds and the HTTP-date forms.
+func ParseRetryAfter(h http.Header, now time.Time) time.Duration {
+	v := strings.TrimSpace(h.Get("Retry-After"))
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.ParseFloat(v, 64); err == nil {
+		if secs <= 0 {
+			return 0
+		}
+		return time.Duration(secs * float64(time.Second))
+	}
+	if t, err := http.ParseTime(v); err == nil && t.After(now) {
+		return t.Sub(now)
+	}
+	return 0
+}
+
+func ParseInt(h http.Header, key string) (int64, bool) {
+	v := strings.TrimSpace(h.Get(key))
+	if v == "" {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,37 @@
+package upstream
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	h := http.Header{}
+	h.Set("Retry-After", "7")
+	if got := ParseRetryAfter(h, now); got != 7*time.Second {
+		t.Fatalf("expected 7s, got %v", got)
+	}
+	h.Set("Retry-After", now.Add(30*time.Second).Format(http.TimeFormat))
+	if got := ParseRetryAfter(h, now); got != 30*time.Second {
+		t.Fatalf("expected 30s, got %v", got)
+	}
+}
+
+func TestResumeAtUsesExhaustedBudget(t *testing.T) {
+	now := time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC)
+	rl := RateLimit{
+		Requests: Budget{Known: true, Limit: 50, Remaining: 10, Reset: now.Add(5 * time.Second)},
+		Tokens:   Budget{Known: true, Limit: 1000, Remaining: 0, Reset: now.Add(40 * time.Second)},
+	}
+	if got := rl.ResumeAt(now); !got.Equal(now.Add(40 * time.Second)) {
+		t.Fatalf("expected token reset, got %v", got)
+	}
+	if got := rl.Headroom(now); got != 0 {
+		t.Fatalf("expected zero headroom, got %v", got)
+	}
+	if got := rl.Headroom(now.Add(time.Minute)); got != 1 {
+		t.Fatalf("expected budgets past reset to be ignored, got %v", got)
+	}
+}